@@ -1,17 +1,30 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 // DefaultBaseURL is the default control plane API base URL.
 const DefaultBaseURL = "https://api.launchtunnel.dev"
 
+// DefaultTimeout bounds each API request unless WithTimeout says otherwise.
+const DefaultTimeout = 30 * time.Second
+
+// ErrDryRun is returned for requests that a dry-run client described
+// instead of sending.
+var ErrDryRun = errors.New("request not sent (dry run)")
+
 // APIError represents a structured error response from the control plane.
 type APIError struct {
 	HTTPStatus int
@@ -44,6 +57,9 @@ type CreateTunnelRequest struct {
 	Description string `json:"description,omitempty"`
 	Branch      string `json:"branch,omitempty"`
 	ExpiresIn   string `json:"expires_in,omitempty"`
+	// Region asks for a relay in this region, e.g. "eu-west". The server
+	// may pick another if it is unavailable; see TunnelResponse.Region.
+	Region string `json:"region,omitempty"`
 }
 
 // TunnelResponse is a single tunnel object returned by the API.
@@ -60,6 +76,7 @@ type TunnelResponse struct {
 	Status        string     `json:"status"`
 	RelayEndpoint string     `json:"relay_endpoint,omitempty"`
 	SessionToken  string     `json:"session_token,omitempty"`
+	Region        string     `json:"region,omitempty"`
 	BytesIn       int64      `json:"bytes_in"`
 	BytesOut      int64      `json:"bytes_out"`
 	RequestCount  int64      `json:"request_count"`
@@ -69,6 +86,11 @@ type TunnelResponse struct {
 	CreatedAt     time.Time  `json:"created_at"`
 	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
 
+	// Access control settings, when the API reports them. The password itself
+	// is never returned, only whether one is set.
+	PasswordProtected bool     `json:"password_protected,omitempty"`
+	IPAllowlist       []string `json:"ip_allowlist,omitempty"`
+
 	ConnectionEvents []ConnectionEvent `json:"connection_events,omitempty"`
 }
 
@@ -79,6 +101,37 @@ type ConnectionEvent struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// LogEntry is a single request log line for a tunnel.
+type LogEntry struct {
+	ID         string    `json:"id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMs int64     `json:"duration_ms"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	BytesIn    int64     `json:"bytes_in,omitempty"`
+	BytesOut   int64     `json:"bytes_out,omitempty"`
+}
+
+// TunnelMetrics is a time series of traffic for one tunnel.
+type TunnelMetrics struct {
+	TunnelID string          `json:"tunnel_id"`
+	Window   string          `json:"window"`
+	Interval string          `json:"interval,omitempty"`
+	Buckets  []MetricsBucket `json:"buckets"`
+}
+
+// MetricsBucket aggregates traffic over one interval starting at Start.
+type MetricsBucket struct {
+	Start        time.Time `json:"start"`
+	Requests     int64     `json:"requests"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+	LatencyP50Ms float64   `json:"latency_p50_ms"`
+	LatencyP95Ms float64   `json:"latency_p95_ms"`
+}
+
 // Pagination holds paging metadata.
 type Pagination struct {
 	Total  int `json:"total"`
@@ -90,6 +143,10 @@ type tunnelEnvelope struct {
 	Tunnel TunnelResponse `json:"tunnel"`
 }
 
+type metricsEnvelope struct {
+	Metrics TunnelMetrics `json:"metrics"`
+}
+
 type tunnelsEnvelope struct {
 	Tunnels    []TunnelResponse `json:"tunnels"`
 	Pagination Pagination       `json:"pagination"`
@@ -106,6 +163,23 @@ type UserInfo struct {
 	Email  string `json:"email"`
 	Tier   string `json:"tier"`
 	Status string `json:"status"`
+	// Quota is the plan's limits and usage, when the server includes them.
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// Quota is an account's plan limits and its current use of them, returned
+// by GET /api/v1/account/quota. A limit of 0 means unlimited.
+type Quota struct {
+	Tier           string     `json:"tier"`
+	MaxTunnels     int        `json:"max_tunnels"`
+	ActiveTunnels  int        `json:"active_tunnels"`
+	BandwidthLimit int64      `json:"bandwidth_limit_bytes"`
+	BandwidthUsed  int64      `json:"bandwidth_used_bytes"`
+	ResetsAt       *time.Time `json:"resets_at,omitempty"` // when BandwidthUsed starts over
+}
+
+type quotaEnvelope struct {
+	Quota Quota `json:"quota"`
 }
 
 // CLISessionResponse is returned by GET /api/v1/auth/cli-session/{session_id}.
@@ -114,11 +188,50 @@ type CLISessionResponse struct {
 	APIKey string `json:"api_key,omitempty"`
 }
 
+// DeviceLoginResponse is returned by POST /api/v1/auth/device. The user
+// enters UserCode at VerificationURL, on any device, while the CLI polls
+// with DeviceCode.
+type DeviceLoginResponse struct {
+	DeviceCode string `json:"device_code"`
+	UserCode   string `json:"user_code"`
+	// VerificationURL is where the user enters the code;
+	// VerificationURLComplete, if set, has the code filled in.
+	VerificationURL         string `json:"verification_url"`
+	VerificationURLComplete string `json:"verification_url_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"` // seconds until the code expires
+	Interval                int    `json:"interval"`   // seconds to wait between polls
+}
+
+// Device login states reported in DeviceTokenResponse.Status.
+const (
+	DeviceAuthorizationPending = "authorization_pending"
+	DeviceSlowDown             = "slow_down"
+	DeviceAuthenticated        = "authenticated"
+	DeviceAccessDenied         = "access_denied"
+	DeviceExpired              = "expired_token"
+)
+
+// DeviceTokenResponse is returned by POST /api/v1/auth/device/token.
+// APIKey is set once Status is DeviceAuthenticated.
+type DeviceTokenResponse struct {
+	Status string `json:"status"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+type deviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
 // CreateAPIKeyRequest is the body for POST /api/v1/api-keys.
 type CreateAPIKeyRequest struct {
 	Name string `json:"name,omitempty"`
 }
 
+// UpdateAPIKeyRequest is the body for PATCH /api/v1/api-keys/{id}.
+type UpdateAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
 // APIKeyResponse is a single API key object.
 type APIKeyResponse struct {
 	ID         string     `json:"id"`
@@ -148,20 +261,53 @@ type Client struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
+	dryRun     io.Writer
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTLSConfig makes the client use cfg for HTTPS connections, e.g. to
+// trust the private CA of a self-hosted control plane.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg
+		c.httpClient.Transport = transport
+	}
+}
+
+// WithTimeout sets the time limit for each API request, including reading
+// the response body. Zero means no limit.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = d
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification. Anyone on
+// the network path can then impersonate the server and read the API key,
+// so this is only meant for testing against self-hosted servers.
+func WithInsecureSkipVerify() Option {
+	return WithTLSConfig(&tls.Config{InsecureSkipVerify: true})
 }
 
 // New creates a new Client.
-func New(baseURL, apiKey string) *Client {
+func New(baseURL, apiKey string, opts ...Option) *Client {
 	if baseURL == "" {
 		baseURL = DefaultBaseURL
 	}
-	return &Client{
+	c := &Client{
 		baseURL: baseURL,
 		apiKey:  apiKey,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: DefaultTimeout,
 		},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // SetAPIKey updates the API key used for authentication.
@@ -169,6 +315,13 @@ func (c *Client) SetAPIKey(key string) {
 	c.apiKey = key
 }
 
+// SetDryRun makes the client describe every mutating request (anything
+// but GET) on w and return ErrDryRun instead of sending it. Reads are
+// still sent. A nil w turns dry-run mode off.
+func (c *Client) SetDryRun(w io.Writer) {
+	c.dryRun = w
+}
+
 // BaseURL returns the base URL the client is configured with.
 func (c *Client) BaseURL() string {
 	return c.baseURL
@@ -185,6 +338,20 @@ func (c *Client) CreateTunnel(req CreateTunnelRequest) (*TunnelResponse, error)
 	return &env.Tunnel, nil
 }
 
+// CreateTunnelIdempotent creates a new tunnel, sending key in the
+// Idempotency-Key header so that retries of the same request (with the same
+// key) do not create duplicate tunnels. This only helps if the server honors
+// the header; otherwise it behaves exactly like CreateTunnel.
+func (c *Client) CreateTunnelIdempotent(req CreateTunnelRequest, key string) (*TunnelResponse, error) {
+	var env tunnelEnvelope
+	header := http.Header{}
+	header.Set("Idempotency-Key", key)
+	if err := c.doReq("POST", "/api/v1/tunnels", req, &env, true, header); err != nil {
+		return nil, err
+	}
+	return &env.Tunnel, nil
+}
+
 // ListTunnels returns the user's tunnels.
 func (c *Client) ListTunnels() ([]TunnelResponse, error) {
 	var env tunnelsEnvelope
@@ -208,10 +375,11 @@ func (c *Client) StopTunnel(tunnelID string) error {
 	return c.do("POST", "/api/v1/tunnels/"+tunnelID+"/stop", nil, nil)
 }
 
-// DeleteTunnel stops and deletes a tunnel.
+// DeleteTunnel stops and deletes a tunnel. Any 2xx response is success;
+// the body may be empty, {"deleted": true}, or the deleted tunnel, and is
+// not decoded.
 func (c *Client) DeleteTunnel(tunnelID string) error {
-	var env tunnelEnvelope
-	return c.do("DELETE", "/api/v1/tunnels/"+tunnelID, nil, &env)
+	return c.do("DELETE", "/api/v1/tunnels/"+tunnelID, nil, nil)
 }
 
 // SetTunnelPassword sets password protection on a tunnel.
@@ -226,6 +394,119 @@ func (c *Client) SetTunnelIPAllowlist(tunnelID string, allowlist []string) error
 	return c.do("PUT", "/api/v1/tunnels/"+tunnelID+"/ip-allowlist", body, nil)
 }
 
+// GetTunnelMetrics returns traffic buckets for a tunnel over window
+// (e.g. "1h", "24h"). An empty window uses the server default.
+func (c *Client) GetTunnelMetrics(tunnelID string, window string) (*TunnelMetrics, error) {
+	path := "/api/v1/tunnels/" + tunnelID + "/metrics"
+	if window != "" {
+		path += "?window=" + url.QueryEscape(window)
+	}
+	var env metricsEnvelope
+	if err := c.do("GET", path, nil, &env); err != nil {
+		return nil, err
+	}
+	return &env.Metrics, nil
+}
+
+// StreamTunnelLogs opens the server-sent event stream of request logs for a
+// tunnel. If follow is true the server keeps the stream open and pushes new
+// entries as they arrive; otherwise it sends recent entries and closes. When
+// reconnecting, pass the ID of the last entry received as lastEventID so the
+// server resumes after it; otherwise pass "". The caller must close the
+// returned body and should parse it with ReadLogEntries. The stream is
+// bounded by ctx rather than the client timeout.
+func (c *Client) StreamTunnelLogs(ctx context.Context, tunnelID string, follow bool, lastEventID string) (io.ReadCloser, error) {
+	path := "/api/v1/tunnels/" + tunnelID + "/logs"
+	if follow {
+		path += "?follow=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	// Long-lived streams must not be cut off by the per-request timeout.
+	streamClient := *c.httpClient
+	streamClient.Timeout = 0
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach LaunchTunnel servers: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, parseAPIError(resp.StatusCode, data)
+	}
+	return resp.Body, nil
+}
+
+// ErrMalformedLogEntry is returned by ReadLogEntries when an entry is not
+// valid JSON. Unlike a dropped connection, reconnecting will not help.
+var ErrMalformedLogEntry = errors.New("malformed log entry")
+
+// ReadLogEntries parses a server-sent event stream from r and calls fn for
+// each log entry. An entry without an ID of its own gets the stream's last
+// event ID, which StreamTunnelLogs can resume from. It returns nil when r
+// reaches EOF, or the first error from reading, decoding (wrapping
+// ErrMalformedLogEntry), or fn. As in the SSE spec, an event cut off by
+// EOF before its terminating blank line is discarded.
+func ReadLogEntries(r io.Reader, fn func(LogEntry) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var data strings.Builder
+	event, lastID := "", ""
+	dispatch := func() error {
+		defer func() {
+			data.Reset()
+			event = ""
+		}()
+		if data.Len() == 0 || (event != "" && event != "log") {
+			return nil
+		}
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(data.String()), &entry); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedLogEntry, err)
+		}
+		if entry.ID == "" {
+			entry.ID = lastID
+		}
+		return fn(entry)
+	}
+	for sc.Scan() {
+		line := sc.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			// Blank line dispatches the accumulated event.
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case field == "":
+			// Comment / keep-alive.
+		case field == "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case field == "event":
+			event = strings.TrimSpace(value)
+		case field == "id":
+			lastID = value
+		}
+	}
+	return sc.Err()
+}
+
 // ---------- auth operations ----------
 
 // VerifyAPIKey validates the current API key and returns user info.
@@ -237,6 +518,15 @@ func (c *Client) VerifyAPIKey() (*VerifyResponse, error) {
 	return &resp, nil
 }
 
+// GetQuota returns the account's plan limits and usage.
+func (c *Client) GetQuota() (*Quota, error) {
+	var env quotaEnvelope
+	if err := c.do("GET", "/api/v1/account/quota", nil, &env); err != nil {
+		return nil, err
+	}
+	return &env.Quota, nil
+}
+
 // PollCLISession polls the CLI session endpoint during the browser login flow.
 func (c *Client) PollCLISession(sessionID string) (*CLISessionResponse, error) {
 	var resp CLISessionResponse
@@ -246,6 +536,36 @@ func (c *Client) PollCLISession(sessionID string) (*CLISessionResponse, error) {
 	return &resp, nil
 }
 
+// StartDeviceLogin begins a device-code login, for machines without a
+// browser.
+func (c *Client) StartDeviceLogin() (*DeviceLoginResponse, error) {
+	var resp DeviceLoginResponse
+	if err := c.doNoAuth("POST", "/api/v1/auth/device", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PollDeviceLogin reports the state of a device-code login. Like OAuth
+// servers, the control plane may report a state other than
+// DeviceAuthenticated as an error whose code is the state; it is returned
+// as the Status all the same.
+func (c *Client) PollDeviceLogin(deviceCode string) (*DeviceTokenResponse, error) {
+	var resp DeviceTokenResponse
+	err := c.doNoAuth("POST", "/api/v1/auth/device/token", deviceTokenRequest{DeviceCode: deviceCode}, &resp)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch state := strings.ToLower(apiErr.Code); state {
+		case DeviceAuthorizationPending, DeviceSlowDown, DeviceAccessDenied, DeviceExpired:
+			return &DeviceTokenResponse{Status: state}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // ---------- API key operations ----------
 
 // CreateAPIKey creates a new API key.
@@ -267,6 +587,16 @@ func (c *Client) ListAPIKeys() ([]APIKeyResponse, error) {
 	return env.APIKeys, nil
 }
 
+// UpdateAPIKey renames an API key and returns the updated key.
+func (c *Client) UpdateAPIKey(keyID, name string) (*APIKeyResponse, error) {
+	var env apiKeyEnvelope
+	body := UpdateAPIKeyRequest{Name: name}
+	if err := c.do("PATCH", "/api/v1/api-keys/"+keyID, body, &env); err != nil {
+		return nil, err
+	}
+	return &env.APIKey, nil
+}
+
 // RevokeAPIKey revokes an API key by its ID.
 func (c *Client) RevokeAPIKey(keyID string) error {
 	var env deleteEnvelope
@@ -276,14 +606,19 @@ func (c *Client) RevokeAPIKey(keyID string) error {
 // ---------- internal HTTP helpers ----------
 
 func (c *Client) do(method, path string, body any, out any) error {
-	return c.doReq(method, path, body, out, true)
+	return c.doReq(method, path, body, out, true, nil)
 }
 
 func (c *Client) doNoAuth(method, path string, body any, out any) error {
-	return c.doReq(method, path, body, out, false)
+	return c.doReq(method, path, body, out, false, nil)
 }
 
-func (c *Client) doReq(method, path string, body any, out any, auth bool) error {
+func (c *Client) doReq(method, path string, body any, out any, auth bool, header http.Header) error {
+	if c.dryRun != nil && method != "GET" {
+		describeRequest(c.dryRun, method, c.baseURL+path, body)
+		return ErrDryRun
+	}
+
 	var bodyReader io.Reader
 	if body != nil {
 		b, err := json.Marshal(body)
@@ -302,6 +637,9 @@ func (c *Client) doReq(method, path string, body any, out any, auth bool) error
 		req.Header.Set("Content-Type", "application/json")
 	}
 	req.Header.Set("Accept", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	if auth && c.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	}
@@ -330,6 +668,32 @@ func (c *Client) doReq(method, path string, body any, out any, auth bool) error
 	return nil
 }
 
+// redactedFields are request body fields that describeRequest masks.
+var redactedFields = []string{"password", "api_key", "key", "device_code"}
+
+// describeRequest writes a one-line summary of a request, followed by its
+// JSON body with secrets masked, for dry-run mode.
+func describeRequest(w io.Writer, method, url string, body any) {
+	fmt.Fprintf(w, "Would send %s %s\n", method, url)
+	if body == nil {
+		return
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	var fields map[string]any
+	if json.Unmarshal(b, &fields) == nil {
+		for _, name := range redactedFields {
+			if _, ok := fields[name]; ok {
+				fields[name] = "********"
+			}
+		}
+		b, _ = json.Marshal(fields)
+	}
+	fmt.Fprintf(w, "  %s\n", b)
+}
+
 func parseAPIError(status int, body []byte) *APIError {
 	var env apiErrorEnvelope
 	if err := json.Unmarshal(body, &env); err == nil && env.Error.Code != "" {
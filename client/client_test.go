@@ -0,0 +1,354 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteTunnel_ResponseShapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"empty body", http.StatusNoContent, ""},
+		{"empty body 200", http.StatusOK, ""},
+		{"delete envelope", http.StatusOK, `{"deleted": true}`},
+		{"tunnel envelope", http.StatusOK, `{"tunnel": {"id": "tun_1", "status": "stopped"}}`},
+		{"unexpected JSON", http.StatusOK, `["ok"]`},
+		{"not JSON", http.StatusAccepted, "OK"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "DELETE" || r.URL.Path != "/api/v1/tunnels/tun_1" {
+					t.Errorf("got %s %s", r.Method, r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			if err := New(srv.URL, "key").DeleteTunnel("tun_1"); err != nil {
+				t.Errorf("DeleteTunnel: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteTunnel_NotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": "NOT_FOUND", "message": "Tunnel not found"}}`))
+	}))
+	defer srv.Close()
+
+	err := New(srv.URL, "key").DeleteTunnel("tun_1")
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.HTTPStatus != http.StatusNotFound {
+		t.Fatalf("DeleteTunnel: got %v, want 404 *APIError", err)
+	}
+}
+
+func TestAPIError_Is(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *APIError
+		want error
+	}{
+		{"404", &APIError{HTTPStatus: 404, Code: "UNKNOWN_ERROR"}, ErrNotFound},
+		{"401", &APIError{HTTPStatus: 401, Code: "UNKNOWN_ERROR"}, ErrUnauthorized},
+		{"403", &APIError{HTTPStatus: 403, Code: "FORBIDDEN"}, ErrForbidden},
+		{"400", &APIError{HTTPStatus: 400, Code: "VALIDATION_ERROR"}, ErrBadRequest},
+		{"429", &APIError{HTTPStatus: 429}, ErrRateLimited},
+		{"quota by status", &APIError{HTTPStatus: 402}, ErrQuotaExceeded},
+		{"quota by code", &APIError{HTTPStatus: 403, Code: "QUOTA_EXCEEDED"}, ErrQuotaExceeded},
+		{"invalid key by code", &APIError{HTTPStatus: 400, Code: "INVALID_API_KEY"}, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped := fmt.Errorf("deleting tunnel: %w", tt.err)
+			if !errors.Is(wrapped, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false, want true", tt.err, tt.want)
+			}
+		})
+	}
+
+	serverErr := &APIError{HTTPStatus: 500, Code: "INTERNAL_ERROR"}
+	for _, sentinel := range []error{ErrBadRequest, ErrUnauthorized, ErrForbidden, ErrNotFound, ErrQuotaExceeded, ErrRateLimited} {
+		if errors.Is(serverErr, sentinel) {
+			t.Errorf("errors.Is(%v, %v) = true, want false", serverErr, sentinel)
+		}
+	}
+	if IsNotFound(errors.New("connection refused")) {
+		t.Error("IsNotFound matched a non-API error")
+	}
+	if !IsNotFound(&APIError{HTTPStatus: 404}) || !IsUnauthorized(&APIError{HTTPStatus: 401}) ||
+		!IsQuotaExceeded(&APIError{Code: "QUOTA_EXCEEDED"}) {
+		t.Error("predicates disagree with errors.Is")
+	}
+}
+
+func TestTunnelResponse_TimestampVariants(t *testing.T) {
+	want := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	for _, ts := range []string{
+		"2025-03-01T12:30:00Z",
+		"2025-03-01T12:30:00.000Z",
+		"2025-03-01T12:30:00.123456+00:00",
+		"2025-03-01T14:30:00+0200",
+		"2025-03-01 12:30:00Z",
+		"2025-03-01T12:30:00",
+	} {
+		var tun TunnelResponse
+		body := `{"id": "tun_1", "created_at": "` + ts + `", "expires_at": "` + ts + `"}`
+		if err := json.Unmarshal([]byte(body), &tun); err != nil {
+			t.Errorf("%s: %v", ts, err)
+			continue
+		}
+		if got := tun.CreatedAt.Truncate(time.Second); !got.Equal(want) {
+			t.Errorf("%s: CreatedAt = %v, want %v", ts, got, want)
+		}
+		if tun.ExpiresAt == nil || !tun.ExpiresAt.Truncate(time.Second).Equal(want) {
+			t.Errorf("%s: ExpiresAt = %v, want %v", ts, tun.ExpiresAt, want)
+		}
+		if tun.ID != "tun_1" {
+			t.Errorf("%s: ID = %q", ts, tun.ID)
+		}
+	}
+}
+
+func TestTunnelResponse_Expiry(t *testing.T) {
+	var tun TunnelResponse
+	if _, ok := tun.TimeUntilExpiry(); ok || tun.IsExpired() {
+		t.Error("tunnel without expires_at reported an expiry")
+	}
+
+	past := time.Now().Add(-time.Minute)
+	tun.ExpiresAt = &past
+	if !tun.IsExpired() {
+		t.Error("IsExpired = false for a past expiry")
+	}
+
+	future := time.Now().Add(time.Hour)
+	tun.ExpiresAt = &future
+	if d, ok := tun.TimeUntilExpiry(); !ok || d <= 0 || tun.IsExpired() {
+		t.Errorf("TimeUntilExpiry = %v, %v for a future expiry", d, ok)
+	}
+}
+
+func TestUpdateAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" || r.URL.Path != "/api/v1/api-keys/key_1" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		var body UpdateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name != "ci" {
+			t.Errorf("body = %+v, %v; want name ci", body, err)
+		}
+		w.Write([]byte(`{"api_key": {"id": "key_1", "prefix": "lt_abcd", "name": "ci"}}`))
+	}))
+	defer srv.Close()
+
+	key, err := New(srv.URL, "key").UpdateAPIKey("key_1", "ci")
+	if err != nil {
+		t.Fatalf("UpdateAPIKey: %v", err)
+	}
+	if key.ID != "key_1" || key.Name != "ci" {
+		t.Errorf("got %+v", key)
+	}
+}
+
+func TestDryRun_SkipsMutatingRequests(t *testing.T) {
+	var methods []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Write([]byte(`{"tunnels": []}`))
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	c := New(srv.URL, "key")
+	c.SetDryRun(&out)
+
+	if _, err := c.ListTunnels(); err != nil {
+		t.Fatalf("ListTunnels: %v", err)
+	}
+	if err := c.SetTunnelPassword("tun_1", "hunter2"); !errors.Is(err, ErrDryRun) {
+		t.Fatalf("SetTunnelPassword: got %v, want ErrDryRun", err)
+	}
+
+	if len(methods) != 1 || methods[0] != "GET" {
+		t.Errorf("server saw %v, want only the GET", methods)
+	}
+	got := out.String()
+	if !strings.Contains(got, "PUT "+srv.URL+"/api/v1/tunnels/tun_1/password") {
+		t.Errorf("output %q does not describe the PUT", got)
+	}
+	if strings.Contains(got, "hunter2") {
+		t.Errorf("output %q leaks the password", got)
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tunnels": []}`))
+	}))
+	defer srv.Close()
+
+	if _, err := New(srv.URL, "key").ListTunnels(); err == nil {
+		t.Error("default client trusted a self-signed certificate")
+	}
+	if _, err := New(srv.URL, "key", WithInsecureSkipVerify()).ListTunnels(); err != nil {
+		t.Errorf("WithInsecureSkipVerify: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	if _, err := New(srv.URL, "key", WithTLSConfig(&tls.Config{RootCAs: pool})).ListTunnels(); err != nil {
+		t.Errorf("WithTLSConfig with the server's CA: %v", err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"tunnels": []}`))
+	}))
+	defer srv.Close()
+
+	if _, err := New(srv.URL, "key", WithTimeout(20*time.Millisecond)).ListTunnels(); err == nil {
+		t.Error("WithTimeout(20ms): request succeeded, want a timeout")
+	}
+	if _, err := New(srv.URL, "key", WithTimeout(0)).ListTunnels(); err != nil {
+		t.Errorf("WithTimeout(0): %v", err)
+	}
+}
+
+func TestDeviceLogin(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("%s sent an Authorization header", r.URL.Path)
+		}
+		switch r.URL.Path {
+		case "/api/v1/auth/device":
+			w.Write([]byte(`{"device_code": "dev_1", "user_code": "ABCD-EFGH", "verification_url": "https://app.launchtunnel.dev/device", "expires_in": 600, "interval": 5}`))
+		case "/api/v1/auth/device/token":
+			var body struct {
+				DeviceCode string `json:"device_code"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.DeviceCode != "dev_1" {
+				t.Errorf("token request body: %+v, %v", body, err)
+			}
+			polls++
+			switch polls {
+			case 1:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": {"code": "AUTHORIZATION_PENDING", "message": "waiting"}}`))
+			case 2:
+				w.Write([]byte(`{"status": "slow_down"}`))
+			default:
+				w.Write([]byte(`{"status": "authenticated", "api_key": "lt_key"}`))
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "old")
+	dev, err := c.StartDeviceLogin()
+	if err != nil {
+		t.Fatalf("StartDeviceLogin: %v", err)
+	}
+	if dev.UserCode != "ABCD-EFGH" || dev.DeviceCode != "dev_1" || dev.Interval != 5 || dev.ExpiresIn != 600 {
+		t.Errorf("StartDeviceLogin = %+v", dev)
+	}
+
+	for _, want := range []string{DeviceAuthorizationPending, DeviceSlowDown, DeviceAuthenticated} {
+		resp, err := c.PollDeviceLogin("dev_1")
+		if err != nil {
+			t.Fatalf("PollDeviceLogin: %v", err)
+		}
+		if resp.Status != want {
+			t.Errorf("Status = %q, want %q", resp.Status, want)
+		}
+		if want == DeviceAuthenticated && resp.APIKey != "lt_key" {
+			t.Errorf("APIKey = %q, want lt_key", resp.APIKey)
+		}
+	}
+}
+
+func TestReadLogEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    []string // "id path" per entry
+		wantErr error
+	}{
+		{"single", "data: {\"path\":\"/a\"}\n\n", []string{" /a"}, nil},
+		{"no space after colon", "data:{\"path\":\"/a\"}\n\n", []string{" /a"}, nil},
+		{"multi-line data", "data: {\"path\":\ndata: \"/a\"}\n\n", []string{" /a"}, nil},
+		{"comments", ": keep-alive\ndata: {\"path\":\"/a\"}\n: ping\n\n:\n\n", []string{" /a"}, nil},
+		{"log event", "event: log\ndata: {\"path\":\"/a\"}\n\n", []string{" /a"}, nil},
+		{"other events", "event: ping\ndata: {}\n\nevent: status\ndata: not json\n\ndata: {\"path\":\"/b\"}\n\n", []string{" /b"}, nil},
+		{"event name resets", "event: ping\ndata: {}\n\ndata: {\"path\":\"/b\"}\n\n", []string{" /b"}, nil},
+		{"ids", "id: 1\ndata: {\"path\":\"/a\"}\n\ndata: {\"path\":\"/b\"}\n\nid: 3\ndata: {\"id\":\"own\",\"path\":\"/c\"}\n\n",
+			[]string{"1 /a", "1 /b", "own /c"}, nil},
+		{"trailing event without blank line", "data: {\"path\":\"/a\"}\n\ndata: {\"path\":\"/b\"}\n", []string{" /a"}, nil},
+		{"empty data", "data:\n\n", nil, nil},
+		{"malformed", "data: {\"path\":\n\n", nil, ErrMalformedLogEntry},
+	}
+	for _, tt := range tests {
+		var got []string
+		err := ReadLogEntries(strings.NewReader(tt.stream), func(e LogEntry) error {
+			got = append(got, e.ID+" "+e.Path)
+			return nil
+		})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got entries %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := ReadLogEntries(strings.NewReader("data: {}\n\ndata: {}\n\n"), func(LogEntry) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("callback error: got %v after %d calls, want it returned after 1", err, calls)
+	}
+}
+
+func TestStreamTunnelLogs_LastEventID(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "lt_key")
+	for _, id := range []string{"", "42"} {
+		body, err := c.StreamTunnelLogs(context.Background(), "tun_1", true, id)
+		if err != nil {
+			t.Fatalf("StreamTunnelLogs: %v", err)
+		}
+		body.Close()
+	}
+	if len(got) != 2 || got[0] != "" || got[1] != "42" {
+		t.Errorf("Last-Event-ID headers = %q, want [\"\" \"42\"]", got)
+	}
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Sentinel errors for common API failures. An *APIError matches them with
+// errors.Is according to its HTTP status or error code, so callers need
+// not inspect either:
+//
+//	if errors.Is(err, client.ErrNotFound) { ... }
+var (
+	ErrBadRequest    = errors.New("bad request")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrForbidden     = errors.New("forbidden")
+	ErrNotFound      = errors.New("not found")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrRateLimited   = errors.New("rate limited")
+)
+
+// sentinels maps each sentinel error to the HTTP status and error codes
+// that mean it. Servers that report a code with a generic status are still
+// matched by the code.
+var sentinels = map[error]struct {
+	status int
+	codes  []string
+}{
+	ErrBadRequest:    {http.StatusBadRequest, []string{"BAD_REQUEST", "VALIDATION_ERROR"}},
+	ErrUnauthorized:  {http.StatusUnauthorized, []string{"UNAUTHORIZED", "INVALID_API_KEY"}},
+	ErrForbidden:     {http.StatusForbidden, []string{"FORBIDDEN"}},
+	ErrNotFound:      {http.StatusNotFound, []string{"NOT_FOUND"}},
+	ErrQuotaExceeded: {http.StatusPaymentRequired, []string{"QUOTA_EXCEEDED", "TUNNEL_LIMIT_REACHED"}},
+	ErrRateLimited:   {http.StatusTooManyRequests, []string{"RATE_LIMITED"}},
+}
+
+// Is reports whether e is the kind of failure target names, letting
+// errors.Is match an *APIError against the sentinel errors.
+func (e *APIError) Is(target error) bool {
+	s, ok := sentinels[target]
+	if !ok {
+		return false
+	}
+	if e.HTTPStatus == s.status {
+		return true
+	}
+	for _, code := range s.codes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether err means the requested resource does not
+// exist.
+func IsNotFound(err error) bool { return errors.Is(err, ErrNotFound) }
+
+// IsUnauthorized reports whether err means the API key is missing or was
+// rejected.
+func IsUnauthorized(err error) bool { return errors.Is(err, ErrUnauthorized) }
+
+// IsForbidden reports whether err means the API key is valid but not
+// allowed to do this.
+func IsForbidden(err error) bool { return errors.Is(err, ErrForbidden) }
+
+// IsQuotaExceeded reports whether err means a plan limit was reached.
+func IsQuotaExceeded(err error) bool { return errors.Is(err, ErrQuotaExceeded) }
+
+// IsRateLimited reports whether err means too many requests were sent.
+func IsRateLimited(err error) bool { return errors.Is(err, ErrRateLimited) }
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// timeLayouts are the timestamp formats accepted in API responses, most
+// common first. Besides RFC 3339 (with or without fractional seconds),
+// some servers omit the colon in the offset or use a space separator.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseTime parses an API timestamp in any of timeLayouts. Timestamps
+// without an offset are taken to be UTC.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// UnmarshalJSON decodes a tunnel, accepting the timestamp variants in
+// timeLayouts for created_at and expires_at.
+func (t *TunnelResponse) UnmarshalJSON(data []byte) error {
+	type plain TunnelResponse
+	aux := struct {
+		*plain
+		CreatedAt *string `json:"created_at"`
+		ExpiresAt *string `json:"expires_at"`
+	}{plain: (*plain)(t)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.CreatedAt != nil && *aux.CreatedAt != "" {
+		ts, err := parseTime(*aux.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("created_at: %w", err)
+		}
+		t.CreatedAt = ts
+	}
+	t.ExpiresAt = nil
+	if aux.ExpiresAt != nil && *aux.ExpiresAt != "" {
+		ts, err := parseTime(*aux.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("expires_at: %w", err)
+		}
+		t.ExpiresAt = &ts
+	}
+	return nil
+}
+
+// TimeUntilExpiry returns how long remains until the tunnel expires, which
+// is negative once it has. ok is false if the tunnel never expires.
+func (t *TunnelResponse) TimeUntilExpiry() (d time.Duration, ok bool) {
+	if t.ExpiresAt == nil {
+		return 0, false
+	}
+	return time.Until(*t.ExpiresAt), true
+}
+
+// IsExpired reports whether the tunnel's expiry time has passed.
+func (t *TunnelResponse) IsExpired() bool {
+	d, ok := t.TimeUntilExpiry()
+	return ok && d <= 0
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Label length limits for tunnel names and subdomains.
+const (
+	MinLabelLength = 3
+	MaxLabelLength = 63
+)
+
+// NormalizeLabel lowercases s and checks that it is usable as a tunnel name
+// or subdomain: a DNS label of MinLabelLength to MaxLabelLength letters,
+// digits, and hyphens that starts and ends with a letter or digit.
+func NormalizeLabel(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) < MinLabelLength || len(s) > MaxLabelLength {
+		return "", fmt.Errorf("must be %d-%d characters long", MinLabelLength, MaxLabelLength)
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case c == '-':
+			if i == 0 || i == len(s)-1 {
+				return "", fmt.Errorf("must start and end with a letter or digit")
+			}
+		default:
+			return "", fmt.Errorf("may only contain letters, digits, and hyphens")
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  string
+	}{
+		{in: "ab", wantErr: "3-63 characters"},
+		{in: "abc", want: "abc"},
+		{in: strings.Repeat("a", 63), want: strings.Repeat("a", 63)},
+		{in: strings.Repeat("a", 64), wantErr: "3-63 characters"},
+		{in: "  MyApp-2 ", want: "myapp-2"},
+		{in: "-app", wantErr: "start and end"},
+		{in: "app-", wantErr: "start and end"},
+		{in: "my_app", wantErr: "letters, digits, and hyphens"},
+		{in: "my.app", wantErr: "letters, digits, and hyphens"},
+		{in: "café", wantErr: "letters, digits, and hyphens"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeLabel(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NormalizeLabel(%q) = %q, %v; want an error mentioning %q", tt.in, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeLabel(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/carloluisito/launchtunnel-cli/client"
 	"github.com/carloluisito/launchtunnel-cli/display"
@@ -18,6 +21,7 @@ func newAPIKeyCmd() *cobra.Command {
 	cmd.AddCommand(
 		newAPIKeyCreateCmd(),
 		newAPIKeyListCmd(),
+		newAPIKeyRenameCmd(),
 		newAPIKeyRevokeCmd(),
 	)
 
@@ -25,58 +29,114 @@ func newAPIKeyCmd() *cobra.Command {
 }
 
 func newAPIKeyCreateCmd() *cobra.Command {
-	var name string
+	var (
+		name       string
+		show       bool
+		jsonOutput bool
+		outputFile string
+	)
 
 	cmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create a new API key",
-		Args:  cobra.NoArgs,
+		Long: `Create a new API key.
+
+The secret is shown only once. To keep it out of shell history and
+scrollback it is masked by default; pass --show to print it in full,
+--output-file to write it to a file readable only by you, or --json to
+get the full key for scripting.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
 			key, err := c.CreateAPIKey(name)
+			if isDryRun(err) {
+				return nil
+			}
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
+			}
+
+			if outputFile != "" {
+				if err := os.WriteFile(outputFile, []byte(key.Key+"\n"), 0600); err != nil {
+					failf("API key created, but writing %s failed: %v\nRevoke it with 'lt api-key revoke %s'.", outputFile, err, key.ID)
+				}
 			}
 
-			fmt.Printf("API key created: %s  (save this -- it will not be shown again)\n", key.Key)
+			switch {
+			case jsonOutput:
+				return display.PrintJSON(os.Stdout, key)
+			case show:
+				fmt.Printf("API key created: %s  (save this -- it will not be shown again)\n", key.Key)
+			case outputFile != "":
+				fmt.Printf("API key created: %s  (written to %s)\n", maskKey(key.Key), outputFile)
+			default:
+				fmt.Printf("API key created: %s\n", maskKey(key.Key))
+				fmt.Println("The full key is hidden and cannot be retrieved later. To keep a key, create it with --show")
+				fmt.Printf("or --output-file, and revoke this one with 'lt api-key revoke %s'.\n", key.ID)
+			}
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVar(&name, "name", "", "human-readable label for this key")
+	cmd.Flags().BoolVar(&show, "show", false, "print the full key instead of a masked one")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "print the created key, including the secret, as JSON")
+	cmd.Flags().StringVar(&outputFile, "output-file", "", "write the full key to this file with 0600 permissions")
 	return cmd
 }
 
+// maskKey returns the first 8 characters of key followed by "...".
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "..."
+	}
+	return key[:8] + "..."
+}
+
 func newAPIKeyListCmd() *cobra.Command {
-	var jsonOutput bool
+	var output outputFlags
 
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all API keys",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := output.resolve()
+			if err != nil {
+				fail(err)
+			}
+
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
 			keys, err := c.ListAPIKeys()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			if jsonOutput {
+			switch format {
+			case outputJSON:
 				return display.PrintJSON(os.Stdout, keys)
+			case outputYAML:
+				return display.PrintYAML(os.Stdout, keys)
+			case outputCSV:
+				rows := make([][]string, 0, len(keys))
+				for _, k := range keys {
+					lastUsed := ""
+					if k.LastUsedAt != nil {
+						lastUsed = k.LastUsedAt.Format(time.RFC3339)
+					}
+					rows = append(rows, []string{k.ID, k.Prefix, k.Name, k.CreatedAt.Format(time.RFC3339), lastUsed})
+				}
+				return display.PrintCSV(os.Stdout, []string{"id", "prefix", "name", "created_at", "last_used_at"}, rows)
 			}
 
 			if len(keys) == 0 {
@@ -102,53 +162,124 @@ func newAPIKeyListCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output as JSON")
+	addOutputFlags(cmd, &output)
 	return cmd
 }
 
 func newAPIKeyRevokeCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "revoke <key_prefix>",
-		Short: "Revoke an API key by its prefix",
+		Use:   "revoke <key_id_or_prefix>",
+		Short: "Revoke an API key by its ID or prefix",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
+			if err := revokeAPIKey(c, args[0]); err != nil {
+				if isDryRun(err) {
+					return nil
+				}
+				fail(err)
+			}
 
-			// The prefix is the first 8+ characters. We need to find the key ID
-			// by listing keys and matching on the prefix.
-			keys, err := c.ListAPIKeys()
+			fmt.Printf("API key %s revoked. Active tunnels using this key have been terminated.\n", args[0])
+			return nil
+		},
+	}
+}
+
+// revokeAPIKey revokes the key identified by ref, which is either a key
+// ID or a key prefix. IDs are tried first, so the common case needs no
+// listing.
+func revokeAPIKey(c *client.Client, ref string) error {
+	err := c.RevokeAPIKey(ref)
+	if err == nil {
+		return nil
+	}
+	if !client.IsNotFound(err) && !errors.Is(err, client.ErrBadRequest) {
+		return err
+	}
+
+	keyID, err := apiKeyIDByPrefix(c, ref)
+	if err != nil {
+		return err
+	}
+	return c.RevokeAPIKey(keyID)
+}
+
+func newAPIKeyRenameCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "rename <key_prefix> <new_name>",
+		Short: "Rename an API key",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			prefix := args[0]
-			var matchedID string
-			for _, k := range keys {
-				if k.Prefix == prefix {
-					matchedID = k.ID
-					break
-				}
-			}
+			c := newClient(apiKey)
 
-			if matchedID == "" {
-				fmt.Fprintf(os.Stderr, "No API key found with prefix %s.\n", prefix)
-				os.Exit(1)
+			prefix, name := args[0], args[1]
+			keyID, err := apiKeyIDByPrefix(c, prefix)
+			if err != nil {
+				fail(err)
 			}
 
-			if err := c.RevokeAPIKey(matchedID); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+			key, err := c.UpdateAPIKey(keyID, name)
+			if isDryRun(err) {
+				return nil
+			}
+			if err != nil {
+				fail(err)
 			}
 
-			fmt.Printf("API key %s... revoked. Active tunnels using this key have been terminated.\n", prefix)
+			if jsonOutput {
+				return display.PrintJSON(os.Stdout, key)
+			}
+			fmt.Printf("API key %s... renamed to %q.\n", prefix, name)
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "print the updated key as JSON")
+	return cmd
+}
+
+// apiKeyIDByPrefix returns the ID of the API key with the given prefix.
+// The API addresses keys by ID, but users only ever see the prefix, so
+// this lists the keys and matches on it. It is an error for the prefix to
+// match more than one key.
+func apiKeyIDByPrefix(c *client.Client, prefix string) (string, error) {
+	keys, err := c.ListAPIKeys()
+	if err != nil {
+		return "", err
+	}
+	var matches []client.APIKeyResponse
+	for _, k := range keys {
+		if k.Prefix == prefix {
+			matches = append(matches, k)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("No API key found with prefix %s.", prefix)
+	case 1:
+		return matches[0].ID, nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Prefix %s matches %d API keys. Use the key ID instead:", prefix, len(matches))
+	for _, k := range matches {
+		fmt.Fprintf(&b, "\n  %s", k.ID)
+		if k.Name != "" {
+			fmt.Fprintf(&b, "  (%s)", k.Name)
+		}
+	}
+	return "", errors.New(b.String())
 }
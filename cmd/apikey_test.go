@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+)
+
+// fakeKeysAPI serves the API key endpoints for keys and records the IDs
+// that were revoked.
+func fakeKeysAPI(t *testing.T, keys string, ids ...string) (*client.Client, *[]string) {
+	t.Helper()
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+	var revoked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/api-keys/")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/v1/api-keys":
+			w.Write([]byte(`{"api_keys": ` + keys + `}`))
+		case r.Method == "DELETE" && known[id]:
+			revoked = append(revoked, id)
+			w.Write([]byte(`{"deleted": true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "NOT_FOUND", "message": "API key not found"}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return client.New(srv.URL, "key"), &revoked
+}
+
+func TestRevokeAPIKey_ByID(t *testing.T) {
+	c, revoked := fakeKeysAPI(t, `[]`, "key_1")
+
+	if err := revokeAPIKey(c, "key_1"); err != nil {
+		t.Fatalf("revokeAPIKey: %v", err)
+	}
+	if len(*revoked) != 1 || (*revoked)[0] != "key_1" {
+		t.Errorf("revoked = %v, want [key_1]", *revoked)
+	}
+}
+
+func TestRevokeAPIKey_UniquePrefix(t *testing.T) {
+	c, revoked := fakeKeysAPI(t,
+		`[{"id": "key_1", "prefix": "lt_aaaa"}, {"id": "key_2", "prefix": "lt_bbbb"}]`,
+		"key_1", "key_2")
+
+	if err := revokeAPIKey(c, "lt_bbbb"); err != nil {
+		t.Fatalf("revokeAPIKey: %v", err)
+	}
+	if len(*revoked) != 1 || (*revoked)[0] != "key_2" {
+		t.Errorf("revoked = %v, want [key_2]", *revoked)
+	}
+}
+
+func TestRevokeAPIKey_AmbiguousPrefix(t *testing.T) {
+	c, revoked := fakeKeysAPI(t,
+		`[{"id": "key_1", "prefix": "lt_aaaa", "name": "laptop"}, {"id": "key_2", "prefix": "lt_aaaa", "name": "ci"}]`,
+		"key_1", "key_2")
+
+	err := revokeAPIKey(c, "lt_aaaa")
+	if err == nil {
+		t.Fatal("revokeAPIKey succeeded, want ambiguity error")
+	}
+	for _, want := range []string{"key_1", "key_2", "laptop", "ci"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+	if len(*revoked) != 0 {
+		t.Errorf("revoked = %v, want none", *revoked)
+	}
+}
+
+func TestRevokeAPIKey_NotFound(t *testing.T) {
+	c, _ := fakeKeysAPI(t, `[{"id": "key_1", "prefix": "lt_aaaa"}]`, "key_1")
+
+	err := revokeAPIKey(c, "lt_zzzz")
+	if err == nil || !strings.Contains(err.Error(), "No API key found") {
+		t.Errorf("revokeAPIKey: got %v, want not-found error", err)
+	}
+}
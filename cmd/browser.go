@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// openBrowser opens url in the user's default browser (best-effort).
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	_ = cmd.Start()
+}
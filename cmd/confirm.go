@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/carloluisito/launchtunnel-cli/display"
+)
+
+// canPrompt reports whether the user can answer a confirmation prompt.
+func canPrompt() bool {
+	return display.IsTerminal(os.Stdin) && display.IsTerminal(os.Stderr)
+}
+
+// confirm asks question on stderr and reads a y/N answer from stdin. Only
+// "y" or "yes" count as agreement.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// confirmDestructive guards an action that cannot be undone. It succeeds
+// without asking when yes is set, prompts when the user can answer, and
+// otherwise fails so that scripts must pass --yes explicitly. Nothing is
+// destroyed under --dry-run, so it never asks then.
+func confirmDestructive(question string, yes bool) error {
+	if yes || flagDryRun {
+		return nil
+	}
+	if !canPrompt() {
+		return fmt.Errorf("Refusing to continue without confirmation. Pass --yes to proceed.")
+	}
+	if !confirm(question) {
+		return fmt.Errorf("Aborted.")
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+	"golang.org/x/term"
+)
+
+const (
+	dashboardRefresh     = 500 * time.Millisecond
+	dashboardMaxRequests = 200
+	dashboardMaxMessages = 5
+)
+
+// dashboard is the full-screen view shown by --tui. It collects request
+// and connection events and redraws them in place until stopped.
+type dashboard struct {
+	sessions []*tunnelSession
+	metrics  *tunnel.Metrics
+
+	mu       sync.Mutex
+	requests []tunnel.Event // oldest first
+	messages []string
+	state    map[string]string // tunnel ID -> connection state
+}
+
+func newDashboard(sessions []*tunnelSession, metrics *tunnel.Metrics) *dashboard {
+	d := &dashboard{
+		sessions: sessions,
+		metrics:  metrics,
+		state:    make(map[string]string),
+	}
+	for _, s := range sessions {
+		d.state[s.tun.ID] = "connecting"
+	}
+	return d
+}
+
+// Emit implements tunnel.EventSink.
+func (d *dashboard) Emit(e tunnel.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch e.Type {
+	case tunnel.EventRequest:
+		d.requests = append(d.requests, e)
+		if len(d.requests) > dashboardMaxRequests {
+			d.requests = d.requests[len(d.requests)-dashboardMaxRequests:]
+		}
+	case tunnel.EventConnected, tunnel.EventReconnected:
+		d.state[e.TunnelID] = "connected"
+	case tunnel.EventDisconnected:
+		d.state[e.TunnelID] = "disconnected"
+	case tunnel.EventReconnecting:
+		d.state[e.TunnelID] = fmt.Sprintf("reconnecting (attempt %d)", e.Attempt)
+	case tunnel.EventTunnelStopped:
+		d.state[e.TunnelID] = "stopped"
+	}
+}
+
+// addMessage records a line of warning output for the message pane.
+func (d *dashboard) addMessage(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.messages = append(d.messages, time.Now().Format("15:04:05")+"  "+line)
+	if len(d.messages) > dashboardMaxMessages {
+		d.messages = d.messages[len(d.messages)-dashboardMaxMessages:]
+	}
+}
+
+// start switches the terminal to the dashboard and redraws it until the
+// returned stop function is called. Pressing q or Ctrl+C calls cancel.
+// Anything written to stderr meanwhile is shown in the message pane.
+func (d *dashboard) start(cancel context.CancelFunc) (stop func(), err error) {
+	stdinFd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(stdinFd)
+	if err != nil {
+		return nil, fmt.Errorf("--tui: %w", err)
+	}
+
+	origStderr := os.Stderr
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		term.Restore(stdinFd, oldState)
+		return nil, fmt.Errorf("--tui: %w", err)
+	}
+	os.Stderr = pw
+	tunnel.Stderr = pw
+	piped := make(chan struct{})
+	go func() {
+		defer close(piped)
+		sc := bufio.NewScanner(pr)
+		for sc.Scan() {
+			d.addMessage(sc.Text())
+		}
+	}()
+
+	// Reading stdin cannot be interrupted, so this goroutine outlives
+	// the dashboard; it exits with the process.
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			if bytes.ContainsAny(buf[:n], "qQ\x03") {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(dashboardRefresh)
+		defer ticker.Stop()
+		for {
+			d.draw(os.Stdout)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+		os.Stderr = origStderr
+		tunnel.Stderr = origStderr
+		pw.Close()
+		<-piped
+		pr.Close()
+		fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+		term.Restore(stdinFd, oldState)
+	}, nil
+}
+
+// draw renders one frame of the dashboard to w.
+func (d *dashboard) draw(w io.Writer) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	var stats map[string]tunnel.TunnelStats
+	if d.metrics != nil {
+		stats = d.metrics.Snapshot()
+	}
+
+	d.mu.Lock()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s  %s\n\n", display.Bold("LaunchTunnel"), "(press q to quit)")
+
+	var totalIn, totalOut int64
+	tt := display.NewTable("PUBLIC URL", "LOCAL", "STATE", "IN", "OUT", "RECONNECTS")
+	tt.SetColumnColorizer(2, display.StatusColor)
+	tt.SetAlign(3, display.AlignRight)
+	tt.SetAlign(4, display.AlignRight)
+	tt.SetAlign(5, display.AlignRight)
+	for _, s := range d.sessions {
+		st := stats[s.tun.ID]
+		totalIn += st.BytesIn
+		totalOut += st.BytesOut
+		tt.AddRow(
+			s.tun.PublicURL,
+			tunnel.LocalTarget(s.localHost, s.localPort),
+			d.state[s.tun.ID],
+			display.FormatBytes(st.BytesIn),
+			display.FormatBytes(st.BytesOut),
+			strconv.FormatInt(st.Reconnects, 10),
+		)
+	}
+	tt.Render(&buf)
+	if len(d.sessions) > 1 {
+		fmt.Fprintf(&buf, "Total: %s in, %s out\n", display.FormatBytes(totalIn), display.FormatBytes(totalOut))
+	}
+	buf.WriteString("\n")
+
+	// Fit as many recent requests as the terminal has room for, keeping
+	// space for the table header and the message pane.
+	used := strings.Count(buf.String(), "\n") + 2
+	if len(d.messages) > 0 {
+		used += len(d.messages) + 2
+	}
+	rows := height - used
+	reqs := d.requests
+	if rows < 0 {
+		rows = 0
+	}
+	if len(reqs) > rows {
+		reqs = reqs[len(reqs)-rows:]
+	}
+	rt := display.NewTable("TIME", "METHOD", "PATH", "STATUS", "DURATION")
+	rt.SetColumnColorizer(3, httpStatusColor)
+	rt.SetAlign(4, display.AlignRight)
+	pathWidth := width - 40
+	for i := len(reqs) - 1; i >= 0; i-- {
+		e := reqs[i]
+		rt.AddRow(
+			e.Time.Format("15:04:05"),
+			e.Method,
+			truncate(e.Path, pathWidth),
+			strconv.Itoa(e.Status),
+			fmt.Sprintf("%dms", e.DurationMs),
+		)
+	}
+	rt.Render(&buf)
+
+	if len(d.messages) > 0 {
+		buf.WriteString("\n")
+		for _, m := range d.messages {
+			fmt.Fprintln(&buf, truncate(m, width))
+		}
+	}
+	d.mu.Unlock()
+
+	// The terminal is in raw mode, so each line needs an explicit
+	// carriage return.
+	frame := strings.ReplaceAll(buf.String(), "\n", "\x1b[K\r\n")
+	fmt.Fprint(w, "\x1b[H"+frame+"\x1b[J")
+}
+
+// httpStatusColor colors an HTTP status code by class.
+func httpStatusColor(code string) string {
+	switch {
+	case strings.HasPrefix(code, "2"), strings.HasPrefix(code, "3"):
+		return display.Green(code)
+	case strings.HasPrefix(code, "4"):
+		return display.Yellow(code)
+	case strings.HasPrefix(code, "5"):
+		return display.Red(code)
+	default:
+		return code
+	}
+}
+
+// truncate shortens s to at most n bytes, marking the cut with "...".
+func truncate(s string, n int) string {
+	if n < 4 {
+		n = 4
+	}
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/spf13/cobra"
+)
+
+func newDeleteCmd() *cobra.Command {
+	var (
+		all bool
+		yes bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "delete [tunnel_id]",
+		Short: "Stop and permanently delete one or all tunnels",
+		Long: `Stop and permanently delete one or all tunnels.
+
+Unlike 'lt stop', which only stops a tunnel, delete also removes it from
+the server: it disappears from 'lt list' and its public URL is released.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !all && len(args) == 0 {
+				failf("Provide a tunnel ID or use --all to delete all tunnels.")
+			}
+
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+
+			if all {
+				tunnels, err := c.ListTunnels()
+				if err != nil {
+					fail(err)
+				}
+				if len(tunnels) == 0 {
+					infof("No tunnels.\n")
+					return nil
+				}
+				if err := confirmDestructive(fmt.Sprintf("Delete all %d tunnel(s)? This cannot be undone.", len(tunnels)), yes); err != nil {
+					fail(err)
+				}
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+				defer stop()
+				succeeded, failed := applyToTunnels(ctx, tunnels, c.DeleteTunnel, "delete")
+				reportApplied(ctx, "Deleted", len(tunnels), succeeded, failed)
+				return nil
+			}
+
+			tunnelID := args[0]
+			if err := c.DeleteTunnel(tunnelID); err != nil {
+				if isDryRun(err) {
+					return nil
+				}
+				if client.IsNotFound(err) {
+					fail(notFoundf("Tunnel %s not found.", tunnelID))
+				}
+				fail(err)
+			}
+
+			infof("Tunnel %s deleted.\n", tunnelID)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "delete all tunnels")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "do not ask for confirmation")
+	return cmd
+}
@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/config"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+	"github.com/spf13/cobra"
+)
+
+// checkStatus is the outcome of one doctor check.
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+	checkSkip
+)
+
+// checkResult is one line of the doctor checklist. hint tells the user
+// what to do about a failure or warning.
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+	hint   string
+}
+
+// doctorTimeout bounds each network check.
+const doctorTimeout = 10 * time.Second
+
+func newDoctorCmd() *cobra.Command {
+	var (
+		localHost string
+		port      int
+		relay     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose configuration, authentication, and connectivity problems",
+		Long: `Diagnose configuration, authentication, and connectivity problems.
+
+Checks that the config file can be read, that you are logged in with a
+valid API key, that the control plane and relay can be reached, and, with
+--port, that the local server is accepting connections.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{annotationConfigOptional: ""},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			localHost, err := resolveLocalHost(localHost)
+			if err != nil {
+				fail(err)
+			}
+
+			results := []checkResult{checkConfig()}
+			creds, apiKey := checkCredentials()
+			results = append(results, creds)
+			api, c := checkControlPlane(apiKey)
+			results = append(results, api)
+			results = append(results, checkRelay(c, relay))
+			results = append(results, checkLocal(localHost, port))
+
+			failed := printChecklist(results)
+			if failed {
+				os.Exit(exitError)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVarP(&port, "port", "p", 0, "local port to check")
+	cmd.Flags().StringVar(&localHost, "local-host", "", "local host to check (default from config, usually 127.0.0.1)")
+	cmd.Flags().StringVar(&relay, "relay", "", "relay endpoint to check (default: from your tunnels)")
+	return cmd
+}
+
+// printChecklist prints results and reports whether any check failed.
+func printChecklist(results []checkResult) bool {
+	tbl := display.NewTable("", "CHECK", "RESULT")
+	tbl.SetColumnColorizer(0, func(mark string) string {
+		switch mark {
+		case "ok":
+			return display.Green(mark)
+		case "FAIL":
+			return display.Red(mark)
+		default:
+			return display.Yellow(mark)
+		}
+	})
+	failed := false
+	var hints []string
+	for _, r := range results {
+		mark := "ok"
+		switch r.status {
+		case checkWarn:
+			mark = "warn"
+		case checkFail:
+			mark = "FAIL"
+			failed = true
+		case checkSkip:
+			mark = "skip"
+		}
+		tbl.AddRow(mark, r.name, r.detail)
+		if r.hint != "" && r.status != checkPass {
+			hints = append(hints, fmt.Sprintf("%s: %s", r.name, r.hint))
+		}
+	}
+	tbl.Render(os.Stdout)
+
+	if len(hints) > 0 {
+		fmt.Println()
+		for _, h := range hints {
+			fmt.Println("  " + h)
+		}
+	}
+	return failed
+}
+
+func checkConfig() checkResult {
+	r := checkResult{name: "Config file"}
+	path, err := config.ConfigPath(flagConfigPath)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+	if cliCfgErr != nil {
+		r.status, r.detail = checkFail, cliCfgErr.Error()
+		r.hint = fmt.Sprintf("Fix or remove %s; the defaults apply without it.", path)
+		return r
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		r.detail = "not found, using defaults"
+		return r
+	}
+	r.detail = path
+	return r
+}
+
+// checkCredentials returns the API key if one is configured.
+func checkCredentials() (checkResult, string) {
+	r := checkResult{name: "Credentials"}
+	apiKey, err := requireAuth()
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r, ""
+	}
+	r.detail = "API key " + maskKey(apiKey)
+	if os.Getenv(apiKeyEnv) != "" {
+		r.detail += " from " + apiKeyEnv
+	}
+	return r, apiKey
+}
+
+// checkControlPlane verifies apiKey against the control plane, which also
+// shows whether it can be reached. It returns an authenticated client, or
+// nil if the key could not be verified.
+func checkControlPlane(apiKey string) (checkResult, *client.Client) {
+	r := checkResult{name: "Control plane"}
+	c := newClient(apiKey)
+	start := time.Now()
+	user, err := c.VerifyAPIKey()
+	latency := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		var apiErr *client.APIError
+		if !errors.As(err, &apiErr) {
+			r.status, r.detail = checkFail, fmt.Sprintf("%s unreachable: %v", cliCfg.APIURL, err)
+			r.hint = "Check your internet connection, proxy settings (--proxy), and --api-url."
+			return r, nil
+		}
+		r.status = checkFail
+		r.detail = fmt.Sprintf("%s reachable (%s), but %s", cliCfg.APIURL, latency, apiErr.Message)
+		if apiKey != "" && (client.IsUnauthorized(err) || client.IsForbidden(err)) {
+			r.hint = "The API key was rejected. Run 'lt login' again."
+		} else if apiKey == "" {
+			r.status = checkWarn
+			r.detail = fmt.Sprintf("%s reachable (%s)", cliCfg.APIURL, latency)
+		}
+		return r, nil
+	}
+
+	r.detail = fmt.Sprintf("%s (%s), logged in as %s", cliCfg.APIURL, latency, user.User.Email)
+	if latency > 2*time.Second {
+		r.status = checkWarn
+		r.hint = "The control plane is slow to respond; expect slow commands."
+	}
+	return r, c
+}
+
+// checkRelay opens and immediately closes a WebSocket to the relay. Without
+// a session token the relay rejects the upgrade, but getting an HTTP
+// response at all shows it is reachable.
+func checkRelay(c *client.Client, endpoint string) checkResult {
+	r := checkResult{name: "Relay"}
+	token := ""
+	if endpoint == "" {
+		endpoint, token = knownRelay(c)
+	}
+	if endpoint == "" {
+		r.status, r.detail = checkSkip, "no relay endpoint known"
+		r.hint = "Pass --relay, or run again while a tunnel is active."
+		return r
+	}
+
+	u, err := tunnel.ParseRelayEndpoint(endpoint)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		r.hint = "Pass a ws:// or wss:// URL to --relay."
+		return r
+	}
+	header := relayHeader.Clone()
+	if token != "" && flagRelayTokenIn == "header" {
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set(tunnel.SessionTokenHeader, token)
+	} else if token != "" {
+		q := u.Query()
+		q.Set("session_token", token)
+		u.RawQuery = q.Encode()
+	}
+	target := u.String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	start := time.Now()
+	conn, resp, err := websocket.Dial(ctx, target, &websocket.DialOptions{HTTPClient: relayHTTPClient, HTTPHeader: header})
+	latency := time.Since(start).Round(time.Millisecond)
+	switch {
+	case err == nil:
+		conn.Close(websocket.StatusNormalClosure, "doctor")
+		r.detail = fmt.Sprintf("%s (%s)", endpoint, latency)
+	case resp != nil:
+		r.detail = fmt.Sprintf("%s reachable (%s, HTTP %d without a session)", endpoint, latency, resp.StatusCode)
+	default:
+		r.status, r.detail = checkFail, fmt.Sprintf("%s unreachable: %v", endpoint, err)
+		r.hint = "A firewall or proxy may be blocking WebSockets; try --proxy."
+	}
+	return r
+}
+
+// knownRelay returns a relay endpoint, and its session token if known,
+// from the running tunnels' saved state or else from the tunnel list.
+func knownRelay(c *client.Client) (endpoint, token string) {
+	if state, _ := config.LoadActiveState(); state != nil {
+		for _, t := range state.Tunnels {
+			if t.RelayEndpoint != "" {
+				return t.RelayEndpoint, t.SessionToken
+			}
+		}
+	}
+	if c == nil {
+		return "", ""
+	}
+	tunnels, err := c.ListTunnels()
+	if err != nil {
+		return "", ""
+	}
+	for _, t := range tunnels {
+		if t.RelayEndpoint != "" {
+			return t.RelayEndpoint, ""
+		}
+	}
+	return "", ""
+}
+
+func checkLocal(host string, port int) checkResult {
+	r := checkResult{name: "Local server"}
+	if port == 0 {
+		r.status, r.detail = checkSkip, "no port given"
+		r.hint = "Pass --port to check the server you want to expose."
+		return r
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		r.status, r.detail = checkFail, fmt.Sprintf("%s: %v", addr, err)
+		r.hint = fmt.Sprintf("Start your server on port %d, or check --local-host.", port)
+		return r
+	}
+	conn.Close()
+	r.detail = addr + " accepting connections"
+	return r
+}
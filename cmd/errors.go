@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/spf13/cobra"
+)
+
+// Exit statuses. Authentication failures exit with exitError like most
+// others; scripts can tell them apart by the error code under --json.
+const (
+	exitError      = 1
+	exitConnection = 2 // the control plane or relay is unreachable, or the connection was lost
+	exitNotFound   = 3
+)
+
+// Error codes reported under --json, in the style of the API's own.
+// Errors from the API keep the API's code.
+const (
+	codeError        = "ERROR"
+	codeUnauthorized = "UNAUTHORIZED"
+	codeNotFound     = "NOT_FOUND"
+	codeConnection   = "CONNECTION_FAILED"
+)
+
+// jsonErrors is set when the command being run was asked for JSON output,
+// so failures are reported as JSON too.
+var jsonErrors bool
+
+// wantsJSON reports whether cmd was asked for JSON output, with --json or
+// --output json.
+func wantsJSON(cmd *cobra.Command) bool {
+	if f := cmd.Flags().Lookup("json"); f != nil && f.Value.String() == "true" {
+		return true
+	}
+	f := cmd.Flags().Lookup("output")
+	return f != nil && f.Value.String() == outputJSON
+}
+
+// cliError is an error with the code and exit status fail reports for it.
+type cliError struct {
+	code   string
+	status int
+	msg    string
+}
+
+func (e *cliError) Error() string { return e.msg }
+
+// unauthorizedf returns an error that fail reports as UNAUTHORIZED.
+func unauthorizedf(format string, args ...any) error {
+	return &cliError{code: codeUnauthorized, status: exitError, msg: fmt.Sprintf(format, args...)}
+}
+
+// notFoundf returns an error that fail reports as NOT_FOUND.
+func notFoundf(format string, args ...any) error {
+	return &cliError{code: codeNotFound, status: exitNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+// connectionErrorf returns an error that fail reports as CONNECTION_FAILED.
+func connectionErrorf(format string, args ...any) error {
+	return &cliError{code: codeConnection, status: exitConnection, msg: fmt.Sprintf(format, args...)}
+}
+
+// errUnreachable is reported when the control plane cannot be reached.
+var errUnreachable = connectionErrorf("Unable to reach LaunchTunnel servers. Check your internet connection.")
+
+// describeError returns the code, exit status, and message fail reports
+// for err.
+func describeError(err error) (code string, status int, msg string) {
+	var cliErr *cliError
+	if errors.As(err, &cliErr) {
+		return cliErr.code, cliErr.status, cliErr.msg
+	}
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		code, status = apiErr.Code, exitError
+		switch {
+		case client.IsNotFound(err):
+			code, status = codeNotFound, exitNotFound
+		case client.IsUnauthorized(err):
+			code = codeUnauthorized
+		}
+		if code == "" {
+			code = codeError
+		}
+		// Show the API's message, keeping any context wrapped around it.
+		msg = strings.Replace(err.Error(), apiErr.Error(), apiErr.Message, 1)
+		if client.IsQuotaExceeded(err) {
+			msg += "\nUpgrade for higher limits at " + upgradeURL()
+		}
+		return code, status, msg
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return codeConnection, exitConnection, err.Error()
+	}
+	return codeError, exitError, err.Error()
+}
+
+// writeError reports err to w, as {"error":{"code":...,"message":...}} if
+// asJSON is set, and returns the exit status for it.
+func writeError(w io.Writer, asJSON bool, err error) int {
+	code, status, msg := describeError(err)
+	if !asJSON {
+		fmt.Fprintln(w, msg)
+		return status
+	}
+	var env struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	env.Error.Code, env.Error.Message = code, msg
+	_ = display.PrintJSON(w, env)
+	return status
+}
+
+// fail reports err and exits. Under --json the error is printed to stdout
+// as JSON, where scripts read the command's output; otherwise its message
+// goes to stderr.
+func fail(err error) {
+	w := os.Stderr
+	if jsonErrors {
+		w = os.Stdout
+	}
+	os.Exit(writeError(w, jsonErrors, err))
+}
+
+// failf is fail with a formatted message.
+func failf(format string, args ...any) {
+	fail(fmt.Errorf(format, args...))
+}
+
+// failAPI reports a failed control plane request: the API's error, or
+// that the servers could not be reached.
+func failAPI(err error) {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		fail(err)
+	}
+	fail(errUnreachable)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+)
+
+func TestDescribeError(t *testing.T) {
+	oldCfg := cliCfg
+	defer func() { cliCfg = oldCfg }()
+	cliCfg.FrontendURL = "https://app.launchtunnel.dev"
+
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   string
+		wantStatus int
+		wantMsg    string
+	}{
+		{"plain", errors.New("boom"), codeError, exitError, "boom"},
+		{"not found", notFoundf("Tunnel %s not found.", "tun_1"), codeNotFound, exitNotFound, "Tunnel tun_1 not found."},
+		{"unauthorized", unauthorizedf("Not authenticated."), codeUnauthorized, exitError, "Not authenticated."},
+		{"unreachable", errUnreachable, codeConnection, exitConnection, errUnreachable.Error()},
+		{"network", fmt.Errorf("listing tunnels: %w", dialErr), codeConnection, exitConnection, "listing tunnels: dial tcp: connection refused"},
+		{"API not found", &client.APIError{HTTPStatus: 404, Code: "TUNNEL_NOT_FOUND", Message: "no such tunnel"}, codeNotFound, exitNotFound, "no such tunnel"},
+		{"API unauthorized", &client.APIError{HTTPStatus: 401, Message: "bad key"}, codeUnauthorized, exitError, "bad key"},
+		{"API other", &client.APIError{HTTPStatus: 409, Code: "SUBDOMAIN_TAKEN", Message: "taken"}, "SUBDOMAIN_TAKEN", exitError, "taken"},
+		{"API quota", &client.APIError{HTTPStatus: 402, Code: "TUNNEL_LIMIT_REACHED", Message: "tunnel limit reached"}, "TUNNEL_LIMIT_REACHED", exitError,
+			"tunnel limit reached\nUpgrade for higher limits at https://app.launchtunnel.dev/settings/billing"},
+		{"API wrapped", fmt.Errorf("web: %w", &client.APIError{HTTPStatus: 500, Message: "oops"}), codeError, exitError, "web: oops"},
+	}
+	for _, tt := range tests {
+		code, status, msg := describeError(tt.err)
+		if code != tt.wantCode || status != tt.wantStatus || msg != tt.wantMsg {
+			t.Errorf("%s: describeError = %q, %d, %q; want %q, %d, %q",
+				tt.name, code, status, msg, tt.wantCode, tt.wantStatus, tt.wantMsg)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	status := writeError(&buf, true, notFoundf("Tunnel %s not found.", "tun_1"))
+	if status != exitNotFound {
+		t.Errorf("status = %d, want %d", status, exitNotFound)
+	}
+	var env map[string]map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &env); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+	}
+	want := map[string]string{"code": "NOT_FOUND", "message": "Tunnel tun_1 not found."}
+	if len(env) != 1 || fmt.Sprint(env["error"]) != fmt.Sprint(want) {
+		t.Errorf("envelope = %v, want {error: %v}", env, want)
+	}
+
+	buf.Reset()
+	writeError(&buf, false, errors.New("boom"))
+	if buf.String() != "boom\n" {
+		t.Errorf("text output = %q", buf.String())
+	}
+}
+
+// TestFail_ExitStatus runs fail in a child process to see its exit status
+// and output.
+func TestFail_ExitStatus(t *testing.T) {
+	if os.Getenv("LT_TEST_FAIL") != "" {
+		jsonErrors = true
+		fail(errUnreachable)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFail_ExitStatus$")
+	cmd.Env = append(os.Environ(), "LT_TEST_FAIL=1")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitConnection {
+		t.Fatalf("child exited with %v, want status %d", err, exitConnection)
+	}
+	var env struct {
+		Error struct{ Code, Message string }
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &env); err != nil || env.Error.Code != codeConnection {
+		t.Errorf("stdout = %q, want a %s error envelope", stdout.String(), codeConnection)
+	}
+}
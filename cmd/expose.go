@@ -2,16 +2,24 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"nhooyr.io/websocket"
 
 	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/config"
 	"github.com/carloluisito/launchtunnel-cli/display"
 	"github.com/carloluisito/launchtunnel-cli/protocol"
 	"github.com/carloluisito/launchtunnel-cli/tunnel"
@@ -20,201 +28,544 @@ import (
 
 func newExposeCmd() *cobra.Command {
 	var (
-		name        string
-		subdomain   string
-		localHost   string
-		inspect     bool
-		noReconnect bool
-		jsonOutput  bool
+		ports      []int
+		name       string
+		subdomain  string
+		localHost  string
+		region     string
+		expires    string
+		fwd        forwardFlags
+		jsonOutput bool
+		urlOnly    bool
+		idempotent bool
+		force      bool
+		duration   time.Duration
 	)
 
 	cmd := &cobra.Command{
-		Use:   "expose <protocol> <port>",
+		Use:   "expose [protocol] [port]",
 		Short: "Expose a local port to the public internet",
-		Args:  cobra.ExactArgs(2),
+		Long: `Expose a local port to the public internet.
+
+Several ports can be exposed at once by repeating --port (or passing a
+comma-separated list); each gets its own tunnel and all of them are stopped
+together on Ctrl+C.
+
+The protocol and port may be omitted when default_protocol and default_port
+are set in the config file. Arguments and --port always take precedence
+over the config defaults.`,
+		Args: cobra.RangeArgs(0, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			proto := strings.ToLower(args[0])
+			proto := cliCfg.DefaultProtocol
+			if len(args) > 0 {
+				proto = strings.ToLower(args[0])
+			}
+			if proto == "" {
+				failf("Provide a protocol to expose, e.g. 'lt expose http 3000', or set default_protocol in the config.")
+			}
 			if proto != "http" && proto != "tcp" {
-				fmt.Fprintln(os.Stderr, "Invalid protocol. Must be 'http' or 'tcp'.")
-				os.Exit(1)
+				failf("Invalid protocol. Must be 'http' or 'tcp'.")
+			}
+
+			var allPorts []int
+			if len(args) == 2 {
+				port, err := strconv.Atoi(args[1])
+				if err != nil {
+					port = 0
+				}
+				allPorts = append(allPorts, port)
+			}
+			allPorts = append(allPorts, ports...)
+			if len(allPorts) == 0 && cliCfg.DefaultPort != 0 {
+				allPorts = append(allPorts, cliCfg.DefaultPort)
+			}
+			if len(allPorts) == 0 {
+				failf("Provide a port to expose, e.g. 'lt expose http 3000'.")
+			}
+			for _, port := range allPorts {
+				if port < 1 || port > 65535 {
+					failf("Invalid port number. Port must be between 1 and 65535.")
+				}
+			}
+			if len(allPorts) > 1 && subdomain != "" {
+				failf("--subdomain cannot be used when exposing multiple ports.")
+			}
+			name, subdomain, err := normalizeNameFlags(name, subdomain, allPorts)
+			if err != nil {
+				fail(err)
+			}
+			expires, err = normalizeExpires(expires)
+			if err != nil {
+				failf("Invalid --expires value: %v.", err)
 			}
 
-			port, err := strconv.Atoi(args[1])
-			if err != nil || port < 1 || port > 65535 {
-				fmt.Fprintln(os.Stderr, "Invalid port number. Port must be between 1 and 65535.")
-				os.Exit(1)
+			opts, err := fwd.resolve()
+			if err != nil {
+				fail(err)
+			}
+			defer opts.close()
+			if jsonOutput || urlOnly {
+				// Keep stderr clean for scripts reading the output.
+				opts.stats = false
+			}
+			if duration < 0 {
+				failf("--duration must be positive.")
 			}
+			opts.duration = duration
 
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			if localHost == "" {
-				localHost = cliCfg.DefaultLocalHost
+			localHost, err = resolveLocalHost(localHost)
+			if err != nil {
+				fail(err)
+			}
+			if err := opts.checkLocalHost(localHost); err != nil {
+				fail(err)
+			}
+			region, err = resolveRegion(region)
+			if err != nil {
+				fail(err)
+			}
+			if err := checkSensitivePorts(allPorts, force); err != nil {
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
 
-			tun, err := c.CreateTunnel(client.CreateTunnelRequest{
-				Protocol:  proto,
-				LocalPort: port,
-				LocalHost: localHost,
-				Name:      name,
-				Subdomain: subdomain,
-			})
-			if err != nil {
-				if apiErr, ok := err.(*client.APIError); ok {
-					fmt.Fprintln(os.Stderr, apiErr.Message)
-					os.Exit(1)
+			var sessions []*tunnelSession
+			for _, port := range allPorts {
+				tun, err := createTunnel(c, client.CreateTunnelRequest{
+					Protocol:  proto,
+					LocalPort: port,
+					LocalHost: localHost,
+					Name:      tunnelName(name, port, len(allPorts)),
+					Subdomain: subdomain,
+					Region:    region,
+					ExpiresIn: expires,
+				}, idempotent)
+				if isDryRun(err) {
+					continue
+				}
+				if err != nil {
+					stopTunnels(c, sessions)
+					failAPI(err)
 				}
-				fmt.Fprintln(os.Stderr, "Unable to reach LaunchTunnel servers. Check your internet connection.")
-				os.Exit(1)
+				warnRegion(region, tun)
+				sessions = append(sessions, &tunnelSession{
+					tun:       tun,
+					localHost: localHost,
+					localPort: port,
+					proto:     proto,
+				})
+			}
+
+			if flagDryRun {
+				return nil
 			}
 
 			if jsonOutput {
-				display.PrintJSON(os.Stdout, map[string]any{
-					"tunnel_id":  tun.ID,
-					"public_url": tun.PublicURL,
-					"protocol":   tun.Protocol,
-					"local_host": localHost,
-					"local_port": port,
-					"status":     tun.Status,
-					"created_at": tun.CreatedAt.Format(time.RFC3339),
-				})
-			} else {
+				items := make([]map[string]any, 0, len(sessions))
+				for _, s := range sessions {
+					item := map[string]any{
+						"tunnel_id":  s.tun.ID,
+						"public_url": s.tun.PublicURL,
+						"protocol":   s.tun.Protocol,
+						"local_host": s.localHost,
+						"local_port": s.localPort,
+						"status":     s.tun.Status,
+						"created_at": s.tun.CreatedAt.Format(time.RFC3339),
+					}
+					if s.tun.Region != "" {
+						item["region"] = s.tun.Region
+					}
+					items = append(items, item)
+				}
+				if len(items) == 1 {
+					display.PrintJSON(os.Stdout, items[0])
+				} else {
+					display.PrintJSON(os.Stdout, items)
+				}
+			} else if urlOnly {
+				printURLs(sessions)
+			} else if !flagQuiet {
 				fmt.Println("Tunnel established successfully.")
 				fmt.Println()
-				fmt.Printf("  Public URL:    %s\n", tun.PublicURL)
-				fmt.Printf("  Protocol:      %s\n", tun.Protocol)
-				fmt.Printf("  Local target:  %s:%d\n", localHost, port)
-				fmt.Printf("  Tunnel ID:     %s\n", tun.ID)
-				fmt.Printf("  Status:        %s\n", tun.Status)
-				fmt.Println()
+				for _, s := range sessions {
+					fmt.Printf("  Public URL:    %s\n", s.tun.PublicURL)
+					fmt.Printf("  Protocol:      %s\n", s.tun.Protocol)
+					fmt.Printf("  Local target:  %s\n", tunnel.LocalTarget(s.localHost, s.localPort))
+					if s.tun.Region != "" {
+						fmt.Printf("  Region:        %s\n", s.tun.Region)
+					}
+					if s.tun.ExpiresAt != nil {
+						fmt.Printf("  Expires:       %s\n", formatExpiry(s.tun))
+					}
+					fmt.Printf("  Tunnel ID:     %s\n", s.tun.ID)
+					fmt.Printf("  Status:        %s\n", display.StatusColor(s.tun.Status))
+					fmt.Println()
+				}
 			}
 
 			// Connect to the relay.
-			conn, err := dialRelay(tun.RelayEndpoint, tun.SessionToken)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to connect to relay: %v\n", err)
-				os.Exit(2)
+			for _, s := range sessions {
+				conn, err := dialRelay(s.tun.RelayEndpoint, s.tun.SessionToken)
+				if err != nil {
+					stopTunnels(c, sessions)
+					fail(connectionErrorf("Failed to connect to relay: %v", err))
+				}
+				s.conn = conn
 			}
 
-			if !jsonOutput {
+			if !jsonOutput && !urlOnly && !flagQuiet {
 				fmt.Println("Press Ctrl+C to stop the tunnel.")
 			}
 
-			return runTunnelLoop(conn, tun, localHost, port, proto, inspect, noReconnect, c)
+			return runTunnels(sessions, opts, c)
 		},
 	}
 
-	cmd.Flags().StringVar(&name, "name", "", "human-readable label for this tunnel")
+	cmd.Flags().IntSliceVar(&ports, "port", nil, "additional local port to expose (repeatable or comma-separated; default: default_port from config)")
+	cmd.Flags().StringVar(&name, "name", "", "label for this tunnel (alphanumeric + hyphens, 3-63 chars)")
 	cmd.Flags().StringVar(&subdomain, "subdomain", "", "request a specific subdomain (Pro tier only)")
-	cmd.Flags().StringVar(&localHost, "local-host", "", "local hostname to forward to (default: 127.0.0.1)")
-	cmd.Flags().BoolVar(&inspect, "inspect", false, "enable request/response inspection logging (HTTP only)")
-	cmd.Flags().BoolVar(&noReconnect, "no-reconnect", false, "disable automatic reconnection on disconnect")
+	cmd.Flags().StringVar(&region, "region", "", "preferred relay region, e.g. eu-west (default: default_region from config, or the server's choice)")
+	cmd.Flags().StringVar(&expires, "expires", "", "have the server expire the tunnel after this long: e.g. 30m, 8h, 7d, 2w")
+	cmd.Flags().StringVar(&localHost, "local-host", "", "local hostname or IP address to forward to, e.g. ::1 for IPv6 (default: 127.0.0.1)")
+	fwd.register(cmd)
+	cmd.Flags().BoolVar(&urlOnly, "output-url-only", false, "print only the public URL to stdout (--json takes precedence)")
 	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output tunnel metadata as JSON")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "stop the tunnel and exit after this long, e.g. 30m (independent of server-side expiry)")
+	cmd.Flags().BoolVar(&force, "force", false, "expose sensitive ports such as 22 (SSH) or 5432 (PostgreSQL) without asking")
+	cmd.Flags().BoolVar(&idempotent, "idempotent", false, "send an idempotency key so the server can dedupe retried creates")
 
 	return cmd
 }
 
-func dialRelay(endpoint string, sessionToken string) (*websocket.Conn, error) {
-	// The relay expects the session token as a query parameter.
-	sep := "?"
-	if strings.Contains(endpoint, "?") {
-		sep = "&"
+// newProtocolCmd returns 'lt http' or 'lt tcp': expose with the protocol
+// given, so 'lt http 3000' is 'lt expose http 3000'. It is built from
+// newExposeCmd, so the flags and behaviour always match.
+func newProtocolCmd(proto string) *cobra.Command {
+	cmd := newExposeCmd()
+	expose := cmd.RunE
+	cmd.Use = proto + " [port]"
+	cmd.Short = fmt.Sprintf("Expose a local port over %s (shorthand for 'expose %s')", strings.ToUpper(proto), proto)
+	cmd.Long = fmt.Sprintf(`Expose a local port over %s. 'lt %s 3000' is shorthand for
+'lt expose %s 3000' and takes the same flags.
+
+The port may be omitted when default_port is set in the config file.`, strings.ToUpper(proto), proto, proto)
+	cmd.Args = cobra.MaximumNArgs(1)
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return expose(cmd, append([]string{proto}, args...))
 	}
-	wsURL := endpoint + sep + "session_token=" + sessionToken
+	return cmd
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+// createTunnel creates a tunnel, attaching a fresh idempotency key when
+// idempotent is set. Any retry of the same logical create must reuse the key.
+func createTunnel(c *client.Client, req client.CreateTunnelRequest, idempotent bool) (*client.TunnelResponse, error) {
+	if idempotent {
+		return c.CreateTunnelIdempotent(req, generateSessionID())
+	}
+	return c.CreateTunnel(req)
+}
 
-	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("dialing relay: %w", err)
+// normalizeNameFlags lowercases and validates --name and --subdomain before
+// any tunnel is created, including the per-port names tunnelName derives.
+func normalizeNameFlags(name, subdomain string, ports []int) (string, string, error) {
+	if name != "" {
+		n, err := client.NormalizeLabel(name)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid --name %q: %v.", name, err)
+		}
+		name = n
+		for _, port := range ports {
+			if _, err := client.NormalizeLabel(tunnelName(name, port, len(ports))); err != nil {
+				return "", "", fmt.Errorf("Invalid --name %q: with the port suffix, %v.", name, err)
+			}
+		}
+	}
+	if subdomain != "" {
+		s, err := client.NormalizeLabel(subdomain)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid --subdomain %q: %v.", subdomain, err)
+		}
+		subdomain = s
 	}
-	conn.SetReadLimit(11 * 1024 * 1024)
-	return conn, nil
+	return name, subdomain, nil
 }
 
-func runTunnelLoop(
-	conn *websocket.Conn,
-	tun *client.TunnelResponse,
-	localHost string,
-	localPort int,
-	proto string,
-	inspect bool,
-	noReconnect bool,
-	apiClient *client.Client,
-) error {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
+// tunnelName derives the name for one of several tunnels created by a single
+// command. With multiple ports the port is appended so names stay distinct.
+func tunnelName(name string, port, count int) string {
+	if name == "" || count == 1 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, port)
+}
 
-	for {
-		mux := protocol.NewMux(conn, false)
+// regionPattern matches relay region names such as "eu-west".
+var regionPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
 
-		// The relay sends pings; the mux automatically replies with pongs
-		// via handlePing in readLoop. We just register a pong callback for
-		// logging in verbose mode.
-		if flagVerbose {
-			mux.OnPong(func() {
-				fmt.Fprintln(os.Stderr, "heartbeat: pong received")
-			})
-		}
+// resolveRegion validates a --region value, defaulting to the config's
+// default_region. An empty result lets the server choose.
+func resolveRegion(region string) (string, error) {
+	if region == "" {
+		region = cliCfg.DefaultRegion
+	}
+	region = strings.ToLower(strings.TrimSpace(region))
+	if region != "" && !regionPattern.MatchString(region) {
+		return "", fmt.Errorf("Invalid region %q. Use a region name such as us-east or eu-west.", region)
+	}
+	return region, nil
+}
 
-		// Accept streams until mux closes or we are interrupted.
-		exitCode := acceptStreams(ctx, mux, localHost, localPort, proto, inspect)
+// warnRegion notes when the server placed a tunnel outside the requested
+// region.
+func warnRegion(requested string, tun *client.TunnelResponse) {
+	if requested != "" && tun.Region != "" && !strings.EqualFold(requested, tun.Region) {
+		fmt.Fprintf(os.Stderr, "Note: region %s is unavailable; tunnel %s uses %s.\n", requested, tun.ID, tun.Region)
+	}
+}
 
-		if exitCode == 0 {
-			// Tell the control plane we're stopping (best-effort).
-			if apiClient != nil {
-				_ = apiClient.StopTunnel(tun.ID)
-			}
-			conn.Close(websocket.StatusNormalClosure, "client shutdown")
-			mux.Close()
-			return nil
-		}
+// dialRelay connects to a tunnel's relay, retrying a few times in quick
+// succession before giving up.
+func dialRelay(endpoint string, sessionToken string) (*websocket.Conn, error) {
+	return tunnel.DialRelayRetry(context.Background(), endpoint, sessionToken, relayDialAttempts, relayDialOptions()...)
+}
+
+// relayDialOptions returns the options shared by every relay dial.
+func relayDialOptions() []tunnel.DialOption {
+	opts := []tunnel.DialOption{tunnel.WithHTTPClient(relayHTTPClient), tunnel.WithDialTimeout(relayDialTimeout)}
+	if relayHeader != nil {
+		opts = append(opts, tunnel.WithHeader(relayHeader))
+	}
+	if flagRelayTokenIn == "header" {
+		opts = append(opts, tunnel.WithTokenInHeader())
+	}
+	if flagNoWSCompression || (cliCfg.WSCompression != nil && !*cliCfg.WSCompression) {
+		opts = append(opts, tunnel.WithoutCompression())
+	}
+	return opts
+}
+
+// relayDialTimeout bounds each attempt to connect to the relay, and
+// relayDialAttempts is how many attempts a new tunnel gets.
+const (
+	relayDialTimeout  = 15 * time.Second
+	relayDialAttempts = 4
+)
 
-		mux.Close()
+// The client pings the relay every keepaliveInterval and treats the
+// connection as dead after keepaliveMaxMissed unanswered pings, which is
+// usually well before a socket error would surface.
+const (
+	keepaliveInterval  = 15 * time.Second
+	keepaliveMaxMissed = 3
+)
+
+// tunnelSession is a created tunnel together with its relay connection and
+// the local target its streams are forwarded to.
+type tunnelSession struct {
+	tun       *client.TunnelResponse
+	conn      *websocket.Conn
+	localHost string
+	localPort int
+	proto     string
+}
+
+// stopTunnels tells the control plane to stop each session's tunnel
+// (best-effort).
+func stopTunnels(apiClient *client.Client, sessions []*tunnelSession) {
+	if apiClient == nil {
+		return
+	}
+	for _, s := range sessions {
+		_ = apiClient.StopTunnel(s.tun.ID)
+	}
+}
+
+// shutdownSignals stop running tunnels gracefully: Ctrl+C, SIGTERM from a
+// process manager or container runtime, and SIGHUP when the terminal goes
+// away.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+// saveActiveState records sessions for 'lt resume', in case the CLI dies
+// before it can stop them.
+func saveActiveState(sessions []*tunnelSession) error {
+	state := &config.ActiveState{APIURL: cliCfg.APIURL, SavedAt: time.Now()}
+	for _, s := range sessions {
+		state.Tunnels = append(state.Tunnels, config.ActiveTunnel{
+			ID:            s.tun.ID,
+			PublicURL:     s.tun.PublicURL,
+			Protocol:      s.proto,
+			LocalHost:     s.localHost,
+			LocalPort:     s.localPort,
+			RelayEndpoint: s.tun.RelayEndpoint,
+			SessionToken:  s.tun.SessionToken,
+		})
+	}
+	return config.SaveActiveState(state)
+}
+
+// runTunnels serves all sessions concurrently under a single Ctrl+C. When
+// any tunnel terminates because its connection cannot be restored, the
+// others are shut down too. When opts.duration is set, all tunnels are shut
+// down once it elapses. All tunnels are stopped on exit.
+func runTunnels(sessions []*tunnelSession, opts *tunnelOptions, apiClient *client.Client) error {
+	sigCtx, stop := signal.NotifyContext(context.Background(), shutdownSignals...)
+	defer stop()
+	ctx, cancel := context.WithCancel(sigCtx)
+	defer cancel()
+	if opts.duration > 0 {
+		// The deferred cancel also stops the timer on Ctrl+C.
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
 
-		// Connection lost.
-		if noReconnect || (cliCfg.AutoReconnect != nil && !*cliCfg.AutoReconnect) {
-			fmt.Fprintln(os.Stderr, "Connection lost. Reconnection disabled.")
-			os.Exit(2)
+	if opts.metricsListener != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", opts.metrics)
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() { _ = srv.Serve(opts.metricsListener) }()
+		defer srv.Close()
+	}
+
+	stopStats := func() {}
+	if opts.stats {
+		statsCtx, cancelStats := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			runStatsLine(statsCtx, os.Stderr, opts.metrics, statsInterval)
+		}()
+		stopStats = func() {
+			cancelStats()
+			<-done
 		}
+	}
 
-		// Attempt reconnection.
-		newConn, err := tunnel.Reconnect(ctx, tun.RelayEndpoint, tun.SessionToken, flagVerbose)
+	stopDashboard := func() {}
+	if opts.tui {
+		dash := newDashboard(sessions, opts.metrics)
+		stop, err := dash.start(cancel)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Unable to reconnect. Tunnel terminated.")
-			os.Exit(2)
+			stopTunnels(apiClient, sessions)
+			fail(err)
 		}
-		conn = newConn
+		stopDashboard = stop
+		opts.events = tunnel.MultiSink(opts.events, dash)
+	}
+
+	if err := saveActiveState(sessions); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: 'lt resume' will not be available: %v\n", err)
+	}
+	for _, s := range sessions {
+		tunnel.Emit(opts.events, tunnel.Event{Type: tunnel.EventTunnelCreated, TunnelID: s.tun.ID, URL: s.tun.PublicURL})
+	}
+
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Pointer[error]
+	)
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s *tunnelSession) {
+			defer wg.Done()
+			if err := runTunnelLoop(ctx, s, opts); err != nil {
+				failed.CompareAndSwap(nil, &err)
+				cancel()
+			}
+		}(s)
+	}
+	wg.Wait()
+	stopStats()
+	stopDashboard()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && failed.Load() == nil {
+		fmt.Fprintf(os.Stderr, "Duration of %s elapsed. Stopping.\n", opts.duration)
+	}
+	stopTunnels(apiClient, sessions)
+	_ = config.RemoveActiveState()
+	for _, s := range sessions {
+		tunnel.Emit(opts.events, tunnel.Event{Type: tunnel.EventTunnelStopped, TunnelID: s.tun.ID})
+	}
+	if err := failed.Load(); err != nil {
+		fail(*err)
 	}
+	return nil
 }
 
-// acceptStreams accepts streams from the mux and forwards them.
-// Returns 0 for graceful shutdown, 2 for connection loss.
-func acceptStreams(ctx context.Context, mux *protocol.Mux, localHost string, localPort int, proto string, inspect bool) int {
-	for {
-		stream, err := mux.AcceptStream(ctx)
+// runTunnelLoop serves a single tunnel until ctx is cancelled, reconnecting
+// to the relay when the connection drops. It returns nil on graceful
+// shutdown and a connection error if the connection could not be restored.
+func runTunnelLoop(ctx context.Context, s *tunnelSession, opts *tunnelOptions) error {
+	if opts.waitInterval > 0 {
+		err := tunnel.WaitForLocal(ctx, s.localHost, s.localPort, opts.waitInterval, opts.waitTimeout)
+		if ctx.Err() != nil {
+			s.conn.Close(websocket.StatusNormalClosure, "client shutdown")
+			return nil
+		}
 		if err != nil {
-			// Check if it's a context cancellation (SIGINT).
-			select {
-			case <-ctx.Done():
-				return 0
-			default:
-			}
-			// Mux closed: connection lost.
-			return 2
+			fmt.Fprintf(os.Stderr, "Warning: local server on %s still unreachable after %s. Serving anyway.\n",
+				tunnel.LocalTarget(s.localHost, s.localPort), opts.waitTimeout)
 		}
+	}
+
+	muxOpts := []protocol.Option{protocol.WithKeepalive(keepaliveInterval, keepaliveMaxMissed)}
+	debug := slog.Default().Enabled(ctx, slog.LevelDebug)
+	if debug || opts.metrics != nil {
+		muxOpts = append(muxOpts, protocol.WithTracer(func(dir protocol.Direction, f protocol.Frame) {
+			if debug {
+				slog.Debug("frame", "tunnel", s.tun.ID, "dir", dir, "frame", f)
+			}
+			if opts.metrics != nil && f.Type == protocol.FrameData {
+				opts.metrics.AddBytes(s.tun.ID, dir == protocol.DirectionIn, len(f.Payload))
+			}
+		}))
+	}
+	noReconnect := opts.noReconnect || (cliCfg.AutoReconnect != nil && !*cliCfg.AutoReconnect)
 
-		switch proto {
-		case "http":
-			go tunnel.ForwardHTTP(stream, localHost, localPort, inspect, flagVerbose)
-		case "tcp":
-			go tunnel.ForwardTCP(stream, localHost, localPort, flagVerbose)
+	session := tunnel.NewSession(tunnel.SessionConfig{
+		Tunnel:      s.tun,
+		Conn:        s.conn,
+		LocalHost:   s.localHost,
+		LocalPort:   s.localPort,
+		Protocol:    s.proto,
+		Forward:     opts.forwardOptions(s.tun.ID),
+		DialOptions: relayDialOptions(),
+		MuxOptions:  muxOpts,
+		OnMux: func(mux *protocol.Mux) {
+			mux.OnKeepaliveTimeout(func() {
+				fmt.Fprintln(opts.statusWriter(), "Heartbeat lost: the relay stopped answering pings.")
+			})
+			// The relay sends pings; the mux automatically replies with
+			// pongs via handlePing in readLoop. We just register a pong
+			// callback for debug logging.
+			if debug {
+				mux.OnPong(func() {
+					slog.Debug("heartbeat: pong received", "tunnel", s.tun.ID)
+				})
+			}
+		},
+		NoReconnect: noReconnect,
+		Status:      opts.statusWriter(),
+	})
+	if debug {
+		session.OnStateChange(func(old, new tunnel.State) {
+			slog.Debug("tunnel state changed", "tunnel", s.tun.ID, "from", old, "to", new)
+		})
+	}
+	err := session.Run(ctx)
+	if errors.Is(err, tunnel.ErrConnectionLost) {
+		if noReconnect {
+			return connectionErrorf("Connection lost. Reconnection disabled.")
 		}
+		return connectionErrorf("Unable to reconnect. Tunnel terminated.")
+	}
+	if err != nil {
+		return connectionErrorf("%v", err)
 	}
+	return nil
 }
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveRegion(t *testing.T) {
+	oldCfg := cliCfg
+	defer func() { cliCfg = oldCfg }()
+
+	tests := []struct {
+		flag, cfg, want string
+		wantErr         bool
+	}{
+		{want: ""},
+		{cfg: "eu-west", want: "eu-west"},
+		{flag: " US-East ", cfg: "eu-west", want: "us-east"},
+		{flag: "eu west", wantErr: true},
+		{flag: "-eu", wantErr: true},
+	}
+	for _, tt := range tests {
+		cliCfg.DefaultRegion = tt.cfg
+		got, err := resolveRegion(tt.flag)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveRegion(%q) = %q, want an error", tt.flag, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveRegion(%q) with config %q = %q, %v; want %q", tt.flag, tt.cfg, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeExpires(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "", want: ""},
+		{in: "30m", want: "30m"},
+		{in: "90m", want: "90m"},
+		{in: "120m", want: "2h"},
+		{in: "8h", want: "8h"},
+		{in: " 8H ", want: "8h"},
+		{in: "7d", want: "168h"},
+		{in: "2w", want: "336h"},
+		{in: "1d12h", want: "36h"},
+		{in: "1h30m", want: "90m"},
+		{in: "30d", want: "720h"},
+		{in: "0h", wantErr: true},
+		{in: "0d0h", wantErr: true},
+		{in: "-1h", wantErr: true},
+		{in: "31d", wantErr: true},
+		{in: "5w", wantErr: true},
+		{in: "99999999999w", wantErr: true},
+		{in: "30s", wantErr: true},
+		{in: "1.5h", wantErr: true},
+		{in: "8", wantErr: true},
+		{in: "h", wantErr: true},
+		{in: "1h30", wantErr: true},
+		{in: "forever", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeExpires(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("normalizeExpires(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeExpires(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestProtocolCmd_MatchesExposeFlags(t *testing.T) {
+	want := newExposeCmd().Flags().FlagUsages()
+	for _, proto := range []string{"http", "tcp"} {
+		cmd := newProtocolCmd(proto)
+		if got := cmd.Flags().FlagUsages(); got != want {
+			t.Errorf("lt %s flags differ from expose:\n%s\nwant:\n%s", proto, got, want)
+		}
+		if err := cmd.Args(cmd, []string{"3000", "4000"}); err == nil {
+			t.Errorf("lt %s accepted two ports", proto)
+		}
+	}
+}
+
+func TestMergeHeaderRules(t *testing.T) {
+	flags, err := headerRules([]string{"X-Env: staging", "Server: lt"}, []string{"x-team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := mergeHeaderRules(
+		map[string]string{"x-env": "preview", "X-Team": "web", "X-Policy": " on "},
+		[]string{"server", "X-Powered-By"},
+		flags,
+	)
+
+	// Flags win over the config's sets, whether they set or remove.
+	wantSet := http.Header{"X-Env": {"staging"}, "Server": {"lt"}, "X-Policy": {"on"}}
+	if !reflect.DeepEqual(rules.Set, wantSet) {
+		t.Errorf("Set = %v, want %v", rules.Set, wantSet)
+	}
+	// Removals run before sets, so removing Server still lets the flag set it.
+	wantRemove := []string{"Server", "X-Powered-By", "X-Team"}
+	if !slices.Equal(rules.Remove, wantRemove) {
+		t.Errorf("Remove = %v, want %v", rules.Remove, wantRemove)
+	}
+	if len(flags.Set) != 2 {
+		t.Errorf("flag rules modified: %v", flags.Set)
+	}
+}
+
+func TestNormalizeNameFlags(t *testing.T) {
+	long := strings.Repeat("a", 58)
+	tests := []struct {
+		name, subdomain string
+		ports           []int
+		wantName        string
+		wantSub         string
+		wantErr         string
+	}{
+		{name: "MyApp", subdomain: "Demo", ports: []int{3000}, wantName: "myapp", wantSub: "demo"},
+		{name: "ab", ports: []int{3000}, wantErr: "--name"},
+		{subdomain: "-demo", ports: []int{3000}, wantErr: "--subdomain"},
+		// The name alone fits, and so does name-3000, but name-10000
+		// exceeds the 63-character limit.
+		{name: long, ports: []int{3000}, wantName: long},
+		{name: long, ports: []int{3000, 4000}, wantName: long},
+		{name: long, ports: []int{3000, 10000}, wantErr: "with the port suffix"},
+	}
+	for _, tt := range tests {
+		name, sub, err := normalizeNameFlags(tt.name, tt.subdomain, tt.ports)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("normalizeNameFlags(%q, %q, %v): got %v, want an error mentioning %q", tt.name, tt.subdomain, tt.ports, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || name != tt.wantName || sub != tt.wantSub {
+			t.Errorf("normalizeNameFlags(%q, %q, %v) = %q, %q, %v; want %q, %q", tt.name, tt.subdomain, tt.ports, name, sub, err, tt.wantName, tt.wantSub)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+//go:build unix
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+)
+
+// eventFunc adapts a function to tunnel.EventSink.
+type eventFunc func(tunnel.Event)
+
+func (f eventFunc) Emit(e tunnel.Event) { f(e) }
+
+func TestRunTunnels_StopsTunnelOnSIGTERM(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// Fake control plane: records stop calls.
+	var (
+		mu      sync.Mutex
+		stopped []string
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/stop") {
+			mu.Lock()
+			stopped = append(stopped, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/tunnels/"), "/stop"))
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer api.Close()
+
+	// Fake relay: accepts the WebSocket and holds it open.
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		_, _, _ = conn.Read(r.Context())
+	}))
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(relay.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("websocket.Dial: %v", err)
+	}
+
+	started := make(chan struct{})
+	var once sync.Once
+	opts := &tunnelOptions{
+		noReconnect: true,
+		events: eventFunc(func(e tunnel.Event) {
+			if e.Type == tunnel.EventTunnelCreated {
+				once.Do(func() { close(started) })
+			}
+		}),
+	}
+	sessions := []*tunnelSession{{
+		tun:       &client.TunnelResponse{ID: "tun_term", PublicURL: "https://term.example"},
+		conn:      conn,
+		localHost: "127.0.0.1",
+		localPort: 1,
+		proto:     "tcp",
+	}}
+
+	done := make(chan error, 1)
+	go func() { done <- runTunnels(sessions, opts, client.New(api.URL, "key")) }()
+
+	select {
+	case <-started:
+	case <-ctx.Done():
+		t.Fatal("tunnels did not start")
+	}
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runTunnels: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("runTunnels did not return after SIGTERM")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stopped) != 1 || stopped[0] != "tun_term" {
+		t.Errorf("stopped tunnels = %v, want [tun_term]", stopped)
+	}
+}
@@ -1,60 +1,206 @@
 package cmd
 
 import (
+	"cmp"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/carloluisito/launchtunnel-cli/client"
 	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
 	"github.com/spf13/cobra"
 )
 
 func newListCmd() *cobra.Command {
-	var jsonOutput bool
+	var (
+		output  outputFlags
+		sortBy  string
+		reverse bool
+		filter  tunnelFilter
+	)
 
 	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List active tunnels",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := output.resolve()
+			if err != nil {
+				fail(err)
+			}
+			cmpFn, err := tunnelComparator(sortBy)
+			if err != nil {
+				fail(err)
+			}
+
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
 			tunnels, err := c.ListTunnels()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
+			tunnels = filter.apply(tunnels)
+			sortTunnels(tunnels, cmpFn, reverse)
 
-			if jsonOutput {
+			switch format {
+			case outputJSON:
 				return display.PrintJSON(os.Stdout, tunnels)
+			case outputYAML:
+				return display.PrintYAML(os.Stdout, tunnels)
+			case outputCSV:
+				rows := make([][]string, 0, len(tunnels))
+				for _, t := range tunnels {
+					rows = append(rows, []string{
+						t.ID,
+						t.PublicURL,
+						t.Protocol,
+						t.LocalHost,
+						strconv.Itoa(t.LocalPort),
+						t.Status,
+						t.CreatedAt.Format(time.RFC3339),
+					})
+				}
+				return display.PrintCSV(os.Stdout, []string{
+					"id", "public_url", "protocol", "local_host", "local_port", "status", "created_at",
+				}, rows)
 			}
 
 			if len(tunnels) == 0 {
-				fmt.Println("No active tunnels.")
+				if filter.active() {
+					fmt.Println("No tunnels match the filters.")
+				} else {
+					fmt.Println("No active tunnels.")
+				}
+				warnNearQuota(apiKey)
 				return nil
 			}
 
 			tbl := display.NewTable("ID", "URL", "PROTOCOL", "LOCAL", "STATUS", "AGE")
+			tbl.SetColumnColorizer(4, display.StatusColor)
+			tbl.SetAlign(5, display.AlignRight)
 			for _, t := range tunnels {
-				local := fmt.Sprintf("%s:%d", t.LocalHost, t.LocalPort)
+				local := tunnel.LocalTarget(t.LocalHost, t.LocalPort)
 				age := formatAge(t.CreatedAt)
 				tbl.AddRow(t.ID, t.PublicURL, t.Protocol, local, t.Status, age)
 			}
+			if width, ok := display.TerminalWidth(os.Stdout); ok {
+				tbl.FitWidth(width, 0, 1)
+			}
 			tbl.Render(os.Stdout)
+			warnNearQuota(apiKey)
 			return nil
 		},
 	}
 
-	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output as JSON array")
+	addOutputFlags(cmd, &output)
+	cmd.Flags().StringVar(&sortBy, "sort", "", "sort by age, url, status, or bytes (default: server order)")
+	cmd.Flags().BoolVar(&reverse, "reverse", false, "reverse the sort order")
+	cmd.Flags().StringVar(&filter.status, "status", "", "only show tunnels with this status, e.g. active")
+	cmd.Flags().StringVar(&filter.protocol, "protocol", "", "only show tunnels using this protocol (http or tcp)")
+	cmd.Flags().StringVar(&filter.text, "filter", "", "only show tunnels whose name, URL, or ID contains this text")
 	return cmd
 }
 
+// tunnelFilter narrows 'lt list' output. A tunnel must match every field
+// that is set. All comparisons ignore case.
+type tunnelFilter struct {
+	status   string
+	protocol string
+	text     string
+}
+
+// active reports whether any filter is set.
+func (f tunnelFilter) active() bool {
+	return f.status != "" || f.protocol != "" || f.text != ""
+}
+
+// match reports whether t passes the filter.
+func (f tunnelFilter) match(t client.TunnelResponse) bool {
+	if f.status != "" && !strings.EqualFold(t.Status, f.status) {
+		return false
+	}
+	if f.protocol != "" && !strings.EqualFold(t.Protocol, f.protocol) {
+		return false
+	}
+	if f.text != "" {
+		text := strings.ToLower(f.text)
+		if !strings.Contains(strings.ToLower(t.Name), text) &&
+			!strings.Contains(strings.ToLower(t.PublicURL), text) &&
+			!strings.Contains(strings.ToLower(t.ID), text) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the tunnels that match the filter, reusing the backing
+// array of tunnels.
+func (f tunnelFilter) apply(tunnels []client.TunnelResponse) []client.TunnelResponse {
+	if !f.active() {
+		return tunnels
+	}
+	return slices.DeleteFunc(tunnels, func(t client.TunnelResponse) bool {
+		return !f.match(t)
+	})
+}
+
+// tunnelComparators order tunnels for --sort. Each sorts ascending by its
+// key: age puts the newest tunnel first, bytes the one with the least
+// traffic in both directions.
+var tunnelComparators = map[string]func(a, b client.TunnelResponse) int{
+	"age": func(a, b client.TunnelResponse) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	},
+	"url": func(a, b client.TunnelResponse) int {
+		return strings.Compare(a.PublicURL, b.PublicURL)
+	},
+	"status": func(a, b client.TunnelResponse) int {
+		return strings.Compare(strings.ToLower(a.Status), strings.ToLower(b.Status))
+	},
+	"bytes": func(a, b client.TunnelResponse) int {
+		return cmp.Compare(a.BytesIn+a.BytesOut, b.BytesIn+b.BytesOut)
+	},
+}
+
+// tunnelComparator returns the comparator for a --sort key, or nil for the
+// empty key, which keeps the server's order.
+func tunnelComparator(key string) (func(a, b client.TunnelResponse) int, error) {
+	if key == "" {
+		return nil, nil
+	}
+	fn, ok := tunnelComparators[strings.ToLower(key)]
+	if !ok {
+		return nil, fmt.Errorf("Invalid --sort %q. Must be one of: age, url, status, bytes.", key)
+	}
+	return fn, nil
+}
+
+// sortTunnels sorts tunnels in place with cmpFn, keeping the server's order
+// among equal tunnels. With reverse the order is flipped; with a nil cmpFn
+// only reverse applies.
+func sortTunnels(tunnels []client.TunnelResponse, cmpFn func(a, b client.TunnelResponse) int, reverse bool) {
+	if cmpFn == nil {
+		if reverse {
+			slices.Reverse(tunnels)
+		}
+		return
+	}
+	slices.SortStableFunc(tunnels, func(a, b client.TunnelResponse) int {
+		if reverse {
+			return cmpFn(b, a)
+		}
+		return cmpFn(a, b)
+	})
+}
+
 func formatAge(created time.Time) string {
 	d := time.Since(created)
 	switch {
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+)
+
+func tunnelIDs(tunnels []client.TunnelResponse) []string {
+	ids := make([]string, len(tunnels))
+	for i, t := range tunnels {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func sampleTunnels() []client.TunnelResponse {
+	now := time.Now()
+	return []client.TunnelResponse{
+		{ID: "a", PublicURL: "https://b.dev", Status: "stopped", CreatedAt: now.Add(-2 * time.Hour), BytesIn: 10, BytesOut: 5},
+		{ID: "b", PublicURL: "https://c.dev", Status: "active", CreatedAt: now.Add(-time.Minute), BytesIn: 1 << 40},
+		{ID: "c", PublicURL: "https://a.dev", Status: "Active", CreatedAt: now.Add(-24 * time.Hour), BytesOut: 100},
+	}
+}
+
+func TestSortTunnels(t *testing.T) {
+	tests := []struct {
+		key     string
+		reverse bool
+		want    []string
+	}{
+		{"", false, []string{"a", "b", "c"}},
+		{"", true, []string{"c", "b", "a"}},
+		{"age", false, []string{"b", "a", "c"}},
+		{"age", true, []string{"c", "a", "b"}},
+		{"url", false, []string{"c", "a", "b"}},
+		{"status", false, []string{"b", "c", "a"}},
+		{"status", true, []string{"a", "b", "c"}},
+		{"bytes", false, []string{"a", "c", "b"}},
+		{"BYTES", true, []string{"b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		cmpFn, err := tunnelComparator(tt.key)
+		if err != nil {
+			t.Fatalf("tunnelComparator(%q): %v", tt.key, err)
+		}
+		tunnels := sampleTunnels()
+		sortTunnels(tunnels, cmpFn, tt.reverse)
+		if got := tunnelIDs(tunnels); !slices.Equal(got, tt.want) {
+			t.Errorf("--sort %q --reverse=%v: got %v, want %v", tt.key, tt.reverse, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelComparator_Invalid(t *testing.T) {
+	if _, err := tunnelComparator("name"); err == nil {
+		t.Error("tunnelComparator(\"name\") succeeded, want error")
+	}
+}
+
+func TestTunnelFilter(t *testing.T) {
+	tunnels := []client.TunnelResponse{
+		{ID: "tun_1", Name: "myapp-web", PublicURL: "https://myapp.dev", Protocol: "http", Status: "active"},
+		{ID: "tun_2", Name: "myapp-db", PublicURL: "tcp://relay.dev:4000", Protocol: "tcp", Status: "active"},
+		{ID: "tun_3", Name: "blog", PublicURL: "https://blog.dev", Protocol: "http", Status: "stopped"},
+		{ID: "tun_MYAPP", Name: "other", PublicURL: "https://other.dev", Protocol: "HTTP", Status: "Active"},
+	}
+	tests := []struct {
+		name   string
+		filter tunnelFilter
+		want   []string
+	}{
+		{"none", tunnelFilter{}, []string{"tun_1", "tun_2", "tun_3", "tun_MYAPP"}},
+		{"status", tunnelFilter{status: "active"}, []string{"tun_1", "tun_2", "tun_MYAPP"}},
+		{"protocol", tunnelFilter{protocol: "http"}, []string{"tun_1", "tun_3", "tun_MYAPP"}},
+		{"text in name", tunnelFilter{text: "APP-"}, []string{"tun_1", "tun_2"}},
+		{"text in url", tunnelFilter{text: "blog.dev"}, []string{"tun_3"}},
+		{"text in id", tunnelFilter{text: "myapp"}, []string{"tun_1", "tun_2", "tun_MYAPP"}},
+		{"combined", tunnelFilter{status: "active", protocol: "http", text: "myapp"}, []string{"tun_1", "tun_MYAPP"}},
+		{"no match", tunnelFilter{status: "stopped", protocol: "tcp"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tunnelIDs(tt.filter.apply(slices.Clone(tunnels)))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
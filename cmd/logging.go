@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/carloluisito/launchtunnel-cli/protocol"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+)
+
+// logLevels are the values accepted by --log-level.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// newLogger returns a logger for diagnostics written to w. level is one
+// of logLevels; empty means warn, or debug with --verbose. format is text
+// or json; empty means text.
+func newLogger(w io.Writer, level, format string, verbose bool) (*slog.Logger, error) {
+	if level == "" {
+		level = "warn"
+		if verbose {
+			level = "debug"
+		}
+	}
+	lvl, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return nil, fmt.Errorf("Invalid --log-level %q. Must be debug, info, warn, or error.", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("Invalid --log-format %q. Must be text or json.", format)
+}
+
+// setLogger makes l the logger for the CLI and the tunnel and protocol
+// packages.
+func setLogger(l *slog.Logger) {
+	slog.SetDefault(l)
+	tunnel.SetLogger(l)
+	protocol.SetLogger(l)
+}
+
+// eventLogFormat returns the format of --log-file events: --log-format,
+// or json if it is not set.
+func eventLogFormat() string {
+	if flagLogFormat == "" {
+		return "json"
+	}
+	return strings.ToLower(flagLogFormat)
+}
@@ -3,10 +3,10 @@ package cmd
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"os/exec"
-	"runtime"
 	"time"
 
 	"github.com/carloluisito/launchtunnel-cli/client"
@@ -17,26 +17,48 @@ import (
 const (
 	browserPollInterval = 2 * time.Second
 	browserPollTimeout  = 5 * time.Minute
+	// browserWaitNotice is how often the user is told login is still
+	// waiting for the browser.
+	browserWaitNotice = 30 * time.Second
+
+	// devicePollInterval is used when the control plane does not say how
+	// often to poll a device login; deviceSlowDown is added to the
+	// interval each time it asks the CLI to slow down.
+	devicePollInterval = 5 * time.Second
+	deviceSlowDown     = 5 * time.Second
 )
 
 func newLoginCmd() *cobra.Command {
-	var apiKeyFlag string
+	var (
+		apiKeyFlag string
+		device     bool
+	)
 
 	cmd := &cobra.Command{
 		Use:   "login",
 		Short: "Authenticate the CLI with a LaunchTunnel account",
-		Args:  cobra.NoArgs,
+		Long: `Authenticate the CLI with a LaunchTunnel account.
+
+By default a browser opens to log in. On a machine without one, use
+--device to get a code to enter from any other device, or --api-key.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			c := client.New(cliCfg.APIURL, "")
+			c := newClient("")
 
-			if apiKeyFlag != "" {
+			switch {
+			case apiKeyFlag != "" && device:
+				failf("--api-key cannot be combined with --device.")
+			case apiKeyFlag != "":
 				return loginWithAPIKey(c, apiKeyFlag)
+			case device:
+				return loginWithDevice(c)
 			}
 			return loginWithBrowser(c)
 		},
 	}
 
 	cmd.Flags().StringVar(&apiKeyFlag, "api-key", "", "authenticate directly with an API key")
+	cmd.Flags().BoolVar(&device, "device", false, "log in by entering a code on another device, without a local browser")
 	return cmd
 }
 
@@ -44,12 +66,10 @@ func loginWithAPIKey(c *client.Client, key string) error {
 	c.SetAPIKey(key)
 	resp, err := c.VerifyAPIKey()
 	if err != nil {
-		if apiErr, ok := err.(*client.APIError); ok && apiErr.HTTPStatus == 401 {
-			fmt.Fprintln(os.Stderr, "Invalid API key. Check your key at https://app.launchtunnel.dev/settings/api-keys")
-			os.Exit(1)
+		if client.IsUnauthorized(err) {
+			fail(unauthorizedf("Invalid API key. Check your key at https://app.launchtunnel.dev/settings/api-keys"))
 		}
-		fmt.Fprintln(os.Stderr, "Unable to reach LaunchTunnel servers. Check your internet connection.")
-		os.Exit(1)
+		fail(errUnreachable)
 	}
 
 	if err := config.SaveCredentials(&config.Credentials{
@@ -60,7 +80,8 @@ func loginWithAPIKey(c *client.Client, key string) error {
 		return fmt.Errorf("saving credentials: %w", err)
 	}
 
-	fmt.Printf("Authenticated as %s. API key stored.\n", resp.User.Email)
+	infof("Authenticated as %s. API key stored.\n", resp.User.Email)
+	printPlan(c, &resp.User)
 	return nil
 }
 
@@ -68,14 +89,28 @@ func loginWithBrowser(c *client.Client) error {
 	sessionID := generateSessionID()
 	authURL := fmt.Sprintf("%s/cli?session=%s", cliCfg.FrontendURL, sessionID)
 
+	// Without the control plane the browser flow can never finish, so
+	// say so now rather than after the full timeout.
+	if err := checkReachable(c, sessionID); err != nil {
+		slog.Debug("control plane unreachable", "url", cliCfg.APIURL, "err", err)
+		fail(connectionErrorf("Unable to reach LaunchTunnel at %s.\n"+
+			"Check your internet connection and try again, or log in with 'lt login --api-key <key>'.", cliCfg.APIURL))
+	}
+
 	fmt.Println("Opening browser for authentication...")
 	fmt.Printf("If the browser does not open, visit: %s\n", authURL)
 
 	openBrowser(authURL)
 
 	deadline := time.Now().Add(browserPollTimeout)
+	nextNotice := time.Now().Add(browserWaitNotice)
 	for time.Now().Before(deadline) {
 		time.Sleep(browserPollInterval)
+		if now := time.Now(); now.After(nextNotice) {
+			left := deadline.Sub(now).Round(time.Second)
+			infof("Still waiting for you to log in in the browser (%s left)...\n", left)
+			nextNotice = now.Add(browserWaitNotice)
+		}
 
 		resp, err := c.PollCLISession(sessionID)
 		if err != nil {
@@ -83,35 +118,120 @@ func loginWithBrowser(c *client.Client) error {
 		}
 
 		if resp.Status == "authenticated" && resp.APIKey != "" {
-			c.SetAPIKey(resp.APIKey)
-			verify, err := c.VerifyAPIKey()
-			email := ""
-			if err == nil {
-				email = verify.User.Email
-			}
+			return saveLogin(c, resp.APIKey)
+		}
+	}
 
-			if err := config.SaveCredentials(&config.Credentials{
-				APIKey: resp.APIKey,
-				APIURL: cliCfg.APIURL,
-				Email:  email,
-			}); err != nil {
-				return fmt.Errorf("saving credentials: %w", err)
-			}
+	failf("Login timed out. Run 'lt login' to try again.")
+	return nil
+}
 
-			if email != "" {
-				fmt.Printf("Authenticated as %s. API key stored.\n", email)
-			} else {
-				fmt.Println("Authenticated. API key stored.")
-			}
+// loginWithDevice logs in with a code the user enters in a browser
+// elsewhere, polling until they do or the code expires.
+func loginWithDevice(c *client.Client) error {
+	dev, err := c.StartDeviceLogin()
+	if err != nil {
+		if isDryRun(err) {
 			return nil
 		}
+		failAPI(err)
 	}
 
-	fmt.Fprintln(os.Stderr, "Login timed out. Run 'lt login' to try again.")
-	os.Exit(1)
+	verifyURL := dev.VerificationURL
+	if verifyURL == "" {
+		verifyURL = cliCfg.FrontendURL + "/device"
+	}
+	fmt.Printf("To log in, visit %s and enter the code: %s\n", verifyURL, dev.UserCode)
+	if dev.VerificationURLComplete != "" {
+		fmt.Printf("Or open: %s\n", dev.VerificationURLComplete)
+	}
+
+	interval := time.Duration(dev.Interval) * time.Second
+	if interval <= 0 {
+		interval = devicePollInterval
+	}
+	timeout := time.Duration(dev.ExpiresIn) * time.Second
+	if timeout <= 0 {
+		timeout = browserPollTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	nextNotice := time.Now().Add(browserWaitNotice)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		if now := time.Now(); now.After(nextNotice) {
+			left := deadline.Sub(now).Round(time.Second)
+			infof("Still waiting for the code to be entered (%s left)...\n", left)
+			nextNotice = now.Add(browserWaitNotice)
+		}
+
+		resp, err := c.PollDeviceLogin(dev.DeviceCode)
+		if err != nil {
+			continue
+		}
+		switch resp.Status {
+		case client.DeviceAuthenticated:
+			if resp.APIKey != "" {
+				return saveLogin(c, resp.APIKey)
+			}
+		case client.DeviceSlowDown:
+			interval += deviceSlowDown
+		case client.DeviceAccessDenied:
+			fail(unauthorizedf("Login was denied. Run 'lt login --device' to try again."))
+		case client.DeviceExpired:
+			deadline = time.Now()
+		}
+	}
+
+	failf("The login code expired. Run 'lt login --device' to get a new one.")
 	return nil
 }
 
+// saveLogin stores apiKey, obtained from a browser or device login, with
+// the account's email if it can be looked up.
+func saveLogin(c *client.Client, apiKey string) error {
+	c.SetAPIKey(apiKey)
+	verify, err := c.VerifyAPIKey()
+	email := ""
+	if err == nil {
+		email = verify.User.Email
+	}
+
+	if err := config.SaveCredentials(&config.Credentials{
+		APIKey: apiKey,
+		APIURL: cliCfg.APIURL,
+		Email:  email,
+	}); err != nil {
+		return fmt.Errorf("saving credentials: %w", err)
+	}
+
+	if email != "" {
+		infof("Authenticated as %s. API key stored.\n", email)
+		printPlan(c, &verify.User)
+	} else {
+		infof("Authenticated. API key stored.\n")
+	}
+	return nil
+}
+
+// printPlan shows the account's plan and limits after logging in.
+func printPlan(c *client.Client, user *client.UserInfo) {
+	if !flagQuiet {
+		printQuota(os.Stdout, user.Tier, fetchQuota(c, user))
+	}
+}
+
+// checkReachable polls the not yet started CLI session once to see that
+// the control plane answers. An error from the API still counts as an
+// answer.
+func checkReachable(c *client.Client, sessionID string) error {
+	_, err := c.PollCLISession(sessionID)
+	var apiErr *client.APIError
+	if err == nil || errors.As(err, &apiErr) || isDryRun(err) {
+		return nil
+	}
+	return err
+}
+
 func generateSessionID() string {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
@@ -119,16 +239,3 @@ func generateSessionID() string {
 	}
 	return hex.EncodeToString(b)
 }
-
-func openBrowser(url string) {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = exec.Command("open", url)
-	case "windows":
-		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
-	default:
-		cmd = exec.Command("xdg-open", url)
-	}
-	_ = cmd.Start()
-}
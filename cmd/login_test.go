@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+)
+
+func TestCheckReachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": "NOT_FOUND", "message": "session not found"}}`))
+	}))
+	if err := checkReachable(client.New(srv.URL, ""), "s1"); err != nil {
+		t.Errorf("checkReachable with an API error: %v, want nil", err)
+	}
+
+	srv.Close()
+	if err := checkReachable(client.New(srv.URL, ""), "s1"); err == nil {
+		t.Error("checkReachable with the server down: got nil, want an error")
+	}
+}
@@ -1,9 +1,6 @@
 package cmd
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/carloluisito/launchtunnel-cli/config"
 	"github.com/spf13/cobra"
 )
@@ -15,10 +12,9 @@ func newLogoutCmd() *cobra.Command {
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := config.RemoveCredentials(); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
-			fmt.Println("Logged out. Credentials removed.")
+			infof("Logged out. Credentials removed.\n")
 			return nil
 		},
 	}
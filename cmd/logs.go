@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/spf13/cobra"
+)
+
+const (
+	logsInitialBackoff = 1 * time.Second
+	logsMaxBackoff     = 30 * time.Second
+)
+
+func newLogsCmd() *cobra.Command {
+	var (
+		follow     bool
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "logs <tunnel_id>",
+		Short: "Show request logs for a tunnel",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+			tunnelID := args[0]
+
+			ctx, stop := signal.NotifyContext(context.Background(), shutdownSignals...)
+			defer stop()
+
+			enc := json.NewEncoder(os.Stdout)
+			printEntry := func(e client.LogEntry) error {
+				if jsonOutput {
+					return enc.Encode(e)
+				}
+				_, err := fmt.Printf("%s  %-6s %s %d %s\n",
+					e.Timestamp.Local().Format("15:04:05"),
+					e.Method, e.Path, e.Status,
+					(time.Duration(e.DurationMs) * time.Millisecond).String())
+				return err
+			}
+
+			// The log stream reconnects on its own schedule, independent of
+			// any running tunnel, resuming after the last entry printed.
+			backoff := logsInitialBackoff
+			lastID := ""
+			for {
+				var printErr error
+				body, err := c.StreamTunnelLogs(ctx, tunnelID, follow, lastID)
+				if err == nil {
+					backoff = logsInitialBackoff
+					err = client.ReadLogEntries(body, func(e client.LogEntry) error {
+						if printErr = printEntry(e); printErr != nil {
+							return printErr
+						}
+						if e.ID != "" {
+							lastID = e.ID
+						}
+						return nil
+					})
+					body.Close()
+				}
+
+				if ctx.Err() != nil {
+					return nil
+				}
+				// Neither a bad entry nor a closed stdout is fixed by
+				// reconnecting.
+				if printErr != nil {
+					fail(fmt.Errorf("writing log entry: %w", printErr))
+				}
+				if errors.Is(err, client.ErrMalformedLogEntry) {
+					fail(err)
+				}
+
+				var apiErr *client.APIError
+				if errors.As(err, &apiErr) {
+					if client.IsNotFound(err) {
+						fail(notFoundf("Tunnel %s not found.", tunnelID))
+					}
+					fail(err)
+				}
+
+				if !follow {
+					if err != nil {
+						fail(err)
+					}
+					return nil
+				}
+
+				if err != nil {
+					slog.Debug("log stream interrupted", "tunnel", tunnelID, "err", err)
+				}
+				fmt.Fprintf(os.Stderr, "Log stream disconnected. Reconnecting in %s...\n", backoff)
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > logsMaxBackoff {
+					backoff = logsMaxBackoff
+				}
+			}
+		},
+	}
+
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep streaming new log entries")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output one JSON object per entry")
+	return cmd
+}
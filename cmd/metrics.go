@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/spf13/cobra"
+)
+
+func newMetricsCmd() *cobra.Command {
+	var (
+		window     string
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "metrics <tunnel_id>",
+		Short: "Show traffic metrics for a tunnel",
+		Long: `Show traffic metrics for a tunnel.
+
+Requests, bytes, and latency are shown per interval over --window. If the
+server does not provide a metrics time series, the cumulative counters for
+the tunnel are shown instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+			tunnelID := args[0]
+
+			metrics, err := c.GetTunnelMetrics(tunnelID, window)
+			if err != nil {
+				// Servers without the metrics endpoint answer 404 or 501.
+				var apiErr *client.APIError
+				if !client.IsNotFound(err) && !(errors.As(err, &apiErr) && apiErr.HTTPStatus == 501) {
+					fail(err)
+				}
+				return printCumulativeMetrics(c, tunnelID, jsonOutput)
+			}
+
+			if jsonOutput {
+				return display.PrintJSON(os.Stdout, metrics)
+			}
+
+			if len(metrics.Buckets) == 0 {
+				fmt.Printf("No traffic in the last %s.\n", metrics.Window)
+				return nil
+			}
+
+			requests := make([]float64, len(metrics.Buckets))
+			for i, b := range metrics.Buckets {
+				requests[i] = float64(b.Requests)
+			}
+			fmt.Printf("Requests (%s):  %s\n\n", metrics.Window, display.Sparkline(requests))
+
+			tbl := display.NewTable("TIME", "REQUESTS", "BYTES IN", "BYTES OUT", "P50", "P95")
+			for col := 1; col <= 5; col++ {
+				tbl.SetAlign(col, display.AlignRight)
+			}
+			for _, b := range metrics.Buckets {
+				tbl.AddRow(
+					b.Start.Local().Format("01-02 15:04"),
+					strconv.FormatInt(b.Requests, 10),
+					display.FormatBytes(b.BytesIn),
+					display.FormatBytes(b.BytesOut),
+					formatLatency(b.LatencyP50Ms),
+					formatLatency(b.LatencyP95Ms),
+				)
+			}
+			tbl.Render(os.Stdout)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&window, "window", "1h", "time window to show, e.g. 1h, 24h, 7d")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output as JSON")
+	return cmd
+}
+
+// printCumulativeMetrics is the fallback when the metrics endpoint is not
+// available: it shows the lifetime counters from GetTunnel.
+func printCumulativeMetrics(c *client.Client, tunnelID string, jsonOutput bool) error {
+	tun, err := c.GetTunnel(tunnelID)
+	if err != nil {
+		if client.IsNotFound(err) {
+			fail(notFoundf("Tunnel %s not found.", tunnelID))
+		}
+		fail(err)
+	}
+
+	if jsonOutput {
+		return display.PrintJSON(os.Stdout, map[string]any{
+			"tunnel_id":     tun.ID,
+			"bytes_in":      tun.BytesIn,
+			"bytes_out":     tun.BytesOut,
+			"request_count": tun.RequestCount,
+		})
+	}
+
+	fmt.Fprintln(os.Stderr, "Time-series metrics are not available; showing totals.")
+	fmt.Printf("Bytes in:        %s\n", display.FormatBytes(tun.BytesIn))
+	fmt.Printf("Bytes out:       %s\n", display.FormatBytes(tun.BytesOut))
+	fmt.Printf("Requests:        %d\n", tun.RequestCount)
+	return nil
+}
+
+func formatLatency(ms float64) string {
+	if ms <= 0 {
+		return "-"
+	}
+	if ms < 1000 {
+		return fmt.Sprintf("%.0fms", ms)
+	}
+	return fmt.Sprintf("%.1fs", ms/1000)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/spf13/cobra"
+)
+
+func newOpenCmd() *cobra.Command {
+	var printOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "open [tunnel_id]",
+		Short: "Open a tunnel's public URL in the browser",
+		Long: `Open a tunnel's public URL in the browser.
+
+Without a tunnel ID, the only active tunnel is opened. If several tunnels
+are active, pass the ID of the one to open.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+
+			var tun *client.TunnelResponse
+			if len(args) == 1 {
+				tun, err = c.GetTunnel(args[0])
+				if err != nil {
+					if client.IsNotFound(err) {
+						fail(notFoundf("Tunnel %s not found.", args[0]))
+					}
+					fail(err)
+				}
+			} else {
+				tunnels, err := c.ListTunnels()
+				if err != nil {
+					fail(err)
+				}
+				var active []client.TunnelResponse
+				for _, t := range tunnels {
+					if t.Status == "active" {
+						active = append(active, t)
+					}
+				}
+				switch len(active) {
+				case 0:
+					failf("No active tunnels.")
+				case 1:
+					tun = &active[0]
+				default:
+					// List the candidates for a person; under --json the
+					// error alone is reported.
+					if !jsonErrors {
+						tbl := display.NewTable("ID", "URL", "NAME")
+						for _, t := range active {
+							tbl.AddRow(t.ID, t.PublicURL, t.Name)
+						}
+						tbl.Render(os.Stderr)
+						fmt.Fprintln(os.Stderr)
+					}
+					failf("Multiple active tunnels. Specify which one to open with 'lt open <tunnel_id>'.")
+				}
+			}
+
+			if printOnly {
+				fmt.Println(tun.PublicURL)
+				return nil
+			}
+
+			infof("Opening %s\n", tun.PublicURL)
+			openBrowser(tun.PublicURL)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&printOnly, "print", false, "print the URL instead of opening it")
+	return cmd
+}
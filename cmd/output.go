@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats accepted by --output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+	outputYAML  = "yaml"
+)
+
+// outputFlags holds the --output flag and its legacy --json alias.
+type outputFlags struct {
+	format string
+	json   bool
+}
+
+// addOutputFlags registers --output/-o and the backward-compatible --json
+// alias on cmd.
+func addOutputFlags(cmd *cobra.Command, o *outputFlags) {
+	cmd.Flags().StringVarP(&o.format, "output", "o", outputTable, "output format: table, json, csv, or yaml")
+	cmd.Flags().BoolVar(&o.json, "json", false, "output as JSON (alias for --output json)")
+}
+
+// infof prints a human-oriented status message to stdout unless --quiet
+// is set. Data a command was asked for, and errors, are printed regardless.
+func infof(format string, args ...any) {
+	if !flagQuiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// printURLs prints each session's public URL on its own line, for
+// --output-url-only.
+func printURLs(sessions []*tunnelSession) {
+	for _, s := range sessions {
+		fmt.Println(s.tun.PublicURL)
+	}
+}
+
+// resolve returns the selected output format, validating the flag value.
+func (o *outputFlags) resolve() (string, error) {
+	format := strings.ToLower(o.format)
+	switch format {
+	case outputTable, outputJSON, outputCSV, outputYAML:
+	default:
+		return "", fmt.Errorf("Invalid --output value %q. Must be one of: table, json, csv, yaml.", o.format)
+	}
+	if o.json {
+		if format != outputTable && format != outputJSON {
+			return "", fmt.Errorf("--json cannot be combined with --output %s.", format)
+		}
+		return outputJSON, nil
+	}
+	return format, nil
+}
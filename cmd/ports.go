@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+)
+
+// sensitivePorts are well-known ports of services that are rarely meant
+// to be public. sensitive_ports in the config replaces this list.
+var sensitivePorts = map[int]string{
+	22:    "SSH",
+	23:    "Telnet",
+	25:    "SMTP",
+	135:   "Windows RPC",
+	445:   "SMB",
+	1433:  "SQL Server",
+	1521:  "Oracle",
+	2375:  "Docker API",
+	3306:  "MySQL",
+	3389:  "Remote Desktop",
+	5432:  "PostgreSQL",
+	5900:  "VNC",
+	6379:  "Redis",
+	9200:  "Elasticsearch",
+	11211: "Memcached",
+	27017: "MongoDB",
+}
+
+// sensitiveService returns a description of the service usually found on
+// port if it is sensitive, and whether it is.
+func sensitiveService(port int) (string, bool) {
+	if cliCfg.SensitivePorts != nil {
+		if !slices.Contains(cliCfg.SensitivePorts, port) {
+			return "", false
+		}
+		if name, ok := sensitivePorts[port]; ok {
+			return name, true
+		}
+		return "a sensitive service", true
+	}
+	name, ok := sensitivePorts[port]
+	return name, ok
+}
+
+// checkSensitivePorts refuses to expose a sensitive port unless force is
+// set or the user confirms interactively.
+func checkSensitivePorts(ports []int, force bool) error {
+	if force {
+		return nil
+	}
+	for _, port := range ports {
+		name, ok := sensitiveService(port)
+		if !ok {
+			continue
+		}
+		if !canPrompt() {
+			return fmt.Errorf("Port %d is usually %s, which should not be public. Pass --force to expose it anyway.", port, name)
+		}
+		if !confirm(fmt.Sprintf("Port %d is usually %s, which should not be public. Expose it anyway?", port, name)) {
+			return fmt.Errorf("Aborted.")
+		}
+	}
+	return nil
+}
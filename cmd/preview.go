@@ -3,18 +3,18 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/carloluisito/launchtunnel-cli/client"
 	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
 	"github.com/spf13/cobra"
 )
 
 func newPreviewCmd() *cobra.Command {
 	var (
-		port        int
+		ports       []int
 		name        string
 		project     string
 		protocol    string
@@ -23,11 +23,15 @@ func newPreviewCmd() *cobra.Command {
 		ipAllow     string
 		subdomain   string
 		localHost   string
-		inspect     bool
+		region      string
 		jsonOutput  bool
-		noReconnect bool
+		urlOnly     bool
+		fwd         forwardFlags
 		description string
 		branch      string
+		idempotent  bool
+		force       bool
+		duration    time.Duration
 	)
 
 	cmd := &cobra.Command{
@@ -38,169 +42,221 @@ func newPreviewCmd() *cobra.Command {
 This is the recommended way to create previews. Use 'lt expose' for
 backward-compatible tunnel creation.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if port == 0 {
-				fmt.Fprintln(os.Stderr, "Error: --port is required")
-				os.Exit(1)
+			// Flags win over the config defaults.
+			if len(ports) == 0 && cliCfg.DefaultPort != 0 {
+				ports = []int{cliCfg.DefaultPort}
 			}
-			if port < 1 || port > 65535 {
-				fmt.Fprintln(os.Stderr, "Invalid port number. Port must be between 1 and 65535.")
-				os.Exit(1)
+			if !cmd.Flags().Changed("protocol") && cliCfg.DefaultProtocol != "" {
+				protocol = cliCfg.DefaultProtocol
+			}
+			if len(ports) == 0 {
+				failf("Error: --port is required")
+			}
+			for _, port := range ports {
+				if port < 1 || port > 65535 {
+					failf("Invalid port number. Port must be between 1 and 65535.")
+				}
+			}
+			if len(ports) > 1 && subdomain != "" {
+				failf("--subdomain cannot be used when exposing multiple ports.")
+			}
+			name, subdomain, err := normalizeNameFlags(name, subdomain, ports)
+			if err != nil {
+				fail(err)
 			}
 
 			proto := strings.ToLower(protocol)
 			if proto != "http" && proto != "tcp" {
-				fmt.Fprintln(os.Stderr, "Invalid protocol. Must be 'http' or 'tcp'.")
-				os.Exit(1)
+				failf("Invalid protocol. Must be 'http' or 'tcp'.")
 			}
 
-			// Normalize "d" suffix to hours for Go's time.ParseDuration.
-			if expires != "" && strings.HasSuffix(expires, "d") {
-				daysStr := strings.TrimSuffix(expires, "d")
-				days, err := strconv.Atoi(daysStr)
-				if err != nil || days <= 0 {
-					fmt.Fprintln(os.Stderr, "Invalid --expires value. Use formats like: 1h, 4h, 8h, 24h, 48h, 7d")
-					os.Exit(1)
-				}
-				expires = strconv.Itoa(days*24) + "h"
+			expires, err := normalizeExpires(expires)
+			if err != nil {
+				failf("Invalid --expires value: %v.", err)
+			}
+
+			opts, err := fwd.resolve()
+			if err != nil {
+				fail(err)
 			}
+			defer opts.close()
+			if jsonOutput || urlOnly {
+				// Keep stderr clean for scripts reading the output.
+				opts.stats = false
+			}
+			if duration < 0 {
+				failf("--duration must be positive.")
+			}
+			opts.duration = duration
 
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			if localHost == "" {
-				localHost = cliCfg.DefaultLocalHost
+			localHost, err = resolveLocalHost(localHost)
+			if err != nil {
+				fail(err)
+			}
+			if err := opts.checkLocalHost(localHost); err != nil {
+				fail(err)
+			}
+			region, err = resolveRegion(region)
+			if err != nil {
+				fail(err)
+			}
+			if err := checkSensitivePorts(ports, force); err != nil {
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
 
-			tun, err := c.CreateTunnel(client.CreateTunnelRequest{
-				Protocol:    proto,
-				LocalPort:   port,
-				LocalHost:   localHost,
-				Name:        name,
-				Subdomain:   subdomain,
-				Description: description,
-				Branch:      branch,
-				ExpiresIn:   expires,
-			})
-			if err != nil {
-				if apiErr, ok := err.(*client.APIError); ok {
-					fmt.Fprintln(os.Stderr, apiErr.Message)
-					os.Exit(1)
+			var sessions []*tunnelSession
+			for _, port := range ports {
+				tun, err := createTunnel(c, client.CreateTunnelRequest{
+					Protocol:    proto,
+					LocalPort:   port,
+					LocalHost:   localHost,
+					Name:        tunnelName(name, port, len(ports)),
+					Subdomain:   subdomain,
+					Description: description,
+					Branch:      branch,
+					ExpiresIn:   expires,
+					Region:      region,
+				}, idempotent)
+				if isDryRun(err) {
+					continue
 				}
-				fmt.Fprintln(os.Stderr, "Unable to reach LaunchTunnel servers. Check your internet connection.")
-				os.Exit(1)
-			}
+				if err != nil {
+					stopTunnels(c, sessions)
+					failAPI(err)
+				}
+				warnRegion(region, tun)
+				sessions = append(sessions, &tunnelSession{
+					tun:       tun,
+					localHost: localHost,
+					localPort: port,
+					proto:     proto,
+				})
 
-			// Set password if --auth was provided.
-			if authMode != "" {
-				if err := c.SetTunnelPassword(tun.ID, authMode); err != nil {
-					if apiErr, ok := err.(*client.APIError); ok {
-						fmt.Fprintln(os.Stderr, apiErr.Message)
-						os.Exit(1)
+				// Set password if --auth was provided.
+				if authMode != "" {
+					if err := c.SetTunnelPassword(tun.ID, authMode); err != nil {
+						stopTunnels(c, sessions)
+						if _, ok := err.(*client.APIError); ok {
+							fail(err)
+						}
+						failf("Failed to set tunnel password.")
 					}
-					fmt.Fprintln(os.Stderr, "Failed to set tunnel password.")
-					os.Exit(1)
 				}
-			}
 
-			// Set IP allowlist if --ip-allow was provided.
-			if ipAllow != "" {
-				ips := strings.Split(ipAllow, ",")
-				for i := range ips {
-					ips[i] = strings.TrimSpace(ips[i])
-				}
-				if err := c.SetTunnelIPAllowlist(tun.ID, ips); err != nil {
-					if apiErr, ok := err.(*client.APIError); ok {
-						fmt.Fprintln(os.Stderr, apiErr.Message)
-						os.Exit(1)
+				// Set IP allowlist if --ip-allow was provided.
+				if ipAllow != "" {
+					ips := strings.Split(ipAllow, ",")
+					for i := range ips {
+						ips[i] = strings.TrimSpace(ips[i])
+					}
+					if err := c.SetTunnelIPAllowlist(tun.ID, ips); err != nil {
+						stopTunnels(c, sessions)
+						if _, ok := err.(*client.APIError); ok {
+							fail(err)
+						}
+						failf("Failed to set IP allowlist.")
 					}
-					fmt.Fprintln(os.Stderr, "Failed to set IP allowlist.")
-					os.Exit(1)
 				}
 			}
 
+			if flagDryRun {
+				return nil
+			}
+
 			if jsonOutput {
-				display.PrintJSON(os.Stdout, map[string]any{
-					"preview_id": tun.ID,
-					"name":       tun.Name,
-					"public_url": tun.PublicURL,
-					"protocol":   tun.Protocol,
-					"local_host": localHost,
-					"local_port": port,
-					"status":     tun.Status,
-					"created_at": tun.CreatedAt.Format(time.RFC3339),
-				})
-			} else {
+				items := make([]map[string]any, 0, len(sessions))
+				for _, s := range sessions {
+					item := map[string]any{
+						"preview_id": s.tun.ID,
+						"name":       s.tun.Name,
+						"public_url": s.tun.PublicURL,
+						"protocol":   s.tun.Protocol,
+						"local_host": s.localHost,
+						"local_port": s.localPort,
+						"status":     s.tun.Status,
+						"created_at": s.tun.CreatedAt.Format(time.RFC3339),
+					}
+					if s.tun.Region != "" {
+						item["region"] = s.tun.Region
+					}
+					items = append(items, item)
+				}
+				if len(items) == 1 {
+					display.PrintJSON(os.Stdout, items[0])
+				} else {
+					display.PrintJSON(os.Stdout, items)
+				}
+			} else if urlOnly {
+				printURLs(sessions)
+			} else if !flagQuiet {
 				fmt.Println()
 				fmt.Println("  Preview is live!")
 				fmt.Println()
-				fmt.Printf("    URL:        %s\n", tun.PublicURL)
-				fmt.Printf("    Name:       %s\n", tun.Name)
-				if project != "" {
-					fmt.Printf("    Project:    %s\n", project)
-				}
-				fmt.Printf("    Protocol:   %s\n", tun.Protocol)
-				fmt.Printf("    Local:      %s:%d\n", localHost, port)
-				if tun.ExpiresAt != nil {
-					fmt.Printf("    Expires:    %s\n", formatDuration(time.Until(*tun.ExpiresAt)))
+				for _, s := range sessions {
+					fmt.Printf("    URL:        %s\n", s.tun.PublicURL)
+					fmt.Printf("    Name:       %s\n", s.tun.Name)
+					if project != "" {
+						fmt.Printf("    Project:    %s\n", project)
+					}
+					fmt.Printf("    Protocol:   %s\n", s.tun.Protocol)
+					fmt.Printf("    Local:      %s\n", tunnel.LocalTarget(s.localHost, s.localPort))
+					if s.tun.Region != "" {
+						fmt.Printf("    Region:     %s\n", s.tun.Region)
+					}
+					if s.tun.ExpiresAt != nil {
+						fmt.Printf("    Expires:    %s\n", formatExpiry(s.tun))
+					}
+					fmt.Printf("    Preview ID: %s\n", s.tun.ID)
+					fmt.Println()
 				}
-				fmt.Printf("    Preview ID: %s\n", tun.ID)
-				fmt.Println()
 			}
 
 			// Connect to the relay.
-			conn, err := dialRelay(tun.RelayEndpoint, tun.SessionToken)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to connect to relay: %v\n", err)
-				os.Exit(2)
+			for _, s := range sessions {
+				conn, err := dialRelay(s.tun.RelayEndpoint, s.tun.SessionToken)
+				if err != nil {
+					stopTunnels(c, sessions)
+					fail(connectionErrorf("Failed to connect to relay: %v", err))
+				}
+				s.conn = conn
 			}
 
-			if !jsonOutput {
+			if !jsonOutput && !urlOnly && !flagQuiet {
 				fmt.Println("  Press Ctrl+C to stop.")
 				fmt.Println()
 			}
 
-			return runTunnelLoop(conn, tun, localHost, port, proto, inspect, noReconnect, c)
+			return runTunnels(sessions, opts, c)
 		},
 	}
 
-	cmd.Flags().IntVar(&port, "port", 0, "local port to expose (required)")
+	cmd.Flags().IntSliceVar(&ports, "port", nil, "local port to expose (repeatable or comma-separated; required unless default_port is set in config)")
 	cmd.Flags().StringVar(&name, "name", "", "preview name (alphanumeric + hyphens, 3-63 chars)")
 	cmd.Flags().StringVar(&project, "project", "", "assign to a project (default: personal)")
-	cmd.Flags().StringVar(&protocol, "protocol", "http", "protocol: http or tcp")
-	cmd.Flags().StringVar(&expires, "expires", "", "auto-expire: 1h, 4h, 8h, 24h, 48h, 7d")
+	cmd.Flags().StringVar(&protocol, "protocol", "http", "protocol: http or tcp (overrides default_protocol in config)")
+	cmd.Flags().StringVar(&expires, "expires", "", "auto-expire after this long: e.g. 30m, 8h, 7d, 2w")
 	cmd.Flags().StringVar(&authMode, "auth", "", "access control: password")
 	cmd.Flags().StringVar(&ipAllow, "ip-allow", "", "comma-separated IP/CIDR allowlist")
 	cmd.Flags().StringVar(&subdomain, "subdomain", "", "custom subdomain (Pro only)")
-	cmd.Flags().StringVar(&localHost, "local-host", "", "local hostname to forward to (default: 127.0.0.1)")
-	cmd.Flags().BoolVar(&inspect, "inspect", false, "enable request logging")
+	cmd.Flags().StringVar(&region, "region", "", "preferred relay region, e.g. eu-west (default: default_region from config, or the server's choice)")
+	cmd.Flags().StringVar(&localHost, "local-host", "", "local hostname or IP address to forward to, e.g. ::1 for IPv6 (default: 127.0.0.1)")
+	fwd.register(cmd)
+	cmd.Flags().BoolVar(&urlOnly, "output-url-only", false, "print only the public URL to stdout (--json takes precedence)")
 	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output as JSON")
-	cmd.Flags().BoolVar(&noReconnect, "no-reconnect", false, "disable automatic reconnection")
 	cmd.Flags().StringVar(&description, "description", "", "preview description")
 	cmd.Flags().StringVar(&branch, "branch", "", "git branch name")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "stop the tunnel and exit after this long, e.g. 30m (independent of --expires)")
+	cmd.Flags().BoolVar(&force, "force", false, "expose sensitive ports such as 22 (SSH) or 5432 (PostgreSQL) without asking")
+	cmd.Flags().BoolVar(&idempotent, "idempotent", false, "send an idempotency key so the server can dedupe retried creates")
 
 	_ = cmd.MarkFlagRequired("port")
 
 	return cmd
 }
-
-// formatDuration formats a duration into a human-readable string.
-func formatDuration(d time.Duration) string {
-	if d < 0 {
-		return "expired"
-	}
-	hours := int(d.Hours())
-	if hours < 1 {
-		minutes := int(d.Minutes())
-		return "in " + strconv.Itoa(minutes) + " minutes"
-	}
-	if hours < 24 {
-		return "in " + strconv.Itoa(hours) + " hours"
-	}
-	days := hours / 24
-	return "in " + strconv.Itoa(days) + " days"
-}
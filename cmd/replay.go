@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+	"github.com/spf13/cobra"
+)
+
+func newReplayCmd() *cobra.Command {
+	var (
+		port      int
+		localHost string
+		index     int
+		all       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "replay <har-file>",
+		Short: "Re-send a recorded request to the local app",
+		Long: `Re-send a request recorded in a HAR file straight to the local app,
+without a tunnel, and print the response.
+
+The first request is replayed unless --index picks another (0-based).
+With --all, every request is replayed in order.`,
+		Example: `  lt replay webhook.har --port 3000
+  lt replay session.har --port 3000 --index 2
+  lt replay session.har --port 3000 --all`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if port < 1 || port > 65535 {
+				failf("Provide the local port to replay against with --port.")
+			}
+			localHost, err := resolveLocalHost(localHost)
+			if err != nil {
+				fail(err)
+			}
+
+			har, err := tunnel.ReadHAR(args[0])
+			if err != nil {
+				fail(err)
+			}
+			entries := har.Log.Entries
+			if len(entries) == 0 {
+				failf("%s contains no requests.", args[0])
+			}
+			if !all {
+				if index < 0 || index >= len(entries) {
+					failf("Invalid --index %d. %s has %d request(s).", index, args[0], len(entries))
+				}
+				entries = entries[index : index+1]
+			}
+
+			var failures []error
+			for i, e := range entries {
+				if i > 0 {
+					fmt.Println()
+				}
+				if err := replayEntry(e.Request, localHost, port); err != nil {
+					failures = append(failures, err)
+					// With --all, say which request failed and carry on.
+					if len(entries) > 1 {
+						fmt.Fprintln(os.Stderr, err)
+					}
+				}
+			}
+			switch {
+			case len(failures) == 0:
+				return nil
+			case len(entries) == 1:
+				fail(connectionErrorf("%v", failures[0]))
+			default:
+				fail(connectionErrorf("%d of %d requests could not be replayed.", len(failures), len(entries)))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&port, "port", 0, "local port to send the request to")
+	cmd.Flags().StringVar(&localHost, "local-host", "", "local hostname or IP address to send the request to (default: 127.0.0.1)")
+	cmd.Flags().IntVar(&index, "index", 0, "which request in the file to replay (0-based)")
+	cmd.Flags().BoolVar(&all, "all", false, "replay every request in the file, in order")
+	return cmd
+}
+
+// replayEntry sends one recorded request and prints the response.
+func replayEntry(r tunnel.HARRequest, localHost string, port int) error {
+	req, err := r.NewRequest(localHost, port)
+	if err != nil {
+		return err
+	}
+	fmt.Println(display.Bold(fmt.Sprintf("%s %s", req.Method, req.URL.RequestURI())))
+
+	start := time.Now()
+	resp, err := tunnel.Replay(req)
+	if err != nil {
+		return fmt.Errorf("Connection to %s failed: %v. Is your application running?", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("%s %s\n", resp.Proto, statusText(resp))
+	resp.Header.Write(os.Stdout)
+	fmt.Println()
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	fmt.Println()
+	fmt.Fprintf(os.Stderr, "(%s)\n", time.Since(start).Truncate(time.Millisecond))
+	return nil
+}
+
+// statusText formats resp's status code, colored by class.
+func statusText(resp *http.Response) string {
+	code := fmt.Sprintf("%d", resp.StatusCode)
+	return httpStatusColor(code) + " " + http.StatusText(resp.StatusCode)
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+	"github.com/spf13/cobra"
+)
+
+func newRestartCmd() *cobra.Command {
+	var (
+		password   string
+		fwd        forwardFlags
+		jsonOutput bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restart <tunnel_id>",
+		Short: "Recreate a tunnel with the same settings and reconnect to it",
+		Long: `Recreate a tunnel with the same settings and reconnect to it.
+
+The existing tunnel is deleted and a new one is created with the same
+protocol, local target, subdomain, name, description, branch, and remaining
+expiry. The IP allowlist is re-applied. Passwords cannot be read back from
+the API, so pass --auth to re-apply password protection.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := fwd.resolve()
+			if err != nil {
+				fail(err)
+			}
+			defer opts.close()
+
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+
+			old, err := c.GetTunnel(args[0])
+			if err != nil {
+				if client.IsNotFound(err) {
+					fail(notFoundf("Tunnel %s not found.", args[0]))
+				}
+				fail(err)
+			}
+
+			req := client.CreateTunnelRequest{
+				Protocol:    old.Protocol,
+				LocalPort:   old.LocalPort,
+				LocalHost:   old.LocalHost,
+				Name:        old.Name,
+				Subdomain:   old.Subdomain,
+				WorkspaceID: old.WorkspaceID,
+				Description: old.Description,
+				Branch:      old.Branch,
+				Region:      old.Region,
+			}
+			if remaining, ok := old.TimeUntilExpiry(); ok {
+				if remaining <= 0 {
+					failf("Tunnel %s has expired and cannot be restarted.", old.ID)
+				}
+				req.ExpiresIn = remainingExpires(remaining)
+			}
+			req.LocalHost, err = resolveLocalHost(req.LocalHost)
+			if err != nil {
+				fail(err)
+			}
+			if err := opts.checkLocalHost(req.LocalHost); err != nil {
+				fail(err)
+			}
+
+			if err := c.DeleteTunnel(old.ID); err != nil && !isDryRun(err) {
+				if !client.IsNotFound(err) {
+					failf("Failed to stop %s: %v", old.ID, err)
+				}
+			}
+
+			tun, err := c.CreateTunnel(req)
+			if isDryRun(err) {
+				return nil
+			}
+			if err != nil {
+				failAPI(err)
+			}
+
+			if password != "" {
+				if err := c.SetTunnelPassword(tun.ID, password); err != nil {
+					failf("Failed to set tunnel password.")
+				}
+			} else if old.PasswordProtected {
+				fmt.Fprintln(os.Stderr, "Warning: the previous tunnel was password protected. Pass --auth to re-apply a password.")
+			}
+
+			if len(old.IPAllowlist) > 0 {
+				if err := c.SetTunnelIPAllowlist(tun.ID, old.IPAllowlist); err != nil {
+					failf("Failed to set IP allowlist.")
+				}
+			}
+
+			if jsonOutput {
+				display.PrintJSON(os.Stdout, map[string]any{
+					"tunnel_id":          tun.ID,
+					"previous_tunnel_id": old.ID,
+					"public_url":         tun.PublicURL,
+					"protocol":           tun.Protocol,
+					"local_host":         req.LocalHost,
+					"local_port":         req.LocalPort,
+					"status":             tun.Status,
+					"created_at":         tun.CreatedAt.Format(time.RFC3339),
+				})
+			} else if !flagQuiet {
+				fmt.Println("Tunnel restarted successfully.")
+				fmt.Println()
+				fmt.Printf("  Public URL:    %s\n", tun.PublicURL)
+				fmt.Printf("  Protocol:      %s\n", tun.Protocol)
+				fmt.Printf("  Local target:  %s\n", tunnel.LocalTarget(req.LocalHost, req.LocalPort))
+				fmt.Printf("  Tunnel ID:     %s (was %s)\n", tun.ID, old.ID)
+				fmt.Printf("  Status:        %s\n", display.StatusColor(tun.Status))
+				fmt.Println()
+			}
+
+			conn, err := dialRelay(tun.RelayEndpoint, tun.SessionToken)
+			if err != nil {
+				_ = c.StopTunnel(tun.ID)
+				fail(connectionErrorf("Failed to connect to relay: %v", err))
+			}
+
+			if !jsonOutput && !flagQuiet {
+				fmt.Println("Press Ctrl+C to stop the tunnel.")
+			}
+
+			return runTunnels([]*tunnelSession{{
+				tun:       tun,
+				conn:      conn,
+				localHost: req.LocalHost,
+				localPort: req.LocalPort,
+				proto:     tun.Protocol,
+			}}, opts, c)
+		},
+	}
+
+	cmd.Flags().StringVar(&password, "auth", "", "re-apply password protection with this password")
+	fwd.register(cmd)
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output tunnel metadata as JSON")
+
+	return cmd
+}
+
+// remainingExpires returns the expiry that gives a restarted tunnel the
+// time its predecessor had left, rounded to the minute. It is at least a
+// minute, so a tunnel about to expire does not come back without one.
+func remainingExpires(remaining time.Duration) string {
+	return formatExpires(max(remaining.Round(time.Minute), time.Minute))
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemainingExpires(t *testing.T) {
+	tests := []struct {
+		remaining time.Duration
+		want      string
+	}{
+		{8*time.Hour - 20*time.Second, "8h"},
+		{7*time.Hour + 59*time.Minute, "479m"},
+		{90 * time.Second, "2m"},
+		{20 * time.Second, "1m"},
+		{time.Millisecond, "1m"},
+	}
+	for _, tt := range tests {
+		got := remainingExpires(tt.remaining)
+		if got != tt.want {
+			t.Errorf("remainingExpires(%s) = %q, want %q", tt.remaining, got, tt.want)
+		}
+		if _, err := normalizeExpires(got); err != nil {
+			t.Errorf("remainingExpires(%s) = %q, which normalizeExpires rejects: %v", tt.remaining, got, err)
+		}
+	}
+}
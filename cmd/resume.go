@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/config"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+	"github.com/spf13/cobra"
+)
+
+func newResumeCmd() *cobra.Command {
+	var fwd forwardFlags
+
+	cmd := &cobra.Command{
+		Use:   "resume",
+		Short: "Reconnect to the tunnels of a CLI that exited unexpectedly",
+		Long: `Reconnect to the tunnels of a CLI that exited unexpectedly.
+
+While tunnels run in the foreground, their IDs and relay credentials are
+kept in ~/.launchtunnel/active.json; the file is removed when they are
+stopped cleanly. If the CLI was killed, for example when the machine went
+to sleep, 'lt resume' reconnects to the same tunnels and public URLs
+instead of creating new ones.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := fwd.resolve()
+			if err != nil {
+				fail(err)
+			}
+			defer opts.close()
+
+			state, err := config.LoadActiveState()
+			if err != nil {
+				fail(err)
+			}
+			if state == nil || len(state.Tunnels) == 0 {
+				failf("No tunnel to resume.")
+			}
+			if state.APIURL != "" && state.APIURL != cliCfg.APIURL {
+				failf("The saved tunnels belong to %s, but the CLI is using %s.", state.APIURL, cliCfg.APIURL)
+			}
+
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+
+			var sessions []*tunnelSession
+			for _, saved := range state.Tunnels {
+				tun, err := c.GetTunnel(saved.ID)
+				if err != nil {
+					if client.IsNotFound(err) {
+						_ = config.RemoveActiveTunnel(saved.ID)
+						fail(notFoundf("Tunnel %s no longer exists. Create a new one with 'lt expose'.", saved.ID))
+					}
+					failAPI(err)
+				}
+				if reason := unresumable(tun); reason != "" {
+					_ = config.RemoveActiveTunnel(saved.ID)
+					failf("Tunnel %s %s and cannot be resumed. Create a new one with 'lt expose'.", saved.ID, reason)
+				}
+				if err := opts.checkLocalHost(saved.LocalHost); err != nil {
+					fail(err)
+				}
+
+				// The API does not always return relay credentials for an
+				// existing tunnel; fall back to the saved ones.
+				if tun.RelayEndpoint == "" {
+					tun.RelayEndpoint = saved.RelayEndpoint
+				}
+				if tun.SessionToken == "" {
+					tun.SessionToken = saved.SessionToken
+				}
+				sessions = append(sessions, &tunnelSession{
+					tun:       tun,
+					localHost: saved.LocalHost,
+					localPort: saved.LocalPort,
+					proto:     saved.Protocol,
+				})
+			}
+
+			for _, s := range sessions {
+				conn, err := dialRelay(s.tun.RelayEndpoint, s.tun.SessionToken)
+				if err != nil {
+					for _, s := range sessions {
+						if s.conn != nil {
+							s.conn.CloseNow()
+						}
+					}
+					fail(connectionErrorf("Failed to reconnect to relay: %v", err))
+				}
+				s.conn = conn
+			}
+
+			if !flagQuiet {
+				fmt.Println("Tunnel resumed.")
+				fmt.Println()
+				for _, s := range sessions {
+					fmt.Printf("  Public URL:    %s\n", s.tun.PublicURL)
+					fmt.Printf("  Local target:  %s\n", tunnel.LocalTarget(s.localHost, s.localPort))
+					fmt.Printf("  Tunnel ID:     %s\n", s.tun.ID)
+					fmt.Println()
+				}
+				fmt.Println("Press Ctrl+C to stop the tunnel.")
+			}
+
+			return runTunnels(sessions, opts, c)
+		},
+	}
+
+	fwd.register(cmd)
+	return cmd
+}
+
+// unresumable returns why tun cannot be reconnected to, or "" if it can.
+func unresumable(tun *client.TunnelResponse) string {
+	switch strings.ToLower(tun.Status) {
+	case "stopped", "expired", "deleted":
+		return "is " + strings.ToLower(tun.Status)
+	}
+	if tun.IsExpired() {
+		return "has expired"
+	}
+	return ""
+}
@@ -1,10 +1,19 @@
 package cmd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/carloluisito/launchtunnel-cli/client"
 	"github.com/carloluisito/launchtunnel-cli/config"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
 	"github.com/spf13/cobra"
 )
 
@@ -21,32 +30,107 @@ var (
 	flagAPIURL     string
 	flagVerbose    bool
 	flagNoColor    bool
+	flagProxy      string
+	flagQuiet      bool
+	flagDryRun     bool
+	flagInsecure   bool
+	flagCACert     string
+	flagTimeout    time.Duration
+
+	flagRelayHeaders    []string
+	flagRelayTokenIn    string
+	flagNoWSCompression bool
+	flagLogLevel        string
+	flagLogFormat       string
 )
 
 // cliCfg is loaded once by the persistent pre-run hook.
 var cliCfg config.CLIConfig
 
+// cliCfgErr is the error loading the config file, kept for commands
+// annotated with annotationConfigOptional, which run with the defaults.
+var cliCfgErr error
+
+// annotationConfigOptional marks a command that runs even if the config
+// file cannot be loaded.
+const annotationConfigOptional = "config-optional"
+
+// relayHTTPClient dials relay WebSockets, through --proxy or the proxy
+// environment variables.
+var relayHTTPClient *http.Client
+
+// relayHeader holds the --relay-header headers sent when dialing a relay.
+var relayHeader http.Header
+
+// apiTimeout limits each control plane request, from --timeout or the
+// config file.
+var apiTimeout = client.DefaultTimeout
+
+// tlsConfig holds the TLS settings from --ca-cert and --insecure for both
+// the API and the relay, or nil for the defaults.
+var tlsConfig *tls.Config
+
 func NewRootCmd() *cobra.Command {
 	root := &cobra.Command{
-		Use:           "lt",
-		Short:         "LaunchTunnel - share what you're building with the world",
+		Use:   "lt",
+		Short: "LaunchTunnel - share what you're building with the world",
+		Long: `LaunchTunnel - share what you're building with the world
+
+Environment:
+  LT_API_KEY   API key to use instead of the one saved by 'lt login'
+  LT_API_URL   control plane API URL (--api-url takes precedence)`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			jsonErrors = wantsJSON(cmd)
 			cfgPath, err := config.ConfigPath(flagConfigPath)
 			if err != nil {
 				return err
 			}
 			cliCfg, err = config.LoadCLIConfig(cfgPath)
+			if err != nil {
+				// Commands that diagnose the setup, like doctor, report a
+				// broken config themselves instead of refusing to run.
+				if _, ok := cmd.Annotations[annotationConfigOptional]; !ok {
+					return err
+				}
+				cliCfgErr = err
+				cliCfg = config.DefaultCLIConfig()
+			}
+			// Color only when writing to a terminal and not opted out via
+			// --no-color or NO_COLOR.
+			display.SetColor(!flagNoColor && display.ShouldColor(os.Stdout))
+			logger, err := newLogger(os.Stderr, flagLogLevel, flagLogFormat, flagVerbose)
+			if err != nil {
+				return err
+			}
+			setLogger(logger)
+			apiTimeout, err = resolveAPITimeout(cmd.Flags().Changed("timeout"))
+			if err != nil {
+				return err
+			}
+			tlsConfig, err = loadTLSConfig(flagCACert, flagInsecure)
 			if err != nil {
 				return err
 			}
-			// Flag > env > credentials file > config file.
+			relayHTTPClient, err = tunnel.RelayHTTPClient(flagProxy, tlsConfig)
+			if err != nil {
+				return fmt.Errorf("--proxy: %w", err)
+			}
+			relayHeader, err = parseRelayHeaders(flagRelayHeaders)
+			if err != nil {
+				return err
+			}
+			if flagRelayTokenIn != "query" && flagRelayTokenIn != "header" {
+				return fmt.Errorf("Invalid --relay-token-in %q. Use query or header.", flagRelayTokenIn)
+			}
+			// Flag > env > credentials file > config file. The URL saved
+			// with the credentials is ignored when LT_API_KEY replaces them.
 			if flagAPIURL != "" {
 				cliCfg.APIURL = flagAPIURL
 			} else if env := os.Getenv("LT_API_URL"); env != "" {
 				cliCfg.APIURL = env
-			} else if creds, _ := config.LoadCredentials(); creds != nil && creds.APIURL != "" {
+			} else if creds, _ := config.LoadCredentials(); creds != nil && creds.APIURL != "" && os.Getenv(apiKeyEnv) == "" {
 				cliCfg.APIURL = creds.APIURL
 			}
 			return nil
@@ -56,17 +140,41 @@ func NewRootCmd() *cobra.Command {
 	root.PersistentFlags().StringVar(&flagConfigPath, "config", "", "path to config file (default: ~/.launchtunnel/config.json)")
 	root.PersistentFlags().StringVar(&flagAPIURL, "api-url", "", "override the control plane API URL")
 	root.PersistentFlags().BoolVar(&flagVerbose, "verbose", false, "enable verbose/debug logging to stderr")
-	root.PersistentFlags().BoolVar(&flagNoColor, "no-color", false, "disable colored output")
+	root.PersistentFlags().StringVar(&flagLogLevel, "log-level", "", "least severe diagnostics logged to stderr: debug, info, warn, or error (default warn, or debug with --verbose)")
+	root.PersistentFlags().StringVar(&flagLogFormat, "log-format", "", "format of diagnostics and --log-file events: text or json (default text for diagnostics, json for events)")
+	root.PersistentFlags().StringVar(&flagProxy, "proxy", "", "proxy for relay connections: http://, https://, or socks5:// URL (default: HTTPS_PROXY/HTTP_PROXY/ALL_PROXY)")
+	root.PersistentFlags().BoolVarP(&flagQuiet, "quiet", "q", false, "suppress non-error status output")
+	root.PersistentFlags().StringVar(&flagCACert, "ca-cert", "", "PEM file of extra CA certificates to trust, for self-hosted servers")
+	root.PersistentFlags().BoolVar(&flagInsecure, "insecure", false, "INSECURE: skip TLS certificate verification for the API and relay; anyone on the network can intercept traffic and your API key")
+	root.PersistentFlags().StringArrayVar(&flagRelayHeaders, "relay-header", nil, "add a header to relay connections, as 'Key: Value', e.g. for an auth proxy (repeatable)")
+	root.PersistentFlags().StringVar(&flagRelayTokenIn, "relay-token-in", "query", "send the relay session token in the URL (query) or an X-Lt-Session-Token header (header)")
+	root.PersistentFlags().BoolVar(&flagNoWSCompression, "no-ws-compression", false, "disable WebSocket compression on relay connections (also ws_compression in config)")
+	root.PersistentFlags().DurationVar(&flagTimeout, "timeout", client.DefaultTimeout, "time limit for each control plane request, overriding api_timeout in the config; 0 disables it")
+	root.PersistentFlags().BoolVar(&flagDryRun, "dry-run", false, "print the API requests that would change anything instead of sending them")
+	root.PersistentFlags().BoolVar(&flagNoColor, "no-color", false, "disable colored output (also honors NO_COLOR)")
 
 	root.AddCommand(
 		newPreviewCmd(),
 		newExposeCmd(),
+		newProtocolCmd("http"),
+		newProtocolCmd("tcp"),
 		newListCmd(),
 		newStopCmd(),
+		newDeleteCmd(),
+		newRestartCmd(),
+		newResumeCmd(),
+		newUpCmd(),
+		newDownCmd(),
 		newStatusCmd(),
+		newDoctorCmd(),
+		newLogsCmd(),
+		newMetricsCmd(),
+		newOpenCmd(),
+		newReplayCmd(),
 		newVersionCmd(),
 		newLoginCmd(),
 		newLogoutCmd(),
+		newWhoamiCmd(),
 		newSignupCmd(),
 		newAPIKeyCmd(),
 	)
@@ -77,20 +185,111 @@ func NewRootCmd() *cobra.Command {
 // Execute runs the root command and exits with the appropriate code.
 func Execute() {
 	if err := NewRootCmd().Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		fail(err)
 	}
 }
 
-// requireAuth loads credentials and returns the API key, or prints an error and
-// returns an empty string.
+// newClient returns a control plane client authenticated with apiKey.
+// With --dry-run, mutating requests are described on stdout instead of
+// sent. extra options are applied last, e.g. to shorten the timeout.
+func newClient(apiKey string, extra ...client.Option) *client.Client {
+	opts := []client.Option{client.WithTimeout(apiTimeout)}
+	if tlsConfig != nil {
+		opts = append(opts, client.WithTLSConfig(tlsConfig))
+	}
+	opts = append(opts, extra...)
+	c := client.New(cliCfg.APIURL, apiKey, opts...)
+	if flagDryRun {
+		c.SetDryRun(os.Stdout)
+	}
+	return c
+}
+
+// isDryRun reports whether err is a request that --dry-run did not send.
+func isDryRun(err error) bool {
+	return errors.Is(err, client.ErrDryRun)
+}
+
+// parseRelayHeaders parses --relay-header values.
+func parseRelayHeaders(specs []string) (http.Header, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	h := make(http.Header)
+	for _, spec := range specs {
+		k, v, err := tunnel.ParseHeader(spec)
+		if err != nil {
+			return nil, fmt.Errorf("--relay-header: %w", err)
+		}
+		h.Add(k, v)
+	}
+	return h, nil
+}
+
+// resolveAPITimeout picks the control plane request timeout: --timeout if
+// given, then api_timeout from the config file, then the default.
+func resolveAPITimeout(flagSet bool) (time.Duration, error) {
+	d := client.DefaultTimeout
+	switch {
+	case flagSet:
+		d = flagTimeout
+	case cliCfg.APITimeout != "":
+		v, err := time.ParseDuration(cliCfg.APITimeout)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid api_timeout %q in config. Use a duration like 30s.", cliCfg.APITimeout)
+		}
+		d = v
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("--timeout must not be negative.")
+	}
+	return d, nil
+}
+
+// loadTLSConfig builds the TLS settings for --ca-cert and --insecure. It
+// returns nil when neither is set so the defaults apply.
+func loadTLSConfig(caCert string, insecure bool) (*tls.Config, error) {
+	if caCert == "" && !insecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("--ca-cert: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("--ca-cert: no certificates found in %s", caCert)
+		}
+		cfg.RootCAs = pool
+	}
+	if insecure {
+		fmt.Fprintln(os.Stderr, "Warning: TLS certificate verification is disabled (--insecure).")
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+// apiKeyEnv names the environment variable that supplies an API key
+// without 'lt login', for CI and other stateless environments.
+const apiKeyEnv = "LT_API_KEY"
+
+// requireAuth returns the API key: LT_API_KEY if set, otherwise the key
+// saved by 'lt login'.
 func requireAuth() (string, error) {
+	if key := strings.TrimSpace(os.Getenv(apiKeyEnv)); key != "" {
+		return key, nil
+	}
 	creds, err := config.LoadCredentials()
 	if err != nil {
 		return "", fmt.Errorf("reading credentials: %w", err)
 	}
 	if creds == nil || creds.APIKey == "" {
-		return "", fmt.Errorf("Not authenticated. Run 'lt login' first.")
+		return "", unauthorizedf("Not authenticated. Run 'lt login' first, or set %s.", apiKeyEnv)
 	}
 	return creds.APIKey, nil
 }
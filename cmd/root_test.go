@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/config"
+)
+
+func TestRequireAuth_ResolutionOrder(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(apiKeyEnv, "")
+
+	if _, err := requireAuth(); err == nil || !strings.Contains(err.Error(), apiKeyEnv) {
+		t.Fatalf("no key: got %v, want an error mentioning %s", err, apiKeyEnv)
+	}
+
+	if err := config.SaveCredentials(&config.Credentials{APIKey: "lt_file"}); err != nil {
+		t.Fatalf("SaveCredentials: %v", err)
+	}
+	if key, err := requireAuth(); err != nil || key != "lt_file" {
+		t.Errorf("credentials file: got %q, %v; want lt_file", key, err)
+	}
+
+	t.Setenv(apiKeyEnv, " lt_env\n")
+	if key, err := requireAuth(); err != nil || key != "lt_env" {
+		t.Errorf("env and file: got %q, %v; want the env key to win", key, err)
+	}
+
+	if err := config.RemoveCredentials(); err != nil {
+		t.Fatalf("RemoveCredentials: %v", err)
+	}
+	if key, err := requireAuth(); err != nil || key != "lt_env" {
+		t.Errorf("env only: got %q, %v; want lt_env", key, err)
+	}
+}
+
+func TestResolveAPITimeout(t *testing.T) {
+	oldCfg, oldFlag := cliCfg, flagTimeout
+	defer func() { cliCfg, flagTimeout = oldCfg, oldFlag }()
+
+	tests := []struct {
+		name    string
+		flagSet bool
+		flag    time.Duration
+		cfg     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", want: client.DefaultTimeout},
+		{name: "config", cfg: "2m", want: 2 * time.Minute},
+		{name: "config disables", cfg: "0", want: 0},
+		{name: "flag beats config", flagSet: true, flag: 5 * time.Second, cfg: "2m", want: 5 * time.Second},
+		{name: "flag disables", flagSet: true, flag: 0, cfg: "2m", want: 0},
+		{name: "bad config", cfg: "soon", wantErr: true},
+		{name: "negative", flagSet: true, flag: -time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cliCfg.APITimeout, flagTimeout = tt.cfg, tt.flag
+			got, err := resolveAPITimeout(tt.flagSet)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %s, %v; want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		level, format string
+		verbose       bool
+		wantDebug     bool
+		wantWarn      bool
+		wantJSON      bool
+	}{
+		{"", "", false, false, true, false},
+		{"", "", true, true, true, false},
+		{"error", "", true, false, false, false},
+		{"DEBUG", "json", false, true, true, true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger, err := newLogger(&buf, tt.level, tt.format, tt.verbose)
+		if err != nil {
+			t.Fatalf("newLogger(%q, %q, %v): %v", tt.level, tt.format, tt.verbose, err)
+		}
+		logger.Debug("debug line")
+		logger.Warn("warn line")
+		out := buf.String()
+		if got := strings.Contains(out, "debug line"); got != tt.wantDebug {
+			t.Errorf("newLogger(%q, %q, %v): logged debug = %v, want %v", tt.level, tt.format, tt.verbose, got, tt.wantDebug)
+		}
+		if got := strings.Contains(out, "warn line"); got != tt.wantWarn {
+			t.Errorf("newLogger(%q, %q, %v): logged warn = %v, want %v", tt.level, tt.format, tt.verbose, got, tt.wantWarn)
+		}
+		if got := strings.HasPrefix(out, "{"); out != "" && got != tt.wantJSON {
+			t.Errorf("newLogger(%q, %q, %v): output %q, want JSON = %v", tt.level, tt.format, tt.verbose, out, tt.wantJSON)
+		}
+	}
+
+	for _, bad := range [][2]string{{"trace", ""}, {"", "xml"}} {
+		if _, err := newLogger(io.Discard, bad[0], bad[1], false); err == nil {
+			t.Errorf("newLogger(%q, %q): got nil error", bad[0], bad[1])
+		}
+	}
+}
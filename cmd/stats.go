@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+)
+
+// statsInterval is how often --stats refreshes the throughput line.
+const statsInterval = 5 * time.Second
+
+// runStatsLine writes a summary of m's counters to w every interval until
+// ctx is done. On a terminal the summary rewrites a single line; otherwise
+// each summary is printed on a line of its own.
+func runStatsLine(ctx context.Context, w *os.File, m *tunnel.Metrics, interval time.Duration) {
+	rewrite := display.IsTerminal(w)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev tunnel.TunnelStats
+	for {
+		select {
+		case <-ctx.Done():
+			if rewrite {
+				fmt.Fprintln(w)
+			}
+			return
+		case <-ticker.C:
+		}
+		cur := totalStats(m.Snapshot())
+		line := formatStats(cur, prev, interval)
+		prev = cur
+		if rewrite {
+			fmt.Fprint(w, "\r\x1b[K"+line)
+		} else {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// totalStats sums the counters of every tunnel.
+func totalStats(snapshot map[string]tunnel.TunnelStats) tunnel.TunnelStats {
+	var total tunnel.TunnelStats
+	for _, t := range snapshot {
+		total.ActiveStreams += t.ActiveStreams
+		total.Requests += t.Requests
+		total.BytesIn += t.BytesIn
+		total.BytesOut += t.BytesOut
+	}
+	return total
+}
+
+// formatStats describes cur, with transfer rates computed from the change
+// since prev over interval.
+func formatStats(cur, prev tunnel.TunnelStats, interval time.Duration) string {
+	rate := func(now, before int64) string {
+		return display.FormatBytes(int64(float64(now-before)/interval.Seconds())) + "/s"
+	}
+	return fmt.Sprintf("Streams: %d active, %d total  In: %s (%s)  Out: %s (%s)",
+		cur.ActiveStreams, cur.Requests,
+		display.FormatBytes(cur.BytesIn), rate(cur.BytesIn, prev.BytesIn),
+		display.FormatBytes(cur.BytesOut), rate(cur.BytesOut, prev.BytesOut))
+}
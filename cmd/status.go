@@ -1,61 +1,239 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/carloluisito/launchtunnel-cli/client"
 	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
 	"github.com/spf13/cobra"
 )
 
 func newStatusCmd() *cobra.Command {
-	var jsonOutput bool
+	var (
+		output      outputFlags
+		eventsLimit int
+		follow      bool
+		interval    time.Duration
+	)
 
 	cmd := &cobra.Command{
 		Use:   "status <tunnel_id>",
 		Short: "Show the status of a specific tunnel",
-		Args:  cobra.ExactArgs(1),
+		Long: `Show the status of a specific tunnel.
+
+With --follow, the status is fetched again every --interval and redrawn in
+place until Ctrl+C. Combined with --json, each update is printed as one
+line of JSON.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := output.resolve()
+			if err != nil {
+				fail(err)
+			}
+			if eventsLimit < 0 {
+				failf("--events-limit must not be negative.")
+			}
+			if follow {
+				if format != outputTable && format != outputJSON {
+					failf("--follow cannot be combined with --output %s.", format)
+				}
+				if interval <= 0 {
+					failf("--interval must be positive.")
+				}
+			}
+
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
+			if follow {
+				return followStatus(c, args[0], format == outputJSON, eventsLimit, interval)
+			}
 			tun, err := c.GetTunnel(args[0])
 			if err != nil {
-				if apiErr, ok := err.(*client.APIError); ok && apiErr.HTTPStatus == 404 {
-					fmt.Fprintf(os.Stderr, "Tunnel %s not found.\n", args[0])
-					os.Exit(1)
+				if client.IsNotFound(err) {
+					fail(notFoundf("Tunnel %s not found.", args[0]))
 				}
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			if jsonOutput {
+			tun.ConnectionEvents = recentEvents(tun.ConnectionEvents, eventsLimit)
+
+			switch format {
+			case outputJSON:
 				return display.PrintJSON(os.Stdout, tun)
+			case outputYAML:
+				return display.PrintYAML(os.Stdout, tun)
+			case outputCSV:
+				return display.PrintCSV(os.Stdout, []string{
+					"id", "public_url", "protocol", "local_host", "local_port", "status",
+					"created_at", "bytes_in", "bytes_out", "request_count",
+				}, [][]string{{
+					tun.ID,
+					tun.PublicURL,
+					tun.Protocol,
+					tun.LocalHost,
+					strconv.Itoa(tun.LocalPort),
+					tun.Status,
+					tun.CreatedAt.Format(time.RFC3339),
+					strconv.FormatInt(tun.BytesIn, 10),
+					strconv.FormatInt(tun.BytesOut, 10),
+					strconv.FormatInt(tun.RequestCount, 10),
+				}})
 			}
 
-			fmt.Printf("Tunnel ID:       %s\n", tun.ID)
-			fmt.Printf("Public URL:      %s\n", tun.PublicURL)
-			fmt.Printf("Protocol:        %s\n", tun.Protocol)
-			fmt.Printf("Local target:    %s:%d\n", tun.LocalHost, tun.LocalPort)
-			fmt.Printf("Status:          %s\n", tun.Status)
-			fmt.Printf("Uptime:          %s\n", formatUptime(tun.CreatedAt))
-			fmt.Printf("Bytes in:        %s\n", display.FormatBytes(tun.BytesIn))
-			fmt.Printf("Bytes out:       %s\n", display.FormatBytes(tun.BytesOut))
-			fmt.Printf("Requests:        %d\n", tun.RequestCount)
+			printTunnelStatus(os.Stdout, tun)
+			warnNearQuota(apiKey)
 			return nil
 		},
 	}
 
-	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output as JSON")
+	addOutputFlags(cmd, &output)
+	cmd.Flags().IntVar(&eventsLimit, "events-limit", 10, "show at most this many recent connection events (0 for all)")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep polling and redraw the status until interrupted")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "how often --follow polls")
 	return cmd
 }
 
+// printTunnelStatus writes the human-readable status block for tun.
+func printTunnelStatus(w io.Writer, tun *client.TunnelResponse) {
+	fmt.Fprintf(w, "Tunnel ID:       %s\n", tun.ID)
+	fmt.Fprintf(w, "Public URL:      %s\n", tun.PublicURL)
+	fmt.Fprintf(w, "Protocol:        %s\n", tun.Protocol)
+	fmt.Fprintf(w, "Local target:    %s\n", tunnel.LocalTarget(tun.LocalHost, tun.LocalPort))
+	if tun.Region != "" {
+		fmt.Fprintf(w, "Region:          %s\n", tun.Region)
+	}
+	fmt.Fprintf(w, "Status:          %s\n", display.StatusColor(tun.Status))
+	fmt.Fprintf(w, "Uptime:          %s\n", formatUptime(tun.CreatedAt))
+	fmt.Fprintf(w, "Expires:         %s\n", formatExpiry(tun))
+
+	// Right-align the counters so their magnitudes line up.
+	bytesIn := display.FormatBytes(tun.BytesIn)
+	bytesOut := display.FormatBytes(tun.BytesOut)
+	requests := strconv.FormatInt(tun.RequestCount, 10)
+	width := max(len(bytesIn), len(bytesOut), len(requests))
+	fmt.Fprintf(w, "Bytes in:        %*s\n", width, bytesIn)
+	fmt.Fprintf(w, "Bytes out:       %*s\n", width, bytesOut)
+	fmt.Fprintf(w, "Requests:        %*s\n", width, requests)
+
+	if len(tun.ConnectionEvents) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Connection events:")
+		tbl := display.NewTable("WHEN", "EVENT", "REASON")
+		for _, e := range tun.ConnectionEvents {
+			tbl.AddRow(formatAge(e.Timestamp)+" ago", e.Event, e.Reason)
+		}
+		tbl.Render(w)
+	}
+}
+
+// followStatus polls tunnelID every interval until interrupted. On a
+// terminal each status block replaces the previous one; otherwise blocks
+// are printed one after another. With asJSON, each poll is printed as a
+// single line of JSON instead.
+func followStatus(c *client.Client, tunnelID string, asJSON bool, eventsLimit int, interval time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	redraw := !asJSON && display.IsTerminal(os.Stdout)
+	enc := json.NewEncoder(os.Stdout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		last  *client.TunnelResponse
+		lines int
+	)
+	for {
+		tun, err := c.GetTunnel(tunnelID)
+		if client.IsNotFound(err) {
+			fail(notFoundf("Tunnel %s not found.", tunnelID))
+		}
+		if err == nil {
+			tun.ConnectionEvents = recentEvents(tun.ConnectionEvents, eventsLimit)
+			last = tun
+		}
+
+		switch {
+		case asJSON && err != nil:
+			fmt.Fprintln(os.Stderr, err)
+		case asJSON:
+			if err := enc.Encode(tun); err != nil {
+				return err
+			}
+		default:
+			var buf bytes.Buffer
+			if last != nil {
+				printTunnelStatus(&buf, last)
+				fmt.Fprintln(&buf)
+			}
+			if err != nil {
+				fmt.Fprintf(&buf, "Update failed at %s: %v\n", time.Now().Format(time.TimeOnly), err)
+			} else {
+				fmt.Fprintf(&buf, "Updated %s. Press Ctrl+C to stop.\n", time.Now().Format(time.TimeOnly))
+			}
+			if redraw && lines > 0 {
+				// Move back to the start of the previous block and clear it.
+				fmt.Fprintf(os.Stdout, "\x1b[%dA\r\x1b[J", lines)
+			} else if lines > 0 {
+				fmt.Fprintln(os.Stdout)
+			}
+			lines = strings.Count(buf.String(), "\n")
+			_, _ = os.Stdout.Write(buf.Bytes())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// recentEvents sorts events oldest first and keeps the last limit of
+// them. A limit of 0 keeps every event.
+func recentEvents(events []client.ConnectionEvent, limit int) []client.ConnectionEvent {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	if limit > 0 && len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return events
+}
+
+// formatExpiry describes when tun expires: "in 3 hours", "expired", or
+// "never".
+func formatExpiry(tun *client.TunnelResponse) string {
+	d, ok := tun.TimeUntilExpiry()
+	switch {
+	case !ok:
+		return "never"
+	case d <= 0:
+		return "expired"
+	case d < time.Hour:
+		return "in " + strconv.Itoa(int(d.Minutes())) + " minutes"
+	case d < 24*time.Hour:
+		return "in " + strconv.Itoa(int(d.Hours())) + " hours"
+	default:
+		return "in " + strconv.Itoa(int(d.Hours())/24) + " days"
+	}
+}
+
 func formatUptime(created time.Time) string {
 	d := time.Since(created)
 	h := int(d.Hours())
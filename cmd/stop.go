@@ -1,67 +1,155 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
 
 	"github.com/carloluisito/launchtunnel-cli/client"
 	"github.com/spf13/cobra"
 )
 
 func newStopCmd() *cobra.Command {
-	var all bool
+	var (
+		all bool
+		yes bool
+	)
 
 	cmd := &cobra.Command{
 		Use:   "stop [tunnel_id]",
 		Short: "Stop one or all active tunnels",
-		Args:  cobra.MaximumNArgs(1),
+		Long: `Stop one or all active tunnels.
+
+A stopped tunnel stops accepting traffic but is kept on the server, so it
+still shows up in 'lt list' and can be inspected with 'lt status'. Use
+'lt delete' to remove a tunnel for good.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if !all && len(args) == 0 {
-				fmt.Fprintln(os.Stderr, "Provide a tunnel ID or use --all to stop all tunnels.")
-				os.Exit(1)
+				failf("Provide a tunnel ID or use --all to stop all tunnels.")
 			}
 
 			apiKey, err := requireAuth()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				fail(err)
 			}
 
-			c := client.New(cliCfg.APIURL, apiKey)
+			c := newClient(apiKey)
 
 			if all {
 				tunnels, err := c.ListTunnels()
 				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					os.Exit(1)
+					fail(err)
 				}
-				count := 0
+				var running []client.TunnelResponse
 				for _, t := range tunnels {
-					if err := c.DeleteTunnel(t.ID); err != nil {
-						fmt.Fprintf(os.Stderr, "Failed to stop %s: %v\n", t.ID, err)
-						continue
+					if !strings.EqualFold(t.Status, "stopped") {
+						running = append(running, t)
 					}
-					count++
 				}
-				fmt.Printf("Stopped %d tunnel(s).\n", count)
+				if len(running) == 0 {
+					infof("No active tunnels.\n")
+					return nil
+				}
+				if err := confirmDestructive(fmt.Sprintf("Stop all %d tunnel(s)?", len(running)), yes); err != nil {
+					fail(err)
+				}
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+				defer stop()
+				succeeded, failed := applyToTunnels(ctx, running, c.StopTunnel, "stop")
+				reportApplied(ctx, "Stopped", len(running), succeeded, failed)
 				return nil
 			}
 
 			tunnelID := args[0]
-			if err := c.DeleteTunnel(tunnelID); err != nil {
-				if apiErr, ok := err.(*client.APIError); ok && apiErr.HTTPStatus == 404 {
-					fmt.Fprintf(os.Stderr, "Tunnel %s not found.\n", tunnelID)
-					os.Exit(1)
+			if err := c.StopTunnel(tunnelID); err != nil {
+				if isDryRun(err) {
+					return nil
 				}
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				if client.IsNotFound(err) {
+					fail(notFoundf("Tunnel %s not found.", tunnelID))
+				}
+				fail(err)
 			}
 
-			fmt.Printf("Tunnel %s stopped.\n", tunnelID)
+			infof("Tunnel %s stopped.\n", tunnelID)
 			return nil
 		},
 	}
 
 	cmd.Flags().BoolVar(&all, "all", false, "stop all active tunnels")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "do not ask for confirmation")
 	return cmd
 }
+
+// maxConcurrentActions bounds how many per-tunnel API calls
+// applyToTunnels has in flight at once.
+const maxConcurrentActions = 8
+
+// applyToTunnels calls action for each tunnel using up to
+// maxConcurrentActions workers, reporting failures on stderr with verb
+// ("stop", "delete"). Once ctx is cancelled no new calls are started;
+// tunnels that were skipped count as neither succeeded nor failed.
+func applyToTunnels(ctx context.Context, tunnels []client.TunnelResponse, action func(tunnelID string) error, verb string) (succeeded, failed int) {
+	jobs := make(chan string)
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for range min(maxConcurrentActions, len(tunnels)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				err := action(id)
+				if isDryRun(err) {
+					err = nil
+				}
+				mu.Lock()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to %s %s: %v\n", verb, id, err)
+					failed++
+				} else {
+					succeeded++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, t := range tunnels {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- t.ID:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return succeeded, failed
+}
+
+// reportApplied prints the summary of an applyToTunnels run, e.g.
+// "Stopped 3 tunnel(s), 1 failed." past is the capitalized past tense of
+// the action.
+func reportApplied(ctx context.Context, past string, total, succeeded, failed int) {
+	if flagDryRun {
+		infof("Dry run: %d tunnel(s) would be %s.\n", succeeded, strings.ToLower(past))
+		return
+	}
+	msg := fmt.Sprintf("%s %d tunnel(s)", past, succeeded)
+	if failed > 0 {
+		msg += fmt.Sprintf(", %d failed", failed)
+	}
+	if skipped := total - succeeded - failed; skipped > 0 && ctx.Err() != nil {
+		msg += fmt.Sprintf(", %d skipped after interrupt", skipped)
+	}
+	infof("%s.\n", msg)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+)
+
+// fakeStopper records calls made through applyToTunnels and tracks how
+// many were in flight at once.
+type fakeStopper struct {
+	mu       sync.Mutex
+	calls    []string
+	inFlight atomic.Int32
+	peak     atomic.Int32
+	fail     map[string]bool
+	delay    time.Duration
+}
+
+func (f *fakeStopper) StopTunnel(tunnelID string) error {
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		peak := f.peak.Load()
+		if n <= peak || f.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	f.calls = append(f.calls, tunnelID)
+	f.mu.Unlock()
+	if f.fail[tunnelID] {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func makeTunnels(n int) []client.TunnelResponse {
+	tunnels := make([]client.TunnelResponse, n)
+	for i := range tunnels {
+		tunnels[i].ID = fmt.Sprintf("tun_%d", i)
+	}
+	return tunnels
+}
+
+func TestApplyToTunnels_BoundedConcurrency(t *testing.T) {
+	f := &fakeStopper{
+		delay: 20 * time.Millisecond,
+		fail:  map[string]bool{"tun_3": true, "tun_17": true},
+	}
+	tunnels := makeTunnels(30)
+
+	succeeded, failed := applyToTunnels(context.Background(), tunnels, f.StopTunnel, "stop")
+
+	if succeeded != 28 || failed != 2 {
+		t.Errorf("succeeded, failed = %d, %d, want 28, 2", succeeded, failed)
+	}
+	if len(f.calls) != len(tunnels) {
+		t.Errorf("got %d calls, want %d", len(f.calls), len(tunnels))
+	}
+	if peak := f.peak.Load(); peak > maxConcurrentActions {
+		t.Errorf("peak concurrency = %d, want at most %d", peak, maxConcurrentActions)
+	} else if peak < 2 {
+		t.Errorf("peak concurrency = %d, want calls to run in parallel", peak)
+	}
+}
+
+func TestApplyToTunnels_StopsIssuingAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var started atomic.Int32
+	action := func(string) error {
+		if started.Add(1) == 1 {
+			cancel()
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	tunnels := makeTunnels(100)
+
+	succeeded, failed := applyToTunnels(ctx, tunnels, action, "stop")
+
+	if failed != 0 {
+		t.Errorf("failed = %d, want 0", failed)
+	}
+	if succeeded == 0 || succeeded > 2*maxConcurrentActions {
+		t.Errorf("succeeded = %d, want between 1 and %d after cancel", succeeded, 2*maxConcurrentActions)
+	}
+	if int(started.Load()) != succeeded {
+		t.Errorf("started %d calls but %d were counted", started.Load(), succeeded)
+	}
+}
@@ -0,0 +1,601 @@
+package cmd
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/protocol"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+	"github.com/spf13/cobra"
+)
+
+// forwardFlags are the flags shared by every command that runs tunnels in
+// the foreground (expose, preview, restart, up).
+type forwardFlags struct {
+	inspect        bool
+	inspectHeaders bool
+	redactHeaders  []string
+	noReconnect    bool
+	errorPage      string
+
+	requestHeaders        []string
+	responseHeaders       []string
+	removeRequestHeaders  []string
+	removeResponseHeaders []string
+	localBasicAuth        string
+	maxResponseSize       string
+	rateLimit             string
+	bandwidth             string
+	tcpFrameSize          string
+	cache                 bool
+	cacheSize             string
+	compressResponses     bool
+	localScheme           string
+	waitForLocal          bool
+	waitInterval          time.Duration
+	waitTimeout           time.Duration
+	logFile               string
+	metricsAddr           string
+	tui                   bool
+	stats                 bool
+	allowAnyHost          bool
+
+	// Zero means unset: the config file value, then the built-in default,
+	// is used instead.
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	idleConnTimeout       time.Duration
+	responseHeaderTimeout time.Duration
+}
+
+// register adds the shared flags to cmd.
+func (f *forwardFlags) register(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&f.inspect, "inspect", false, "enable request/response inspection logging (HTTP only), tagged with each request's X-Lt-Request-Id")
+	cmd.Flags().BoolVar(&f.inspectHeaders, "inspect-headers", false, "like --inspect, but also log request and response headers")
+	cmd.Flags().StringArrayVar(&f.redactHeaders, "redact-header", nil, "hide this header's value in inspect output, in addition to the defaults (repeatable)")
+	cmd.Flags().BoolVar(&f.noReconnect, "no-reconnect", false, "disable automatic reconnection on disconnect")
+	cmd.Flags().StringVar(&f.errorPage, "error-page", "", "HTML template served when the local app is unreachable (HTTP only)")
+	cmd.Flags().StringArrayVar(&f.requestHeaders, "request-header", nil, "set a header on requests to the local app, as 'Key: Value' (repeatable)")
+	cmd.Flags().StringArrayVar(&f.responseHeaders, "response-header", nil, "set a header on responses from the local app, as 'Key: Value' (repeatable)")
+	cmd.Flags().StringArrayVar(&f.removeRequestHeaders, "remove-request-header", nil, "remove a header from requests to the local app (repeatable)")
+	cmd.Flags().StringArrayVar(&f.removeResponseHeaders, "remove-response-header", nil, "remove a header from responses from the local app (repeatable)")
+	cmd.Flags().StringVar(&f.maxResponseSize, "max-response-size", "", "largest response body to forward, e.g. 50MB (default: unlimited)")
+	cmd.Flags().StringVar(&f.rateLimit, "rate-limit", "", "maximum HTTP requests per tunnel, e.g. 50/s or 600/m; excess requests get 429")
+	cmd.Flags().StringVar(&f.bandwidth, "bandwidth", "", "maximum bytes per second sent back through each tunnel, e.g. 1MB/s")
+	cmd.Flags().StringVar(&f.tcpFrameSize, "tcp-frame-size", "", "largest chunk read from the local app and sent as one frame, up to 10MB (default 256KB, TCP only)")
+	cmd.Flags().BoolVar(&f.cache, "cache", false, "cache cacheable GET responses from the local app in memory (HTTP only)")
+	cmd.Flags().StringVar(&f.cacheSize, "cache-size", "64MB", "maximum memory used by --cache per tunnel")
+	cmd.Flags().BoolVar(&f.compressResponses, "compress-responses", false, "gzip uncompressed responses from the local app for clients that accept it (HTTP only)")
+	cmd.Flags().StringVar(&f.localScheme, "local-scheme", "http", "how to talk to the local app: http, or https for a server that only speaks TLS, whose certificate is not verified (HTTP only)")
+	cmd.Flags().BoolVar(&f.waitForLocal, "wait-for-local", false, "wait until the local port accepts connections before serving traffic")
+	cmd.Flags().DurationVar(&f.waitInterval, "wait-interval", time.Second, "how often --wait-for-local probes the local port")
+	cmd.Flags().DurationVar(&f.waitTimeout, "wait-timeout", time.Minute, "how long --wait-for-local waits before serving anyway (0 waits forever)")
+	cmd.Flags().StringVar(&f.logFile, "log-file", "", "append lifecycle events to this file ('-' for stderr)")
+	cmd.Flags().StringVar(&f.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address, e.g. :9100 (off by default)")
+	cmd.Flags().BoolVar(&f.allowAnyHost, "allow-any-host", false, "forward to any local host, ignoring allowed_local_hosts")
+	cmd.Flags().BoolVar(&f.tui, "tui", false, "show a live dashboard of requests and connection state instead of log output")
+	cmd.Flags().BoolVar(&f.stats, "stats", false, "show a live line of active streams and bytes transferred on stderr")
+	cmd.Flags().IntVar(&f.maxIdleConns, "max-idle-conns", 0, "idle connections kept open to local servers (default 100, or max_idle_conns in config)")
+	cmd.Flags().IntVar(&f.maxIdleConnsPerHost, "max-idle-conns-per-host", 0, "idle connections kept open per local server (default 100, or max_idle_conns_per_host in config)")
+	cmd.Flags().DurationVar(&f.idleConnTimeout, "idle-conn-timeout", 0, "how long idle local connections are kept (default 90s, or idle_conn_timeout in config)")
+	cmd.Flags().DurationVar(&f.responseHeaderTimeout, "response-header-timeout", 0, "fail requests whose local server takes longer than this to send headers (default none, or response_header_timeout in config)")
+	cmd.Flags().StringVar(&f.localBasicAuth, "local-basic-auth", "", "send HTTP basic auth 'user:pass' to the local app when the request has none")
+}
+
+// resolve validates the flags and loads any referenced files, so problems
+// surface before a tunnel is created.
+func (f *forwardFlags) resolve() (*tunnelOptions, error) {
+	opts := &tunnelOptions{
+		inspect:        f.inspect,
+		inspectHeaders: f.inspectHeaders,
+		noReconnect:    f.noReconnect,
+
+		compressResponses: f.compressResponses,
+		localScheme:       strings.ToLower(f.localScheme),
+
+		allowedLocalHosts: cliCfg.AllowedLocalHosts,
+		allowAnyHost:      f.allowAnyHost,
+	}
+	if opts.localScheme != "http" && opts.localScheme != "https" {
+		return nil, fmt.Errorf("Invalid --local-scheme %q. Use http or https.", f.localScheme)
+	}
+	if f.errorPage != "" {
+		data, err := os.ReadFile(f.errorPage)
+		if err != nil {
+			return nil, fmt.Errorf("reading --error-page: %w", err)
+		}
+		opts.errorPage, err = tunnel.ParseErrorPage(string(data))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	opts.requestHeaders, err = headerRules(f.requestHeaders, f.removeRequestHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("request headers: %w", err)
+	}
+	opts.requestHeaders = mergeHeaderRules(cliCfg.DefaultRequestHeaders, cliCfg.RemoveRequestHeaders, opts.requestHeaders)
+	opts.responseHeaders, err = headerRules(f.responseHeaders, f.removeResponseHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("response headers: %w", err)
+	}
+	opts.responseHeaders = mergeHeaderRules(cliCfg.DefaultResponseHeaders, cliCfg.RemoveResponseHeaders, opts.responseHeaders)
+
+	if f.localBasicAuth != "" {
+		// Don't echo the value: it holds a password.
+		if user, _, ok := strings.Cut(f.localBasicAuth, ":"); !ok || user == "" {
+			return nil, fmt.Errorf("--local-basic-auth must be in the form user:pass")
+		}
+		opts.localBasicAuth = f.localBasicAuth
+	}
+
+	if f.maxResponseSize != "" {
+		n, err := parseSize(f.maxResponseSize)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("Invalid --max-response-size %q. Use a size like 512KB, 50MB, or 1GB.", f.maxResponseSize)
+		}
+		opts.maxResponseSize = n
+	}
+
+	if f.rateLimit != "" {
+		rate, err := parseRate(f.rateLimit, func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) })
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --rate-limit %q. Use a rate like 50/s or 600/m.", f.rateLimit)
+		}
+		opts.rateLimit = rate
+	}
+	if f.bandwidth != "" {
+		rate, err := parseRate(f.bandwidth, parseSize)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --bandwidth %q. Use a rate like 512KB/s or 1MB/s.", f.bandwidth)
+		}
+		opts.bandwidth = rate
+	}
+	if f.tcpFrameSize != "" {
+		n, err := parseSize(f.tcpFrameSize)
+		if err != nil || n < 1024 || n > protocol.MaxPayloadSize {
+			return nil, fmt.Errorf("Invalid --tcp-frame-size %q. Use a size from 1KB to 10MB, like 256KB or 1MB.", f.tcpFrameSize)
+		}
+		opts.tcpFrameSize = int(n)
+	}
+
+	if f.waitForLocal {
+		if f.waitInterval <= 0 || f.waitTimeout < 0 {
+			return nil, fmt.Errorf("--wait-interval must be positive and --wait-timeout must not be negative.")
+		}
+		opts.waitInterval = f.waitInterval
+		opts.waitTimeout = f.waitTimeout
+	}
+
+	if f.tui {
+		if !display.IsTerminal(os.Stdout) || !display.IsTerminal(os.Stdin) {
+			return nil, fmt.Errorf("--tui requires an interactive terminal.")
+		}
+		if f.logFile == "-" {
+			return nil, fmt.Errorf("--tui cannot be combined with --log-file -.")
+		}
+	}
+
+	tc, err := f.transportConfig()
+	if err != nil {
+		return nil, err
+	}
+	tunnel.SetTransportConfig(tc)
+
+	if f.cache {
+		n, err := parseSize(f.cacheSize)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("Invalid --cache-size %q. Use a size like 16MB or 1GB.", f.cacheSize)
+		}
+		opts.cacheSize = n
+	}
+
+	if len(f.redactHeaders) > 0 {
+		opts.redactHeaders = append([]string(nil), tunnel.DefaultRedactHeaders...)
+		for _, name := range f.redactHeaders {
+			k, err := tunnel.ParseHeaderName(name)
+			if err != nil {
+				return nil, fmt.Errorf("--redact-header: %w", err)
+			}
+			opts.redactHeaders = append(opts.redactHeaders, k)
+		}
+	}
+
+	// Everything that holds a resource comes last, so a bad flag above
+	// cannot leak it.
+	if f.metricsAddr != "" {
+		// Listen now so a busy port is reported before any tunnel exists.
+		ln, err := net.Listen("tcp", f.metricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("--metrics-addr: %w", err)
+		}
+		opts.metricsListener = ln
+		opts.ensureMetrics()
+	}
+	if f.tui {
+		// The dashboard reads its byte counters from the metrics.
+		opts.ensureMetrics()
+		opts.tui = true
+	}
+	// The dashboard already shows throughput, and --quiet asks for none.
+	if f.stats && !f.tui && !flagQuiet {
+		opts.ensureMetrics()
+		opts.stats = true
+	}
+
+	if f.logFile != "" {
+		var w io.Writer = os.Stderr
+		if f.logFile != "-" {
+			file, err := os.OpenFile(f.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				opts.close()
+				return nil, fmt.Errorf("opening --log-file: %w", err)
+			}
+			opts.logFile = file
+			w = file
+		}
+		if eventLogFormat() == "text" {
+			opts.events = tunnel.MultiSink(tunnel.NewTextEventSink(w), opts.events)
+		} else {
+			opts.events = tunnel.MultiSink(tunnel.NewJSONEventSink(w), opts.events)
+		}
+	}
+	return opts, nil
+}
+
+// headerRules builds the edits for one direction from "Key: Value" specs
+// and bare header names to remove.
+func headerRules(set, remove []string) (tunnel.HeaderRules, error) {
+	var rules tunnel.HeaderRules
+	for _, spec := range set {
+		k, v, err := tunnel.ParseHeader(spec)
+		if err != nil {
+			return rules, err
+		}
+		if rules.Set == nil {
+			rules.Set = make(http.Header)
+		}
+		rules.Set.Add(k, v)
+	}
+	for _, name := range remove {
+		k, err := tunnel.ParseHeaderName(name)
+		if err != nil {
+			return rules, err
+		}
+		rules.Remove = append(rules.Remove, k)
+	}
+	return rules, nil
+}
+
+// mergeHeaderRules combines the header edits from the config, set and
+// remove, with those from flags, which win: a header the flags set or
+// remove is left out of the config's sets. The config was validated when
+// it was loaded.
+func mergeHeaderRules(set map[string]string, remove []string, flags tunnel.HeaderRules) tunnel.HeaderRules {
+	rules := tunnel.HeaderRules{Set: flags.Set.Clone()}
+	for _, name := range remove {
+		rules.Remove = append(rules.Remove, textproto.CanonicalMIMEHeaderKey(name))
+	}
+	rules.Remove = append(rules.Remove, flags.Remove...)
+	for name, value := range set {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if _, ok := flags.Set[name]; ok || slices.Contains(flags.Remove, name) {
+			continue
+		}
+		if rules.Set == nil {
+			rules.Set = make(http.Header)
+		}
+		rules.Set.Set(name, strings.TrimSpace(value))
+	}
+	return rules
+}
+
+// tunnelOptions are the resolved settings runTunnels applies to every tunnel.
+type tunnelOptions struct {
+	inspect        bool
+	inspectHeaders bool
+	redactHeaders  []string
+	noReconnect    bool
+	errorPage      *template.Template
+
+	requestHeaders  tunnel.HeaderRules
+	responseHeaders tunnel.HeaderRules
+	localBasicAuth  string
+	maxResponseSize int64
+	rateLimit       float64 // requests per second
+	bandwidth       float64 // bytes per second
+	tcpFrameSize    int     // 0 means the forwarder's default
+	cacheSize       int64   // 0 disables the response cache
+
+	compressResponses bool
+	localScheme       string
+
+	// waitInterval is non-zero when the local port should be probed
+	// before serving; waitTimeout bounds the wait.
+	waitInterval time.Duration
+	waitTimeout  time.Duration
+
+	// events receives lifecycle events; nil when neither --log-file nor
+	// --metrics-addr is set.
+	events tunnel.EventSink
+
+	// metrics, when set, is served on metricsListener while tunnels run.
+	metrics         *tunnel.Metrics
+	metricsListener net.Listener
+
+	// logFile is the open --log-file, if one other than stderr was given.
+	logFile *os.File
+
+	// tui replaces log output with a live dashboard while tunnels run.
+	tui bool
+
+	// stats prints a periodic throughput line to stderr while tunnels run.
+	stats bool
+
+	// allowedLocalHosts comes from the config; allowAnyHost skips it.
+	allowedLocalHosts []string
+	allowAnyHost      bool
+
+	// duration, if positive, stops every tunnel and exits after that long.
+	duration time.Duration
+}
+
+// close releases what resolve opened: the --log-file and the
+// --metrics-addr listener. The listener may already have been closed by
+// the metrics server, which is harmless.
+func (o *tunnelOptions) close() {
+	if o.logFile != nil {
+		_ = o.logFile.Close()
+	}
+	if o.metricsListener != nil {
+		_ = o.metricsListener.Close()
+	}
+}
+
+// ensureMetrics creates the metrics and subscribes them to the tunnel
+// events, unless that has already happened.
+func (o *tunnelOptions) ensureMetrics() {
+	if o.metrics == nil {
+		o.metrics = tunnel.NewMetrics()
+		o.events = tunnel.MultiSink(o.events, o.metrics)
+	}
+}
+
+// statusWriter returns where connection notices such as reconnects are
+// printed: stderr, unless --quiet is set or the --tui dashboard reports
+// the connection state instead.
+func (o *tunnelOptions) statusWriter() io.Writer {
+	if flagQuiet || o.tui {
+		return io.Discard
+	}
+	return os.Stderr
+}
+
+// transportConfig merges the transport flags over the config file over the
+// built-in defaults.
+func (f *forwardFlags) transportConfig() (tunnel.TransportConfig, error) {
+	tc := tunnel.DefaultTransportConfig()
+
+	pickInt := func(flag, cfg int, name string, dst *int) error {
+		v := cfg
+		if flag != 0 {
+			v = flag
+		}
+		if v < 0 {
+			return fmt.Errorf("--%s must be positive.", name)
+		}
+		if v > 0 {
+			*dst = v
+		}
+		return nil
+	}
+	pickDuration := func(flag time.Duration, cfg, name string, dst *time.Duration) error {
+		v := flag
+		if v == 0 && cfg != "" {
+			d, err := time.ParseDuration(cfg)
+			if err != nil {
+				return fmt.Errorf("Invalid %s %q in config. Use a duration like 30s.", strings.ReplaceAll(name, "-", "_"), cfg)
+			}
+			v = d
+		}
+		if v < 0 {
+			return fmt.Errorf("--%s must be positive.", name)
+		}
+		if v > 0 {
+			*dst = v
+		}
+		return nil
+	}
+
+	if err := pickInt(f.maxIdleConns, cliCfg.MaxIdleConns, "max-idle-conns", &tc.MaxIdleConns); err != nil {
+		return tc, err
+	}
+	if err := pickInt(f.maxIdleConnsPerHost, cliCfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", &tc.MaxIdleConnsPerHost); err != nil {
+		return tc, err
+	}
+	if err := pickDuration(f.idleConnTimeout, cliCfg.IdleConnTimeout, "idle-conn-timeout", &tc.IdleConnTimeout); err != nil {
+		return tc, err
+	}
+	if err := pickDuration(f.responseHeaderTimeout, cliCfg.ResponseHeaderTimeout, "response-header-timeout", &tc.ResponseHeaderTimeout); err != nil {
+		return tc, err
+	}
+	return tc, nil
+}
+
+// resolveLocalHost validates a --local-host value, defaulting to the config's
+// default_local_host, and strips the brackets of an IPv6 address.
+func resolveLocalHost(host string) (string, error) {
+	if host == "" {
+		host = cliCfg.DefaultLocalHost
+	}
+	return tunnel.ParseLocalHost(host)
+}
+
+// checkLocalHost reports whether tunnels may forward to host, so a
+// disallowed target is rejected before any tunnel is created.
+func (o *tunnelOptions) checkLocalHost(host string) error {
+	if o.allowAnyHost {
+		return nil
+	}
+	return tunnel.CheckLocalHost(host, o.allowedLocalHosts)
+}
+
+// forwardOptions returns the forwarder settings for the tunnel with the
+// given ID. Each call gets its own limiters, so limits apply per tunnel.
+func (o *tunnelOptions) forwardOptions(tunnelID string) *tunnel.Options {
+	fo := &tunnel.Options{
+		Inspect:        o.inspect,
+		InspectHeaders: o.inspectHeaders,
+		RedactHeaders:  o.redactHeaders,
+		TunnelID:       tunnelID,
+		ErrorPage:      o.errorPage,
+
+		RequestHeaders:  o.requestHeaders,
+		ResponseHeaders: o.responseHeaders,
+		LocalBasicAuth:  o.localBasicAuth,
+		MaxResponseSize: o.maxResponseSize,
+		TCPFrameSize:    o.tcpFrameSize,
+		Events:          o.events,
+
+		CompressResponses: o.compressResponses,
+		LocalScheme:       o.localScheme,
+
+		AllowedLocalHosts: o.allowedLocalHosts,
+		AllowAnyHost:      o.allowAnyHost,
+	}
+	if o.rateLimit > 0 {
+		// Allow a second's worth of requests in a burst.
+		fo.RateLimit = tunnel.NewLimiter(o.rateLimit, int(math.Ceil(o.rateLimit)))
+	}
+	if o.bandwidth > 0 {
+		fo.Bandwidth = tunnel.NewLimiter(o.bandwidth, int(math.Ceil(o.bandwidth)))
+	}
+	if o.cacheSize > 0 {
+		fo.Cache = tunnel.NewResponseCache(o.cacheSize)
+	}
+	return fo
+}
+
+// parseRate parses "<amount>/<unit>" where unit is s, m, or h, returning
+// the amount per second. parseAmount parses the part before the slash.
+func parseRate(s string, parseAmount func(string) (int64, error)) (float64, error) {
+	amount, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return 0, fmt.Errorf("missing /unit")
+	}
+	n, err := parseAmount(amount)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("rate must be positive")
+	}
+	var per time.Duration
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	default:
+		return 0, fmt.Errorf("unknown unit %q", unit)
+	}
+	return float64(n) / per.Seconds(), nil
+}
+
+// parseSize parses a byte count with an optional B, KB, MB, or GB suffix
+// (binary multiples, case-insensitive).
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+// maxExpires is the longest expiry the control plane accepts.
+const maxExpires = 30 * 24 * time.Hour
+
+// expiresUnits are the units accepted by normalizeExpires.
+var expiresUnits = map[byte]time.Duration{
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// normalizeExpires validates a tunnel expiry such as 30m, 8h, 7d, 2w, or
+// 1d12h and returns it in hours or minutes, e.g. "168h", as the control
+// plane parses expires_in with Go's time.ParseDuration. An empty expiry
+// means none and is returned as is.
+func normalizeExpires(expires string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(expires))
+	if s == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(s, "-") {
+		return "", fmt.Errorf("%q must be positive", expires)
+	}
+
+	var d time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		unit, ok := time.Duration(0), i < len(s)
+		if ok {
+			unit, ok = expiresUnits[s[i]]
+		}
+		n, err := strconv.Atoi(s[:i])
+		if !ok || err != nil {
+			return "", fmt.Errorf("%q is not a duration; use a number followed by m, h, d, or w, e.g. 30m, 8h, 7d", expires)
+		}
+		if time.Duration(n) > maxExpires/unit {
+			return "", fmt.Errorf("%q is longer than the %dd maximum", expires, maxExpires/(24*time.Hour))
+		}
+		d += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+	switch {
+	case d <= 0:
+		return "", fmt.Errorf("%q must be positive", expires)
+	case d > maxExpires:
+		return "", fmt.Errorf("%q is longer than the %dd maximum", expires, maxExpires/(24*time.Hour))
+	}
+	return formatExpires(d), nil
+}
+
+// formatExpires renders d in whole hours if it has no minutes, or else in
+// minutes.
+func formatExpires(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	}
+	return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestForwardFlagsResolve_LogFile(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "events.log")
+	newFlags := func(args ...string) *forwardFlags {
+		t.Helper()
+		var fwd forwardFlags
+		cmd := &cobra.Command{Use: "test"}
+		fwd.register(cmd)
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatalf("ParseFlags(%q): %v", args, err)
+		}
+		return &fwd
+	}
+
+	// --tui fails without a terminal; the log file must not be opened
+	// before that check.
+	if _, err := newFlags("--log-file", logPath, "--tui").resolve(); err == nil || !strings.Contains(err.Error(), "--tui") {
+		t.Fatalf("resolve with --tui: got %v, want a --tui error", err)
+	}
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("log file was created by a failed resolve: %v", err)
+	}
+
+	opts, err := newFlags("--log-file", logPath).resolve()
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if opts.logFile == nil || opts.events == nil {
+		t.Fatal("resolve did not open the log file")
+	}
+	opts.close()
+	if err := opts.logFile.Close(); err == nil {
+		t.Error("close left the log file open")
+	}
+}
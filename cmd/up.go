@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/config"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/carloluisito/launchtunnel-cli/tunnel"
+	"github.com/spf13/cobra"
+)
+
+func newUpCmd() *cobra.Command {
+	var (
+		file string
+		fwd  forwardFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "up",
+		Short: "Bring online every tunnel defined in a launchtunnel.yaml file",
+		Long: `Bring online every tunnel defined in a launchtunnel.yaml file.
+
+Example file:
+
+  tunnels:
+    - name: api
+      port: 8080
+    - name: web
+      port: 3000
+      subdomain: my-web
+      expires: 8h
+      ip_allow: [203.0.113.0/24]
+
+Each entry requires a name and a port; protocol defaults to http. All
+tunnels run under one process and are stopped together on Ctrl+C.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tf, err := config.LoadTunnelsFile(file)
+			if err != nil {
+				fail(err)
+			}
+
+			for _, spec := range tf.Tunnels {
+				if _, err := normalizeExpires(spec.Expires); err != nil {
+					failf("%s: line %d: invalid expires value: %v", file, spec.Line, err)
+				}
+			}
+
+			opts, err := fwd.resolve()
+			if err != nil {
+				fail(err)
+			}
+			defer opts.close()
+			for i, spec := range tf.Tunnels {
+				localHost, err := resolveLocalHost(spec.LocalHost)
+				if err == nil {
+					err = opts.checkLocalHost(localHost)
+				}
+				if err != nil {
+					failf("%s: line %d: %v", file, spec.Line, err)
+				}
+				tf.Tunnels[i].LocalHost = localHost
+			}
+
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+
+			var sessions []*tunnelSession
+			for _, spec := range tf.Tunnels {
+				localHost := spec.LocalHost
+				expires, _ := normalizeExpires(spec.Expires)
+
+				tun, err := c.CreateTunnel(client.CreateTunnelRequest{
+					Protocol:    spec.Protocol,
+					LocalPort:   spec.Port,
+					LocalHost:   localHost,
+					Name:        spec.Name,
+					Subdomain:   spec.Subdomain,
+					Description: spec.Description,
+					Branch:      spec.Branch,
+					ExpiresIn:   expires,
+				})
+				if isDryRun(err) {
+					continue
+				}
+				if err != nil {
+					stopTunnels(c, sessions)
+					if _, ok := err.(*client.APIError); ok {
+						fail(fmt.Errorf("%s: %w", spec.Name, err))
+					}
+					fail(errUnreachable)
+				}
+				sessions = append(sessions, &tunnelSession{
+					tun:       tun,
+					localHost: localHost,
+					localPort: spec.Port,
+					proto:     spec.Protocol,
+				})
+
+				if spec.Password != "" {
+					if err := c.SetTunnelPassword(tun.ID, spec.Password); err != nil {
+						stopTunnels(c, sessions)
+						failf("%s: failed to set tunnel password.", spec.Name)
+					}
+				}
+				if len(spec.IPAllow) > 0 {
+					if err := c.SetTunnelIPAllowlist(tun.ID, spec.IPAllow); err != nil {
+						stopTunnels(c, sessions)
+						failf("%s: failed to set IP allowlist.", spec.Name)
+					}
+				}
+			}
+
+			if flagDryRun {
+				return nil
+			}
+
+			for _, s := range sessions {
+				conn, err := dialRelay(s.tun.RelayEndpoint, s.tun.SessionToken)
+				if err != nil {
+					stopTunnels(c, sessions)
+					fail(connectionErrorf("Failed to connect to relay: %v", err))
+				}
+				s.conn = conn
+			}
+
+			if !flagQuiet {
+				tbl := display.NewTable("NAME", "URL", "PROTOCOL", "LOCAL", "ID")
+				for _, s := range sessions {
+					tbl.AddRow(s.tun.Name, s.tun.PublicURL, s.proto, tunnel.LocalTarget(s.localHost, s.localPort), s.tun.ID)
+				}
+				tbl.Render(os.Stdout)
+				fmt.Println()
+				fmt.Println("Press Ctrl+C to stop all tunnels.")
+			}
+
+			return runTunnels(sessions, opts, c)
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", config.DefaultTunnelsFile, "path to the tunnels file")
+	fwd.register(cmd)
+	return cmd
+}
+
+func newDownCmd() *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Stop every tunnel defined in a launchtunnel.yaml file",
+		Long: `Stop every tunnel defined in a launchtunnel.yaml file.
+
+Tunnels are matched to file entries by name.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tf, err := config.LoadTunnelsFile(file)
+			if err != nil {
+				fail(err)
+			}
+
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+			tunnels, err := c.ListTunnels()
+			if err != nil {
+				fail(err)
+			}
+
+			names := make(map[string]bool, len(tf.Tunnels))
+			for _, spec := range tf.Tunnels {
+				names[spec.Name] = true
+			}
+
+			var matched []client.TunnelResponse
+			for _, t := range tunnels {
+				if names[t.Name] {
+					matched = append(matched, t)
+				}
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			succeeded, failed := applyToTunnels(ctx, matched, c.DeleteTunnel, "stop")
+			reportApplied(ctx, "Stopped", len(matched), succeeded, failed)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", config.DefaultTunnelsFile, "path to the tunnels file")
+	return cmd
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/display"
+	"github.com/spf13/cobra"
+)
+
+// quotaWarnFraction is how much of a plan limit must be in use before list
+// and status warn about it.
+const quotaWarnFraction = 0.8
+
+func newWhoamiCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "whoami",
+		Short: "Show the logged-in account, its plan, and its limits",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			apiKey, err := requireAuth()
+			if err != nil {
+				fail(err)
+			}
+
+			c := newClient(apiKey)
+			resp, err := c.VerifyAPIKey()
+			if err != nil {
+				failAPI(err)
+			}
+			user := resp.User
+			user.Quota = fetchQuota(c, &user)
+
+			if jsonOutput {
+				return display.PrintJSON(os.Stdout, user)
+			}
+			fmt.Printf("Logged in as %s\n", user.Email)
+			printQuota(os.Stdout, user.Tier, user.Quota)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output as JSON")
+	return cmd
+}
+
+// upgradeURL is where the user can raise their plan's limits.
+func upgradeURL() string {
+	return cliCfg.FrontendURL + "/settings/billing"
+}
+
+// fetchQuota returns the quota included with user, or else the one from
+// the quota endpoint. It returns nil if the server reports neither.
+func fetchQuota(c *client.Client, user *client.UserInfo) *client.Quota {
+	if user != nil && user.Quota != nil {
+		return user.Quota
+	}
+	q, err := c.GetQuota()
+	if err != nil {
+		return nil
+	}
+	return q
+}
+
+// printQuota writes the plan and, if known, its limits and usage.
+func printQuota(w io.Writer, tier string, q *client.Quota) {
+	if q != nil && q.Tier != "" {
+		tier = q.Tier
+	}
+	if tier != "" {
+		fmt.Fprintf(w, "Plan:            %s\n", tier)
+	}
+	if q == nil {
+		return
+	}
+	if q.MaxTunnels > 0 {
+		fmt.Fprintf(w, "Tunnels:         %d of %d active\n", q.ActiveTunnels, q.MaxTunnels)
+	} else {
+		fmt.Fprintf(w, "Tunnels:         %d active (unlimited)\n", q.ActiveTunnels)
+	}
+	if q.BandwidthLimit > 0 {
+		line := fmt.Sprintf("%s of %s used", display.FormatBytes(q.BandwidthUsed), display.FormatBytes(q.BandwidthLimit))
+		if q.ResetsAt != nil {
+			line += ", resets " + q.ResetsAt.Local().Format("Jan 2")
+		}
+		fmt.Fprintf(w, "Bandwidth:       %s\n", line)
+	} else if q.BandwidthUsed > 0 {
+		fmt.Fprintf(w, "Bandwidth:       %s used (unlimited)\n", display.FormatBytes(q.BandwidthUsed))
+	}
+}
+
+// quotaWarnings returns a warning for each limit q has used at least
+// quotaWarnFraction of.
+func quotaWarnings(q *client.Quota) []string {
+	if q == nil {
+		return nil
+	}
+	plan := "your plan"
+	if q.Tier != "" {
+		plan = "the " + q.Tier + " plan"
+	}
+	var warnings []string
+	if q.MaxTunnels > 0 && float64(q.ActiveTunnels) >= quotaWarnFraction*float64(q.MaxTunnels) {
+		warnings = append(warnings, fmt.Sprintf("%d of %d tunnels on %s are in use.", q.ActiveTunnels, q.MaxTunnels, plan))
+	}
+	if q.BandwidthLimit > 0 && float64(q.BandwidthUsed) >= quotaWarnFraction*float64(q.BandwidthLimit) {
+		warnings = append(warnings, fmt.Sprintf("%s of the %s bandwidth on %s is used.",
+			display.FormatBytes(q.BandwidthUsed), display.FormatBytes(q.BandwidthLimit), plan))
+	}
+	return warnings
+}
+
+// quotaCheckTimeout bounds the quota request behind warnNearQuota, so a
+// server without quotas cannot hold up list and status for long.
+const quotaCheckTimeout = 3 * time.Second
+
+// warnNearQuota warns on stderr when the account is close to a plan limit.
+// The check is best effort: if the quota cannot be fetched within
+// quotaCheckTimeout, nothing is printed. It is skipped under --quiet.
+func warnNearQuota(apiKey string) {
+	if flagQuiet {
+		return
+	}
+	timeout := quotaCheckTimeout
+	if apiTimeout > 0 {
+		timeout = min(timeout, apiTimeout)
+	}
+	q, err := newClient(apiKey, client.WithTimeout(timeout)).GetQuota()
+	if err != nil {
+		return
+	}
+	warnings := quotaWarnings(q)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if len(warnings) > 0 {
+		fmt.Fprintf(os.Stderr, "Upgrade for higher limits at %s\n", upgradeURL())
+	}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+)
+
+func TestQuotaWarnings(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *client.Quota
+		want []string
+	}{
+		{"none", nil, nil},
+		{"unlimited", &client.Quota{ActiveTunnels: 50, BandwidthUsed: 1 << 40}, nil},
+		{"below", &client.Quota{MaxTunnels: 5, ActiveTunnels: 3, BandwidthLimit: 100, BandwidthUsed: 79}, nil},
+		{"tunnels", &client.Quota{Tier: "free", MaxTunnels: 3, ActiveTunnels: 3},
+			[]string{"3 of 3 tunnels on the free plan are in use."}},
+		{"bandwidth", &client.Quota{BandwidthLimit: 5 << 30, BandwidthUsed: 9 << 29},
+			[]string{"4.5 GB of the 5.0 GB bandwidth on your plan is used."}},
+	}
+	for _, tt := range tests {
+		if got := quotaWarnings(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: quotaWarnings = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrintQuota(t *testing.T) {
+	var buf bytes.Buffer
+	printQuota(&buf, "free", nil)
+	if got := buf.String(); got != "Plan:            free\n" {
+		t.Errorf("tier only: got %q", got)
+	}
+
+	buf.Reset()
+	printQuota(&buf, "free", &client.Quota{Tier: "pro", ActiveTunnels: 2, BandwidthLimit: 1 << 30, BandwidthUsed: 1 << 29})
+	for _, want := range []string{"Plan:            pro\n", "2 active (unlimited)", "512.0 MB of 1.0 GB used"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output %q lacks %q", buf.String(), want)
+		}
+	}
+}
+
+func TestWarnNearQuota_Bounded(t *testing.T) {
+	oldCfg, oldTimeout, oldQuiet := cliCfg, apiTimeout, flagQuiet
+	defer func() { cliCfg, apiTimeout, flagQuiet = oldCfg, oldTimeout, oldQuiet }()
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	cliCfg.APIURL = srv.URL
+
+	flagQuiet = true
+	warnNearQuota("lt_key")
+	if n := requests.Load(); n != 0 {
+		t.Errorf("--quiet: made %d quota requests, want none", n)
+	}
+
+	// A server that never answers holds the check up for at most the
+	// shorter of --timeout and quotaCheckTimeout.
+	flagQuiet = false
+	apiTimeout = 100 * time.Millisecond
+	start := time.Now()
+	warnNearQuota("lt_key")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("warnNearQuota took %s against an unresponsive server", elapsed)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("made %d quota requests, want 1", n)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const configFile = "config.json"
@@ -17,6 +18,49 @@ type CLIConfig struct {
 	DefaultLocalHost string `json:"default_local_host,omitempty"`
 	AutoReconnect    *bool  `json:"auto_reconnect,omitempty"`
 	Inspect          bool   `json:"inspect,omitempty"`
+
+	// WSCompression enables permessage-deflate on relay connections; nil
+	// means the default, on.
+	WSCompression *bool `json:"ws_compression,omitempty"`
+
+	// AllowedLocalHosts are the hosts, IPs or CIDR ranges tunnels may
+	// forward to besides loopback.
+	AllowedLocalHosts []string `json:"allowed_local_hosts,omitempty"`
+
+	// DefaultProtocol and DefaultPort are used by expose and preview when
+	// no protocol or port is given on the command line.
+	DefaultProtocol string `json:"default_protocol,omitempty"`
+	DefaultPort     int    `json:"default_port,omitempty"`
+
+	// DefaultRegion is the relay region expose and preview ask for when
+	// --region is not given; empty lets the server choose.
+	DefaultRegion string `json:"default_region,omitempty"`
+
+	// Local HTTP transport tuning; zero values keep the built-in defaults.
+	// Timeouts are duration strings such as "90s".
+	MaxIdleConns          int    `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost   int    `json:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout       string `json:"idle_conn_timeout,omitempty"`
+	ResponseHeaderTimeout string `json:"response_header_timeout,omitempty"`
+
+	// APITimeout limits each control plane request, as a duration string
+	// such as "2m". "0" disables the limit; empty keeps the 30s default.
+	APITimeout string `json:"api_timeout,omitempty"`
+
+	// Header edits applied to every HTTP tunnel, as a shared policy such as
+	// removing Server or adding X-Env. Removals run before sets. The
+	// --request-header, --response-header, and --remove-*-header flags take
+	// precedence: a flag setting a header replaces the config's value, and
+	// a flag removing one also drops the config's value for it.
+	DefaultRequestHeaders  map[string]string `json:"default_request_headers,omitempty"`
+	DefaultResponseHeaders map[string]string `json:"default_response_headers,omitempty"`
+	RemoveRequestHeaders   []string          `json:"remove_request_headers,omitempty"`
+	RemoveResponseHeaders  []string          `json:"remove_response_headers,omitempty"`
+
+	// SensitivePorts, when set, replaces the built-in list of ports that
+	// expose and preview refuse without --force. An empty list disables
+	// the check.
+	SensitivePorts []int `json:"sensitive_ports,omitempty"`
 }
 
 // DefaultCLIConfig returns the built-in defaults.
@@ -74,5 +118,55 @@ func LoadCLIConfig(path string) (CLIConfig, error) {
 		cfg.FrontendURL = "https://app.launchtunnel.dev"
 	}
 
+	cfg.DefaultProtocol = strings.ToLower(cfg.DefaultProtocol)
+	if cfg.DefaultProtocol != "" && cfg.DefaultProtocol != "http" && cfg.DefaultProtocol != "tcp" {
+		return cfg, fmt.Errorf("invalid default_protocol %q in config: must be http or tcp", cfg.DefaultProtocol)
+	}
+	if cfg.DefaultPort < 0 || cfg.DefaultPort > 65535 {
+		return cfg, fmt.Errorf("invalid default_port %d in config: must be between 1 and 65535", cfg.DefaultPort)
+	}
+
+	for field, headers := range map[string]map[string]string{
+		"default_request_headers":  cfg.DefaultRequestHeaders,
+		"default_response_headers": cfg.DefaultResponseHeaders,
+	} {
+		for name, value := range headers {
+			if err := checkHeader(name, value); err != nil {
+				return cfg, fmt.Errorf("invalid header %q in %s: %w", name, field, err)
+			}
+		}
+	}
+	for field, names := range map[string][]string{
+		"remove_request_headers":  cfg.RemoveRequestHeaders,
+		"remove_response_headers": cfg.RemoveResponseHeaders,
+	} {
+		for _, name := range names {
+			if err := checkHeader(name, ""); err != nil {
+				return cfg, fmt.Errorf("invalid header %q in %s: %w", name, field, err)
+			}
+		}
+	}
+
 	return cfg, nil
 }
+
+// checkHeader checks that name is a valid header name (an RFC 7230 token)
+// and value holds no line breaks or NULs.
+func checkHeader(name, value string) error {
+	if name == "" {
+		return errors.New("empty header name")
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return fmt.Errorf("header name contains %q", c)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return errors.New("value contains control characters")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const activeStateFile = "active.json"
+
+// ActiveState records the tunnels of the running foreground command so
+// that 'lt resume' can reconnect to them if the CLI dies without stopping
+// them.
+type ActiveState struct {
+	APIURL  string         `json:"api_url,omitempty"`
+	SavedAt time.Time      `json:"saved_at"`
+	Tunnels []ActiveTunnel `json:"tunnels"`
+}
+
+// ActiveTunnel is one tunnel in an ActiveState. SessionToken authenticates
+// the relay connection, so the file is written with 0600 permissions.
+type ActiveTunnel struct {
+	ID            string `json:"id"`
+	PublicURL     string `json:"public_url"`
+	Protocol      string `json:"protocol"`
+	LocalHost     string `json:"local_host"`
+	LocalPort     int    `json:"local_port"`
+	RelayEndpoint string `json:"relay_endpoint"`
+	SessionToken  string `json:"session_token"`
+}
+
+// ActiveStatePath returns the full path to the active tunnel state file.
+func ActiveStatePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("determining home directory: %w", err)
+	}
+	return filepath.Join(home, dirName, activeStateFile), nil
+}
+
+// LoadActiveState reads ~/.launchtunnel/active.json. Returns nil, nil if
+// the file does not exist.
+func LoadActiveState() (*ActiveState, error) {
+	p, err := ActiveStatePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading tunnel state: %w", err)
+	}
+
+	var state ActiveState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing tunnel state: %w", err)
+	}
+	return &state, nil
+}
+
+// SaveActiveState writes state to ~/.launchtunnel/active.json with 0600
+// permissions, replacing any previous state.
+func SaveActiveState(state *ActiveState) error {
+	p, err := ActiveStatePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling tunnel state: %w", err)
+	}
+
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		return fmt.Errorf("writing tunnel state: %w", err)
+	}
+	return nil
+}
+
+// RemoveActiveState deletes the active tunnel state file.
+func RemoveActiveState() error {
+	p, err := ActiveStatePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing tunnel state: %w", err)
+	}
+	return nil
+}
+
+// RemoveActiveTunnel drops the tunnel with the given ID from the active
+// tunnel state, keeping the others resumable. The file is deleted once no
+// tunnels are left.
+func RemoveActiveTunnel(id string) error {
+	state, err := LoadActiveState()
+	if err != nil || state == nil {
+		return err
+	}
+
+	kept := state.Tunnels[:0]
+	for _, t := range state.Tunnels {
+		if t.ID != id {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		return RemoveActiveState()
+	}
+	state.Tunnels = kept
+	return SaveActiveState(state)
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestActiveState_RoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if state, err := LoadActiveState(); err != nil || state != nil {
+		t.Fatalf("no file: got %v, %v; want nil, nil", state, err)
+	}
+
+	saved := &ActiveState{
+		APIURL:  "https://api.example.com",
+		SavedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Tunnels: []ActiveTunnel{
+			{ID: "tun_a", PublicURL: "https://a.example.com", Protocol: "http", LocalHost: "localhost", LocalPort: 3000, RelayEndpoint: "wss://relay", SessionToken: "tok_a"},
+			{ID: "tun_b", PublicURL: "https://b.example.com", Protocol: "tcp", LocalHost: "localhost", LocalPort: 5432, RelayEndpoint: "wss://relay", SessionToken: "tok_b"},
+		},
+	}
+	if err := SaveActiveState(saved); err != nil {
+		t.Fatalf("SaveActiveState: %v", err)
+	}
+
+	p, err := ActiveStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("permissions: got %o, want 600", perm)
+	}
+
+	state, err := LoadActiveState()
+	if err != nil {
+		t.Fatalf("LoadActiveState: %v", err)
+	}
+	if state.APIURL != saved.APIURL || !state.SavedAt.Equal(saved.SavedAt) || len(state.Tunnels) != 2 {
+		t.Fatalf("loaded %+v, want %+v", state, saved)
+	}
+	for i := range saved.Tunnels {
+		if state.Tunnels[i] != saved.Tunnels[i] {
+			t.Errorf("tunnel %d: got %+v, want %+v", i, state.Tunnels[i], saved.Tunnels[i])
+		}
+	}
+
+	if err := RemoveActiveTunnel("tun_a"); err != nil {
+		t.Fatalf("RemoveActiveTunnel: %v", err)
+	}
+	state, err = LoadActiveState()
+	if err != nil || state == nil || len(state.Tunnels) != 1 || state.Tunnels[0].ID != "tun_b" {
+		t.Fatalf("after removing tun_a: got %+v, %v; want only tun_b", state, err)
+	}
+
+	if err := RemoveActiveTunnel("tun_b"); err != nil {
+		t.Fatalf("RemoveActiveTunnel: %v", err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("state file still exists after removing the last tunnel: %v", err)
+	}
+
+	if err := SaveActiveState(saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveActiveState(); err != nil {
+		t.Fatalf("RemoveActiveState: %v", err)
+	}
+	if state, err := LoadActiveState(); err != nil || state != nil {
+		t.Errorf("after RemoveActiveState: got %v, %v; want nil, nil", state, err)
+	}
+	if err := RemoveActiveState(); err != nil {
+		t.Errorf("removing a missing file: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTunnelsFile is the file 'lt up' and 'lt down' read when no path is given.
+const DefaultTunnelsFile = "launchtunnel.yaml"
+
+// TunnelsFile is a declarative list of tunnels, typically committed alongside
+// a project as launchtunnel.yaml.
+type TunnelsFile struct {
+	Tunnels []TunnelSpec `yaml:"tunnels"`
+}
+
+// TunnelSpec describes one tunnel in a TunnelsFile.
+type TunnelSpec struct {
+	Name        string   `yaml:"name"`
+	Protocol    string   `yaml:"protocol,omitempty"`
+	Port        int      `yaml:"port"`
+	LocalHost   string   `yaml:"local_host,omitempty"`
+	Subdomain   string   `yaml:"subdomain,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+	Branch      string   `yaml:"branch,omitempty"`
+	Expires     string   `yaml:"expires,omitempty"`
+	Password    string   `yaml:"password,omitempty"`
+	IPAllow     []string `yaml:"ip_allow,omitempty"`
+
+	// Line is the line in the source file where this entry starts.
+	Line int `yaml:"-"`
+}
+
+// LoadTunnelsFile reads and validates a tunnels file. Syntax and validation
+// errors include the offending line number.
+func LoadTunnelsFile(path string) (*TunnelsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tunnels file: %w", err)
+	}
+	tf, err := ParseTunnelsFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tf, nil
+}
+
+// ParseTunnelsFile parses and validates a tunnels document.
+func ParseTunnelsFile(data []byte) (*TunnelsFile, error) {
+	var tf TunnelsFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&tf); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("no tunnels defined")
+		}
+		return nil, fmt.Errorf("parsing YAML: %w", err)
+	}
+
+	// Decode again as a node tree to recover each entry's line number.
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err == nil {
+		if items := tunnelsNode(&root); items != nil {
+			for i := range tf.Tunnels {
+				if i < len(items.Content) {
+					tf.Tunnels[i].Line = items.Content[i].Line
+				}
+			}
+		}
+	}
+
+	if len(tf.Tunnels) == 0 {
+		return nil, errors.New("no tunnels defined")
+	}
+
+	seen := make(map[string]int)
+	for i := range tf.Tunnels {
+		t := &tf.Tunnels[i]
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", t.Line, err)
+		}
+		if prev, ok := seen[t.Name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate tunnel name %q (first defined on line %d)", t.Line, t.Name, prev)
+		}
+		seen[t.Name] = t.Line
+	}
+	return &tf, nil
+}
+
+// validate checks required fields and normalizes defaults.
+func (t *TunnelSpec) validate() error {
+	if t.Name == "" {
+		return errors.New("tunnel is missing required field \"name\"")
+	}
+	if t.Port == 0 {
+		return fmt.Errorf("tunnel %q is missing required field \"port\"", t.Name)
+	}
+	if t.Port < 1 || t.Port > 65535 {
+		return fmt.Errorf("tunnel %q: port must be between 1 and 65535", t.Name)
+	}
+	t.Protocol = strings.ToLower(t.Protocol)
+	if t.Protocol == "" {
+		t.Protocol = "http"
+	}
+	if t.Protocol != "http" && t.Protocol != "tcp" {
+		return fmt.Errorf("tunnel %q: protocol must be 'http' or 'tcp'", t.Name)
+	}
+	return nil
+}
+
+// tunnelsNode returns the sequence node under the top-level "tunnels" key.
+func tunnelsNode(root *yaml.Node) *yaml.Node {
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return nil
+	}
+	m := root.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == "tunnels" && m.Content[i+1].Kind == yaml.SequenceNode {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTunnelsFile(t *testing.T) {
+	const valid = `tunnels:
+  - name: web
+    port: 3000
+  - name: db
+    protocol: TCP
+    port: 5432
+    local_host: 10.0.0.5
+`
+	tf, err := ParseTunnelsFile([]byte(valid))
+	if err != nil {
+		t.Fatalf("ParseTunnelsFile: %v", err)
+	}
+	if len(tf.Tunnels) != 2 {
+		t.Fatalf("got %d tunnels, want 2", len(tf.Tunnels))
+	}
+	web, db := tf.Tunnels[0], tf.Tunnels[1]
+	if web.Name != "web" || web.Port != 3000 || web.Protocol != "http" || web.Line != 2 {
+		t.Errorf("web = %+v, want port 3000, protocol http, line 2", web)
+	}
+	if db.Name != "db" || db.Port != 5432 || db.Protocol != "tcp" || db.LocalHost != "10.0.0.5" || db.Line != 4 {
+		t.Errorf("db = %+v, want port 5432, protocol tcp, local_host 10.0.0.5, line 4", db)
+	}
+}
+
+func TestParseTunnelsFile_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{"empty", "", []string{"no tunnels defined"}},
+		{"no tunnels", "tunnels: []\n", []string{"no tunnels defined"}},
+		// yaml.v3 reports where the enclosing construct starts, not always
+		// the offending line, so only check that a line is given.
+		{"malformed", "tunnels:\n  - name: web\n    port: [3000\n", []string{"parsing YAML", "yaml: line "}},
+		{"bad indentation", "tunnels:\n  - name: web\n   port: 3000\n", []string{"parsing YAML", "yaml: line "}},
+		{"wrong type", "tunnels:\n  - name: web\n    port: http\n", []string{"parsing YAML", "line 3"}},
+		{"unknown field", "tunnels:\n  - name: web\n    port: 3000\n    prot: tcp\n", []string{"line 4", "field prot not found"}},
+		{"missing name", "tunnels:\n  - name: web\n    port: 3000\n  - port: 4000\n", []string{"line 4", `missing required field "name"`}},
+		{"missing port", "tunnels:\n  - name: web\n    protocol: http\n", []string{"line 2", `"web" is missing required field "port"`}},
+		{"port out of range", "tunnels:\n  - name: web\n    port: 70000\n", []string{"line 2", "between 1 and 65535"}},
+		{"bad protocol", "tunnels:\n  - name: web\n    port: 3000\n    protocol: udp\n", []string{"line 2", "'http' or 'tcp'"}},
+		{"duplicate name", "tunnels:\n  - name: web\n    port: 3000\n  - name: api\n    port: 4000\n  - name: web\n    port: 5000\n",
+			[]string{"line 6", `duplicate tunnel name "web"`, "first defined on line 2"}},
+	}
+	for _, tt := range tests {
+		_, err := ParseTunnelsFile([]byte(tt.yaml))
+		if err == nil {
+			t.Errorf("%s: got no error", tt.name)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q lacks %q", tt.name, err, want)
+			}
+		}
+	}
+}
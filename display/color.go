@@ -0,0 +1,99 @@
+package display
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ANSI escape sequences used for styling terminal output.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// colorEnabled controls whether the styling helpers emit escape sequences.
+// It is off until the CLI decides the output destination supports color.
+var colorEnabled = false
+
+// SetColor enables or disables colored output globally.
+func SetColor(enabled bool) {
+	colorEnabled = enabled
+}
+
+// ColorEnabled reports whether colored output is currently enabled.
+func ColorEnabled() bool {
+	return colorEnabled
+}
+
+// ShouldColor reports whether output written to f should be colored: the
+// NO_COLOR environment variable must be unset and f must be a terminal.
+func ShouldColor(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return IsTerminal(f)
+}
+
+// IsTerminal reports whether f refers to a character device such as a TTY.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// TerminalWidth returns the width in columns of the terminal f refers to,
+// falling back to the COLUMNS environment variable. ok is false if
+// neither is available, for example when output is piped.
+func TerminalWidth(f *os.File) (width int, ok bool) {
+	if IsTerminal(f) {
+		if w, _, err := term.GetSize(int(f.Fd())); err == nil && w > 0 {
+			return w, true
+		}
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w, true
+	}
+	return 0, false
+}
+
+func style(code, s string) string {
+	if !colorEnabled || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// Bold renders s in bold.
+func Bold(s string) string { return style(ansiBold, s) }
+
+// Red renders s in red.
+func Red(s string) string { return style(ansiRed, s) }
+
+// Green renders s in green.
+func Green(s string) string { return style(ansiGreen, s) }
+
+// Yellow renders s in yellow.
+func Yellow(s string) string { return style(ansiYellow, s) }
+
+// StatusColor colors a tunnel status value: green for active, red for
+// stopped or expired, yellow for transitional states.
+func StatusColor(status string) string {
+	switch strings.ToLower(status) {
+	case "active", "connected":
+		return Green(status)
+	case "stopped", "expired", "disconnected", "deleted":
+		return Red(status)
+	case "pending", "reconnecting":
+		return Yellow(status)
+	default:
+		return status
+	}
+}
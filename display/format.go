@@ -0,0 +1,198 @@
+package display
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PrintCSV writes headers followed by rows as RFC 4180 CSV to w. Fields
+// containing commas, quotes, or newlines are quoted.
+func PrintCSV(w io.Writer, headers []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(headers); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// PrintYAML writes v to w as a YAML document. The value is first marshalled
+// with encoding/json, so json struct tags control field names and omission,
+// and object keys keep their declaration order.
+func PrintYAML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := decodeNode(dec)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writeYAML(&buf, node, 0)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// yamlField is one key/value pair of an ordered YAML mapping.
+type yamlField struct {
+	key   string
+	value any
+}
+
+// yamlMap is a mapping whose keys keep their JSON order.
+type yamlMap []yamlField
+
+// decodeNode reads one JSON value from dec, returning a yamlMap, []any, or a
+// scalar (string, json.Number, bool, nil).
+func decodeNode(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			m := yamlMap{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := decodeNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				m = append(m, yamlField{key: keyTok.(string), value: val})
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return m, nil
+		case '[':
+			list := []any{}
+			for dec.More() {
+				val, err := decodeNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, val)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return list, nil
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	default:
+		return tok, nil
+	}
+}
+
+// writeYAML emits node at the given indentation level. Nested collections
+// start on a new line; scalars are written inline by the caller.
+func writeYAML(buf *bytes.Buffer, node any, indent int) {
+	prefix := strings.Repeat("  ", indent)
+	switch n := node.(type) {
+	case yamlMap:
+		if len(n) == 0 {
+			buf.WriteString(prefix + "{}\n")
+			return
+		}
+		for _, f := range n {
+			buf.WriteString(prefix + yamlScalar(f.key) + ":")
+			writeYAMLValue(buf, f.value, indent+1)
+		}
+	case []any:
+		if len(n) == 0 {
+			buf.WriteString(prefix + "[]\n")
+			return
+		}
+		for _, item := range n {
+			buf.WriteString(prefix + "-")
+			if m, ok := item.(yamlMap); ok && len(m) > 0 {
+				// Inline the first key after the dash, indent the rest.
+				var sub bytes.Buffer
+				writeYAML(&sub, m, indent+1)
+				buf.WriteString(" " + strings.TrimPrefix(sub.String(), prefix+"  "))
+				continue
+			}
+			writeYAMLValue(buf, item, indent+1)
+		}
+	default:
+		buf.WriteString(prefix + yamlScalar(n) + "\n")
+	}
+}
+
+// writeYAMLValue writes the value part after a "key:" or "-" marker.
+func writeYAMLValue(buf *bytes.Buffer, v any, indent int) {
+	switch n := v.(type) {
+	case yamlMap:
+		if len(n) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteString("\n")
+		writeYAML(buf, n, indent)
+	case []any:
+		if len(n) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteString("\n")
+		writeYAML(buf, n, indent)
+	default:
+		buf.WriteString(" " + yamlScalar(n) + "\n")
+	}
+}
+
+// yamlScalar formats a scalar, quoting strings that YAML would otherwise
+// interpret as another type or that contain special characters.
+func yamlScalar(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(s)
+	case json.Number:
+		return s.String()
+	case string:
+		if needsYAMLQuotes(s) {
+			return strconv.Quote(s)
+		}
+		return s
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+func needsYAMLQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	// A leading digit, dot, or plus may start a number in another notation
+	// (0x1F, 0o17, .inf) or a timestamp; quoting those is always safe.
+	if strings.ContainsAny(s[:1], "0123456789.+-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	return strings.Contains(s, ": ") || strings.Contains(s, " #") ||
+		strings.ContainsAny(s, "\n\r\t\"\\")
+}
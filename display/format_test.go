@@ -0,0 +1,152 @@
+package display
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPrintYAML_QuotesAmbiguousScalars(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"hello world", "hello world"},
+		{"a:b", "a:b"},
+		{"yes", `"yes"`},
+		{"No", `"No"`},
+		{"null", `"null"`},
+		{"~", `"~"`},
+		{"true", `"true"`},
+		{"123", `"123"`},
+		{"1e3", `"1e3"`},
+		{"0x1F", `"0x1F"`},
+		{".inf", `".inf"`},
+		{"2024-01-01", `"2024-01-01"`},
+		{"", `""`},
+		{" padded", `" padded"`},
+		{":leading", `":leading"`},
+		{"-leading", `"-leading"`},
+		{"#comment", `"#comment"`},
+		{"a #b", `"a #b"`},
+		{"key: value", `"key: value"`},
+		{"*alias", `"*alias"`},
+		{"two\nlines", `"two\nlines"`},
+		{`say "hi"`, `"say \"hi\""`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := PrintYAML(&buf, map[string]string{"k": tt.in}); err != nil {
+			t.Fatalf("PrintYAML(%q): %v", tt.in, err)
+		}
+		if got, want := buf.String(), "k: "+tt.want+"\n"; got != want {
+			t.Errorf("PrintYAML(%q) = %q, want %q", tt.in, got, want)
+		}
+
+		// Whatever the quoting, a YAML parser must read the string back.
+		var back map[string]any
+		if err := yaml.Unmarshal(buf.Bytes(), &back); err != nil {
+			t.Errorf("PrintYAML(%q) is not valid YAML: %v", tt.in, err)
+			continue
+		}
+		if s, ok := back["k"].(string); !ok || s != tt.in {
+			t.Errorf("PrintYAML(%q) reads back as %#v", tt.in, back["k"])
+		}
+	}
+}
+
+func TestPrintYAML_Structure(t *testing.T) {
+	type tunnel struct {
+		ID      string    `json:"id"`
+		Port    int       `json:"port"`
+		Active  bool      `json:"active"`
+		Tags    []string  `json:"tags"`
+		Meta    struct{}  `json:"meta"`
+		Note    *string   `json:"note"`
+		Created time.Time `json:"created"`
+	}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v := []tunnel{
+		{ID: "tun_1", Port: 3000, Active: true, Tags: []string{"web", "yes"}, Created: created},
+		{ID: "tun_2", Port: 5432, Tags: []string{}, Created: created},
+	}
+
+	var buf bytes.Buffer
+	if err := PrintYAML(&buf, v); err != nil {
+		t.Fatalf("PrintYAML: %v", err)
+	}
+	want := `- id: tun_1
+  port: 3000
+  active: true
+  tags:
+    - web
+    - "yes"
+  meta: {}
+  note: null
+  created: "2024-05-01T12:00:00Z"
+- id: tun_2
+  port: 5432
+  active: false
+  tags: []
+  meta: {}
+  note: null
+  created: "2024-05-01T12:00:00Z"
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	var back []map[string]any
+	if err := yaml.Unmarshal(buf.Bytes(), &back); err != nil {
+		t.Fatalf("output is not valid YAML: %v", err)
+	}
+	if len(back) != 2 || back[0]["port"] != 3000 || back[1]["id"] != "tun_2" {
+		t.Errorf("read back %v", back)
+	}
+}
+
+func TestPrintYAML_EmptyCollections(t *testing.T) {
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{[]string{}, "[]\n"},
+		{map[string]int{}, "{}\n"},
+		{[]map[string]int{{}}, "- {}\n"},
+		{[][]int{{}}, "- []\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := PrintYAML(&buf, tt.v); err != nil {
+			t.Fatalf("PrintYAML(%#v): %v", tt.v, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("PrintYAML(%#v) = %q, want %q", tt.v, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestPrintCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := PrintCSV(&buf, []string{"id", "note"}, [][]string{
+		{"tun_1", "plain"},
+		{"tun_2", "a, b"},
+		{"tun_3", `say "hi"`},
+		{"tun_4", "two\nlines"},
+		{"tun_5", ""},
+	})
+	if err != nil {
+		t.Fatalf("PrintCSV: %v", err)
+	}
+	want := "id,note\n" +
+		"tun_1,plain\n" +
+		"tun_2,\"a, b\"\n" +
+		"tun_3,\"say \"\"hi\"\"\"\n" +
+		"tun_4,\"two\nlines\"\n" +
+		"tun_5,\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
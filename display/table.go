@@ -5,20 +5,36 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
+)
+
+// Align controls how a column's cells are padded to the column width.
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignRight
 )
 
 // Table formats columnar data for terminal output.
 type Table struct {
-	headers []string
-	rows    [][]string
-	widths  []int
+	headers    []string
+	rows       [][]string
+	widths     []int
+	aligns     map[int]Align
+	colorizers map[int]func(string) string
+	maxWidths  map[int]int
+
+	// padLast pads the last column to its width. By default the last column
+	// is left unpadded to avoid trailing whitespace.
+	padLast bool
 }
 
 // NewTable creates a table with the given column headers.
 func NewTable(headers ...string) *Table {
 	widths := make([]int, len(headers))
 	for i, h := range headers {
-		widths[i] = len(h)
+		widths[i] = utf8.RuneCountInString(h)
 	}
 	return &Table{
 		headers: headers,
@@ -29,28 +45,96 @@ func NewTable(headers ...string) *Table {
 // AddRow appends a row of values.
 func (t *Table) AddRow(cols ...string) {
 	for i, c := range cols {
-		if i < len(t.widths) && len(c) > t.widths[i] {
-			t.widths[i] = len(c)
+		if i < len(t.widths) {
+			t.widths[i] = max(t.widths[i], utf8.RuneCountInString(c))
 		}
 	}
 	t.rows = append(t.rows, cols)
 }
 
-// Render writes the formatted table to w.
-func (t *Table) Render(w io.Writer) {
-	fmts := make([]string, len(t.headers))
-	for i, width := range t.widths {
-		if i == len(t.headers)-1 {
-			fmts[i] = "%s" // last column: no padding
-		} else {
-			fmts[i] = fmt.Sprintf("%%-%ds", width)
+// SetAlign sets the alignment of column col. Right-aligned columns are
+// always padded, including when they are the last column.
+func (t *Table) SetAlign(col int, align Align) {
+	if t.aligns == nil {
+		t.aligns = make(map[int]Align)
+	}
+	t.aligns[col] = align
+}
+
+// SetPadLastColumn controls whether the last column is padded to its width.
+func (t *Table) SetPadLastColumn(pad bool) {
+	t.padLast = pad
+}
+
+// SetMaxWidth limits column col to n characters. Longer cells are cut and
+// end in an ellipsis. n <= 0 removes the limit.
+func (t *Table) SetMaxWidth(col, n int) {
+	if t.maxWidths == nil {
+		t.maxWidths = make(map[int]int)
+	}
+	if n <= 0 {
+		delete(t.maxWidths, col)
+		return
+	}
+	t.maxWidths[col] = n
+}
+
+// minFitWidth is the narrowest FitWidth shrinks a column to.
+const minFitWidth = 8
+
+// FitWidth limits the shrinkable columns so that every line of the table
+// fits in width characters, taking space from the widest of them first.
+// A column is not shrunk below its header or minFitWidth, so the table may
+// still be wider than width. Call it after all rows have been added.
+func (t *Table) FitWidth(width int, shrinkable ...int) {
+	cur := make([]int, len(t.widths))
+	total := 2 * (len(t.widths) - 1)
+	for i := range t.widths {
+		cur[i] = t.colWidth(i)
+		total += cur[i]
+	}
+
+	for total > width {
+		widest := -1
+		for _, col := range shrinkable {
+			if col < 0 || col >= len(cur) {
+				continue
+			}
+			floor := max(minFitWidth, utf8.RuneCountInString(t.headers[col]))
+			if cur[col] > floor && (widest < 0 || cur[col] > cur[widest]) {
+				widest = col
+			}
+		}
+		if widest < 0 {
+			break
+		}
+		cur[widest]--
+		total--
+	}
+
+	for _, col := range shrinkable {
+		if col >= 0 && col < len(cur) && cur[col] < t.widths[col] {
+			t.SetMaxWidth(col, cur[col])
 		}
 	}
+}
+
+// SetColumnColorizer registers fn to style the cells of column col. The
+// colorizer is applied after padding is computed, so alignment is unaffected
+// by escape sequences. It is a no-op for the header row.
+func (t *Table) SetColumnColorizer(col int, fn func(string) string) {
+	if t.colorizers == nil {
+		t.colorizers = make(map[int]func(string) string)
+	}
+	t.colorizers[col] = fn
+}
 
+// Render writes the formatted table to w.
+func (t *Table) Render(w io.Writer) {
 	// Header row.
 	parts := make([]string, len(t.headers))
 	for i, h := range t.headers {
-		parts[i] = fmt.Sprintf(fmts[i], h)
+		parts[i] = t.cell(i, h, Bold)
 	}
 	fmt.Fprintln(w, strings.Join(parts, "  "))
 
@@ -62,12 +146,57 @@ func (t *Table) Render(w io.Writer) {
 			if i < len(row) {
 				val = row[i]
 			}
-			parts[i] = fmt.Sprintf(fmts[i], val)
+			parts[i] = t.cell(i, val, t.colorizers[i])
 		}
 		fmt.Fprintln(w, strings.Join(parts, "  "))
 	}
 }
 
+// colWidth returns the rendered width of column i: the widest cell, capped
+// by any maximum set with SetMaxWidth.
+func (t *Table) colWidth(i int) int {
+	if n, ok := t.maxWidths[i]; ok && n < t.widths[i] {
+		return n
+	}
+	return t.widths[i]
+}
+
+// cell truncates val to the maximum width of column i, pads it to the
+// column width, and applies the optional colorizer to the value only,
+// leaving the padding unstyled.
+func (t *Table) cell(i int, val string, colorize func(string) string) string {
+	align := t.aligns[i]
+	width := t.colWidth(i)
+	val = Truncate(val, width)
+	pad := ""
+	if i < len(t.headers)-1 || t.padLast || align == AlignRight {
+		if n := width - utf8.RuneCountInString(val); n > 0 {
+			pad = strings.Repeat(" ", n)
+		}
+	}
+	if colorize != nil {
+		val = colorize(val)
+	}
+	if align == AlignRight {
+		return pad + val
+	}
+	return val + pad
+}
+
+// Truncate shortens s to at most n characters, replacing the end with an
+// ellipsis if anything was cut. It counts and cuts whole runes, so
+// multi-byte characters are never split.
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
 // PrintJSON marshals v as indented JSON and writes it to w.
 func PrintJSON(w io.Writer, v any) error {
 	enc := json.NewEncoder(w)
@@ -88,3 +217,25 @@ func FormatBytes(b int64) string {
 	}
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
 }
+
+// sparkTicks are the block characters used by Sparkline, lowest to highest.
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders values as a compact bar chart scaled to the maximum.
+func Sparkline(values []float64) string {
+	maxVal := 0.0
+	for _, v := range values {
+		if v > maxVal {
+			maxVal = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if maxVal > 0 && v > 0 {
+			idx = int(v / maxVal * float64(len(sparkTicks)-1))
+		}
+		b.WriteRune(sparkTicks[idx])
+	}
+	return b.String()
+}
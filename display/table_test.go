@@ -0,0 +1,104 @@
+package display
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abcdef", 7, "abcdef"},
+		{"abcdef", 6, "abcdef"},
+		{"abcdef", 5, "abcd…"},
+		{"abcdef", 1, "…"},
+		{"abcdef", 0, ""},
+		{"", 3, ""},
+		{"héllo wörld", 11, "héllo wörld"},
+		{"héllo wörld", 8, "héllo w…"},
+		{"日本語のテキスト", 4, "日本語…"},
+		{"🚀🚀🚀", 2, "🚀…"},
+	}
+	for _, tt := range tests {
+		got := Truncate(tt.s, tt.n)
+		if got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("Truncate(%q, %d) = %q is not valid UTF-8", tt.s, tt.n, got)
+		}
+	}
+}
+
+func render(tbl *Table) []string {
+	var buf bytes.Buffer
+	tbl.Render(&buf)
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func TestTable_SetMaxWidth(t *testing.T) {
+	tbl := NewTable("ID", "URL", "AGE")
+	tbl.SetMaxWidth(1, 10)
+	tbl.AddRow("tun_1", "https://a-very-long-name.launchtunnel.dev", "3m")
+	tbl.AddRow("tun_2", "https://short.dev", "1h 2m")
+
+	lines := render(tbl)
+	want := []string{
+		"ID     URL         AGE",
+		"tun_1  https://a…  3m",
+		"tun_2  https://s…  1h 2m",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTable_MultiByteWidths(t *testing.T) {
+	tbl := NewTable("NAME", "X")
+	tbl.AddRow("héllo", "1")
+	tbl.AddRow("ab", "2")
+
+	lines := render(tbl)
+	if lines[1] != "héllo  1" || lines[2] != "ab     2" {
+		t.Errorf("got %q, want cells padded by character count", lines)
+	}
+}
+
+func TestTable_FitWidth(t *testing.T) {
+	tbl := NewTable("ID", "URL", "STATUS", "AGE")
+	tbl.AddRow("tun_0123456789abcdef", "https://my-long-preview-name.launchtunnel.dev", "active", "12d")
+	tbl.FitWidth(60, 0, 1)
+
+	for _, line := range render(tbl) {
+		if n := utf8.RuneCountInString(line); n > 60 {
+			t.Errorf("line %q is %d wide, want at most 60", line, n)
+		}
+	}
+	row := render(tbl)[1]
+	if !strings.Contains(row, "active") || !strings.HasSuffix(row, "12d") {
+		t.Errorf("row %q: STATUS and AGE should be intact", row)
+	}
+	// The URL is the widest column, so it gives up all 20 excess characters
+	// before the ID has to shrink.
+	if id, url := tbl.colWidth(0), tbl.colWidth(1); id != 20 || url != 25 {
+		t.Errorf("widths ID=%d URL=%d, want 20 and 25", id, url)
+	}
+}
+
+func TestTable_FitWidthFloor(t *testing.T) {
+	tbl := NewTable("ID", "URL")
+	tbl.AddRow("tun_0123456789", "https://example.launchtunnel.dev")
+	tbl.FitWidth(5, 0, 1)
+
+	if got := tbl.colWidth(0); got != minFitWidth {
+		t.Errorf("ID width = %d, want floor %d", got, minFitWidth)
+	}
+	if got := tbl.colWidth(1); got != minFitWidth {
+		t.Errorf("URL width = %d, want floor %d", got, minFitWidth)
+	}
+}
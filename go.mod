@@ -4,10 +4,14 @@ go 1.24.4
 
 require (
 	github.com/spf13/cobra v1.10.2
+	golang.org/x/net v0.40.0
+	golang.org/x/term v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
 	nhooyr.io/websocket v1.8.17
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
+	golang.org/x/sys v0.33.0 // indirect
 )
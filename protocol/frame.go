@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ const (
 	FrameCloseStream byte = 0x03
 	FramePing        byte = 0x04
 	FramePong        byte = 0x05
+	FrameReset       byte = 0x06
 )
 
 // MaxPayloadSize is the maximum allowed payload size (10 MB).
@@ -35,7 +37,80 @@ type Frame struct {
 	Payload  []byte
 }
 
-// EncodeFrame serialises a Frame into its wire representation.
+// String renders the frame type, stream ID, and payload length, e.g.
+// "DATA stream=3 len=512". The payload itself is never printed.
+func (f Frame) String() string {
+	return fmt.Sprintf("%s stream=%d len=%d", FrameTypeName(f.Type), f.StreamID, len(f.Payload))
+}
+
+// FrameTypeName returns the protocol name of a frame type.
+func FrameTypeName(t byte) string {
+	switch t {
+	case FrameOpenStream:
+		return "OPEN_STREAM"
+	case FrameData:
+		return "DATA"
+	case FrameCloseStream:
+		return "CLOSE_STREAM"
+	case FramePing:
+		return "PING"
+	case FramePong:
+		return "PONG"
+	case FrameReset:
+		return "RESET"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02x)", t)
+	}
+}
+
+// ResetCode explains why a stream was reset. It is carried as a big-endian
+// uint32 in the payload of a RESET frame.
+type ResetCode uint32
+
+// Reset codes.
+const (
+	ResetProtocolError ResetCode = 0x01
+	ResetRefused       ResetCode = 0x02
+	ResetTooBusy       ResetCode = 0x03
+	// ResetConnectRefused means the stream's destination, such as the
+	// local server of a TCP tunnel, could not be connected to.
+	ResetConnectRefused ResetCode = 0x04
+)
+
+func (c ResetCode) String() string {
+	switch c {
+	case ResetProtocolError:
+		return "protocol error"
+	case ResetRefused:
+		return "refused"
+	case ResetTooBusy:
+		return "too busy"
+	case ResetConnectRefused:
+		return "connection refused"
+	default:
+		return fmt.Sprintf("code 0x%02x", uint32(c))
+	}
+}
+
+// encodeResetPayload returns the payload of a RESET frame carrying code.
+func encodeResetPayload(code ResetCode) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(code))
+	return b[:]
+}
+
+// decodeResetPayload extracts the reset code from a RESET frame payload.
+// A missing code is reported as ResetProtocolError.
+func decodeResetPayload(p []byte) ResetCode {
+	if len(p) < 4 {
+		return ResetProtocolError
+	}
+	return ResetCode(binary.BigEndian.Uint32(p[:4]))
+}
+
+// EncodeFrame serialises a Frame into its wire representation. The payload
+// size is not checked: a payload over MaxPayloadSize produces a frame the
+// peer rejects. Use EncodeFrameChecked unless the size is known to be valid.
 func EncodeFrame(f Frame) []byte {
 	pLen := len(f.Payload)
 	buf := make([]byte, frameHeaderSize+pLen)
@@ -46,6 +121,54 @@ func EncodeFrame(f Frame) []byte {
 	return buf
 }
 
+// EncodeFrameChecked is like EncodeFrame but returns ErrPayloadTooLarge if
+// the payload exceeds MaxPayloadSize.
+func EncodeFrameChecked(f Frame) ([]byte, error) {
+	if len(f.Payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(f.Payload))
+	}
+	return EncodeFrame(f), nil
+}
+
+// peekFrame interprets an encoded frame without copying: the returned
+// Payload aliases buf. buf must be a well-formed frame.
+func peekFrame(buf []byte) Frame {
+	if len(buf) < frameHeaderSize {
+		return Frame{}
+	}
+	return Frame{
+		Type:     buf[0],
+		StreamID: binary.BigEndian.Uint32(buf[1:5]),
+		Payload:  buf[frameHeaderSize:],
+	}
+}
+
+// splitFrame decodes the first frame in buf and returns it with the number
+// of bytes it used. It returns n == 0 and a nil error when buf does not yet
+// hold a complete frame. The payload is copied, so buf may be reused.
+func splitFrame(buf []byte) (f Frame, n int, err error) {
+	if len(buf) < frameHeaderSize {
+		return Frame{}, 0, nil
+	}
+	fType := buf[0]
+	if fType < FrameOpenStream || fType > FrameReset {
+		return Frame{}, 0, fmt.Errorf("%w: 0x%02x", ErrInvalidFrame, fType)
+	}
+	payloadLen := binary.BigEndian.Uint32(buf[5:9])
+	if payloadLen > MaxPayloadSize {
+		return Frame{}, 0, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, payloadLen)
+	}
+	n = frameHeaderSize + int(payloadLen)
+	if len(buf) < n {
+		return Frame{}, 0, nil
+	}
+	return Frame{
+		Type:     fType,
+		StreamID: binary.BigEndian.Uint32(buf[1:5]),
+		Payload:  bytes.Clone(buf[frameHeaderSize:n]),
+	}, n, nil
+}
+
 // DecodeFrame reads exactly one frame from r.
 func DecodeFrame(r io.Reader) (Frame, error) {
 	var hdr [frameHeaderSize]byte
@@ -54,7 +177,7 @@ func DecodeFrame(r io.Reader) (Frame, error) {
 	}
 
 	fType := hdr[0]
-	if fType < FrameOpenStream || fType > FramePong {
+	if fType < FrameOpenStream || fType > FrameReset {
 		return Frame{}, fmt.Errorf("%w: 0x%02x", ErrInvalidFrame, fType)
 	}
 
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// pkgLogger receives the mux's diagnostics, such as undecodable data and
+// refused streams. Nothing is logged until SetLogger is called.
+var pkgLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger for the package's diagnostics. nil discards
+// them, the default.
+func SetLogger(l *slog.Logger) {
+	pkgLogger.Store(l)
+}
+
+// logger returns the logger set with SetLogger, or one that discards.
+func logger() *slog.Logger {
+	if l := pkgLogger.Load(); l != nil {
+		return l
+	}
+	return discardLogger
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
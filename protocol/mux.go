@@ -1,11 +1,14 @@
 package protocol
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"nhooyr.io/websocket"
 )
@@ -17,46 +20,192 @@ var (
 	ErrTooManyStreams = errors.New("protocol: too many concurrent streams")
 )
 
-// Mux multiplexes many logical streams over a single WebSocket connection.
+// Mux multiplexes many logical streams over a single connection, usually
+// a WebSocket (see Transport).
 type Mux struct {
-	conn *websocket.Conn
+	conn Transport
 
 	streams    map[uint32]*Stream
 	mu         sync.RWMutex
 	nextID     uint32 // odd for client, even for server
 	isServer   bool
 	maxStreams int // 0 means unlimited
+	tracer     func(Direction, Frame)
 
 	acceptCh chan *Stream
 
+	onAcceptOverflow   func(id uint32)
+	onAcceptOverflowMu sync.RWMutex
+
 	onPong   func()
 	onPongMu sync.RWMutex
 
+	onOpen   func(*Stream)
+	onOpenMu sync.RWMutex
+
+	onKeepaliveTimeout   func()
+	onKeepaliveTimeoutMu sync.RWMutex
+
+	onWriteBlocked   func(queued int)
+	onWriteBlockedMu sync.RWMutex
+
+	// writeBlocked counts enqueues that found the write queue full.
+	writeBlocked atomic.Uint64
+
+	// missedPongs counts keepalive pings sent since the last pong.
+	missedPongs atomic.Int32
+
+	// lastActivity is when a frame was last read or written, in Unix
+	// nanoseconds, for the idle timeout.
+	lastActivity atomic.Int64
+
 	closed chan struct{}
 	once   sync.Once
 	done   chan struct{} // signalled when readLoop exits
 
-	// writeCh is an async channel for outbound WebSocket frames.
-	// A dedicated writeLoop goroutine drains it, removing per-stream
+	// closeDrain bounds how long Close waits for open streams' queued
+	// writes before closing them.
+	closeDrain time.Duration
+
+	// writeQ holds outbound WebSocket frames, ordered by priority. A
+	// dedicated writeLoop goroutine drains it, removing per-stream
 	// serialization through a mutex and preventing large payloads from
-	// blocking small control frames.
-	writeCh   chan []byte
-	writeDone chan struct{} // closed when writeLoop exits
+	// blocking small control frames. writeSlots bounds it: a slot is held
+	// for each queued frame.
+	writeQ     *writeQueue
+	writeSlots chan struct{}
+	writeDone  chan struct{} // closed when writeLoop exits
+	// writeMu is read-held while queuing a frame and write-held to close
+	// the queue, so a frame is never queued after the close.
+	writeMu sync.RWMutex
+}
+
+// DefaultAcceptBacklog is the number of inbound streams that may wait for
+// AcceptStream before new ones are rejected.
+const DefaultAcceptBacklog = 32
+
+// writeQueueSize is how many frames may wait for the writeLoop before
+// writers block.
+const writeQueueSize = 256
+
+// DefaultCloseDrain is how long Close waits, by default, for the data
+// already written to open streams to be sent before closing them.
+const DefaultCloseDrain = 500 * time.Millisecond
+
+// closeStreamGrace is how much longer than the drain window Close waits for
+// the CLOSE_STREAM frames themselves to be queued.
+const closeStreamGrace = 100 * time.Millisecond
+
+// Option configures a Mux at construction time.
+type Option func(*muxOptions)
+
+type muxOptions struct {
+	acceptBacklog     int
+	tracer            func(Direction, Frame)
+	keepaliveInterval time.Duration
+	keepaliveMisses   int
+	closeDrain        time.Duration
+	idleTimeout       time.Duration
+	maxLifetime       time.Duration
 }
 
-// NewMux creates a new multiplexer over conn.
+// Direction tells a tracer whether a frame was sent or received.
+type Direction int
+
+const (
+	DirectionIn Direction = iota
+	DirectionOut
+)
+
+func (d Direction) String() string {
+	if d == DirectionOut {
+		return "send"
+	}
+	return "recv"
+}
+
+// WithTracer registers fn to observe every frame the mux sends (from the
+// writeLoop) and receives (from the readLoop). The frame's Payload aliases
+// the mux's own buffer rather than a copy, so fn must not retain or modify
+// it. fn runs on the mux's I/O goroutines and should return quickly.
+func WithTracer(fn func(dir Direction, f Frame)) Option {
+	return func(o *muxOptions) {
+		o.tracer = fn
+	}
+}
+
+// WithKeepalive makes the mux send a PING every interval and close itself
+// once maxMissed consecutive pings have gone unanswered, so a dead
+// connection is noticed without waiting for a socket error. Done then
+// fires and AcceptStream returns ErrMuxClosed, as for any lost connection.
+func WithKeepalive(interval time.Duration, maxMissed int) Option {
+	return func(o *muxOptions) {
+		o.keepaliveInterval = interval
+		o.keepaliveMisses = maxMissed
+	}
+}
+
+// WithIdleTimeout makes the mux close itself once no frame has been sent
+// or received for d. Keepalive pings and pongs count as activity, so with
+// WithKeepalive the connection is never idle while the peer responds.
+// Done then fires, as for any lost connection.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *muxOptions) {
+		o.idleTimeout = d
+	}
+}
+
+// WithMaxLifetime makes the mux close itself d after it was created,
+// however busy it is. Open streams are closed as by Close, and Done fires.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(o *muxOptions) {
+		o.maxLifetime = d
+	}
+}
+
+// WithCloseDrain sets how long Close waits for data already written to
+// open streams to be sent before it closes them. Zero skips the wait;
+// CLOSE_STREAM frames are still sent. The default is DefaultCloseDrain.
+func WithCloseDrain(d time.Duration) Option {
+	return func(o *muxOptions) {
+		o.closeDrain = d
+	}
+}
+
+// WithAcceptBacklog sets how many inbound streams may queue for AcceptStream.
+// Values below 1 select DefaultAcceptBacklog.
+func WithAcceptBacklog(n int) Option {
+	return func(o *muxOptions) {
+		o.acceptBacklog = n
+	}
+}
+
+// NewMux creates a new multiplexer over conn, which is usually a
+// NewWebSocketTransport.
 // If isServer is true the mux allocates even stream IDs; otherwise odd.
-// The caller should consume streams via AcceptStream.
-func NewMux(conn *websocket.Conn, isServer bool) *Mux {
+// The caller should consume streams via AcceptStream or OnOpenStream.
+func NewMux(conn Transport, isServer bool, opts ...Option) *Mux {
+	o := muxOptions{acceptBacklog: DefaultAcceptBacklog, closeDrain: DefaultCloseDrain}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.acceptBacklog < 1 {
+		o.acceptBacklog = DefaultAcceptBacklog
+	}
+
 	m := &Mux{
-		conn:      conn,
-		streams:   make(map[uint32]*Stream),
-		isServer:  isServer,
-		acceptCh:  make(chan *Stream, 32),
-		closed:    make(chan struct{}),
-		done:      make(chan struct{}),
-		writeCh:   make(chan []byte, 256),
-		writeDone: make(chan struct{}),
+		conn:       conn,
+		streams:    make(map[uint32]*Stream),
+		isServer:   isServer,
+		tracer:     o.tracer,
+		acceptCh:   make(chan *Stream, o.acceptBacklog),
+		closed:     make(chan struct{}),
+		done:       make(chan struct{}),
+		writeQ:     newWriteQueue(),
+		writeSlots: make(chan struct{}, writeQueueSize),
+		writeDone:  make(chan struct{}),
+
+		closeDrain: max(o.closeDrain, 0),
 	}
 	if isServer {
 		m.nextID = 2
@@ -65,11 +214,19 @@ func NewMux(conn *websocket.Conn, isServer bool) *Mux {
 	}
 	go m.readLoop()
 	go m.writeLoop()
+	m.touch()
+	if o.keepaliveInterval > 0 && o.keepaliveMisses > 0 {
+		go m.keepaliveLoop(o.keepaliveInterval, o.keepaliveMisses)
+	}
+	if o.idleTimeout > 0 || o.maxLifetime > 0 {
+		go m.limitLoop(o.idleTimeout, o.maxLifetime)
+	}
 	return m
 }
 
-// SetMaxStreams sets the maximum number of concurrent streams.
-// A value of 0 means unlimited.
+// SetMaxStreams sets the maximum number of concurrent streams, counting
+// both directions. Streams the peer opens beyond the limit are reset with
+// ResetRefused. A value of 0 means unlimited.
 func (m *Mux) SetMaxStreams(n int) {
 	m.mu.Lock()
 	m.maxStreams = n
@@ -93,14 +250,14 @@ func (m *Mux) OpenStream(ctx context.Context) (*Stream, error) {
 	m.nextID += 2
 	m.mu.Unlock()
 
-	s := newStream(id, m.makeWriteFn(id), m.makeCloseFn(id))
+	s := m.newStream(id)
 
 	m.mu.Lock()
 	m.streams[id] = s
 	m.mu.Unlock()
 
 	frame := EncodeFrame(Frame{Type: FrameOpenStream, StreamID: id})
-	if err := m.writeWS(ctx, frame); err != nil {
+	if err := m.enqueue(ctx, outFrame{data: frame, level: levelControl}); err != nil {
 		m.removeStream(id)
 		return nil, fmt.Errorf("protocol: opening stream %d: %w", id, err)
 	}
@@ -109,6 +266,7 @@ func (m *Mux) OpenStream(ctx context.Context) (*Stream, error) {
 }
 
 // AcceptStream blocks until the remote side opens a stream or the mux is closed.
+// It never returns a stream once an OnOpenStream callback is registered.
 func (m *Mux) AcceptStream(ctx context.Context) (*Stream, error) {
 	select {
 	case s, ok := <-m.acceptCh:
@@ -123,6 +281,16 @@ func (m *Mux) AcceptStream(ctx context.Context) (*Stream, error) {
 	}
 }
 
+// Streams returns a channel that yields the streams the remote side opens,
+// for use in a select alongside other channels. It is closed when the mux
+// closes. Receiving from it and calling AcceptStream on the same mux split
+// the streams unpredictably between the two; use one or the other. Like
+// AcceptStream, it receives nothing while an OnOpenStream callback is
+// registered.
+func (m *Mux) Streams() <-chan *Stream {
+	return m.acceptCh
+}
+
 // SendPing sends a PING frame.
 func (m *Mux) SendPing(ctx context.Context) error {
 	select {
@@ -131,7 +299,7 @@ func (m *Mux) SendPing(ctx context.Context) error {
 	default:
 	}
 	frame := EncodeFrame(Frame{Type: FramePing})
-	return m.writeWS(ctx, frame)
+	return m.enqueue(ctx, outFrame{data: frame, level: levelControl})
 }
 
 // OnPong registers a callback that fires when a PONG frame is received.
@@ -141,96 +309,298 @@ func (m *Mux) OnPong(fn func()) {
 	m.onPongMu.Unlock()
 }
 
+// OnOpenStream registers a callback that receives every stream opened by the
+// remote side, as an alternative to an AcceptStream loop. Each call runs on
+// its own goroutine so a slow handler does not stall the readLoop.
+//
+// Setting a callback disables AcceptStream: streams are delivered to exactly
+// one of the two, never both. Register it before the peer opens streams;
+// streams already queued for AcceptStream are not redelivered. Passing nil
+// restores AcceptStream delivery.
+func (m *Mux) OnOpenStream(fn func(*Stream)) {
+	m.onOpenMu.Lock()
+	m.onOpen = fn
+	m.onOpenMu.Unlock()
+}
+
+// OnKeepaliveTimeout registers a callback that fires when the keepalive
+// gives up on the connection, just before the mux closes. fn must not block.
+func (m *Mux) OnKeepaliveTimeout(fn func()) {
+	m.onKeepaliveTimeoutMu.Lock()
+	m.onKeepaliveTimeout = fn
+	m.onKeepaliveTimeoutMu.Unlock()
+}
+
+// OnAcceptOverflow registers a callback that fires when an inbound stream is
+// rejected because the accept backlog is full. The stream has already been
+// reset with ResetTooBusy when fn runs; fn must not block.
+func (m *Mux) OnAcceptOverflow(fn func(id uint32)) {
+	m.onAcceptOverflowMu.Lock()
+	m.onAcceptOverflow = fn
+	m.onAcceptOverflowMu.Unlock()
+}
+
+// OnWriteBlocked registers a callback that fires when a frame cannot be
+// queued because the write queue is full, meaning the WebSocket is the
+// bottleneck rather than the local side. queued is the number of frames
+// waiting. fn runs on the writing goroutine before it blocks; it must
+// return quickly and must not close the mux.
+func (m *Mux) OnWriteBlocked(fn func(queued int)) {
+	m.onWriteBlockedMu.Lock()
+	m.onWriteBlocked = fn
+	m.onWriteBlockedMu.Unlock()
+}
+
+// MuxStats is a snapshot of a mux's state for diagnostics.
+type MuxStats struct {
+	// Streams is the number of open streams.
+	Streams int
+	// QueuedFrames is the number of frames waiting to be written.
+	QueuedFrames int
+	// WriteBlocked counts writes that had to wait for room in the write
+	// queue.
+	WriteBlocked uint64
+}
+
+// Stats returns a snapshot of the mux's counters.
+func (m *Mux) Stats() MuxStats {
+	m.mu.RLock()
+	streams := len(m.streams)
+	m.mu.RUnlock()
+	return MuxStats{
+		Streams:      streams,
+		QueuedFrames: m.writeQ.len(),
+		WriteBlocked: m.writeBlocked.Load(),
+	}
+}
+
 // Done returns a channel that is closed when the mux's readLoop exits.
 // This can be used to detect when the underlying WebSocket connection broke.
 func (m *Mux) Done() <-chan struct{} {
 	return m.done
 }
 
+// The WebSocket close status sent when the mux shuts down without a more
+// specific reason.
+const (
+	defaultCloseCode   = websocket.StatusNormalClosure
+	defaultCloseReason = "mux closed"
+)
+
 // Close shuts down the mux: closes all streams, the accept channel, and the
-// underlying WebSocket connection. It waits for the readLoop to exit.
+// underlying connection. It waits for the readLoop to exit.
+//
+// Open streams are closed gracefully first: Close waits up to the drain
+// window (see WithCloseDrain) for data already written to them to be sent,
+// then sends CLOSE_STREAM for each, so the peer's readers see every stream
+// end with io.EOF rather than the connection drop.
 func (m *Mux) Close() error {
-	m.shutdown()
+	return m.CloseWithReason(defaultCloseCode, defaultCloseReason)
+}
+
+// CloseWithReason is like Close, but tells the peer why the mux is closing
+// with code and reason in the WebSocket close frame, on transports that
+// can send one. Only the first close of a mux is sent; later calls just
+// wait for it to finish.
+func (m *Mux) CloseWithReason(code websocket.StatusCode, reason string) error {
+	m.closeStreams()
+	m.shutdown(code, reason)
 	<-m.done
 	return nil
 }
 
+// closeStreams closes every open stream after its queued writes have been
+// sent, or the drain window has passed. Streams whose close is still stuck
+// behind a full write queue after that are left to shutdown.
+func (m *Mux) closeStreams() {
+	select {
+	case <-m.closed:
+		return
+	default:
+	}
+
+	m.mu.RLock()
+	streams := slices.Collect(maps.Values(m.streams))
+	m.mu.RUnlock()
+	if len(streams) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.closeDrain)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.CloseAfterFlush(ctx)
+		}()
+	}
+
+	allClosed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allClosed)
+	}()
+	timer := time.NewTimer(m.closeDrain + closeStreamGrace)
+	defer timer.Stop()
+	select {
+	case <-allClosed:
+	case <-timer.C:
+	}
+}
+
 // shutdown performs the one-time teardown logic without waiting for readLoop.
-func (m *Mux) shutdown() {
+func (m *Mux) shutdown(code websocket.StatusCode, reason string) {
 	m.once.Do(func() {
 		close(m.closed)
 
+		// Close the streams after unlocking: a stream being closed
+		// concurrently holds its own close lock and then removes itself
+		// from the map.
 		m.mu.Lock()
-		for _, s := range m.streams {
-			s.closeRead()
-		}
+		streams := m.streams
 		m.streams = make(map[uint32]*Stream)
 		m.mu.Unlock()
+		for _, s := range streams {
+			s.closeRead()
+		}
 
 		close(m.acceptCh)
 
 		// Stop the writeLoop and wait for it to drain.
-		close(m.writeCh)
+		m.writeMu.Lock()
+		m.writeQ.close()
+		m.writeMu.Unlock()
 		<-m.writeDone
 
-		// Close the websocket; this will cause readLoop to exit.
-		m.conn.Close(websocket.StatusNormalClosure, "mux closed")
+		// Close the connection; this will cause readLoop to exit.
+		m.conn.Close(code, reason)
 	})
 }
 
-// readLoop reads frames from the WebSocket and dispatches them.
+// readLoop reads frames from the transport and dispatches them.
 func (m *Mux) readLoop() {
 	defer close(m.done)
 
+	// Frames are not required to line up with transport messages: one
+	// message may carry several frames, and a frame may span messages.
+	// buf holds the bytes of a frame that has not fully arrived yet.
+	var buf []byte
 	for {
-		_, data, err := m.conn.Read(context.Background())
+		data, err := m.conn.ReadMessage(context.Background())
 		if err != nil {
+			logger().Debug("mux: connection closed", "err", err)
 			// Connection closed or broken — trigger shutdown (non-blocking).
-			m.shutdown()
+			m.shutdown(defaultCloseCode, defaultCloseReason)
 			return
 		}
+		m.touch()
 
-		if len(data) < frameHeaderSize {
-			continue
+		if len(buf) == 0 {
+			buf = data
+		} else {
+			buf = append(buf, data...)
 		}
-
-		f, err := DecodeFrame(bytes.NewReader(data))
-		if err != nil {
-			continue
+		for {
+			f, n, err := splitFrame(buf)
+			if err != nil {
+				// Frame boundaries are lost; drop what is buffered.
+				logger().Warn("mux: dropping undecodable data", "bytes", len(buf), "err", err)
+				buf = nil
+				break
+			}
+			if n == 0 {
+				break
+			}
+			buf = buf[n:]
+
+			select {
+			case <-m.closed:
+				return
+			default:
+			}
+			m.handleFrame(f)
 		}
-
-		select {
-		case <-m.closed:
-			return
-		default:
+		if len(buf) == 0 {
+			buf = nil
 		}
+	}
+}
+
+// handleFrame dispatches one frame received from the peer.
+func (m *Mux) handleFrame(f Frame) {
+	if m.tracer != nil {
+		m.tracer(DirectionIn, f)
+	}
 
-		switch f.Type {
-		case FrameOpenStream:
-			m.handleOpenStream(f.StreamID)
-		case FrameData:
-			m.handleData(f.StreamID, f.Payload)
-		case FrameCloseStream:
-			m.handleCloseStream(f.StreamID)
-		case FramePing:
-			m.handlePing()
-		case FramePong:
-			m.handlePong()
+	switch f.Type {
+	case FrameOpenStream:
+		// Refusals are reported to the peer with a reset.
+		if err := m.handleOpenStream(f.StreamID); err != nil {
+			logger().Debug("mux: refused stream", "stream", f.StreamID, "err", err)
 		}
+	case FrameData:
+		m.handleData(f.StreamID, f.Payload)
+	case FrameCloseStream:
+		m.handleCloseStream(f.StreamID)
+	case FramePing:
+		m.handlePing()
+	case FramePong:
+		m.handlePong()
+	case FrameReset:
+		m.handleReset(f.StreamID, decodeResetPayload(f.Payload))
 	}
 }
 
-func (m *Mux) handleOpenStream(id uint32) {
-	s := newStream(id, m.makeWriteFn(id), m.makeCloseFn(id))
-
+// handleOpenStream registers a stream opened by the peer. An OPEN for an
+// id that is still in use is refused with a protocol-error reset and the
+// existing stream is kept; ErrStreamExists is returned.
+func (m *Mux) handleOpenStream(id uint32) error {
 	m.mu.Lock()
+	if _, ok := m.streams[id]; ok {
+		m.mu.Unlock()
+		m.sendReset(id, ResetProtocolError)
+		return fmt.Errorf("%w: %d", ErrStreamExists, id)
+	}
+	// The stream limit applies to streams opened by the peer too, so a
+	// misbehaving peer cannot grow the stream table without bound.
+	if m.maxStreams > 0 && len(m.streams) >= m.maxStreams {
+		m.mu.Unlock()
+		m.sendReset(id, ResetRefused)
+		return ErrTooManyStreams
+	}
+	s := m.newStream(id)
 	m.streams[id] = s
 	m.mu.Unlock()
 
+	m.onOpenMu.RLock()
+	fn := m.onOpen
+	m.onOpenMu.RUnlock()
+	if fn != nil {
+		go fn(s)
+		return nil
+	}
+
+	// Never block the readLoop on a stalled accepter: that would also stop
+	// pings and data for every other stream. Reject the stream instead.
 	select {
 	case m.acceptCh <- s:
 	case <-m.closed:
+	default:
+		logger().Debug("mux: accept backlog full, resetting stream", "stream", id)
+		m.removeStream(id)
+		s.reset(ResetTooBusy)
+		m.sendReset(id, ResetTooBusy)
+
+		m.onAcceptOverflowMu.RLock()
+		overflow := m.onAcceptOverflow
+		m.onAcceptOverflowMu.RUnlock()
+		if overflow != nil {
+			overflow(id)
+		}
 	}
+	return nil
 }
 
 func (m *Mux) handleData(id uint32, payload []byte) {
@@ -240,7 +610,14 @@ func (m *Mux) handleData(id uint32, payload []byte) {
 	if !ok {
 		return
 	}
-	s.pushData(payload)
+	if !s.pushData(payload) {
+		// The reader fell too far behind; give up on the stream rather
+		// than buffer without bound.
+		logger().Warn("mux: stream reader fell behind, resetting stream", "stream", id)
+		m.removeStream(id)
+		s.reset(ResetTooBusy)
+		m.sendReset(id, ResetTooBusy)
+	}
 }
 
 func (m *Mux) handleCloseStream(id uint32) {
@@ -254,12 +631,30 @@ func (m *Mux) handleCloseStream(id uint32) {
 	m.removeStream(id)
 }
 
+func (m *Mux) handleReset(id uint32, code ResetCode) {
+	m.mu.RLock()
+	s, ok := m.streams[id]
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+	s.reset(code)
+	m.removeStream(id)
+}
+
+// sendReset tells the peer that stream id was reset with code.
+func (m *Mux) sendReset(id uint32, code ResetCode) {
+	frame := EncodeFrame(Frame{Type: FrameReset, StreamID: id, Payload: encodeResetPayload(code)})
+	_ = m.writeWS(context.Background(), frame)
+}
+
 func (m *Mux) handlePing() {
 	frame := EncodeFrame(Frame{Type: FramePong})
-	_ = m.writeWS(context.Background(), frame)
+	_ = m.enqueue(context.Background(), outFrame{data: frame, level: levelControl})
 }
 
 func (m *Mux) handlePong() {
+	m.missedPongs.Store(0)
 	m.onPongMu.RLock()
 	fn := m.onPong
 	m.onPongMu.RUnlock()
@@ -268,53 +663,212 @@ func (m *Mux) handlePong() {
 	}
 }
 
-// writeLoop is a dedicated goroutine that drains writeCh and sends frames
-// over the WebSocket connection. It exits when writeCh is closed.
+// outFrame is an encoded frame queued for the writeLoop at level. For DATA
+// and CLOSE_STREAM frames, stream is the sender, whose pending-write count
+// is released once the frame has been written to the connection (or
+// discarded on failure).
+type outFrame struct {
+	data   []byte
+	stream *Stream
+	level  int
+}
+
+// keepaliveLoop pings the peer every interval and shuts the mux down when
+// maxMissed pings in a row get no pong.
+func (m *Mux) keepaliveLoop(interval time.Duration, maxMissed int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-ticker.C:
+		}
+		if int(m.missedPongs.Load()) >= maxMissed {
+			m.onKeepaliveTimeoutMu.RLock()
+			fn := m.onKeepaliveTimeout
+			m.onKeepaliveTimeoutMu.RUnlock()
+			if fn != nil {
+				fn()
+			}
+			// The peer is unresponsive, so skip the close handshake that
+			// shutdown would otherwise wait on.
+			_ = m.conn.CloseNow()
+			m.shutdown(websocket.StatusGoingAway, "keepalive timeout")
+			return
+		}
+		m.missedPongs.Add(1)
+		if err := m.SendPing(context.Background()); err != nil {
+			return
+		}
+	}
+}
+
+// touch records activity on the connection for the idle timeout.
+func (m *Mux) touch() {
+	m.lastActivity.Store(time.Now().UnixNano())
+}
+
+// limitLoop closes the mux once it has been idle for idle, or has been open
+// for lifetime. A zero value disables that limit.
+func (m *Mux) limitLoop(idle, lifetime time.Duration) {
+	var (
+		idleTimer *time.Timer
+		idleC     <-chan time.Time
+		lifetimeC <-chan time.Time
+	)
+	if idle > 0 {
+		idleTimer = time.NewTimer(idle)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
+	}
+	if lifetime > 0 {
+		t := time.NewTimer(lifetime)
+		defer t.Stop()
+		lifetimeC = t.C
+	}
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-lifetimeC:
+			_ = m.CloseWithReason(websocket.StatusGoingAway, "max lifetime reached")
+			return
+		case <-idleC:
+			since := time.Since(time.Unix(0, m.lastActivity.Load()))
+			if since >= idle {
+				_ = m.CloseWithReason(websocket.StatusGoingAway, "idle timeout")
+				return
+			}
+			idleTimer.Reset(idle - since)
+		}
+	}
+}
+
+// writeLoop is a dedicated goroutine that drains writeQ and sends frames
+// over the transport. It exits when writeQ is closed and empty.
+//
+// Frames of one stream are written in the order they were enqueued, so its
+// DATA frames always precede its CLOSE_STREAM on the wire.
 func (m *Mux) writeLoop() {
 	defer close(m.writeDone)
-	for data := range m.writeCh {
-		if err := m.conn.Write(context.Background(), websocket.MessageBinary, data); err != nil {
-			m.shutdown()
+	failed := false
+	for {
+		f, ok := m.writeQ.pop()
+		if !ok {
 			return
 		}
+		<-m.writeSlots
+		if !failed {
+			if m.tracer != nil {
+				m.tracer(DirectionOut, peekFrame(f.data))
+			}
+			if err := m.conn.WriteMessage(context.Background(), f.data); err != nil {
+				logger().Debug("mux: write failed", "err", err)
+				// shutdown waits for this loop to exit, so it must run
+				// elsewhere; keep draining so it can close writeQ.
+				failed = true
+				go m.shutdown(websocket.StatusInternalError, "write failed")
+			} else {
+				m.touch()
+			}
+		}
+		if f.stream != nil {
+			f.stream.donePending()
+		}
 	}
 }
 
-// writeWS enqueues a raw frame for the writeLoop goroutine.
-// Returns immediately unless the write channel is full, in which case
-// it blocks until space is available or the mux is closed.
-func (m *Mux) writeWS(_ context.Context, data []byte) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = ErrMuxClosed
-		}
-	}()
+// writeWS enqueues a raw frame for the writeLoop goroutine at normal
+// priority. Returns immediately unless the write queue is full, in which
+// case it counts the stall, calls the OnWriteBlocked hook, and blocks until
+// space is available or the mux is closed.
+func (m *Mux) writeWS(ctx context.Context, data []byte) error {
+	return m.enqueue(ctx, outFrame{data: data, level: levelNormal})
+}
+
+func (m *Mux) enqueue(ctx context.Context, f outFrame) error {
+	m.writeMu.RLock()
+	defer m.writeMu.RUnlock()
 
 	select {
-	case m.writeCh <- data:
-		return nil
-	case <-m.closed:
+	case m.writeSlots <- struct{}{}:
+	default:
+		m.writeBlocked.Add(1)
+		m.onWriteBlockedMu.RLock()
+		blocked := m.onWriteBlocked
+		m.onWriteBlockedMu.RUnlock()
+		if blocked != nil {
+			blocked(m.writeQ.len())
+		}
+
+		// shutdown closes m.closed before taking writeMu, so a sender
+		// blocked here on a full queue is released before it is closed.
+		select {
+		case m.writeSlots <- struct{}{}:
+		case <-m.closed:
+			return ErrMuxClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if !m.writeQ.push(f) {
+		<-m.writeSlots
 		return ErrMuxClosed
 	}
+	return nil
+}
+
+// newStream creates a stream whose writes and close go through this mux.
+func (m *Mux) newStream(id uint32) *Stream {
+	s := newStream(id, nil, nil)
+	s.writeFn = m.makeWriteFn(s)
+	s.closeFn = m.makeCloseFn(s)
+	s.resetFn = m.makeResetFn(s)
+	return s
 }
 
-func (m *Mux) makeWriteFn(id uint32) func([]byte) error {
-	return func(payload []byte) error {
+func (m *Mux) makeWriteFn(s *Stream) func(context.Context, []byte) error {
+	return func(ctx context.Context, payload []byte) error {
 		select {
 		case <-m.closed:
 			return ErrMuxClosed
 		default:
 		}
-		frame := EncodeFrame(Frame{Type: FrameData, StreamID: id, Payload: payload})
-		return m.writeWS(context.Background(), frame)
+		frame, err := EncodeFrameChecked(Frame{Type: FrameData, StreamID: s.ID, Payload: payload})
+		if err != nil {
+			return err
+		}
+		level := s.addPending()
+		if err := m.enqueue(ctx, outFrame{data: frame, stream: s, level: level}); err != nil {
+			s.donePending()
+			return err
+		}
+		return nil
 	}
 }
 
-func (m *Mux) makeCloseFn(id uint32) func() {
+// makeCloseFn queues s's CLOSE_STREAM behind its data, at the same level.
+func (m *Mux) makeCloseFn(s *Stream) func() {
 	return func() {
-		frame := EncodeFrame(Frame{Type: FrameCloseStream, StreamID: id})
-		_ = m.writeWS(context.Background(), frame)
-		m.removeStream(id)
+		frame := EncodeFrame(Frame{Type: FrameCloseStream, StreamID: s.ID})
+		level := s.addPending()
+		if err := m.enqueue(context.Background(), outFrame{data: frame, stream: s, level: level}); err != nil {
+			s.donePending()
+		}
+		m.removeStream(s.ID)
+	}
+}
+
+// makeResetFn queues s's RESET behind its data, like makeCloseFn.
+func (m *Mux) makeResetFn(s *Stream) func(ResetCode) {
+	return func(code ResetCode) {
+		frame := EncodeFrame(Frame{Type: FrameReset, StreamID: s.ID, Payload: encodeResetPayload(code)})
+		level := s.addPending()
+		if err := m.enqueue(context.Background(), outFrame{data: frame, stream: s, level: level}); err != nil {
+			s.donePending()
+		}
+		m.removeStream(s.ID)
 	}
 }
 
@@ -3,9 +3,15 @@ package protocol
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -42,6 +48,10 @@ func TestEncodeDecodeRoundtrip(t *testing.T) {
 			name:  "pong no payload",
 			frame: Frame{Type: FramePong, StreamID: 0},
 		},
+		{
+			name:  "reset with code",
+			frame: Frame{Type: FrameReset, StreamID: 9, Payload: encodeResetPayload(ResetTooBusy)},
+		},
 		{
 			name:  "empty payload",
 			frame: Frame{Type: FrameData, StreamID: 7, Payload: []byte{}},
@@ -111,6 +121,36 @@ func TestEncodeFrame_HeaderSize(t *testing.T) {
 	}
 }
 
+func TestEncodeFrameChecked(t *testing.T) {
+	f := Frame{Type: FrameData, StreamID: 5, Payload: make([]byte, MaxPayloadSize)}
+	encoded, err := EncodeFrameChecked(f)
+	if err != nil {
+		t.Fatalf("payload of MaxPayloadSize: %v", err)
+	}
+	if len(encoded) != frameHeaderSize+MaxPayloadSize {
+		t.Fatalf("encoded length: got %d, want %d", len(encoded), frameHeaderSize+MaxPayloadSize)
+	}
+
+	f.Payload = make([]byte, MaxPayloadSize+1)
+	if _, err := EncodeFrameChecked(f); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("oversized payload: err = %v, want ErrPayloadTooLarge", err)
+	}
+}
+
+func TestMux_OversizedWriteFails(t *testing.T) {
+	_, client, cleanup := setupMuxPair(t)
+	defer cleanup()
+
+	stream, err := client.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	err = stream.writeFn(context.Background(), make([]byte, MaxPayloadSize+1))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("oversized DATA frame: err = %v, want ErrPayloadTooLarge", err)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Stream tests
 // ---------------------------------------------------------------------------
@@ -119,7 +159,7 @@ func TestStream_ReadWrite(t *testing.T) {
 	var written []byte
 	var mu sync.Mutex
 
-	writeFn := func(data []byte) error {
+	writeFn := func(_ context.Context, data []byte) error {
 		mu.Lock()
 		written = append(written, data...)
 		mu.Unlock()
@@ -157,7 +197,7 @@ func TestStream_ReadWrite(t *testing.T) {
 }
 
 func TestStream_ReadAfterClose(t *testing.T) {
-	s := newStream(1, func([]byte) error { return nil }, func() {})
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
 
 	// Push some data, then close the read side.
 	s.pushData([]byte("data"))
@@ -180,7 +220,7 @@ func TestStream_ReadAfterClose(t *testing.T) {
 }
 
 func TestStream_WriteAfterClose(t *testing.T) {
-	s := newStream(1, func([]byte) error { return nil }, func() {})
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
 	s.Close()
 
 	_, err := s.Write([]byte("data"))
@@ -190,7 +230,7 @@ func TestStream_WriteAfterClose(t *testing.T) {
 }
 
 func TestStream_PartialRead(t *testing.T) {
-	s := newStream(1, func([]byte) error { return nil }, func() {})
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
 	s.pushData([]byte("abcdef"))
 
 	buf := make([]byte, 3)
@@ -217,7 +257,8 @@ func TestStream_PartialRead(t *testing.T) {
 // ---------------------------------------------------------------------------
 
 // setupMuxPair creates a server/client mux pair connected via WebSocket.
-func setupMuxPair(t *testing.T) (serverMux *Mux, clientMux *Mux, cleanup func()) {
+// Options apply to the server mux.
+func setupMuxPair(t *testing.T, opts ...Option) (serverMux *Mux, clientMux *Mux, cleanup func()) {
 	t.Helper()
 
 	serverReady := make(chan *Mux, 1)
@@ -228,7 +269,7 @@ func setupMuxPair(t *testing.T) (serverMux *Mux, clientMux *Mux, cleanup func())
 			t.Errorf("websocket.Accept: %v", err)
 			return
 		}
-		m := NewMux(conn, true)
+		m := NewMux(NewWebSocketTransport(conn), true, opts...)
 		serverReady <- m
 	}))
 
@@ -241,7 +282,7 @@ func setupMuxPair(t *testing.T) (serverMux *Mux, clientMux *Mux, cleanup func())
 		t.Fatalf("websocket.Dial: %v", err)
 	}
 
-	clientM := NewMux(clientConn, false)
+	clientM := NewMux(NewWebSocketTransport(clientConn), false)
 
 	select {
 	case serverM := <-serverReady:
@@ -256,6 +297,130 @@ func setupMuxPair(t *testing.T) (serverMux *Mux, clientMux *Mux, cleanup func())
 	}
 }
 
+func TestMux_StreamTransport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serverReady := make(chan *Mux, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		serverReady <- NewMux(NewStreamTransport(conn), true)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientMux := NewMux(NewStreamTransport(conn), false)
+	defer clientMux.Close()
+	serverMux := <-serverReady
+	defer serverMux.Close()
+
+	// Bigger than one read, so frames span reads.
+	payload := make([]byte, 3*streamReadSize+100)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	// Echo each stream's payload back to its opener.
+	go func() {
+		for {
+			s, err := serverMux.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				defer s.Close()
+				buf := make([]byte, len(payload))
+				if _, err := io.ReadFull(s, buf); err == nil {
+					s.Write(buf)
+				}
+			}()
+		}
+	}()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for range 3 {
+		s, err := clientMux.OpenStream(ctx)
+		if err != nil {
+			t.Fatalf("OpenStream: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.Close()
+			if _, err := s.Write(payload); err != nil {
+				t.Errorf("stream %d: Write: %v", s.ID, err)
+				return
+			}
+			got := make([]byte, len(payload))
+			_, err := io.ReadFull(s, got)
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Errorf("stream %d: echo does not match the payload (err %v)", s.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	clientMux.Close()
+	select {
+	case <-serverMux.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server mux did not notice the connection closing")
+	}
+}
+
+// setupRawPeer connects a server-side mux to a bare WebSocket, so tests can
+// send it arbitrary bytes as the peer.
+func setupRawPeer(t *testing.T, opts ...Option) (serverMux *Mux, peer *websocket.Conn, cleanup func()) {
+	t.Helper()
+
+	serverReady := make(chan *Mux, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("websocket.Accept: %v", err)
+			return
+		}
+		serverReady <- NewMux(NewWebSocketTransport(conn), true, opts...)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wsURL := "ws" + srv.URL[len("http"):]
+	peer, _, err := websocket.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("websocket.Dial: %v", err)
+	}
+
+	select {
+	case serverM := <-serverReady:
+		return serverM, peer, func() {
+			peer.CloseNow()
+			serverM.Close()
+			srv.Close()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for server mux")
+		return nil, nil, nil
+	}
+}
+
+// writeRaw sends each frame to the mux as its own WebSocket message.
+func writeRaw(t *testing.T, peer *websocket.Conn, frames ...Frame) {
+	t.Helper()
+	for _, f := range frames {
+		if err := peer.Write(context.Background(), websocket.MessageBinary, EncodeFrame(f)); err != nil {
+			t.Fatalf("peer write: %v", err)
+		}
+	}
+}
+
 func TestMux_OpenAndAcceptStream(t *testing.T) {
 	serverMux, clientMux, cleanup := setupMuxPair(t)
 	defer cleanup()
@@ -506,6 +671,346 @@ func TestMux_CloseStopsAccept(t *testing.T) {
 	}
 }
 
+func TestMux_CloseWithReason(t *testing.T) {
+	serverM, peer, cleanup := setupRawPeer(t)
+	defer cleanup()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		serverM.CloseWithReason(websocket.StatusPolicyViolation, "api key revoked")
+	}()
+
+	// Reading on the peer answers the close handshake.
+	_, _, err := peer.Read(context.Background())
+	var ce websocket.CloseError
+	if !errors.As(err, &ce) {
+		t.Fatalf("peer read: got %v, want a close error", err)
+	}
+	if ce.Code != websocket.StatusPolicyViolation || ce.Reason != "api key revoked" {
+		t.Errorf("close = %d %q, want %d %q", ce.Code, ce.Reason, websocket.StatusPolicyViolation, "api key revoked")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CloseWithReason did not return")
+	}
+}
+
+func TestMux_CloseSendsCloseStreamFirst(t *testing.T) {
+	serverM, peer, cleanup := setupRawPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	writeRaw(t, peer, Frame{Type: FrameOpenStream, StreamID: 1})
+	stream, err := serverM.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	if _, err := stream.Write([]byte("bye")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	go serverM.Close()
+
+	var got []Frame
+	for {
+		_, data, err := peer.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+				t.Fatalf("peer read: got %v, want a normal close", err)
+			}
+			break
+		}
+		f, err := DecodeFrame(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DecodeFrame: %v", err)
+		}
+		got = append(got, f)
+	}
+
+	if len(got) != 2 ||
+		got[0].Type != FrameData || string(got[0].Payload) != "bye" ||
+		got[1].Type != FrameCloseStream || got[1].StreamID != 1 {
+		t.Fatalf("peer received %v, want DATA \"bye\" then CLOSE_STREAM 1 before the close", got)
+	}
+}
+
+func TestMux_GracefulCloseGivesPeerEOF(t *testing.T) {
+	serverM, clientM, cleanup := setupMuxPair(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cs, err := clientM.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	ss, err := serverM.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	payload := bytes.Repeat([]byte("x"), 256*1024)
+	if _, err := ss.Write(payload); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	go serverM.Close()
+
+	got, err := io.ReadAll(cs)
+	if err != nil {
+		t.Fatalf("ReadAll: got %v, want EOF", err)
+	}
+	if len(got) != len(payload) {
+		t.Fatalf("read %d bytes, want %d", len(got), len(payload))
+	}
+}
+
+func TestMux_CloseDrainTimeout(t *testing.T) {
+	serverM, peer, cleanup := setupRawPeer(t, WithCloseDrain(50*time.Millisecond))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	writeRaw(t, peer, Frame{Type: FrameOpenStream, StreamID: 1})
+	stream, err := serverM.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	// The peer never reads, so the writes back up and cannot drain.
+	go func() {
+		chunk := make([]byte, MaxPayloadSize)
+		for {
+			if _, err := stream.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	closed := make(chan struct{})
+	go func() {
+		serverM.Close()
+		close(closed)
+	}()
+	// Close gives up on the drain and sends the WebSocket close; abandon
+	// the handshake so Close does not wait for the stalled peer.
+	time.Sleep(200 * time.Millisecond)
+	peer.CloseNow()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("Close took %s", d)
+	}
+}
+
+func TestMux_OnWriteBlocked(t *testing.T) {
+	serverM, peer, cleanup := setupRawPeer(t, WithCloseDrain(0))
+	defer cleanup()
+	// The peer never reads, so the WebSocket backs up and then the queue.
+	defer peer.CloseNow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	blocked := make(chan int, 1)
+	serverM.OnWriteBlocked(func(queued int) {
+		select {
+		case blocked <- queued:
+		default:
+		}
+	})
+
+	writeRaw(t, peer, Frame{Type: FrameOpenStream, StreamID: 1})
+	stream, err := serverM.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	go func() {
+		chunk := make([]byte, 16*1024)
+		for {
+			if _, err := stream.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case queued := <-blocked:
+		if queued == 0 {
+			t.Errorf("OnWriteBlocked: queued = 0, want a full queue")
+		}
+	case <-ctx.Done():
+		t.Fatal("OnWriteBlocked never fired")
+	}
+	stats := serverM.Stats()
+	if stats.WriteBlocked == 0 {
+		t.Errorf("Stats().WriteBlocked = 0 after a blocked write")
+	}
+	if stats.Streams != 1 {
+		t.Errorf("Stats().Streams = %d, want 1", stats.Streams)
+	}
+}
+
+func TestWriteQueue_PriorityOrder(t *testing.T) {
+	q := newWriteQueue()
+	push := func(name string, level int) {
+		q.push(outFrame{data: []byte(name), level: level})
+	}
+	push("n1", levelNormal)
+	push("low", levelLow)
+	push("n2", levelNormal)
+	push("high", levelHigh)
+	push("ping", levelControl)
+	q.close()
+
+	var got []string
+	for {
+		f, ok := q.pop()
+		if !ok {
+			break
+		}
+		got = append(got, string(f.data))
+	}
+	want := []string{"ping", "high", "n1", "n2", "low"}
+	if !slices.Equal(got, want) {
+		t.Errorf("pop order %v, want %v", got, want)
+	}
+	if q.push(outFrame{level: levelNormal}) {
+		t.Error("push succeeded on a closed queue")
+	}
+}
+
+func TestWriteQueue_Fairness(t *testing.T) {
+	q := newWriteQueue()
+	for i := range 200 {
+		q.push(outFrame{data: []byte(fmt.Sprintf("high %d", i)), level: levelHigh})
+	}
+	for i := range 5 {
+		q.push(outFrame{data: []byte(fmt.Sprintf("normal %d", i)), level: levelNormal})
+		q.push(outFrame{data: []byte(fmt.Sprintf("low %d", i)), level: levelLow})
+	}
+	q.close()
+
+	// Every waiting level gets a frame at least once per fairnessBurst+1
+	// frames, and frames of a level stay in order.
+	lastSeen := map[string]int{"normal": -1, "low": -1}
+	next := map[string]int{}
+	for pos := 0; ; pos++ {
+		f, ok := q.pop()
+		if !ok {
+			break
+		}
+		var level string
+		var n int
+		fmt.Sscanf(string(f.data), "%s %d", &level, &n)
+		if n != next[level] {
+			t.Fatalf("%s frame %d popped before %d", level, n, next[level])
+		}
+		next[level]++
+		if last, ok := lastSeen[level]; ok {
+			if next[level] <= 5 && pos-last > 2*(fairnessBurst+1) {
+				t.Errorf("%s waited %d frames", level, pos-last)
+			}
+			lastSeen[level] = pos
+		}
+	}
+	if next["high"] != 200 || next["normal"] != 5 || next["low"] != 5 {
+		t.Errorf("popped %v", next)
+	}
+}
+
+func TestStream_SetPriorityKeepsQueuedOrder(t *testing.T) {
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
+	if got := s.addPending(); got != levelNormal {
+		t.Fatalf("default level %d, want normal", got)
+	}
+	s.SetPriority(10)
+	if got := s.Priority(); got != PriorityHigh {
+		t.Errorf("Priority() = %d, want it clamped to PriorityHigh", got)
+	}
+	// A frame is still queued at normal, so new ones must follow it there.
+	if got := s.addPending(); got != levelNormal {
+		t.Errorf("level with frames pending = %d, want normal", got)
+	}
+	s.donePending()
+	s.donePending()
+	if got := s.addPending(); got != levelHigh {
+		t.Errorf("level once drained = %d, want high", got)
+	}
+}
+
+func TestMux_HighPriorityStreamJumpsQueue(t *testing.T) {
+	// The tracer holds the writeLoop on the first DATA frame, so the
+	// frames written meanwhile queue up behind it.
+	held, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	tracer := func(dir Direction, f Frame) {
+		if dir == DirectionOut && f.Type == FrameData {
+			once.Do(func() {
+				close(held)
+				<-release
+			})
+		}
+	}
+	serverM, peer, cleanup := setupRawPeer(t, WithTracer(tracer))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	writeRaw(t, peer, Frame{Type: FrameOpenStream, StreamID: 1}, Frame{Type: FrameOpenStream, StreamID: 3})
+	bulk, err := serverM.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	interactive, err := serverM.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	interactive.SetPriority(PriorityHigh)
+
+	for i := range 20 {
+		if _, err := bulk.Write([]byte("bulk")); err != nil {
+			t.Fatalf("bulk Write: %v", err)
+		}
+		if i == 0 {
+			<-held
+		}
+	}
+	if _, err := interactive.Write([]byte("key")); err != nil {
+		t.Fatalf("interactive Write: %v", err)
+	}
+	close(release)
+
+	var order []string
+	for len(order) < 21 {
+		_, data, err := peer.Read(ctx)
+		if err != nil {
+			t.Fatalf("peer read: %v", err)
+		}
+		f, err := DecodeFrame(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DecodeFrame: %v", err)
+		}
+		if f.Type == FrameData {
+			order = append(order, string(f.Payload))
+		}
+	}
+	// The first bulk frame was already being written.
+	if order[0] != "bulk" || order[1] != "key" {
+		t.Errorf("wire order starts %v, want bulk then the high-priority key", order[:3])
+	}
+}
+
 func TestMux_MultipleDataFrames(t *testing.T) {
 	serverMux, clientMux, cleanup := setupMuxPair(t)
 	defer cleanup()
@@ -543,3 +1048,911 @@ func TestMux_MultipleDataFrames(t *testing.T) {
 		t.Errorf("got %q", total)
 	}
 }
+
+func TestMux_OnOpenStream(t *testing.T) {
+	serverMux, clientMux, cleanup := setupMuxPair(t)
+	defer cleanup()
+
+	opened := make(chan *Stream, 1)
+	serverMux.OnOpenStream(func(s *Stream) {
+		opened <- s
+	})
+
+	ctx := context.Background()
+	cs, err := clientMux.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+
+	var ss *Stream
+	select {
+	case ss = <-opened:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for OnOpenStream callback")
+	}
+	if ss.ID != cs.ID {
+		t.Errorf("stream ID mismatch: %d vs %d", ss.ID, cs.ID)
+	}
+
+	// AcceptStream must not also receive the stream.
+	acceptCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if s, err := serverMux.AcceptStream(acceptCtx); err == nil {
+		t.Fatalf("AcceptStream returned stream %d; want no delivery", s.ID)
+	}
+}
+
+func TestMux_Streams(t *testing.T) {
+	serverMux, clientMux, cleanup := setupMuxPair(t)
+	defer cleanup()
+
+	cs, err := clientMux.OpenStream(context.Background())
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	select {
+	case ss := <-serverMux.Streams():
+		if ss.ID != cs.ID {
+			t.Errorf("stream ID mismatch: %d vs %d", ss.ID, cs.ID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for a stream on Streams")
+	}
+
+	serverMux.Close()
+	select {
+	case s, ok := <-serverMux.Streams():
+		if ok {
+			t.Fatalf("got stream %d after Close; want the channel closed", s.ID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Streams was not closed by Close")
+	}
+}
+
+func TestMux_AcceptOverflowDoesNotBlockPong(t *testing.T) {
+	_, clientMux, cleanup := setupMuxPair(t, WithAcceptBacklog(1))
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// Nobody accepts on the server: the first stream fills the backlog and
+	// the rest must be rejected rather than stalling the server readLoop.
+	var streams []*Stream
+	for i := 0; i < 3; i++ {
+		s, err := clientMux.OpenStream(ctx)
+		if err != nil {
+			t.Fatalf("OpenStream %d: %v", i, err)
+		}
+		streams = append(streams, s)
+	}
+
+	pongReceived := make(chan struct{}, 1)
+	clientMux.OnPong(func() {
+		select {
+		case pongReceived <- struct{}{}:
+		default:
+		}
+	})
+	if err := clientMux.SendPing(ctx); err != nil {
+		t.Fatalf("SendPing: %v", err)
+	}
+	select {
+	case <-pongReceived:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for pong with a stalled accepter")
+	}
+
+	// The last stream was rejected with a too-busy reset.
+	buf := make([]byte, 8)
+	_, err := streams[2].Read(buf)
+	var resetErr *ResetError
+	if !errors.As(err, &resetErr) {
+		t.Fatalf("expected *ResetError, got %v", err)
+	}
+	if resetErr.Code != ResetTooBusy {
+		t.Errorf("reset code: got %v, want %v", resetErr.Code, ResetTooBusy)
+	}
+}
+
+func TestMux_MaxStreamsRejectsInbound(t *testing.T) {
+	serverMux, clientMux, cleanup := setupMuxPair(t)
+	defer cleanup()
+	serverMux.SetMaxStreams(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var client, server []*Stream
+	for i := 0; i < 2; i++ {
+		s, err := clientMux.OpenStream(ctx)
+		if err != nil {
+			t.Fatalf("OpenStream %d: %v", i, err)
+		}
+		client = append(client, s)
+		accepted, err := serverMux.AcceptStream(ctx)
+		if err != nil {
+			t.Fatalf("AcceptStream %d: %v", i, err)
+		}
+		server = append(server, accepted)
+	}
+
+	// One past the limit: the server must refuse it.
+	extra, err := clientMux.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream extra: %v", err)
+	}
+	buf := make([]byte, 8)
+	_, err = extra.Read(buf)
+	var resetErr *ResetError
+	if !errors.As(err, &resetErr) {
+		t.Fatalf("expected *ResetError, got %v", err)
+	}
+	if resetErr.Code != ResetRefused {
+		t.Errorf("reset code: got %v, want %v", resetErr.Code, ResetRefused)
+	}
+
+	// The streams within the limit still work.
+	for i := range client {
+		msg := []byte{'a' + byte(i)}
+		if _, err := client[i].Write(msg); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+		n, err := server[i].Read(buf)
+		if err != nil {
+			t.Fatalf("Read %d: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Errorf("stream %d: got %q, want %q", i, buf[:n], msg)
+		}
+	}
+}
+
+func TestMux_DuplicateOpenKeepsExistingStream(t *testing.T) {
+	serverMux, peer, cleanup := setupRawPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	writeRaw(t, peer,
+		Frame{Type: FrameOpenStream, StreamID: 1},
+		Frame{Type: FrameData, StreamID: 1, Payload: []byte("first ")},
+		Frame{Type: FrameOpenStream, StreamID: 1},
+		Frame{Type: FrameData, StreamID: 1, Payload: []byte("second")},
+		Frame{Type: FrameCloseStream, StreamID: 1},
+	)
+
+	stream, err := serverMux.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "first second" {
+		t.Errorf("data: got %q, want %q", got, "first second")
+	}
+
+	// The duplicate OPEN was answered with a protocol-error reset.
+	_, data, err := peer.Read(ctx)
+	if err != nil {
+		t.Fatalf("peer read: %v", err)
+	}
+	f, err := DecodeFrame(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	if f.Type != FrameReset || f.StreamID != 1 || decodeResetPayload(f.Payload) != ResetProtocolError {
+		t.Errorf("got %v, want protocol-error RESET for stream 1", f)
+	}
+
+	// Opening an id that is in use reports ErrStreamExists.
+	writeRaw(t, peer, Frame{Type: FrameOpenStream, StreamID: 3})
+	if _, err := serverMux.AcceptStream(ctx); err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	if err := serverMux.handleOpenStream(3); !errors.Is(err, ErrStreamExists) {
+		t.Errorf("handleOpenStream(3): got %v, want ErrStreamExists", err)
+	}
+}
+
+func TestMux_StalledStreamDoesNotBlockPing(t *testing.T) {
+	old := streamSpillLimit
+	streamSpillLimit = 64
+	defer func() { streamSpillLimit = old }()
+
+	serverMux, peer, cleanup := setupRawPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Fill the stream's channel and spill a little; nobody reads it.
+	frames := []Frame{{Type: FrameOpenStream, StreamID: 1}}
+	for i := 0; i < 256+32; i++ {
+		frames = append(frames, Frame{Type: FrameData, StreamID: 1, Payload: []byte{byte(i)}})
+	}
+	writeRaw(t, peer, append(frames, Frame{Type: FramePing})...)
+	stream, err := serverMux.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	_, data, err := peer.Read(ctx)
+	if err != nil {
+		t.Fatalf("waiting for pong: %v", err)
+	}
+	if f, _ := DecodeFrame(bytes.NewReader(data)); f.Type != FramePong {
+		t.Fatalf("got %v, want PONG", f)
+	}
+
+	// Passing the spill limit resets the stream.
+	frames = frames[:0]
+	for i := 0; i < 64; i++ {
+		frames = append(frames, Frame{Type: FrameData, StreamID: 1, Payload: []byte{byte(i)}})
+	}
+	writeRaw(t, peer, frames...)
+	_, data, err = peer.Read(ctx)
+	if err != nil {
+		t.Fatalf("waiting for reset: %v", err)
+	}
+	f, _ := DecodeFrame(bytes.NewReader(data))
+	if f.Type != FrameReset || decodeResetPayload(f.Payload) != ResetTooBusy {
+		t.Fatalf("got %v, want too-busy RESET", f)
+	}
+
+	// Everything buffered before the reset is still delivered, in order.
+	got, err := io.ReadAll(stream)
+	var resetErr *ResetError
+	if !errors.As(err, &resetErr) {
+		t.Fatalf("ReadAll: got %v, want *ResetError", err)
+	}
+	// The channel holds 256 bytes and the spill 64 more.
+	if len(got) != 256+64 {
+		t.Fatalf("read %d bytes, want %d", len(got), 256+64)
+	}
+	for i, b := range got[:256+32] {
+		if b != byte(i) {
+			t.Fatalf("byte %d: got %d, want %d", i, b, byte(i))
+		}
+	}
+}
+
+func TestMux_TwoFramesInOneMessage(t *testing.T) {
+	serverMux, peer, cleanup := setupRawPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg := append(EncodeFrame(Frame{Type: FrameOpenStream, StreamID: 1}),
+		EncodeFrame(Frame{Type: FrameData, StreamID: 1, Payload: []byte("hello")})...)
+	if err := peer.Write(ctx, websocket.MessageBinary, msg); err != nil {
+		t.Fatalf("peer write: %v", err)
+	}
+
+	stream, err := serverMux.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := stream.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("got %q, want %q", buf[:n], "hello")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use, for capturing
+// log output.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMux_LogsUndecodableData(t *testing.T) {
+	var logs lockedBuffer
+	SetLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer SetLogger(nil)
+
+	serverMux, peer, cleanup := setupRawPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := peer.Write(ctx, websocket.MessageBinary, []byte{0xff, 1, 2, 3, 4, 5, 6, 7, 8}); err != nil {
+		t.Fatalf("peer write: %v", err)
+	}
+	// The mux handles messages in order, so once this stream arrives the
+	// garbage has been dealt with.
+	writeRaw(t, peer, Frame{Type: FrameOpenStream, StreamID: 1})
+	if _, err := serverMux.AcceptStream(ctx); err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	if got := logs.String(); !strings.Contains(got, "level=WARN") || !strings.Contains(got, "dropping undecodable data") {
+		t.Errorf("logs = %q, want a warning about undecodable data", got)
+	}
+}
+
+func TestMux_FrameSplitAcrossMessages(t *testing.T) {
+	serverMux, peer, cleanup := setupRawPeer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	writeRaw(t, peer, Frame{Type: FrameOpenStream, StreamID: 1})
+	stream, err := serverMux.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	// Split inside the header, then inside the payload.
+	frame := EncodeFrame(Frame{Type: FrameData, StreamID: 1, Payload: []byte("split payload")})
+	for _, part := range [][]byte{frame[:4], frame[4:12], frame[12:]} {
+		if err := peer.Write(ctx, websocket.MessageBinary, part); err != nil {
+			t.Fatalf("peer write: %v", err)
+		}
+	}
+
+	buf := make([]byte, 32)
+	n, err := stream.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(buf[:n]) != "split payload" {
+		t.Errorf("got %q, want %q", buf[:n], "split payload")
+	}
+}
+
+func TestStream_ReadContextCancel(t *testing.T) {
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.ReadContext(ctx, make([]byte, 8))
+		done <- err
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("ReadContext: got %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ReadContext did not return after cancel")
+	}
+
+	// A plain Read still works afterwards.
+	s.pushData([]byte("ok"))
+	buf := make([]byte, 8)
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "ok" {
+		t.Fatalf("Read: got %q, %v", buf[:n], err)
+	}
+}
+
+func TestStream_WriteContextCancel(t *testing.T) {
+	// The write blocks until the mux accepts it, like a full write queue.
+	s := newStream(1, func(ctx context.Context, _ []byte) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, func() {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.WithContext(ctx).Write([]byte("x")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Write: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestStream_CloseAfterFlushWaitsForPendingWrites(t *testing.T) {
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
+	s.addPending()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.CloseAfterFlush(context.Background())
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("CloseAfterFlush returned while a write was pending")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.donePending()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("CloseAfterFlush: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CloseAfterFlush did not return after writes drained")
+	}
+	if !s.isClosed() {
+		t.Error("stream should be closed")
+	}
+}
+
+func TestMux_CloseAfterFlushDeliversData(t *testing.T) {
+	serverMux, clientMux, cleanup := setupMuxPair(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	cs, err := clientMux.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	ss, err := serverMux.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	// Several writes, each under the test connection's default read limit.
+	chunk := bytes.Repeat([]byte("x"), 16*1024)
+	var payload []byte
+	for i := 0; i < 16; i++ {
+		if _, err := cs.Write(chunk); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+		payload = append(payload, chunk...)
+	}
+	drainCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := cs.CloseAfterFlush(drainCtx); err != nil {
+		t.Fatalf("CloseAfterFlush: %v", err)
+	}
+
+	got, err := io.ReadAll(ss)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(payload))
+	}
+}
+
+// stallTransport holds every write until fail is closed, then fails it.
+type stallTransport struct {
+	fail   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (t *stallTransport) ReadMessage(ctx context.Context) ([]byte, error) {
+	<-t.closed
+	return nil, io.EOF
+}
+
+func (t *stallTransport) WriteMessage(ctx context.Context, p []byte) error {
+	select {
+	case <-t.fail:
+	case <-t.closed:
+	}
+	return errors.New("write failed")
+}
+
+func (t *stallTransport) Close(websocket.StatusCode, string) error {
+	return t.CloseNow()
+}
+
+func (t *stallTransport) CloseNow() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func TestMux_CloseDuringCloseAfterFlushAndWriteError(t *testing.T) {
+	tr := &stallTransport{fail: make(chan struct{}), closed: make(chan struct{})}
+	m := NewMux(tr, false, WithCloseDrain(0))
+
+	blocked := make(chan struct{})
+	var blockedOnce sync.Once
+	m.OnWriteBlocked(func(int) { blockedOnce.Do(func() { close(blocked) }) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := m.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	go func() {
+		chunk := make([]byte, 1024)
+		for {
+			if _, err := s.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-blocked:
+	case <-ctx.Done():
+		t.Fatal("the write queue never filled")
+	}
+
+	// The stream's close gives up on draining and queues CLOSE_STREAM
+	// behind the full queue; then the transport fails and the mux closes.
+	flushCtx, cancelFlush := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelFlush()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_ = s.CloseAfterFlush(flushCtx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(tr.fail)
+	go func() {
+		defer wg.Done()
+		m.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close and CloseAfterFlush deadlocked")
+	}
+}
+
+func TestFrame_String(t *testing.T) {
+	f := Frame{Type: FrameData, StreamID: 3, Payload: []byte("hello")}
+	if got, want := f.String(), "DATA stream=3 len=5"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+}
+
+func TestMux_Tracer(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		traced []string
+	)
+	tracer := func(dir Direction, f Frame) {
+		mu.Lock()
+		traced = append(traced, dir.String()+" "+FrameTypeName(f.Type))
+		mu.Unlock()
+	}
+	serverMux, clientMux, cleanup := setupMuxPair(t, WithTracer(tracer))
+	defer cleanup()
+
+	ctx := context.Background()
+	cs, err := clientMux.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	ss, err := serverMux.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	if _, err := cs.Write([]byte("hi")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 8)
+	if _, err := ss.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if _, err := ss.Write([]byte("yo")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := cs.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"recv OPEN_STREAM", "recv DATA", "send DATA"}
+	if len(traced) != len(want) {
+		t.Fatalf("traced %v, want %v", traced, want)
+	}
+	for i := range want {
+		if traced[i] != want[i] {
+			t.Errorf("trace[%d]: got %q, want %q", i, traced[i], want[i])
+		}
+	}
+}
+
+func TestStream_WriteTo(t *testing.T) {
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
+	s.pushData([]byte("abcdef"))
+
+	// A partial Read leaves bytes in readBuf that WriteTo must emit first.
+	buf := make([]byte, 2)
+	if _, err := s.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	s.pushData([]byte("ghi"))
+	s.closeRead()
+
+	var out bytes.Buffer
+	n, err := io.Copy(&out, s)
+	if err != nil {
+		t.Fatalf("io.Copy: %v", err)
+	}
+	if out.String() != "cdefghi" || n != 7 {
+		t.Errorf("got %q (%d bytes), want %q", out.String(), n, "cdefghi")
+	}
+}
+
+func TestStream_ReadChunk(t *testing.T) {
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
+	first := []byte("abcdef")
+	s.pushData(first)
+	s.pushData([]byte("ghi"))
+	s.pushData([]byte("jkl"))
+	s.closeRead()
+
+	// A partial Read leaves the rest of the chunk for ReadChunk.
+	buf := make([]byte, 2)
+	if n, err := s.Read(buf); err != nil || string(buf[:n]) != "ab" {
+		t.Fatalf("Read: got %q, %v", buf[:n], err)
+	}
+	chunk, err := s.ReadChunk()
+	if err != nil || string(chunk) != "cdef" {
+		t.Fatalf("ReadChunk after Read: got %q, %v; want cdef", chunk, err)
+	}
+	if &chunk[0] != &first[2] {
+		t.Error("ReadChunk copied the data")
+	}
+
+	chunk, err = s.ReadChunk()
+	if err != nil || string(chunk) != "ghi" {
+		t.Fatalf("ReadChunk: got %q, %v; want ghi", chunk, err)
+	}
+	// The caller owns the chunk; changing it does not affect later reads.
+	chunk[0] = 'X'
+
+	rest, err := io.ReadAll(s)
+	if err != nil || string(rest) != "jkl" {
+		t.Fatalf("Read after ReadChunk: got %q, %v; want jkl", rest, err)
+	}
+	if chunk, err := s.ReadChunk(); err != io.EOF || chunk != nil {
+		t.Errorf("ReadChunk at EOF: got %q, %v; want io.EOF", chunk, err)
+	}
+}
+
+func TestStream_ReadChunkContext(t *testing.T) {
+	s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.ReadChunkContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestStream_ReadFrom(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		written [][]byte
+	)
+	s := newStream(1, func(_ context.Context, p []byte) error {
+		mu.Lock()
+		written = append(written, append([]byte(nil), p...))
+		mu.Unlock()
+		return nil
+	}, func() {})
+
+	src := bytes.Repeat([]byte("z"), readFromChunkSize+100)
+	n, err := s.ReadFrom(bytes.NewReader(src))
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if n != int64(len(src)) {
+		t.Fatalf("ReadFrom n: got %d, want %d", n, len(src))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(written) != 2 {
+		t.Fatalf("got %d frames, want 2", len(written))
+	}
+	if !bytes.Equal(bytes.Join(written, nil), src) {
+		t.Error("written data does not match source")
+	}
+
+	s.Close()
+	if _, err := s.ReadFrom(bytes.NewReader([]byte("x"))); err != ErrStreamClosed {
+		t.Fatalf("ReadFrom after close: got %v, want ErrStreamClosed", err)
+	}
+}
+
+// benchmarkStreamCopy measures io.Copy draining a stream holding 2 MB of
+// 32 KB chunks. With plain set, io.Copy cannot see WriteTo and falls back to
+// its generic buffer loop (the behaviour before Stream implemented it).
+func benchmarkStreamCopy(b *testing.B, plain bool) {
+	chunk := bytes.Repeat([]byte("x"), 32*1024)
+	const chunks = 64
+	b.SetBytes(int64(len(chunk) * chunks))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		s := newStream(1, func(context.Context, []byte) error { return nil }, func() {})
+		for j := 0; j < chunks; j++ {
+			s.pushData(chunk)
+		}
+		s.closeRead()
+
+		var src io.Reader = s
+		var dst io.Writer = io.Discard
+		if plain {
+			src = struct{ io.Reader }{s}
+			dst = struct{ io.Writer }{io.Discard}
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamCopy_ReadLoop(b *testing.B) { benchmarkStreamCopy(b, true) }
+func BenchmarkStreamCopy_WriteTo(b *testing.B)  { benchmarkStreamCopy(b, false) }
+
+func TestMux_WriteLargerThanMaxPayload(t *testing.T) {
+	serverMux, clientMux, cleanup := setupMuxPair(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	clientStream, err := clientMux.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	serverStream, err := serverMux.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+
+	payload := make([]byte, 2*MaxPayloadSize)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		n, err := clientStream.Write(payload)
+		if err == nil && n != len(payload) {
+			err = io.ErrShortWrite
+		}
+		if err == nil {
+			err = clientStream.Close()
+		}
+		errCh <- err
+	}()
+
+	got, err := io.ReadAll(serverStream)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %d bytes, want %d intact", len(got), len(payload))
+	}
+}
+
+func TestMux_KeepaliveClosesOnStalledPeer(t *testing.T) {
+	// The peer completes the WebSocket handshake and then never reads, so
+	// no PONG ever comes back.
+	stalled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-stalled
+		conn.Close(websocket.StatusNormalClosure, "")
+	}))
+	defer srv.Close()
+	defer close(stalled)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+srv.URL[len("http"):], nil)
+	if err != nil {
+		t.Fatalf("websocket.Dial: %v", err)
+	}
+
+	const interval = 50 * time.Millisecond
+	m := NewMux(NewWebSocketTransport(conn), false, WithKeepalive(interval, 2))
+	defer m.Close()
+
+	timedOut := make(chan struct{})
+	m.OnKeepaliveTimeout(func() { close(timedOut) })
+
+	start := time.Now()
+	if _, err := m.AcceptStream(ctx); !errors.Is(err, ErrMuxClosed) {
+		t.Fatalf("AcceptStream: got %v, want ErrMuxClosed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*interval {
+		t.Errorf("mux closed after %v, want within %v", elapsed, 10*interval)
+	}
+	select {
+	case <-timedOut:
+	default:
+		t.Error("OnKeepaliveTimeout was not called")
+	}
+}
+
+func TestMux_KeepaliveHealthyPeer(t *testing.T) {
+	serverMux, _, cleanup := setupMuxPair(t, WithKeepalive(20*time.Millisecond, 2))
+	defer cleanup()
+
+	select {
+	case <-serverMux.closed:
+		t.Fatal("mux closed although the peer answers pings")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestMux_IdleTimeout(t *testing.T) {
+	const idle = 100 * time.Millisecond
+	serverMux, _, cleanup := setupMuxPair(t, WithIdleTimeout(idle))
+	defer cleanup()
+
+	start := time.Now()
+	select {
+	case <-serverMux.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle mux did not close")
+	}
+	if elapsed := time.Since(start); elapsed < idle {
+		t.Errorf("mux closed after %v, before the %v idle timeout", elapsed, idle)
+	}
+}
+
+func TestMux_IdleTimeoutKeptAliveByPings(t *testing.T) {
+	serverMux, _, cleanup := setupMuxPair(t,
+		WithIdleTimeout(100*time.Millisecond), WithKeepalive(20*time.Millisecond, 2))
+	defer cleanup()
+
+	select {
+	case <-serverMux.Done():
+		t.Fatal("mux closed as idle although pings were flowing")
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
+func TestMux_MaxLifetime(t *testing.T) {
+	const lifetime = 150 * time.Millisecond
+	serverMux, clientMux, cleanup := setupMuxPair(t,
+		WithMaxLifetime(lifetime), WithKeepalive(20*time.Millisecond, 2))
+	defer cleanup()
+
+	start := time.Now()
+	select {
+	case <-serverMux.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("mux outlived its max lifetime")
+	}
+	if elapsed := time.Since(start); elapsed < lifetime/2 {
+		t.Errorf("mux closed after %v, want about %v", elapsed, lifetime)
+	}
+	// The peer sees the connection end too.
+	select {
+	case <-clientMux.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer mux did not notice the close")
+	}
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import "sync"
+
+// Stream priorities for SetPriority. Streams are PriorityNormal unless set
+// otherwise.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
+// Write queue levels, highest first. Control frames (PING, PONG, and
+// OPEN_STREAM) are ahead of every stream's data; the rest map from the
+// sending stream's priority.
+const (
+	levelControl = iota
+	levelHigh
+	levelNormal
+	levelLow
+	numLevels
+)
+
+// priorityLevel returns the write queue level for stream priority p.
+func priorityLevel(p int) int {
+	switch {
+	case p > PriorityNormal:
+		return levelHigh
+	case p < PriorityNormal:
+		return levelLow
+	}
+	return levelNormal
+}
+
+// fairnessBurst is how many frames in a row may be taken from higher
+// levels while a lower one waits before the lower one gets a frame, so a
+// busy high-priority stream slows the others down without starving them.
+const fairnessBurst = 16
+
+// writeQueue orders the frames waiting for the writeLoop. Frames are taken
+// from the highest non-empty level, FIFO within a level, subject to
+// fairnessBurst. A stream's frames all go to one level at a time (see
+// Stream.addPending), so they stay in order on the wire.
+//
+// The queue itself is unbounded; the mux limits it with writeSlots.
+type writeQueue struct {
+	mu     sync.Mutex
+	levels [numLevels][]outFrame
+	passed [numLevels]int // frames taken from above while the level waited
+	n      int
+	closed bool
+	ready  chan struct{} // signalled after a push or close
+}
+
+func newWriteQueue() *writeQueue {
+	return &writeQueue{ready: make(chan struct{}, 1)}
+}
+
+// push adds f at its level. It reports false if the queue is closed.
+func (q *writeQueue) push(f outFrame) bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	q.levels[f.level] = append(q.levels[f.level], f)
+	q.n++
+	q.mu.Unlock()
+	q.signal()
+	return true
+}
+
+// close makes pop report false once the queued frames are taken.
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *writeQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// len returns the number of queued frames.
+func (q *writeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// pop blocks until a frame is queued and returns it, or returns false once
+// the queue is closed and empty. Only one goroutine may call pop.
+func (q *writeQueue) pop() (outFrame, bool) {
+	for {
+		q.mu.Lock()
+		if q.n > 0 {
+			f := q.take()
+			q.mu.Unlock()
+			return f, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return outFrame{}, false
+		}
+		<-q.ready
+	}
+}
+
+// take removes the next frame. q.mu must be held and q.n > 0.
+func (q *writeQueue) take() outFrame {
+	// Control frames always go first; they are small and rare.
+	level := -1
+	if len(q.levels[levelControl]) > 0 {
+		level = levelControl
+	} else {
+		// The highest waiting level goes next, unless a lower one has
+		// been passed over fairnessBurst times.
+		for l := levelHigh; l < numLevels; l++ {
+			if len(q.levels[l]) == 0 {
+				continue
+			}
+			if level < 0 {
+				level = l
+			} else if q.passed[l] >= fairnessBurst {
+				level = l
+				break
+			}
+		}
+		for l := level + 1; l < numLevels; l++ {
+			if len(q.levels[l]) > 0 {
+				q.passed[l]++
+			}
+		}
+		q.passed[level] = 0
+	}
+
+	f := q.levels[level][0]
+	q.levels[level][0] = outFrame{}
+	q.levels[level] = q.levels[level][1:]
+	if len(q.levels[level]) == 0 {
+		// Drop the consumed backing array rather than keep appending
+		// past it.
+		q.levels[level] = nil
+	}
+	q.n--
+	return f
+}
@@ -1,15 +1,32 @@
 package protocol
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 )
 
 var (
 	ErrStreamClosed = errors.New("protocol: stream closed")
+	ErrStreamReset  = errors.New("protocol: stream reset")
 )
 
+// ResetError is returned by Stream reads once the stream was reset, either
+// by the peer or by the local mux. It matches ErrStreamReset with errors.Is.
+type ResetError struct {
+	Code ResetCode
+}
+
+func (e *ResetError) Error() string {
+	return fmt.Sprintf("protocol: stream reset: %s", e.Code)
+}
+
+func (e *ResetError) Is(target error) bool {
+	return target == ErrStreamReset
+}
+
 // Stream implements io.ReadWriteCloser over a multiplexed connection.
 // It is safe for concurrent use by multiple goroutines.
 type Stream struct {
@@ -19,76 +36,238 @@ type Stream struct {
 	dataCh  chan []byte
 	readBuf []byte
 
-	writeFn func([]byte) error // sends a DATA frame via the mux
-	closeFn func()             // notifies the mux to send CLOSE_STREAM
+	// spill holds chunks that arrived while dataCh was full, oldest first;
+	// see pushData.
+	spillMu    sync.Mutex
+	spill      [][]byte
+	spillBytes int
+
+	// rdSem serialises Read and WriteTo, which share readBuf. It is a
+	// one-slot channel rather than a mutex so that waiting for it can be
+	// cancelled.
+	rdSem chan struct{}
+
+	writeFn func(context.Context, []byte) error // sends a DATA frame via the mux
+	closeFn func()                              // notifies the mux to send CLOSE_STREAM
+	resetFn func(ResetCode)                     // notifies the mux to send RESET
 
 	closeOnce sync.Once
 	closed    chan struct{} // closed when stream is done
 
-	// wrMu serialises Write calls so a single DATA frame is not interleaved.
-	wrMu sync.Mutex
+	// resetErr is set before closed is closed when the stream was reset.
+	resetErr error
+
+	// wrSem serialises Write calls so a single DATA frame is not
+	// interleaved. Like rdSem, it is a one-slot channel.
+	wrSem chan struct{}
+
+	// pending counts DATA frames handed to the mux but not yet written to
+	// the connection. drainWaiters are closed when it drops to zero.
+	pendMu       sync.Mutex
+	pending      int
+	drainWaiters []chan struct{}
+
+	// priority is the level set by SetPriority; level the write queue
+	// level of the pending frames, which only follows priority once none
+	// are pending. Both are guarded by pendMu.
+	priority int
+	level    int
 }
 
-func newStream(id uint32, writeFn func([]byte) error, closeFn func()) *Stream {
+// streamSpillLimit caps the bytes a stream buffers beyond dataCh for a
+// reader that has fallen behind. It is a variable so tests can lower it.
+var streamSpillLimit = 16 * 1024 * 1024
+
+// readFromChunkSize is the buffer size ReadFrom reads into, and so the
+// largest DATA frame it produces.
+const readFromChunkSize = 32 * 1024
+
+func newStream(id uint32, writeFn func(context.Context, []byte) error, closeFn func()) *Stream {
 	return &Stream{
 		ID:      id,
 		dataCh:  make(chan []byte, 256),
+		rdSem:   make(chan struct{}, 1),
+		wrSem:   make(chan struct{}, 1),
 		writeFn: writeFn,
 		closeFn: closeFn,
 		closed:  make(chan struct{}),
+		level:   levelNormal,
+	}
+}
+
+// acquire takes the one-slot semaphore sem, or returns ctx.Err() if ctx is
+// done first.
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 // Read reads incoming data from the stream.
 // It blocks until data is available or the stream is closed.
 func (s *Stream) Read(p []byte) (int, error) {
+	return s.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like Read, but returns ctx.Err() if ctx is done before
+// data arrives.
+func (s *Stream) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if err := acquire(ctx, s.rdSem); err != nil {
+		return 0, err
+	}
+	defer func() { <-s.rdSem }()
+
+	data, err := s.nextChunk(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	if n < len(data) {
+		s.readBuf = data[n:]
+	}
+	return n, nil
+}
+
+// ReadChunk returns the next chunk of incoming data without copying it:
+// usually one DATA frame's payload, or what a previous Read left of one.
+// The caller owns the returned slice and may keep or modify it; the stream
+// does not touch it again. It blocks like Read, and returns io.EOF once the
+// stream is closed and drained. Read and ReadChunk may be mixed freely.
+//
+// io.Copy needs no help to avoid the copy: it uses WriteTo.
+func (s *Stream) ReadChunk() ([]byte, error) {
+	return s.ReadChunkContext(context.Background())
+}
+
+// ReadChunkContext is like ReadChunk, but returns ctx.Err() if ctx is done
+// before data arrives.
+func (s *Stream) ReadChunkContext(ctx context.Context) ([]byte, error) {
+	if err := acquire(ctx, s.rdSem); err != nil {
+		return nil, err
+	}
+	defer func() { <-s.rdSem }()
+	return s.nextChunk(ctx)
+}
+
+// WriteTo implements io.WriterTo, letting io.Copy hand each received chunk
+// straight to w instead of copying it through an intermediate buffer. It
+// returns when the stream reaches EOF (reported as a nil error) or when
+// reading or writing fails.
+func (s *Stream) WriteTo(w io.Writer) (int64, error) {
+	return s.writeTo(context.Background(), w)
+}
+
+func (s *Stream) writeTo(ctx context.Context, w io.Writer) (int64, error) {
+	if err := acquire(ctx, s.rdSem); err != nil {
+		return 0, err
+	}
+	defer func() { <-s.rdSem }()
+
+	var total int64
 	for {
-		// Drain leftover bytes from a previous chunk first.
-		if len(s.readBuf) > 0 {
-			n := copy(p, s.readBuf)
-			s.readBuf = s.readBuf[n:]
-			return n, nil
+		data, err := s.nextChunk(ctx)
+		if err != nil {
+			if err == io.EOF {
+				return total, nil
+			}
+			return total, err
+		}
+		n, err := w.Write(data)
+		total += int64(n)
+		if err != nil {
+			if n < len(data) {
+				s.readBuf = data[n:]
+			}
+			return total, err
+		}
+		if n < len(data) {
+			s.readBuf = data[n:]
+			return total, io.ErrShortWrite
 		}
+	}
+}
+
+// nextChunk returns the next unread chunk of incoming data, leftover bytes
+// from a previous partial read first. The caller must hold rdSem.
+// It blocks until data is available, the stream is closed, or ctx is done.
+func (s *Stream) nextChunk(ctx context.Context) ([]byte, error) {
+	// Drain leftover bytes from a previous chunk first.
+	if len(s.readBuf) > 0 {
+		data := s.readBuf
+		s.readBuf = nil
+		return data, nil
+	}
 
+	select {
+	case data, ok := <-s.dataCh:
+		if !ok {
+			return nil, io.EOF
+		}
+		s.refill()
+		return data, nil
+	case <-s.closed:
+		// Drain any remaining data in the channel before returning EOF.
 		select {
 		case data, ok := <-s.dataCh:
 			if !ok {
-				return 0, io.EOF
+				return nil, io.EOF
 			}
-			n := copy(p, data)
-			if n < len(data) {
-				s.readBuf = data[n:]
-			}
-			return n, nil
-		case <-s.closed:
-			// Drain any remaining data in the channel before returning EOF.
-			select {
-			case data, ok := <-s.dataCh:
-				if !ok {
-					return 0, io.EOF
-				}
-				n := copy(p, data)
-				if n < len(data) {
-					s.readBuf = data[n:]
-				}
-				return n, nil
-			default:
-				return 0, io.EOF
+			s.refill()
+			return data, nil
+		default:
+			if s.resetErr != nil {
+				return nil, s.resetErr
 			}
+			return nil, io.EOF
 		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-// Write sends data over the stream as a DATA frame.
+// WithContext returns a view of the stream whose reads and writes give up
+// with ctx.Err() once ctx is done, for use with io.Copy and similar. The
+// stream itself is not closed when ctx is done.
+func (s *Stream) WithContext(ctx context.Context) io.ReadWriter {
+	return &ctxStream{s: s, ctx: ctx}
+}
+
+// ctxStream is the view returned by Stream.WithContext. It keeps the
+// stream's io.WriterTo and io.ReaderFrom fast paths.
+type ctxStream struct {
+	s   *Stream
+	ctx context.Context
+}
+
+func (c *ctxStream) Read(p []byte) (int, error)  { return c.s.ReadContext(c.ctx, p) }
+func (c *ctxStream) Write(p []byte) (int, error) { return c.s.WriteContext(c.ctx, p) }
+
+func (c *ctxStream) WriteTo(w io.Writer) (int64, error)  { return c.s.writeTo(c.ctx, w) }
+func (c *ctxStream) ReadFrom(r io.Reader) (int64, error) { return c.s.readFrom(c.ctx, r) }
+
+// Write sends data over the stream as one or more DATA frames. Payloads
+// larger than MaxPayloadSize are split so the peer can decode every frame.
 func (s *Stream) Write(p []byte) (int, error) {
+	return s.WriteContext(context.Background(), p)
+}
+
+// WriteContext is like Write, but gives up with ctx.Err() if ctx is done
+// while waiting for the mux to accept the data. Frames already handed to
+// the mux are still sent.
+func (s *Stream) WriteContext(ctx context.Context, p []byte) (int, error) {
 	select {
 	case <-s.closed:
 		return 0, ErrStreamClosed
 	default:
 	}
 
-	s.wrMu.Lock()
-	defer s.wrMu.Unlock()
+	if err := acquire(ctx, s.wrSem); err != nil {
+		return 0, err
+	}
+	defer func() { <-s.wrSem }()
 
 	// Re-check after acquiring lock.
 	select {
@@ -97,26 +276,195 @@ func (s *Stream) Write(p []byte) (int, error) {
 	default:
 	}
 
-	// Copy so caller can reuse p.
-	buf := make([]byte, len(p))
-	copy(buf, p)
-	if err := s.writeFn(buf); err != nil {
-		return 0, err
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), MaxPayloadSize)
+		// Copy so caller can reuse p.
+		buf := make([]byte, n)
+		copy(buf, p[:n])
+		if err := s.writeFn(ctx, buf); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
 	}
-	return len(p), nil
+	return written, nil
+}
+
+// ReadFrom implements io.ReaderFrom, letting io.Copy read from r directly
+// into a reusable buffer that is framed without an intermediate copy. Each
+// read from r becomes one DATA frame. It returns when r reaches EOF (reported
+// as a nil error), when reading fails, or when the stream is closed.
+func (s *Stream) ReadFrom(r io.Reader) (int64, error) {
+	return s.readFrom(context.Background(), r)
+}
+
+func (s *Stream) readFrom(ctx context.Context, r io.Reader) (int64, error) {
+	buf := make([]byte, readFromChunkSize)
+	var total int64
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if err := s.writeChunk(ctx, buf[:n]); err != nil {
+				return total, err
+			}
+			total += int64(n)
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return total, nil
+			}
+			return total, rerr
+		}
+	}
+}
+
+// writeChunk sends p as DATA frames of at most MaxPayloadSize under wrSem.
+// The mux encodes p into new frames before returning, so p may be reused
+// afterwards.
+func (s *Stream) writeChunk(ctx context.Context, p []byte) error {
+	if err := acquire(ctx, s.wrSem); err != nil {
+		return err
+	}
+	defer func() { <-s.wrSem }()
+
+	select {
+	case <-s.closed:
+		return ErrStreamClosed
+	default:
+	}
+	for len(p) > 0 {
+		n := min(len(p), MaxPayloadSize)
+		if err := s.writeFn(ctx, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// CloseAfterFlush closes the stream after every DATA frame previously
+// written to it has been written to the underlying connection. It does not
+// wait for the peer: the protocol has no acknowledgement of DATA, so there
+// is no way to learn that the peer received or processed it.
+//
+// Ordering: the mux sends frames in the order they were written, so a plain
+// Close already places CLOSE_STREAM after the stream's DATA on the wire. What
+// Close does not do is wait: it returns immediately and the stream is
+// unregistered at once, so later inbound DATA for it is dropped.
+// CloseAfterFlush blocks until outstanding writes have left this process,
+// which lets callers sequence teardown of the local side after the peer has
+// been sent everything.
+//
+// If ctx is done first, the stream is closed anyway and ctx.Err() returned.
+func (s *Stream) CloseAfterFlush(ctx context.Context) error {
+	// Hold wrSem so no new Write can slip in while draining.
+	err := acquire(ctx, s.wrSem)
+	if err == nil {
+		err = s.waitDrained(ctx)
+		<-s.wrSem
+	}
+	s.Close()
+	return err
 }
 
 // Close closes the stream. It is safe to call multiple times.
 func (s *Stream) Close() error {
+	closed := false
 	s.closeOnce.Do(func() {
 		close(s.closed)
-		if s.closeFn != nil {
-			s.closeFn()
-		}
+		closed = true
 	})
+	// closeFn takes the mux lock, so it must run outside closeOnce:
+	// shutdown holds that lock while it closes each stream.
+	if closed && s.closeFn != nil {
+		s.closeFn()
+	}
 	return nil
 }
 
+// SetPriority sets the priority of the stream's outgoing data relative to
+// other streams of the mux: PriorityHigh, PriorityNormal (the default), or
+// PriorityLow; other values are clamped. Higher-priority data is written
+// first, so an interactive stream is not held up behind a bulk transfer,
+// though lower priorities still get a share of the connection. The change
+// applies once data already queued for the stream has been written.
+func (s *Stream) SetPriority(p int) {
+	s.pendMu.Lock()
+	s.priority = max(PriorityLow, min(p, PriorityHigh))
+	if s.pending == 0 {
+		s.level = priorityLevel(s.priority)
+	}
+	s.pendMu.Unlock()
+}
+
+// Priority returns the priority set by SetPriority.
+func (s *Stream) Priority() int {
+	s.pendMu.Lock()
+	defer s.pendMu.Unlock()
+	return s.priority
+}
+
+// Reset ends the stream abruptly, telling the peer why with code instead
+// of the plain end of stream Close gives. Local reads then return a
+// *ResetError. It does nothing if the stream is already closed.
+func (s *Stream) Reset(code ResetCode) {
+	sent := false
+	s.closeOnce.Do(func() {
+		s.resetErr = &ResetError{Code: code}
+		close(s.closed)
+		sent = true
+	})
+	if sent && s.resetFn != nil {
+		s.resetFn(code)
+	}
+}
+
+// addPending records a frame queued for writing and returns the write queue
+// level to queue it at. While frames are pending the level stays fixed, so
+// a priority change cannot let new frames overtake queued ones.
+func (s *Stream) addPending() int {
+	s.pendMu.Lock()
+	defer s.pendMu.Unlock()
+	if s.pending == 0 {
+		s.level = priorityLevel(s.priority)
+	}
+	s.pending++
+	return s.level
+}
+
+// donePending records that a queued frame was written or discarded.
+func (s *Stream) donePending() {
+	s.pendMu.Lock()
+	s.pending--
+	if s.pending == 0 {
+		for _, ch := range s.drainWaiters {
+			close(ch)
+		}
+		s.drainWaiters = nil
+	}
+	s.pendMu.Unlock()
+}
+
+// waitDrained blocks until no DATA frames are pending or ctx is done.
+func (s *Stream) waitDrained(ctx context.Context) error {
+	s.pendMu.Lock()
+	if s.pending == 0 {
+		s.pendMu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	s.drainWaiters = append(s.drainWaiters, ch)
+	s.pendMu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // isClosed reports whether the stream has been closed.
 func (s *Stream) isClosed() bool {
 	select {
@@ -127,13 +475,61 @@ func (s *Stream) isClosed() bool {
 	}
 }
 
-// pushData delivers incoming data to the stream's read side.
-// Called by the mux readLoop.
-func (s *Stream) pushData(data []byte) {
+// pushData delivers incoming data to the stream's read side. It is called
+// by the mux readLoop and never blocks, so a slow reader cannot stall other
+// streams or keepalives. Data that does not fit in dataCh is spilled to an
+// unbounded queue, which is moved back into dataCh as the reader catches
+// up. pushData returns false, dropping data, once the spilled bytes would
+// exceed streamSpillLimit; the mux then resets the stream.
+func (s *Stream) pushData(data []byte) bool {
 	select {
-	case s.dataCh <- data:
 	case <-s.closed:
+		return true
+	default:
 	}
+
+	s.spillMu.Lock()
+	defer s.spillMu.Unlock()
+	// Keep order: once anything is spilled, later data queues behind it.
+	if len(s.spill) == 0 {
+		select {
+		case s.dataCh <- data:
+			return true
+		default:
+		}
+	}
+	if s.spillBytes+len(data) > streamSpillLimit {
+		return false
+	}
+	s.spill = append(s.spill, data)
+	s.spillBytes += len(data)
+	return true
+}
+
+// refill moves spilled chunks into dataCh while it has room. Called by the
+// reader after taking a chunk, so dataCh is never empty while spill is not.
+func (s *Stream) refill() {
+	s.spillMu.Lock()
+	defer s.spillMu.Unlock()
+	for len(s.spill) > 0 {
+		select {
+		case s.dataCh <- s.spill[0]:
+			s.spillBytes -= len(s.spill[0])
+			s.spill[0] = nil
+			s.spill = s.spill[1:]
+		default:
+			return
+		}
+	}
+}
+
+// reset closes the stream because it was reset with code. Pending reads
+// return a *ResetError instead of io.EOF once buffered data is drained.
+func (s *Stream) reset(code ResetCode) {
+	s.closeOnce.Do(func() {
+		s.resetErr = &ResetError{Code: code}
+		close(s.closed)
+	})
 }
 
 // closeRead shuts down the read side of the stream (remote sent CLOSE_STREAM).
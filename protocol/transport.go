@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"context"
+	"io"
+
+	"nhooyr.io/websocket"
+)
+
+// Transport is the connection a Mux runs over. The mux treats what it
+// reads as a byte stream: frames carry their own length, so they need not
+// line up with messages, and one read may return part of a frame or
+// several frames.
+//
+// The mux calls ReadMessage from one goroutine and WriteMessage from
+// another; neither is called concurrently with itself. Close or CloseNow
+// must unblock a pending ReadMessage.
+type Transport interface {
+	// ReadMessage blocks until data arrives and returns it. The mux keeps
+	// the slice, so the transport must not reuse it.
+	ReadMessage(ctx context.Context) ([]byte, error)
+	// WriteMessage sends p, which holds one or more whole frames.
+	WriteMessage(ctx context.Context, p []byte) error
+	// Close closes the connection, telling the peer code and reason if
+	// the transport has a way to.
+	Close(code websocket.StatusCode, reason string) error
+	// CloseNow closes the connection without telling the peer.
+	CloseNow() error
+}
+
+// NewWebSocketTransport returns a Transport that sends each write as one
+// binary WebSocket message. It raises conn's read limit so frames up to
+// MaxPayloadSize are accepted.
+func NewWebSocketTransport(conn *websocket.Conn) Transport {
+	conn.SetReadLimit(MaxPayloadSize + frameHeaderSize)
+	return wsTransport{conn}
+}
+
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t wsTransport) ReadMessage(ctx context.Context) ([]byte, error) {
+	_, data, err := t.conn.Read(ctx)
+	return data, err
+}
+
+func (t wsTransport) WriteMessage(ctx context.Context, p []byte) error {
+	return t.conn.Write(ctx, websocket.MessageBinary, p)
+}
+
+func (t wsTransport) Close(code websocket.StatusCode, reason string) error {
+	return t.conn.Close(code, reason)
+}
+
+func (t wsTransport) CloseNow() error { return t.conn.CloseNow() }
+
+// streamReadSize is how much a stream transport reads at once.
+const streamReadSize = 64 * 1024
+
+// NewStreamTransport returns a Transport over a byte stream such as a TCP
+// connection or a QUIC stream, for networks that block WebSocket. Frames
+// are written back to back: each starts with its payload length, so the
+// stream needs no framing of its own. The close code and reason are not
+// sent; the peer just sees the stream end.
+//
+// Reads and writes ignore ctx; closing the transport unblocks them.
+func NewStreamTransport(rwc io.ReadWriteCloser) Transport {
+	return streamTransport{rwc}
+}
+
+type streamTransport struct {
+	rwc io.ReadWriteCloser
+}
+
+func (t streamTransport) ReadMessage(context.Context) ([]byte, error) {
+	buf := make([]byte, streamReadSize)
+	n, err := t.rwc.Read(buf)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return nil, err
+}
+
+func (t streamTransport) WriteMessage(_ context.Context, p []byte) error {
+	_, err := t.rwc.Write(p)
+	return err
+}
+
+func (t streamTransport) Close(websocket.StatusCode, string) error { return t.rwc.Close() }
+
+func (t streamTransport) CloseNow() error { return t.rwc.Close() }
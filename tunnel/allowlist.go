@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ParseLocalHost validates a local host as given on the command line or in
+// config: a hostname or an IP address, without a port. An IPv6 address may
+// be bracketed, as in a URL; the brackets are removed.
+func ParseLocalHost(host string) (string, error) {
+	h := strings.TrimSpace(host)
+	if strings.HasPrefix(h, "[") && strings.HasSuffix(h, "]") {
+		h = h[1 : len(h)-1]
+		if a, err := netip.ParseAddr(h); err != nil || !a.Is6() {
+			return "", fmt.Errorf("Invalid local host %q. Only IPv6 addresses may be bracketed.", host)
+		}
+	}
+	if h == "" || strings.ContainsAny(h, "/[] ") {
+		return "", fmt.Errorf("Invalid local host %q. Use a hostname or IP address, e.g. 127.0.0.1 or ::1.", host)
+	}
+	if _, err := netip.ParseAddr(h); err != nil && strings.Contains(h, ":") {
+		return "", fmt.Errorf("Invalid local host %q. Give the port separately, not as part of the host.", host)
+	}
+	return h, nil
+}
+
+// LocalTarget returns the "host:port" address of a local server, with an
+// IPv6 host bracketed, for dialing and display.
+func LocalTarget(host string, port int) string {
+	return net.JoinHostPort(unbracket(host), strconv.Itoa(port))
+}
+
+// unbracket removes the brackets around an IPv6 literal, if any.
+func unbracket(host string) string {
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// CheckLocalHost reports whether the forwarder may connect to host.
+// Loopback addresses and "localhost" are always allowed. Other hosts must
+// match an entry of allowed, which may be a hostname, an IP address, or a
+// CIDR range such as 10.0.0.0/8. Hostnames are compared as written, not
+// resolved, so an allowed name cannot be used to reach an arbitrary IP.
+func CheckLocalHost(host string, allowed []string) error {
+	host = unbracket(host)
+	if isLoopbackHost(host) {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	for _, entry := range allowed {
+		if strings.EqualFold(unbracket(entry), host) {
+			return nil
+		}
+		if ip == nil {
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil && cidr.Contains(ip) {
+			return nil
+		}
+		if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("local host %q is not allowed. Add it to allowed_local_hosts in your config, or pass --allow-any-host.", host)
+}
+
+// isLoopbackHost reports whether host is "localhost" or a loopback IP.
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkLocalHost applies the tunnel's host allowlist to host.
+func (o *Options) checkLocalHost(host string) error {
+	if o.AllowAnyHost {
+		return nil
+	}
+	return CheckLocalHost(host, o.AllowedLocalHosts)
+}
@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold is the number of consecutive dial failures after which
+	// requests to a target are short-circuited.
+	breakerThreshold = 3
+	// breakerCooldown is how long a tripped breaker rejects requests before
+	// letting one through to probe the backend again.
+	breakerCooldown = 10 * time.Second
+)
+
+// circuitBreaker tracks consecutive dial failures for one local target so a
+// dead backend is not re-dialed on every request.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// breakers holds one breaker per target address, like transportCache.
+var (
+	breakerMu sync.Mutex
+	breakers  = make(map[string]*circuitBreaker)
+)
+
+func getBreaker(target string) *circuitBreaker {
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+	if b, ok := breakers[target]; ok {
+		return b
+	}
+	b := &circuitBreaker{}
+	breakers[target] = b
+	return b
+}
+
+// allow reports whether a request may be sent to the backend. When it may
+// not, it also returns how long until the breaker lets a probe through.
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerThreshold {
+		return true, 0
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return false, wait
+	}
+	// Cooldown elapsed: let this request probe, and hold others off for
+	// another cooldown in case it fails too.
+	b.openUntil = time.Now().Add(breakerCooldown)
+	return true, 0
+}
+
+// success closes the breaker after the backend accepted a connection.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.mu.Unlock()
+}
+
+// failure records a dial failure and reports whether it tripped the breaker.
+func (b *circuitBreaker) failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures == breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+package tunnel
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheStatusHeader reports whether a response was served from the cache.
+const cacheStatusHeader = "X-Lt-Cache"
+
+// ResponseCache is an in-memory cache of responses from the local server,
+// keyed by method and URL. It follows the backend's Cache-Control and
+// validators (ETag, Last-Modified): fresh entries are served without
+// contacting the backend, stale ones are revalidated with a conditional
+// request. Entries are evicted least recently used first once the total
+// size exceeds the cap. A ResponseCache is safe for concurrent use.
+type ResponseCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	lru      *list.List // front is most recently used
+
+	now func() time.Time
+}
+
+// cacheEntry is one stored response.
+type cacheEntry struct {
+	key    string
+	status int
+	header http.Header
+	body   []byte
+	stored time.Time
+	maxAge time.Duration
+}
+
+// NewResponseCache returns a cache holding at most maxBytes of responses.
+func NewResponseCache(maxBytes int64) *ResponseCache {
+	return &ResponseCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// maxEntrySize is the largest body stored, so one response can't flush
+// the whole cache.
+func (c *ResponseCache) maxEntrySize() int64 {
+	return c.maxBytes / 4
+}
+
+func cacheKey(req *http.Request) string {
+	// Responses may differ by encoding even when Vary is missing.
+	return req.Method + " " + req.URL.RequestURI() + " " + req.Header.Get("Accept-Encoding")
+}
+
+// lookup returns a cached response for req if a fresh one exists. When a
+// stale entry can be revalidated, lookup adds conditional headers to req
+// and returns the entry for update to use. credentialed reports whether
+// the visitor sent credentials; see hasCredentials.
+func (c *ResponseCache) lookup(req *http.Request, credentialed bool) (*http.Response, *cacheEntry) {
+	if c == nil || req.Method != http.MethodGet {
+		return nil, nil
+	}
+	reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+	if _, ok := reqCC["no-store"]; ok {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	el, ok := c.entries[cacheKey(req)]
+	if !ok {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	e := el.Value.(*cacheEntry)
+	if credentialed && !sharedWithCredentials(e.header) {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	c.lru.MoveToFront(el)
+	age, maxAge := c.now().Sub(e.stored), e.maxAge
+	c.mu.Unlock()
+
+	_, noCache := reqCC["no-cache"]
+	if !noCache && age < maxAge {
+		return e.response(req, age), nil
+	}
+
+	// Leave the client's own conditional request alone: a 304 for it must
+	// reach the client rather than be swapped for the cached body.
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return nil, nil
+	}
+	etag, lastMod := e.header.Get("ETag"), e.header.Get("Last-Modified")
+	if etag == "" && lastMod == "" {
+		return nil, nil
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastMod != "" {
+		req.Header.Set("If-Modified-Since", lastMod)
+	}
+	return nil, e
+}
+
+// update records resp, the backend's answer to req, and returns the
+// response to send to the client. revalidated is the entry lookup asked
+// the backend to confirm, if any, and credentialed is as for lookup.
+func (c *ResponseCache) update(req *http.Request, resp *http.Response, revalidated *cacheEntry, credentialed bool) *http.Response {
+	if c == nil {
+		return resp
+	}
+
+	if revalidated != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		c.mu.Lock()
+		revalidated.stored = c.now()
+		if maxAge, ok := freshness(resp.Header); ok {
+			revalidated.maxAge = maxAge
+		}
+		c.mu.Unlock()
+		return revalidated.response(req, 0)
+	}
+
+	resp.Header.Set(cacheStatusHeader, "MISS")
+	maxAge, ok := c.storable(req, resp, credentialed)
+	if !ok {
+		return resp
+	}
+
+	// Buffer the body, giving up (but still forwarding everything) if it
+	// turns out larger than an entry may be.
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, c.maxEntrySize()+1))
+	if err != nil || n > c.maxEntrySize() {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&buf, resp.Body), resp.Body}
+		return resp
+	}
+	resp.Body.Close()
+	body := buf.Bytes()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+
+	header := resp.Header.Clone()
+	header.Del(cacheStatusHeader)
+	c.add(&cacheEntry{
+		key:    cacheKey(req),
+		status: resp.StatusCode,
+		header: header,
+		body:   body,
+		stored: c.now(),
+		maxAge: maxAge,
+	})
+	return resp
+}
+
+// hasCredentials reports whether req identifies a visitor, with an
+// Authorization header or cookies. It must see the request as the visitor
+// sent it, before header rules or --local-basic-auth add their own.
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+// sharedWithCredentials reports whether a response with header h may be
+// shared with every visitor even though it answered a request with
+// credentials: the backend said so with public or s-maxage.
+func sharedWithCredentials(h http.Header) bool {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	return public || sMaxAge
+}
+
+// storable reports whether resp may be cached and for how long it is fresh.
+func (c *ResponseCache) storable(req *http.Request, resp *http.Response, credentialed bool) (time.Duration, bool) {
+	if req.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	if resp.ContentLength > c.maxEntrySize() {
+		return 0, false
+	}
+	if _, ok := parseCacheControl(req.Header.Get("Cache-Control"))["no-store"]; ok {
+		return 0, false
+	}
+	cc := parseCacheControl(resp.Header.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "private"} {
+		if _, ok := cc[d]; ok {
+			return 0, false
+		}
+	}
+	// A response to a logged-in visitor may be theirs alone.
+	if credentialed && !sharedWithCredentials(resp.Header) {
+		return 0, false
+	}
+	// Responses that set cookies or vary on request headers other than
+	// the encoding are specific to one visitor.
+	if resp.Header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if !strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return 0, false
+			}
+		}
+	}
+
+	maxAge, ok := freshness(resp.Header)
+	if !ok && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		// Nothing says how long it is fresh and it can't be revalidated.
+		return 0, false
+	}
+	return maxAge, true
+}
+
+// freshness returns the lifetime Cache-Control gives a response. no-cache
+// means it must be revalidated on every use.
+func freshness(h http.Header) (time.Duration, bool) {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if _, ok := cc["no-cache"]; ok {
+		return 0, true
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs < 0 {
+				return 0, true
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// parseCacheControl splits a Cache-Control value into lowercase directives
+// and their (unquoted) arguments.
+func parseCacheControl(v string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return cc
+}
+
+// add stores e, replacing any entry with the same key, and evicts least
+// recently used entries until the cache fits.
+func (c *ResponseCache) add(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size()
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops el. c.mu must be held.
+func (c *ResponseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+}
+
+// size approximates the memory an entry holds.
+func (e *cacheEntry) size() int64 {
+	n := int64(len(e.body) + len(e.key))
+	for k, vs := range e.header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+// response builds a response to req from the entry.
+func (e *cacheEntry) response(req *http.Request, age time.Duration) *http.Response {
+	resp := &http.Response{
+		StatusCode:    e.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+	resp.Header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	resp.Header.Set(cacheStatusHeader, "HIT")
+	return resp
+}
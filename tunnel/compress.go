@@ -0,0 +1,167 @@
+package tunnel
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressMinSize is the smallest response, when its length is known, that
+// is worth compressing; below it the gzip framing eats most of the gain.
+const compressMinSize = 1024
+
+// compressResponse replaces resp's body with a gzip or deflate encoding of
+// it when the client accepts one and the local server did not encode the
+// response itself. flush makes every chunk read from the local server
+// reach the client immediately, for streaming responses.
+func compressResponse(req *http.Request, resp *http.Response, flush bool) {
+	if req.Method == http.MethodHead || resp.StatusCode < 200 ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified ||
+		resp.StatusCode == http.StatusPartialContent {
+		return
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Range") != "" {
+		return
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < compressMinSize {
+		return
+	}
+	if !compressible(resp.Header.Get("Content-Type")) {
+		return
+	}
+	encoding := acceptedEncoding(req.Header.Values("Accept-Encoding"))
+	if encoding == "" {
+		return
+	}
+
+	pr, pw := io.Pipe()
+	src := resp.Body
+	go func() {
+		var zw interface {
+			io.WriteCloser
+			Flush() error
+		}
+		if encoding == "gzip" {
+			zw = gzip.NewWriter(pw)
+		} else {
+			zw = zlib.NewWriter(pw)
+		}
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				if _, werr := zw.Write(buf[:n]); werr != nil {
+					pw.CloseWithError(werr)
+					return
+				}
+				if flush {
+					if werr := zw.Flush(); werr != nil {
+						pw.CloseWithError(werr)
+						return
+					}
+				}
+			}
+			if err == io.EOF {
+				pw.CloseWithError(zw.Close())
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	resp.Body = &compressedBody{PipeReader: pr, src: src}
+
+	resp.Header.Set("Content-Encoding", encoding)
+	resp.Header.Del("Content-Length")
+	resp.Header.Add("Vary", "Accept-Encoding")
+	// The encoded body differs from the one a strong ETag was issued for.
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	resp.ContentLength = -1
+	resp.TransferEncoding = []string{"chunked"}
+}
+
+// compressedBody is the reading end of a compressing pipe. Closing it also
+// closes the local server's response body, which stops the compressor.
+type compressedBody struct {
+	*io.PipeReader
+	src io.Closer
+}
+
+func (b *compressedBody) Close() error {
+	b.PipeReader.Close()
+	return b.src.Close()
+}
+
+// acceptedEncoding returns the encoding to use for a request with the given
+// Accept-Encoding header values: "gzip" or "deflate" if acceptable, gzip
+// preferred, or "" for neither.
+func acceptedEncoding(values []string) string {
+	var gzipOK, deflateOK bool
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			ok := true
+			if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+				f, err := strconv.ParseFloat(q, 64)
+				ok = err == nil && f > 0
+			}
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "gzip", "x-gzip":
+				gzipOK = ok
+			case "deflate":
+				deflateOK = ok
+			}
+		}
+	}
+	switch {
+	case gzipOK:
+		return "gzip"
+	case deflateOK:
+		return "deflate"
+	}
+	return ""
+}
+
+// incompressibleTypes are media types whose content is already compressed.
+var incompressibleTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-7z-compressed":  true,
+	"application/x-bzip2":          true,
+	"application/x-rar-compressed": true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/pdf":              true,
+	"application/octet-stream":     true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+// compressible reports whether a response of the given Content-Type is
+// worth compressing. Images, audio, and video are already compressed,
+// except for SVG.
+func compressible(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if mt == "image/svg+xml" {
+		return true
+	}
+	if major, _, _ := strings.Cut(mt, "/"); major == "image" || major == "audio" || major == "video" {
+		return false
+	}
+	return !incompressibleTypes[mt]
+}
@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorPageData is the data an error page template is executed with.
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	Message    string
+	Hint       string
+	TunnelID   string
+}
+
+// defaultErrorPage is the page served to visitors when the tunnel cannot
+// reach the local application.
+var defaultErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status}} {{.StatusText}}</title>
+<style>
+  body { margin: 0; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+         background: #0f172a; color: #e2e8f0; display: flex; min-height: 100vh; align-items: center; justify-content: center; }
+  main { max-width: 36rem; padding: 2rem; }
+  h1 { font-size: 1.5rem; margin: 0 0 .5rem; }
+  .status { color: #f87171; font-weight: 600; letter-spacing: .05em; }
+  p { line-height: 1.5; color: #cbd5e1; }
+  code { color: #e2e8f0; }
+  footer { margin-top: 2rem; font-size: .8rem; color: #64748b; }
+</style>
+</head>
+<body>
+<main>
+  <div class="status">{{.Status}}</div>
+  <h1>{{.StatusText}}</h1>
+  <p>{{.Message}}</p>
+  {{if .Hint}}<p>{{.Hint}}</p>{{end}}
+  <footer>Served by LaunchTunnel{{if .TunnelID}} &middot; tunnel <code>{{.TunnelID}}</code>{{end}}</footer>
+</main>
+</body>
+</html>
+`))
+
+// ParseErrorPage parses a custom error page. The page is an html/template
+// executed with ErrorPageData, so it may reference {{.Status}},
+// {{.StatusText}}, {{.Message}}, {{.Hint}}, and {{.TunnelID}}.
+func ParseErrorPage(src string) (*template.Template, error) {
+	t, err := template.New("error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("parsing error page: %w", err)
+	}
+	return t, nil
+}
+
+// writeErrorResponse writes a synthetic error response to w with a correct
+// Content-Length. Clients that ask for JSON get an API-style error envelope;
+// everyone else gets the HTML error page. Extra headers (e.g. Retry-After)
+// are copied in.
+func writeErrorResponse(w io.Writer, req *http.Request, opts *Options, status int, message, hint string, extra http.Header) error {
+	data := ErrorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    message,
+		Hint:       hint,
+		TunnelID:   opts.TunnelID,
+	}
+
+	var (
+		body        []byte
+		contentType string
+	)
+	if wantsJSON(req) {
+		env := map[string]any{
+			"error": map[string]string{
+				"code":    strings.ToUpper(strings.ReplaceAll(data.StatusText, " ", "_")),
+				"message": message,
+			},
+		}
+		if opts.TunnelID != "" {
+			env["tunnel_id"] = opts.TunnelID
+		}
+		body, _ = json.Marshal(env)
+		contentType = "application/json"
+	} else {
+		page := opts.ErrorPage
+		if page == nil {
+			page = defaultErrorPage
+		}
+		var buf bytes.Buffer
+		if err := page.Execute(&buf, data); err != nil {
+			// A broken custom template must not leave the visitor empty-handed.
+			buf.Reset()
+			_ = defaultErrorPage.Execute(&buf, data)
+		}
+		body = buf.Bytes()
+		contentType = "text/html; charset=utf-8"
+	}
+
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	for k, v := range extra {
+		resp.Header[k] = v
+	}
+	if req != nil {
+		if id := req.Header.Get(RequestIDHeader); id != "" {
+			resp.Header.Set(RequestIDHeader, id)
+		}
+	}
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Set("Cache-Control", "no-store")
+	return resp.Write(w)
+}
+
+// wantsJSON reports whether the client prefers a JSON error body: it
+// accepts application/json and does not also ask for HTML.
+func wantsJSON(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	accept := req.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
@@ -0,0 +1,138 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Lifecycle event types.
+const (
+	EventTunnelCreated = "tunnel_created"
+	EventConnected     = "connected"
+	EventStreamOpened  = "stream_opened"
+	EventStreamClosed  = "stream_closed"
+	EventRequest       = "request"
+	EventDisconnected  = "disconnected"
+	EventReconnecting  = "reconnecting"
+	EventReconnected   = "reconnected"
+	EventTunnelStopped = "tunnel_stopped"
+)
+
+// Event is a tunnel lifecycle event, for consumption by supervisors and
+// other tools rather than people.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	TunnelID string    `json:"tunnel_id,omitempty"`
+	URL      string    `json:"url,omitempty"`
+	StreamID uint32    `json:"stream_id,omitempty"`
+	Attempt  int       `json:"attempt,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// Set on EventRequest, mirroring an --inspect line.
+	RequestID  string `json:"request_id,omitempty"`
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+// EventSink receives lifecycle events. Implementations must be safe for
+// concurrent use.
+type EventSink interface {
+	Emit(Event)
+}
+
+// Emit sends e to sink, filling in the time. A nil sink discards e.
+func Emit(sink EventSink, e Event) {
+	if sink == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	sink.Emit(e)
+}
+
+// multiSink fans events out to several sinks.
+type multiSink []EventSink
+
+func (m multiSink) Emit(e Event) {
+	for _, s := range m {
+		s.Emit(e)
+	}
+}
+
+// MultiSink returns a sink that forwards to every non-nil sink given, or
+// nil if there are none.
+func MultiSink(sinks ...EventSink) EventSink {
+	var m multiSink
+	for _, s := range sinks {
+		if s != nil {
+			m = append(m, s)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+	return m
+}
+
+// JSONEventSink writes each event as one line of JSON.
+type JSONEventSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONEventSink returns a sink writing JSON lines to w.
+func NewJSONEventSink(w io.Writer) *JSONEventSink {
+	return &JSONEventSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONEventSink) Emit(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(e)
+}
+
+// TextEventSink writes each event as one "key=value" line.
+type TextEventSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextEventSink returns a sink writing text lines to w.
+func NewTextEventSink(w io.Writer) *TextEventSink {
+	return &TextEventSink{w: w}
+}
+
+func (s *TextEventSink) Emit(e Event) {
+	line := fmt.Sprintf("%s event=%s", e.Time.UTC().Format(time.RFC3339Nano), e.Type)
+	if e.TunnelID != "" {
+		line += " tunnel=" + e.TunnelID
+	}
+	if e.URL != "" {
+		line += " url=" + e.URL
+	}
+	if e.StreamID != 0 {
+		line += fmt.Sprintf(" stream=%d", e.StreamID)
+	}
+	if e.Attempt != 0 {
+		line += fmt.Sprintf(" attempt=%d", e.Attempt)
+	}
+	if e.Method != "" {
+		line += fmt.Sprintf(" method=%s path=%q status=%d duration_ms=%d", e.Method, e.Path, e.Status, e.DurationMs)
+	}
+	if e.Error != "" {
+		line += fmt.Sprintf(" error=%q", e.Error)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(s.w, line)
+}
@@ -3,14 +3,22 @@ package tunnel
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"math"
+	"mime"
 	"net"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/carloluisito/launchtunnel-cli/display"
 	"github.com/carloluisito/launchtunnel-cli/protocol"
 )
 
@@ -20,117 +28,489 @@ const localDialTimeout = 5 * time.Second
 // It defaults to os.Stderr but can be replaced for testing.
 var Stderr io.Writer = os.Stderr
 
+// TransportConfig tunes the HTTP transports that carry requests to local
+// servers. The settings are process-wide.
+type TransportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	// ResponseHeaderTimeout bounds the wait for a local server's response
+	// headers; 0 means no limit.
+	ResponseHeaderTimeout time.Duration
+	// MaxTransports caps how many local targets keep a transport; beyond
+	// it the least recently used one is dropped. 0 means no limit.
+	MaxTransports int
+}
+
+// DefaultTransportConfig returns the built-in transport settings.
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		MaxTransports:       64,
+	}
+}
+
 // transportCache pools HTTP transports by target address so connections are
 // reused across requests (avoids a new TCP handshake per asset).
 var (
-	transportMu    sync.Mutex
-	transportCache = make(map[string]*http.Transport)
+	transportMu     sync.Mutex
+	transportCache  = make(map[string]*cachedTransport)
+	transportConfig = DefaultTransportConfig()
 )
 
+// cachedTransport is a transportCache entry.
+type cachedTransport struct {
+	t        *http.Transport
+	lastUsed time.Time
+}
+
+// SetTransportConfig replaces the transport settings. Transports built
+// earlier are discarded, so it is best called before any tunnel runs.
+func SetTransportConfig(c TransportConfig) {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	transportConfig = c
+	dropTransports(func(string, *cachedTransport) bool { return true })
+}
+
+// ResetTransportCache discards the pooled transports and their idle
+// connections, so the next request to each local target connects afresh.
+// Call it when a local server moves or restarts behind the same address.
+// Requests in flight are not affected.
+func ResetTransportCache() {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	dropTransports(func(string, *cachedTransport) bool { return true })
+}
+
+// dropTransports removes the cached transports for which drop reports true
+// and closes their idle connections. transportMu must be held.
+func dropTransports(drop func(target string, ct *cachedTransport) bool) {
+	for target, ct := range transportCache {
+		if drop(target, ct) {
+			ct.t.CloseIdleConnections()
+			delete(transportCache, target)
+		}
+	}
+}
+
 func getTransport(target string) *http.Transport {
 	transportMu.Lock()
 	defer transportMu.Unlock()
-	if t, ok := transportCache[target]; ok {
-		return t
+	now := time.Now()
+	if ct, ok := transportCache[target]; ok {
+		ct.lastUsed = now
+		return ct.t
+	}
+
+	// Make room. A transport unused for longer than IdleConnTimeout holds
+	// no connections worth keeping; past that, the least recently used
+	// goes.
+	if idle := transportConfig.IdleConnTimeout; idle > 0 {
+		dropTransports(func(_ string, ct *cachedTransport) bool { return now.Sub(ct.lastUsed) > idle })
 	}
+	if limit := transportConfig.MaxTransports; limit > 0 {
+		for len(transportCache) >= limit {
+			var oldest string
+			for target, ct := range transportCache {
+				if oldest == "" || ct.lastUsed.Before(transportCache[oldest].lastUsed) {
+					oldest = target
+				}
+			}
+			dropTransports(func(target string, _ *cachedTransport) bool { return target == oldest })
+		}
+	}
+
 	t := &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
-		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:          transportConfig.MaxIdleConns,
+		MaxIdleConnsPerHost:   transportConfig.MaxIdleConnsPerHost,
+		IdleConnTimeout:       transportConfig.IdleConnTimeout,
+		ResponseHeaderTimeout: transportConfig.ResponseHeaderTimeout,
 		DialContext: (&net.Dialer{
 			Timeout: localDialTimeout,
 		}).DialContext,
+		TLSClientConfig: localTLSConfig,
 	}
-	transportCache[target] = t
+	transportCache[target] = &cachedTransport{t: t, lastUsed: now}
 	return t
 }
 
+// Options configures optional forwarder behaviour shared by every stream of
+// a tunnel. A nil *Options is equivalent to the zero value.
+type Options struct {
+	// Inspect logs one line per HTTP request.
+	Inspect bool
+	// InspectHeaders adds request and response headers to inspect output,
+	// with the values of RedactHeaders hidden.
+	InspectHeaders bool
+	// RedactHeaders overrides DefaultRedactHeaders when non-nil.
+	RedactHeaders []string
+
+	// TunnelID is shown on synthetic error pages and labels log records.
+	TunnelID string
+	// ErrorPage replaces the built-in HTML error page (see ParseErrorPage).
+	ErrorPage *template.Template
+
+	// RequestHeaders are applied to each request before it is sent to the
+	// local server; ResponseHeaders to each response before it is returned.
+	RequestHeaders  HeaderRules
+	ResponseHeaders HeaderRules
+
+	// LocalBasicAuth holds "user:pass" credentials sent to the local server
+	// when a request carries no Authorization header of its own. It is
+	// unrelated to the tunnel's public password protection and is never
+	// logged.
+	LocalBasicAuth string
+
+	// MaxResponseSize caps the response body size in bytes; 0 means no
+	// limit.
+	MaxResponseSize int64
+
+	// RateLimit, if set, admits HTTP requests at its rate; the rest are
+	// answered with 429. Bandwidth, if set, paces bytes sent back through
+	// the tunnel. Both are shared by every stream of the tunnel.
+	RateLimit *Limiter
+	Bandwidth *Limiter
+
+	// Cache, if set, serves repeat GET requests from memory.
+	Cache *ResponseCache
+
+	// LocalScheme is how requests are sent to the local server: "http", the
+	// default, or "https" for a server that only speaks TLS. Local
+	// certificates are not verified.
+	LocalScheme string
+
+	// TCPFrameSize is how much ForwardTCP reads from the local connection
+	// at once, and so the largest DATA frame it sends. Bigger frames mean
+	// less per-frame overhead on bulk transfers. 0 means
+	// DefaultTCPFrameSize; it is capped at protocol.MaxPayloadSize.
+	TCPFrameSize int
+
+	// CompressResponses gzips response bodies the local server sent
+	// unencoded when the client accepts gzip (or deflate), saving relay
+	// bandwidth. Already-compressed content types are left alone.
+	CompressResponses bool
+
+	// Events, if set, receives lifecycle events for the tunnel.
+	Events EventSink
+
+	// AllowedLocalHosts lists the non-loopback hosts the forwarder may
+	// connect to (see CheckLocalHost). AllowAnyHost disables the check.
+	AllowedLocalHosts []string
+	AllowAnyHost      bool
+}
+
 // ForwardHTTP reads an HTTP request from the stream, forwards it to the local
 // server using a pooled connection, and writes the response back to the stream.
-func ForwardHTTP(stream *protocol.Stream, localHost string, localPort int, inspect bool, verbose bool) {
+func ForwardHTTP(stream *protocol.Stream, localHost string, localPort int, opts *Options) {
 	defer stream.Close()
+	if opts == nil {
+		opts = &Options{}
+	}
 
-	target := net.JoinHostPort(localHost, fmt.Sprintf("%d", localPort))
+	target := LocalTarget(localHost, localPort)
 
 	req, err := http.ReadRequest(bufio.NewReader(stream))
 	if err != nil {
-		if verbose {
-			fmt.Fprintf(Stderr, "error reading request from stream: %v\n", err)
-		}
+		logger().Debug("reading request from stream", "tunnel", opts.TunnelID, "stream", stream.ID, "err", err)
 		return
 	}
 
+	reqID := ensureRequestID(req)
+
 	// Prepare the request for RoundTrip (needs absolute URL, no RequestURI).
-	req.URL.Scheme = "http"
+	req.URL.Scheme = opts.localScheme()
 	req.URL.Host = target
 	req.RequestURI = ""
 
+	if err := opts.checkLocalHost(localHost); err != nil {
+		fmt.Fprintf(Stderr, "Warning: %v\n", err)
+		_ = writeErrorResponse(stream, req, opts, http.StatusBadGateway,
+			"The tunnel is online, but it is not allowed to forward to its local host.",
+			"Check allowed_local_hosts in the CLI config.", nil)
+		return
+	}
+
+	// Whether the visitor is logged in decides what the cache may share;
+	// the credentials added below are the tunnel's, not the visitor's.
+	credentialed := hasCredentials(req)
+	opts.RequestHeaders.applyRequest(req)
+	if opts.LocalBasicAuth != "" && req.Header.Get("Authorization") == "" {
+		user, pass, _ := strings.Cut(opts.LocalBasicAuth, ":")
+		req.SetBasicAuth(user, pass)
+	}
+
+	if opts.RateLimit != nil {
+		if ok, retryAfter := opts.RateLimit.Allow(); !ok {
+			writeTooManyRequests(stream, req, opts, retryAfter)
+			return
+		}
+	}
+
 	start := time.Now()
 
-	transport := getTransport(target)
-	resp, err := transport.RoundTrip(req)
-	if err != nil {
-		fmt.Fprintf(Stderr, "Warning: Connection to %s refused. Is your application running?\n", target)
-		errResp := &http.Response{
-			StatusCode: http.StatusBadGateway,
-			ProtoMajor: 1,
-			ProtoMinor: 1,
-			Header:     make(http.Header),
-			Body:       http.NoBody,
+	resp, revalidate := opts.Cache.lookup(req, credentialed)
+	if resp == nil {
+		resp = roundTrip(stream, req, opts, target, localPort)
+		if resp == nil {
+			return
 		}
-		errResp.Header.Set("Content-Type", "application/json")
-		_ = errResp.Write(stream)
-		return
+
+		if opts.MaxResponseSize > 0 {
+			if resp.ContentLength > opts.MaxResponseSize {
+				resp.Body.Close()
+				fmt.Fprintf(Stderr, "Warning: %s %s returned %s, over the %s response limit.\n",
+					req.Method, req.URL.Path, display.FormatBytes(resp.ContentLength), display.FormatBytes(opts.MaxResponseSize))
+				_ = writeErrorResponse(stream, req, opts, http.StatusBadGateway,
+					"The application's response is larger than this tunnel allows.",
+					fmt.Sprintf("Responses are limited to %s.", display.FormatBytes(opts.MaxResponseSize)), nil)
+				return
+			}
+			resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: opts.MaxResponseSize}
+		}
+
+		resp = opts.Cache.update(req, resp, revalidate, credentialed)
 	}
 	defer resp.Body.Close()
+	opts.ResponseHeaders.apply(resp.Header)
+	resp.Header.Set(RequestIDHeader, reqID)
+	streaming := isStreaming(resp)
+	if opts.CompressResponses {
+		compressResponse(req, resp, streaming)
+	}
 
 	duration := time.Since(start)
 
-	if inspect {
-		fmt.Fprintf(Stderr, "%s %s %d %s\n",
-			req.Method, req.URL.Path, resp.StatusCode, duration.Truncate(time.Millisecond))
+	if opts.Inspect || opts.InspectHeaders {
+		logExchange(opts, req, resp, duration)
 	}
+	Emit(opts.Events, Event{
+		Type:       EventRequest,
+		TunnelID:   opts.TunnelID,
+		StreamID:   stream.ID,
+		RequestID:  reqID,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Status:     resp.StatusCode,
+		DurationMs: duration.Milliseconds(),
+	})
 
 	// Buffer response writes so all headers + start of body coalesce into
 	// one or two large WebSocket DATA frames instead of many small ones.
-	bw := bufio.NewWriterSize(stream, 65536)
-	if err := resp.Write(bw); err != nil {
-		if verbose {
-			fmt.Fprintf(Stderr, "error writing response to stream: %v\n", err)
+	bw := bufio.NewWriterSize(throttle(stream.WithContext(req.Context()), opts), 65536)
+	var w io.Writer = bw
+	if streaming {
+		// Send each chunk as soon as the local server produces it, so
+		// events and progressive output are not held back until the
+		// handler finishes. The wrapper hides bufio.Writer's ReadFrom,
+		// which must not be reentered by the flush in flushingBody.
+		resp.Body = &flushingBody{ReadCloser: resp.Body, w: bw}
+		w = struct{ io.Writer }{bw}
+	}
+	if err := resp.Write(w); err != nil {
+		if errors.Is(err, errResponseTooLarge) {
+			// Headers are already on their way, so the best we can do is
+			// cut the body short. Reset the stream rather than closing it:
+			// a response that ends when the connection closes would
+			// otherwise look complete to the client.
+			fmt.Fprintf(Stderr, "Warning: %s %s exceeded the %s response limit; truncated.\n",
+				req.Method, req.URL.Path, display.FormatBytes(opts.MaxResponseSize))
+			_ = bw.Flush()
+			stream.Reset(protocol.ResetRefused)
+			return
 		}
+		logger().Debug("writing response to stream", "tunnel", opts.TunnelID, "stream", stream.ID, "request_id", reqID, "err", err)
 		return
 	}
 	if err := bw.Flush(); err != nil {
-		if verbose {
-			fmt.Fprintf(Stderr, "error flushing response to stream: %v\n", err)
+		logger().Debug("flushing response to stream", "tunnel", opts.TunnelID, "stream", stream.ID, "request_id", reqID, "err", err)
+	}
+}
+
+// roundTrip sends req to the local server. If that fails, it writes an
+// error response to w and returns nil.
+func roundTrip(w io.Writer, req *http.Request, opts *Options, target string, localPort int) *http.Response {
+	// Short-circuit while the backend is known to be down instead of
+	// re-dialing (and timing out) on every request.
+	breaker := getBreaker(target)
+	if ok, retryAfter := breaker.allow(); !ok {
+		writeUnavailable(w, req, opts, retryAfter)
+		return nil
+	}
+
+	transport := getTransport(target)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		if isDialError(err) {
+			if breaker.failure() {
+				fmt.Fprintf(Stderr, "Warning: %s is unreachable; answering 503 for %s before retrying.\n", target, breakerCooldown)
+				writeUnavailable(w, req, opts, breakerCooldown)
+				return nil
+			}
+		}
+		if msg := schemeMismatch(req.URL.Scheme, target, err); msg != "" {
+			breaker.success()
+			warnScheme(target, msg)
+			_ = writeErrorResponse(w, req, opts, http.StatusBadGateway,
+				"The tunnel is online, but the application it forwards to speaks a different protocol.", msg, nil)
+			return nil
+		}
+		fmt.Fprintf(Stderr, "Warning: Connection to %s refused. Is your application running?\n", target)
+		_ = writeErrorResponse(w, req, opts, http.StatusBadGateway,
+			"The tunnel is online, but it could not reach the application it forwards to.",
+			fmt.Sprintf("Is your app running on port %d?", localPort), nil)
+		return nil
+	}
+	breaker.success()
+	if req.URL.Scheme == "http" && plainToTLSPort(resp) {
+		warnScheme(target, httpsOnly(target))
+	}
+	return resp
+}
+
+// localScheme returns the scheme for requests to the local server.
+func (o *Options) localScheme() string {
+	if o.LocalScheme == "" {
+		return "http"
+	}
+	return o.LocalScheme
+}
+
+// isStreaming reports whether resp's body may arrive over time rather than
+// all at once: server-sent events, chunked responses, and any response
+// without a known length.
+func isStreaming(resp *http.Response) bool {
+	if resp.ContentLength < 0 || slices.Contains(resp.TransferEncoding, "chunked") {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// flushingBody flushes w before every read of the body, so whatever was
+// written for the previous read goes out before waiting on the local
+// server for more. Trailers are still written by resp.Write after the
+// body ends.
+type flushingBody struct {
+	io.ReadCloser
+	w *bufio.Writer
+}
+
+func (b *flushingBody) Read(p []byte) (int, error) {
+	if b.w.Buffered() > 0 {
+		if err := b.w.Flush(); err != nil {
+			return 0, err
 		}
 	}
+	return b.ReadCloser.Read(p)
+}
+
+// errResponseTooLarge is returned by limitedBody once the limit is passed.
+var errResponseTooLarge = errors.New("tunnel: response exceeds size limit")
+
+// limitedBody fails reads once more than remaining bytes have been read.
+// Unlike io.LimitReader it reports overflow as an error instead of EOF, so
+// an oversized body is never mistaken for a complete one.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	// Read one byte past the limit to tell "exactly at" from "over".
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		n += int(b.remaining)
+		return n, errResponseTooLarge
+	}
+	return n, err
+}
+
+// writeUnavailable answers with a 503 page while the circuit breaker is open.
+func writeUnavailable(w io.Writer, req *http.Request, opts *Options, retryAfter time.Duration) {
+	extra := http.Header{}
+	extra.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	_ = writeErrorResponse(w, req, opts, http.StatusServiceUnavailable,
+		"The application behind this tunnel is not responding. Please try again shortly.", "", extra)
+}
+
+// writeTooManyRequests answers with a 429 when the tunnel's rate limit is
+// exceeded.
+func writeTooManyRequests(w io.Writer, req *http.Request, opts *Options, retryAfter time.Duration) {
+	extra := http.Header{}
+	extra.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	_ = writeErrorResponse(w, req, opts, http.StatusTooManyRequests,
+		"This tunnel is receiving more requests than it allows. Please slow down.", "", extra)
+}
+
+// isDialError reports whether err came from failing to connect to the
+// backend, as opposed to a failure mid-request.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// DefaultTCPFrameSize is the TCP read size, and so DATA frame size, used
+// when Options.TCPFrameSize is 0.
+const DefaultTCPFrameSize = 256 * 1024
+
+// tcpFrameSize returns the read size for ForwardTCP.
+func (o *Options) tcpFrameSize() int {
+	if o.TCPFrameSize <= 0 {
+		return DefaultTCPFrameSize
+	}
+	return min(o.TCPFrameSize, protocol.MaxPayloadSize)
 }
 
 // ForwardTCP performs bidirectional byte copying between the stream and the
 // local TCP server.
-func ForwardTCP(stream *protocol.Stream, localHost string, localPort int, verbose bool) {
+func ForwardTCP(stream *protocol.Stream, localHost string, localPort int, opts *Options) {
 	defer stream.Close()
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if err := opts.checkLocalHost(localHost); err != nil {
+		fmt.Fprintf(Stderr, "Warning: %v\n", err)
+		return
+	}
 
-	target := net.JoinHostPort(localHost, fmt.Sprintf("%d", localPort))
+	target := LocalTarget(localHost, localPort)
 
 	conn, err := net.DialTimeout("tcp", target, localDialTimeout)
 	if err != nil {
 		fmt.Fprintf(Stderr, "Warning: Connection to %s refused. Is your application running?\n", target)
+		// TCP has no error page, so tell the relay why the visitor's
+		// connection is being dropped.
+		stream.Reset(protocol.ResetConnectRefused)
 		return
 	}
 	defer conn.Close()
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Each direction stops as soon as the other one ends.
 	go func() {
 		defer cancel()
-		_, _ = io.Copy(stream, conn)
+		// Hide the fast paths so io.CopyBuffer reads into buf, whose
+		// size sets the frame size.
+		buf := make([]byte, opts.tcpFrameSize())
+		dst := struct{ io.Writer }{throttle(stream.WithContext(ctx), opts)}
+		_, _ = io.CopyBuffer(dst, struct{ io.Reader }{conn}, buf)
 	}()
 
 	go func() {
 		defer cancel()
-		_, _ = io.Copy(conn, stream)
+		_, _ = io.Copy(conn, stream.WithContext(ctx))
 	}()
 
 	<-ctx.Done()
@@ -0,0 +1,177 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/protocol"
+)
+
+// Handler serves the streams of a tunnel. Handle is called in its own
+// goroutine for each stream the relay opens and owns the stream until it
+// returns; the stream is closed afterwards if Handle has not done so.
+type Handler interface {
+	Handle(stream *protocol.Stream)
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(stream *protocol.Stream)
+
+// Handle calls f(stream).
+func (f HandlerFunc) Handle(stream *protocol.Stream) {
+	f(stream)
+}
+
+// HTTPForwarder returns the Handler that forwards streams as HTTP to
+// localHost:localPort, as ForwardHTTP does.
+func HTTPForwarder(localHost string, localPort int, opts *Options) Handler {
+	return HandlerFunc(func(stream *protocol.Stream) {
+		ForwardHTTP(stream, localHost, localPort, opts)
+	})
+}
+
+// TCPForwarder returns the Handler that copies streams to and from
+// localHost:localPort, as ForwardTCP does.
+func TCPForwarder(localHost string, localPort int, opts *Options) Handler {
+	return HandlerFunc(func(stream *protocol.Stream) {
+		ForwardTCP(stream, localHost, localPort, opts)
+	})
+}
+
+// Forwarder returns the built-in Handler for proto, "http" or "tcp", or
+// nil for anything else.
+func Forwarder(proto, localHost string, localPort int, opts *Options) Handler {
+	switch proto {
+	case "http":
+		return HTTPForwarder(localHost, localPort, opts)
+	case "tcp":
+		return TCPForwarder(localHost, localPort, opts)
+	}
+	return nil
+}
+
+// HTTPHandler returns a Handler that serves each stream with h, as
+// ServeHandler does.
+func HTTPHandler(h http.Handler) Handler {
+	return HandlerFunc(func(stream *protocol.Stream) {
+		ServeHandler(stream, h)
+	})
+}
+
+// ServeHandler serves the HTTP requests read from stream with h, in
+// process and without a local listener, until the stream or the
+// connection is closed. It is handy for exposing a Go web app under test.
+//
+// The stream is served by an http.Server of its own, so keep-alive,
+// request bodies, and streaming responses (via http.Flusher) behave as
+// they would over TCP. Request.RemoteAddr is "stream/<id>".
+func ServeHandler(stream *protocol.Stream, h http.Handler) {
+	ln := &streamListener{conns: make(chan net.Conn, 1), closed: make(chan struct{})}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 30 * time.Second}
+	c := &streamConn{stream: stream, done: make(chan struct{})}
+	ln.conns <- c
+	go srv.Serve(ln)
+	<-c.done
+	ln.Close()
+}
+
+// streamListener yields the connections queued on conns until closed.
+type streamListener struct {
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *streamListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *streamListener) Addr() net.Addr { return streamAddr(0) }
+
+// streamAddr is the net.Addr of a stream, identified by its ID.
+type streamAddr uint32
+
+func (a streamAddr) Network() string { return "lt" }
+
+func (a streamAddr) String() string { return "stream/" + strconv.FormatUint(uint64(a), 10) }
+
+// streamConn adapts a Stream to net.Conn for http.Server. Read deadlines
+// are honoured, since the server relies on them to abort its background
+// read after each request; write deadlines are ignored.
+type streamConn struct {
+	stream *protocol.Stream
+
+	mu       sync.Mutex
+	deadline time.Time
+	cancel   context.CancelFunc // cancels the read in progress, if any
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	d := c.deadline
+	if !d.IsZero() && !time.Now().Before(d) {
+		c.mu.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if !d.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, d)
+	}
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	n, err := c.stream.ReadContext(ctx, p)
+	cancel()
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return n, os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	return c.stream.Write(p)
+}
+
+func (c *streamConn) Close() error {
+	err := c.stream.Close()
+	c.closeOnce.Do(func() { close(c.done) })
+	return err
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return streamAddr(0) }
+func (c *streamConn) RemoteAddr() net.Addr { return streamAddr(c.stream.ID) }
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	if c.cancel != nil && !t.IsZero() && !t.After(time.Now()) {
+		c.cancel()
+	}
+	return nil
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// HAR is the subset of the HTTP Archive 1.2 format needed to replay
+// recorded requests.
+type HAR struct {
+	Log struct {
+		Entries []HAREntry `json:"entries"`
+	} `json:"log"`
+}
+
+// HAREntry is one recorded request/response pair.
+type HAREntry struct {
+	StartedDateTime string     `json:"startedDateTime"`
+	Request         HARRequest `json:"request"`
+	Response        struct {
+		Status int `json:"status"`
+	} `json:"response"`
+}
+
+// HARRequest is a recorded request.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+}
+
+// HARNameValue is a header name and value.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData is a recorded request body. Encoding is a common extension
+// set to "base64" for binary bodies.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// ReadHAR reads a HAR file from path.
+func ReadHAR(path string) (*HAR, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var h HAR
+	if err := json.NewDecoder(f).Decode(&h); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &h, nil
+}
+
+// replaySkipHeaders are recorded headers that describe the original
+// connection rather than the request, and are recomputed on replay.
+var replaySkipHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// NewRequest rebuilds the recorded request, addressed to the local app at
+// localHost:localPort. The original Host header is kept, as it would be
+// when the request arrives through the tunnel.
+func (r *HARRequest) NewRequest(localHost string, localPort int) (*http.Request, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request URL %q: %w", r.URL, err)
+	}
+	host := u.Host
+
+	var body io.Reader
+	if r.PostData != nil && r.PostData.Text != "" {
+		text := r.PostData.Text
+		if r.PostData.Encoding == "base64" {
+			b, err := base64.StdEncoding.DecodeString(text)
+			if err != nil {
+				return nil, fmt.Errorf("decoding request body: %w", err)
+			}
+			text = string(b)
+		}
+		body = strings.NewReader(text)
+	}
+
+	u.Scheme = "http"
+	u.Host = LocalTarget(localHost, localPort)
+	req, err := http.NewRequest(r.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range r.Headers {
+		// HTTP/2 captures record pseudo-headers such as ":authority".
+		if strings.HasPrefix(h.Name, ":") {
+			if h.Name == ":authority" {
+				host = h.Value
+			}
+			continue
+		}
+		name := http.CanonicalHeaderKey(h.Name)
+		if name == "Host" {
+			host = h.Value
+		}
+		if replaySkipHeaders[name] {
+			continue
+		}
+		req.Header.Add(name, h.Value)
+	}
+	if r.PostData != nil && r.PostData.MimeType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", r.PostData.MimeType)
+	}
+	req.Host = host
+	return req, nil
+}
+
+// Replay sends req to the local app using the forwarder's transport. The
+// caller must close the response body.
+func Replay(req *http.Request) (*http.Response, error) {
+	return getTransport(req.URL.Host).RoundTrip(req)
+}
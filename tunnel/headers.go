@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// HeaderRules describes header edits applied to each forwarded request or
+// response. Removals run before sets, so a header can be replaced outright.
+type HeaderRules struct {
+	Set    http.Header
+	Remove []string
+}
+
+// apply edits h in place.
+func (r HeaderRules) apply(h http.Header) {
+	for _, k := range r.Remove {
+		h.Del(k)
+	}
+	for k, vs := range r.Set {
+		h[k] = append([]string(nil), vs...)
+	}
+}
+
+// applyRequest edits req's headers. Host is carried in req.Host rather than
+// the header map, so it is handled separately.
+func (r HeaderRules) applyRequest(req *http.Request) {
+	r.apply(req.Header)
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+		req.Header.Del("Host")
+	}
+}
+
+// ParseHeader parses a "Key: Value" header spec as given on the command
+// line. The key is canonicalized; the value may be empty.
+func ParseHeader(spec string) (key, value string, err error) {
+	k, v, ok := strings.Cut(spec, ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid header %q: expected \"Key: Value\"", spec)
+	}
+	k = strings.TrimSpace(k)
+	if err := validateHeaderName(k); err != nil {
+		return "", "", fmt.Errorf("invalid header %q: %w", spec, err)
+	}
+	v = strings.TrimSpace(v)
+	if strings.ContainsAny(v, "\r\n\x00") {
+		return "", "", fmt.Errorf("invalid header %q: value contains control characters", spec)
+	}
+	return textproto.CanonicalMIMEHeaderKey(k), v, nil
+}
+
+// ParseHeaderName validates a bare header name, as used by the remove flags.
+func ParseHeaderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if err := validateHeaderName(name); err != nil {
+		return "", fmt.Errorf("invalid header name %q: %w", name, err)
+	}
+	return textproto.CanonicalMIMEHeaderKey(name), nil
+}
+
+// validateHeaderName checks that name is a non-empty RFC 7230 token.
+func validateHeaderName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty header name")
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return fmt.Errorf("header name contains %q", c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"time"
+)
+
+// redactedValue replaces the value of sensitive headers in inspect output.
+const redactedValue = "***"
+
+// DefaultRedactHeaders are the headers whose values are always hidden in
+// inspect output.
+var DefaultRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Csrf-Token",
+}
+
+// redactHeaders returns a copy of h with the values of sensitive headers
+// replaced. Anything that writes headers for display must go through it.
+func (o *Options) redactHeaders(h http.Header) http.Header {
+	names := o.RedactHeaders
+	if names == nil {
+		names = DefaultRedactHeaders
+	}
+	out := h.Clone()
+	if out == nil {
+		out = make(http.Header)
+	}
+	for _, name := range names {
+		k := textproto.CanonicalMIMEHeaderKey(name)
+		if vs, ok := out[k]; ok {
+			redacted := make([]string, len(vs))
+			for i := range redacted {
+				redacted[i] = redactedValue
+			}
+			out[k] = redacted
+		}
+	}
+	return out
+}
+
+// logExchange writes the inspect line for one request, followed by its
+// headers when InspectHeaders is set. The entry is written in one call so
+// concurrent streams don't interleave.
+func logExchange(opts *Options, req *http.Request, resp *http.Response, duration time.Duration) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %d %s",
+		req.Method, req.URL.Path, resp.StatusCode, duration.Truncate(time.Millisecond))
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		fmt.Fprintf(&buf, " [%s]", id)
+	}
+	buf.WriteByte('\n')
+	if opts.InspectHeaders {
+		if req.Host != "" {
+			fmt.Fprintf(&buf, "  > Host: %s\n", req.Host)
+		}
+		writeHeaderLines(&buf, "  > ", opts.redactHeaders(req.Header))
+		writeHeaderLines(&buf, "  < ", opts.redactHeaders(resp.Header))
+	}
+	_, _ = Stderr.Write(buf.Bytes())
+}
+
+// writeHeaderLines writes h sorted by name, one value per line.
+func writeHeaderLines(buf *bytes.Buffer, prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(buf, "%s%s: %s\n", prefix, k, v)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket: it holds up to burst tokens and refills at rate
+// tokens per second. The forwarder uses one for requests per second and one
+// for bytes per second. A Limiter is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// now and sleep are replaced in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewLimiter returns a Limiter that starts full.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Burst returns the bucket size.
+func (l *Limiter) Burst() int {
+	return int(l.burst)
+}
+
+// refill adds the tokens accrued since the last call. l.mu must be held.
+func (l *Limiter) refill() time.Time {
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	return now
+}
+
+// Allow takes one token if available. When none is, it returns false and
+// how long until one will be, like circuitBreaker.allow.
+func (l *Limiter) Allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, l.delay(1 - l.tokens)
+}
+
+// WaitN takes n tokens, sleeping until they have accrued. n should not
+// exceed Burst. Concurrent callers are served in the order they arrive.
+func (l *Limiter) WaitN(n int) {
+	l.mu.Lock()
+	l.refill()
+	// Take the tokens now, possibly going into debt; later callers then
+	// wait behind this one.
+	l.tokens -= float64(n)
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = l.delay(-l.tokens)
+	}
+	l.mu.Unlock()
+	if wait > 0 {
+		l.sleep(wait)
+	}
+}
+
+// delay returns how long it takes to accrue tokens. l.mu must be held.
+func (l *Limiter) delay(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// throttledWriter paces writes to w through a bytes-per-second Limiter.
+type throttledWriter struct {
+	w io.Writer
+	l *Limiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), t.l.Burst())
+		t.l.WaitN(n)
+		m, err := t.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttle wraps w in opts.Bandwidth, if set.
+func throttle(w io.Writer, opts *Options) io.Writer {
+	if opts.Bandwidth == nil {
+		return w
+	}
+	return &throttledWriter{w: w, l: opts.Bandwidth}
+}
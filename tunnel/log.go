@@ -0,0 +1,27 @@
+package tunnel
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// pkgLogger receives the forwarder's and sessions' diagnostics, such as
+// stream errors and reconnection attempts. Nothing is logged until
+// SetLogger is called.
+var pkgLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger for the package's diagnostics. nil discards
+// them, the default.
+func SetLogger(l *slog.Logger) {
+	pkgLogger.Store(l)
+}
+
+// logger returns the logger set with SetLogger, or one that discards.
+func logger() *slog.Logger {
+	if l := pkgLogger.Load(); l != nil {
+		return l
+	}
+	return discardLogger
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Metrics aggregates per-tunnel counters and serves them in the Prometheus
+// text exposition format. It is an EventSink, so it is fed the same
+// lifecycle events as --log-file; byte counts come from AddBytes.
+type Metrics struct {
+	mu      sync.Mutex
+	tunnels map[string]*TunnelStats
+}
+
+// TunnelStats holds one tunnel's counters.
+type TunnelStats struct {
+	ActiveStreams int64
+	Requests      int64
+	BytesIn       int64
+	BytesOut      int64
+	Reconnects    int64
+	Connected     bool
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{tunnels: make(map[string]*TunnelStats)}
+}
+
+// get returns the counters for id, creating them. m.mu must be held.
+func (m *Metrics) get(id string) *TunnelStats {
+	t, ok := m.tunnels[id]
+	if !ok {
+		t = &TunnelStats{}
+		m.tunnels[id] = t
+	}
+	return t
+}
+
+// Emit updates counters from a lifecycle event.
+func (m *Metrics) Emit(e Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.get(e.TunnelID)
+	switch e.Type {
+	case EventConnected:
+		t.Connected = true
+	case EventDisconnected, EventTunnelStopped:
+		t.Connected = false
+	case EventReconnected:
+		t.Reconnects++
+	case EventStreamOpened:
+		t.ActiveStreams++
+		t.Requests++
+	case EventStreamClosed:
+		t.ActiveStreams--
+	}
+}
+
+// AddBytes records n payload bytes received from (in) or sent to (out) the
+// relay for a tunnel.
+func (m *Metrics) AddBytes(tunnelID string, in bool, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.get(tunnelID)
+	if in {
+		t.BytesIn += int64(n)
+	} else {
+		t.BytesOut += int64(n)
+	}
+}
+
+// metricDefs lists the exported series in output order.
+var metricDefs = []struct {
+	name, typ, help string
+	value           func(*TunnelStats) int64
+}{
+	{"lt_active_streams", "gauge", "Streams currently being forwarded.",
+		func(t *TunnelStats) int64 { return t.ActiveStreams }},
+	{"lt_requests_total", "counter", "Streams (HTTP requests or TCP connections) accepted.",
+		func(t *TunnelStats) int64 { return t.Requests }},
+	{"lt_bytes_in_total", "counter", "Payload bytes received from the relay.",
+		func(t *TunnelStats) int64 { return t.BytesIn }},
+	{"lt_bytes_out_total", "counter", "Payload bytes sent to the relay.",
+		func(t *TunnelStats) int64 { return t.BytesOut }},
+	{"lt_reconnects_total", "counter", "Successful reconnections to the relay.",
+		func(t *TunnelStats) int64 { return t.Reconnects }},
+	{"lt_connected", "gauge", "Whether the tunnel is connected to the relay (1) or not (0).",
+		func(t *TunnelStats) int64 {
+			if t.Connected {
+				return 1
+			}
+			return 0
+		}},
+}
+
+// Snapshot returns a copy of every tunnel's counters, keyed by tunnel ID.
+func (m *Metrics) Snapshot() map[string]TunnelStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(map[string]TunnelStats, len(m.tunnels))
+	for id, t := range m.tunnels {
+		snapshot[id] = *t
+	}
+	return snapshot
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot := m.Snapshot()
+	ids := make([]string, 0, len(snapshot))
+	for id := range snapshot {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, def := range metricDefs {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", def.name, def.help, def.name, def.typ)
+		for _, id := range ids {
+			t := snapshot[id]
+			fmt.Fprintf(w, "%s{tunnel_id=%q} %d\n", def.name, id, def.value(&t))
+		}
+	}
+}
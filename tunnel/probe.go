@@ -0,0 +1,55 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrLocalTimeout is returned by WaitForLocal when the local server did not
+// become reachable in time.
+var ErrLocalTimeout = errors.New("tunnel: local server not reachable")
+
+// WaitForLocal polls localHost:localPort with a TCP dial every interval
+// until it accepts a connection, timeout elapses (0 means no timeout), or
+// ctx is done. It prints a single "waiting" notice if the first probe
+// fails.
+func WaitForLocal(ctx context.Context, localHost string, localPort int, interval, timeout time.Duration) error {
+	target := LocalTarget(localHost, localPort)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var d net.Dialer
+	for attempt := 0; ; attempt++ {
+		dialCtx, cancel := context.WithTimeout(ctx, localDialTimeout)
+		conn, err := d.DialContext(dialCtx, "tcp", target)
+		cancel()
+		if err == nil {
+			conn.Close()
+			if attempt > 0 {
+				fmt.Fprintf(Stderr, "Local server %s is up.\n", target)
+			}
+			return nil
+		}
+		if attempt == 0 {
+			fmt.Fprintf(Stderr, "Waiting for local server on %s...\n", target)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%w after %s: %s", ErrLocalTimeout, timeout, target)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
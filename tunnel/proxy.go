@@ -0,0 +1,62 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/proxy"
+)
+
+// RelayHTTPClient returns the HTTP client used to dial the relay WebSocket.
+// proxyURL may be an http://, https://, socks5://, or socks5h:// URL. When
+// it is empty the standard environment variables apply: HTTPS_PROXY and
+// HTTP_PROXY (honoring NO_PROXY), then ALL_PROXY. A non-nil tlsConfig
+// replaces the default TLS settings, e.g. to trust a private CA.
+func RelayHTTPClient(proxyURL string, tlsConfig *tls.Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if proxyURL == "" && getenvAny("HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy") == "" {
+		proxyURL = getenvAny("ALL_PROXY", "all_proxy")
+	}
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", proxyURL)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			transport.Proxy = http.ProxyURL(u)
+		case "socks5", "socks5h":
+			d, err := proxy.FromURL(u, proxy.Direct)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy URL %q: %w", proxyURL, err)
+			}
+			cd, ok := d.(proxy.ContextDialer)
+			if !ok {
+				return nil, fmt.Errorf("invalid proxy URL %q: dialer does not support contexts", proxyURL)
+			}
+			transport.Proxy = nil
+			transport.DialContext = cd.DialContext
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https, or socks5)", u.Scheme)
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// getenvAny returns the first non-empty environment variable among keys.
+func getenvAny(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
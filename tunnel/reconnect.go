@@ -4,7 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -25,56 +26,257 @@ type ReconnectResult struct {
 
 // Reconnect attempts to re-establish a WebSocket connection with exponential
 // backoff. It returns the new connection on success or an error after
-// maxAttempts failures.
-func Reconnect(ctx context.Context, endpoint string, sessionToken string, verbose bool) (*websocket.Conn, error) {
-	out := io.Writer(os.Stderr)
-
-	backoff := initialBackoff
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		if verbose {
-			fmt.Fprintf(out, "Reconnection attempt %d/%d (waiting %s)...\n", attempt, maxAttempts, backoff)
-		} else if attempt == 1 {
+// maxAttempts failures. Each attempt and its outcome are logged and
+// reported to events, which may be nil; tunnelID labels both. A short
+// notice for people watching the terminal goes to status, or nowhere if
+// it is nil. dialOpts are passed to DialRelay.
+func Reconnect(ctx context.Context, endpoint string, sessionToken string, events EventSink, tunnelID string, status io.Writer, dialOpts ...DialOption) (*websocket.Conn, error) {
+	out := status
+	if out == nil {
+		out = io.Discard
+	}
+	log := logger().With("tunnel", tunnelID)
+
+	before := func(attempt int, wait time.Duration) {
+		if attempt == 1 {
 			fmt.Fprintln(out, "Connection lost. Reconnecting...")
 		}
-
-		select {
-		case <-ctx.Done():
+		log.Info("reconnecting", "attempt", attempt, "max_attempts", maxAttempts, "wait", wait)
+		Emit(events, Event{Type: EventReconnecting, TunnelID: tunnelID, Attempt: attempt})
+	}
+	failed := func(attempt int, err error) {
+		log.Info("reconnect attempt failed", "attempt", attempt, "err", err)
+	}
+	conn, attempt, err := dialBackoff(ctx, endpoint, sessionToken, maxAttempts, initialBackoff, before, failed, dialOpts)
+	if err != nil {
+		if ctx.Err() != nil {
 			return nil, ctx.Err()
-		case <-time.After(backoff):
 		}
+		return nil, fmt.Errorf("unable to reconnect after %d attempts", maxAttempts)
+	}
+	fmt.Fprintln(out, "Reconnected successfully.")
+	log.Info("reconnected", "attempt", attempt)
+	Emit(events, Event{Type: EventReconnected, TunnelID: tunnelID, Attempt: attempt})
+	return conn, nil
+}
+
+// initialDialBackoff is the wait before DialRelayRetry's second attempt;
+// it doubles for each one after that.
+const initialDialBackoff = 500 * time.Millisecond
 
-		conn, err := dialRelay(ctx, endpoint, sessionToken)
+// DialRelayRetry is like DialRelay, but makes up to attempts tries, the
+// first straight away and the rest after a short, growing wait, so a
+// relay that is briefly unreachable does not fail a new tunnel. An invalid
+// endpoint is not retried. ctx bounds all attempts together; use
+// WithDialTimeout to bound each one.
+func DialRelayRetry(ctx context.Context, endpoint string, sessionToken string, attempts int, dialOpts ...DialOption) (*websocket.Conn, error) {
+	if _, err := ParseRelayEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	conn, _, err := dialBackoff(ctx, endpoint, sessionToken, max(attempts, 1), 0, nil, nil, dialOpts)
+	if err != nil && attempts > 1 && ctx.Err() == nil {
+		return nil, fmt.Errorf("%w (after %d attempts)", err, attempts)
+	}
+	return conn, err
+}
+
+// dialBackoff dials the relay up to attempts times. It waits first before
+// the first attempt and doubles the wait, up to maxBackoff, for each later
+// one; a zero first wait is followed by initialDialBackoff. before and
+// failed, if set, are called ahead of each wait and after each failed
+// attempt. It returns the connection and the attempt that made it, or the
+// last error.
+func dialBackoff(ctx context.Context, endpoint, sessionToken string, attempts int, first time.Duration,
+	before func(attempt int, wait time.Duration), failed func(attempt int, err error), dialOpts []DialOption) (*websocket.Conn, int, error) {
+	wait := first
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if before != nil {
+			before(attempt, wait)
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, attempt, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		var conn *websocket.Conn
+		conn, err = DialRelay(ctx, endpoint, sessionToken, dialOpts...)
 		if err == nil {
-			fmt.Fprintln(out, "Reconnected successfully.")
-			return conn, nil
+			return conn, attempt, nil
+		}
+		if failed != nil {
+			failed(attempt, err)
+		}
+		if ctx.Err() != nil {
+			return nil, attempt, ctx.Err()
 		}
 
-		if verbose {
-			fmt.Fprintf(out, "Attempt %d failed: %v\n", attempt, err)
+		if wait == 0 {
+			wait = initialDialBackoff
+		} else {
+			wait = min(wait*2, maxBackoff)
 		}
+	}
+	return nil, attempts, err
+}
+
+// relayReadLimit is the largest WebSocket message accepted from the relay:
+// a maximum-size frame plus headroom.
+const relayReadLimit = 11 * 1024 * 1024
+
+// DialOption configures DialRelay.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	httpClient    *http.Client
+	header        http.Header
+	tokenInHeader bool
+	noCompression bool
+	timeout       time.Duration
+}
+
+// SessionTokenHeader carries the session token on the relay dial when
+// WithTokenInHeader is used.
+const SessionTokenHeader = "X-Lt-Session-Token"
+
+// WithHTTPClient dials through hc, typically from RelayHTTPClient so proxy
+// settings apply.
+func WithHTTPClient(hc *http.Client) DialOption {
+	return func(o *dialOptions) {
+		o.httpClient = hc
+	}
+}
 
-		backoff *= 2
-		if backoff > maxBackoff {
-			backoff = maxBackoff
+// WithHeader adds h to the WebSocket upgrade request, e.g. for an
+// authenticating proxy in front of the relay.
+func WithHeader(h http.Header) DialOption {
+	return func(o *dialOptions) {
+		if o.header == nil {
+			o.header = make(http.Header)
 		}
+		for k, vs := range h {
+			o.header[k] = append(o.header[k], vs...)
+		}
+	}
+}
+
+// WithTokenInHeader sends the session token in the SessionTokenHeader
+// header instead of the URL, keeping it out of proxy and access logs.
+func WithTokenInHeader() DialOption {
+	return func(o *dialOptions) {
+		o.tokenInHeader = true
+	}
+}
+
+// WithoutCompression turns off permessage-deflate on the relay connection,
+// saving CPU when the traffic is already compressed.
+func WithoutCompression() DialOption {
+	return func(o *dialOptions) {
+		o.noCompression = true
 	}
+}
 
-	return nil, fmt.Errorf("unable to reconnect after %d attempts", maxAttempts)
+// WithDialTimeout bounds each dial, including the WebSocket handshake, to
+// d. Without it only the caller's context limits a dial.
+func WithDialTimeout(d time.Duration) DialOption {
+	return func(o *dialOptions) {
+		o.timeout = d
+	}
 }
 
-// dialRelay establishes a WebSocket connection to the relay endpoint.
-func dialRelay(ctx context.Context, endpoint string, sessionToken string) (*websocket.Conn, error) {
-	sep := "?"
-	if strings.Contains(endpoint, "?") {
-		sep = "&"
+// relayCompression is the permessage-deflate mode offered to the relay. No
+// context takeover keeps memory per connection low; each message, usually
+// up to 64 KB of a response, is compressed on its own. Measured that way,
+// typical HTML shrinks to 34-38% of its size; responses the local server
+// already compressed gain nothing. If the relay does not support the
+// extension the connection is simply uncompressed.
+const relayCompression = websocket.CompressionNoContextTakeover
+
+// DialRelay establishes a WebSocket connection to a tunnel's relay
+// endpoint, authenticating with the session token. Compression is
+// negotiated unless WithoutCompression is given.
+func DialRelay(ctx context.Context, endpoint string, sessionToken string, opts ...DialOption) (*websocket.Conn, error) {
+	var o dialOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
-	wsURL := endpoint + sep + "session_token=" + sessionToken
 
-	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{})
+	u, err := ParseRelayEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	header := o.header.Clone()
+	if o.tokenInHeader {
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set(SessionTokenHeader, sessionToken)
+	} else {
+		addSessionToken(u, sessionToken)
+	}
+	dialOpts := &websocket.DialOptions{
+		HTTPClient:      o.httpClient,
+		HTTPHeader:      header,
+		CompressionMode: relayCompression,
+	}
+	if o.noCompression {
+		dialOpts.CompressionMode = websocket.CompressionDisabled
+	}
+	conn, resp, err := websocket.Dial(ctx, u.String(), dialOpts)
 	if err != nil {
-		return nil, fmt.Errorf("dialing relay: %w", err)
+		// With a response the relay was reached and refused us, which the
+		// error already says; without one, the network is the likely cause.
+		if resp != nil || ctx.Err() != nil {
+			return nil, fmt.Errorf("dialing relay %s: %w", u.Host, err)
+		}
+		return nil, fmt.Errorf("dialing relay %s: %w (is a firewall or proxy blocking WebSocket connections?)", u.Host, err)
 	}
-	// Increase read limit to support 10 MB payloads.
-	conn.SetReadLimit(11 * 1024 * 1024)
+	// Increase read limit to support 10 MB payloads. The limit applies to
+	// the decompressed message, so compression cannot be used to exceed it.
+	conn.SetReadLimit(relayReadLimit)
 	return conn, nil
 }
+
+// ParseRelayEndpoint parses a relay endpoint as returned by the control
+// plane. http and https URLs are accepted as ws and wss; anything else
+// that is not a ws or wss URL with a host is an error.
+func ParseRelayEndpoint(endpoint string) (*url.URL, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return nil, fmt.Errorf("the tunnel has no relay endpoint")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay endpoint %q: %w", endpoint, err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("invalid relay endpoint %q: want a ws:// or wss:// URL", endpoint)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid relay endpoint %q: missing host", endpoint)
+	}
+	return u, nil
+}
+
+// addSessionToken appends the session token, which the relay expects as a
+// query parameter, to u's query, keeping any parameters already there.
+func addSessionToken(u *url.URL, sessionToken string) {
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += "session_token=" + url.QueryEscape(sessionToken)
+}
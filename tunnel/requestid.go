@@ -0,0 +1,39 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// RequestIDHeader carries the ID that correlates a tunneled request across
+// the relay's logs, --inspect output, and the local server. The relay sets
+// it on requests it forwards; when it is missing the CLI generates one.
+// Either way it is passed on to the local server and echoed in the
+// response, so the visitor sees it too.
+const RequestIDHeader = "X-Lt-Request-Id"
+
+var (
+	// requestIDPrefix keeps IDs from different CLI processes apart.
+	requestIDPrefix = newRequestIDPrefix()
+	requestIDSeq    atomic.Uint64
+)
+
+func newRequestIDPrefix() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return "lt-" + hex.EncodeToString(b)
+}
+
+// ensureRequestID returns req's request ID, generating and setting one if
+// the relay did not.
+func ensureRequestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	id := requestIDPrefix + "-" + strconv.FormatUint(requestIDSeq.Add(1), 10)
+	req.Header.Set(RequestIDHeader, id)
+	return id
+}
@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// localTLSConfig is used for HTTPS to local servers. Their certificates are
+// usually self-signed or from a development CA, and the connection never
+// leaves the machine (or the allowed local hosts), so they are not verified.
+var localTLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+// schemeWarned records the targets already warned about speaking the other
+// scheme, so the warning is printed once rather than on every request.
+var schemeWarned sync.Map
+
+// schemeMismatch returns a warning if err, from a request sent to target
+// with scheme, shows the local server speaks the other one: TLS records in
+// reply to plain HTTP, or plain HTTP in reply to a TLS handshake.
+func schemeMismatch(scheme, target string, err error) string {
+	var recErr tls.RecordHeaderError
+	switch {
+	case scheme == "https" && errors.As(err, &recErr):
+		return fmt.Sprintf("%s answered in plain HTTP, not HTTPS. Drop --local-scheme https.", target)
+	case scheme == "http" && looksLikeTLSRecord(err.Error()):
+		return httpsOnly(target)
+	}
+	return ""
+}
+
+// httpsOnly is the warning for a local server that only speaks HTTPS.
+func httpsOnly(target string) string {
+	return fmt.Sprintf("%s speaks HTTPS, not HTTP. Expose it with --local-scheme https.", target)
+}
+
+// looksLikeTLSRecord reports whether msg, an http.Transport error, quotes
+// a response starting with a TLS alert or handshake record.
+func looksLikeTLSRecord(msg string) bool {
+	_, quoted, ok := strings.Cut(msg, `malformed HTTP response "`)
+	return ok && (strings.HasPrefix(quoted, `\x15\x03`) || strings.HasPrefix(quoted, `\x16\x03`))
+}
+
+// tlsPortPhrases appear in the 400 responses servers send to plain HTTP on
+// an HTTPS port: Go's, and nginx's.
+var tlsPortPhrases = [][]byte{
+	[]byte("HTTP request to an HTTPS server"),
+	[]byte("plain HTTP request was sent to HTTPS port"),
+}
+
+// plainToTLSPort reports whether resp, the answer to a plain HTTP request,
+// is a server complaining that it only speaks HTTPS. It peeks at the body
+// of 400 responses without consuming it.
+func plainToTLSPort(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	br := bufio.NewReaderSize(resp.Body, 512)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, resp.Body}
+	head, _ := br.Peek(512)
+	for _, p := range tlsPortPhrases {
+		if bytes.Contains(head, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnScheme prints msg about target once.
+func warnScheme(target, msg string) {
+	if _, warned := schemeWarned.LoadOrStore(target, true); !warned {
+		fmt.Fprintf(Stderr, "Warning: %s\n", msg)
+	}
+}
@@ -0,0 +1,392 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"nhooyr.io/websocket"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/protocol"
+)
+
+// ErrConnectionLost is returned by Session.Run and Session.Wait when the
+// relay connection was lost and could not be re-established.
+var ErrConnectionLost = errors.New("tunnel: connection lost")
+
+// State is where a Session is in its lifecycle.
+type State int
+
+// Session states. A session starts out StateConnecting, alternates between
+// StateConnected and StateReconnecting as the relay connection drops and
+// is restored, and ends StateClosed.
+const (
+	StateConnecting State = iota
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// SessionConfig describes the tunnel a Session serves.
+type SessionConfig struct {
+	// Client creates the tunnel from Request when Tunnel is nil, and stops
+	// it when the session is stopped. It may be nil if Tunnel is set, in
+	// which case the caller owns the tunnel's lifecycle.
+	Client  *client.Client
+	Request client.CreateTunnelRequest
+
+	// Tunnel is an existing tunnel to serve instead of creating one, and
+	// Conn an open relay connection for it; Start dials one if Conn is nil.
+	Tunnel *client.TunnelResponse
+	Conn   *websocket.Conn
+
+	// LocalHost and LocalPort are where streams are forwarded, and
+	// Protocol ("http" or "tcp") how. They default to 127.0.0.1 and the
+	// tunnel's local port and protocol.
+	LocalHost string
+	LocalPort int
+	Protocol  string
+
+	// Forward configures the forwarder. Its TunnelID is filled in.
+	Forward *Options
+
+	// Handler, if set, serves each stream instead of the built-in
+	// forwarder for Protocol, e.g. HTTPHandler to serve an http.Handler
+	// directly over the tunnel.
+	Handler Handler
+
+	// DialOptions are used for the relay connection and reconnections;
+	// MuxOptions for each mux over it.
+	DialOptions []DialOption
+	MuxOptions  []protocol.Option
+
+	// OnMux, if set, is called with each new mux before streams are
+	// accepted, e.g. to register callbacks.
+	OnMux func(*protocol.Mux)
+
+	// NoReconnect makes a lost connection end the session instead of
+	// being re-established.
+	NoReconnect bool
+
+	// Status receives notices such as "Connection lost. Reconnecting...".
+	// It defaults to Stderr; use io.Discard to silence them.
+	Status io.Writer
+}
+
+// sessionDialAttempts is how many times Start tries to reach the relay.
+const sessionDialAttempts = 4
+
+// eventBuffer is the capacity of a Session's event channel.
+const eventBuffer = 64
+
+// Session serves one tunnel: it creates the tunnel if needed, connects to
+// its relay, forwards every stream to the local server, and reconnects when
+// the connection drops. It is the library form of what 'lt expose' does
+// for each port.
+type Session struct {
+	cfg SessionConfig
+	tun *client.TunnelResponse
+
+	events     chan Event
+	eventsMu   sync.Mutex
+	eventsDone bool
+
+	// stateMu guards state and onState; notifyMu serialises transitions so
+	// callbacks see them in order, and is not held by State.
+	notifyMu sync.Mutex
+	stateMu  sync.Mutex
+	state    State
+	onState  func(old, new State)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// NewSession returns a session for cfg. Nothing happens until Start or Run
+// is called.
+func NewSession(cfg SessionConfig) *Session {
+	return &Session{
+		cfg:    cfg,
+		tun:    cfg.Tunnel,
+		events: make(chan Event, eventBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start creates the tunnel if the config has none, connects to its relay,
+// and serves it in the background until ctx is cancelled, Stop is called,
+// or the connection is lost for good. It returns once the tunnel is
+// connected; use Wait or Done to learn when serving ends. A tunnel Start
+// created is stopped again if its relay cannot be reached.
+func (s *Session) Start(ctx context.Context) error {
+	if err := s.connect(ctx); err != nil {
+		s.err = err
+		s.finish()
+		return err
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	go func() { _ = s.Run(ctx) }()
+	return nil
+}
+
+// connect creates the tunnel and dials its relay, as far as the config
+// does not already provide them.
+func (s *Session) connect(ctx context.Context) error {
+	if s.tun == nil {
+		if s.cfg.Client == nil {
+			return errors.New("tunnel: session needs a Client or a Tunnel")
+		}
+		tun, err := s.cfg.Client.CreateTunnel(s.cfg.Request)
+		if err != nil {
+			return fmt.Errorf("creating tunnel: %w", err)
+		}
+		s.tun = tun
+		s.emit(Event{Type: EventTunnelCreated, TunnelID: tun.ID, URL: tun.PublicURL})
+	}
+	if s.cfg.Conn == nil {
+		conn, err := DialRelayRetry(ctx, s.tun.RelayEndpoint, s.tun.SessionToken, sessionDialAttempts, s.cfg.DialOptions...)
+		if err != nil {
+			// Don't leave a tunnel we created behind with nothing serving it.
+			if s.cfg.Tunnel == nil {
+				_ = s.cfg.Client.StopTunnel(s.tun.ID)
+			}
+			return err
+		}
+		s.cfg.Conn = conn
+	}
+	return nil
+}
+
+// Run serves the tunnel over the config's Conn until ctx is cancelled,
+// reconnecting as needed. It returns nil on cancellation and an error
+// wrapping ErrConnectionLost if the connection could not be restored.
+// Most callers want Start instead.
+func (s *Session) Run(ctx context.Context) error {
+	defer s.finish()
+	if s.tun == nil || s.cfg.Conn == nil {
+		s.err = errors.New("tunnel: Run needs a Tunnel and a Conn")
+		return s.err
+	}
+	s.err = s.serve(ctx)
+	return s.err
+}
+
+func (s *Session) serve(ctx context.Context) error {
+	conn := s.cfg.Conn
+	localHost, localPort := s.cfg.LocalHost, s.cfg.LocalPort
+	if localHost == "" {
+		localHost = "127.0.0.1"
+	}
+	if localPort == 0 {
+		localPort = s.tun.LocalPort
+	}
+	proto := s.cfg.Protocol
+	if proto == "" {
+		proto = s.tun.Protocol
+	}
+	var fwd Options
+	if s.cfg.Forward != nil {
+		fwd = *s.cfg.Forward
+	}
+	fwd.TunnelID = s.tun.ID
+	fwd.Events = MultiSink(fwd.Events, sessionSink{s})
+	h := s.cfg.Handler
+	if h == nil {
+		h = Forwarder(proto, localHost, localPort, &fwd)
+	}
+	if h == nil {
+		return fmt.Errorf("tunnel: unsupported protocol %q", proto)
+	}
+
+	for {
+		mux := protocol.NewMux(protocol.NewWebSocketTransport(conn), false, s.cfg.MuxOptions...)
+		s.setState(StateConnected)
+		Emit(fwd.Events, Event{Type: EventConnected, TunnelID: s.tun.ID})
+		if s.cfg.OnMux != nil {
+			s.cfg.OnMux(mux)
+		}
+
+		if acceptStreams(ctx, mux, h, &fwd) {
+			mux.CloseWithReason(websocket.StatusNormalClosure, "client shutdown")
+			return nil
+		}
+
+		// The relay is gone or unresponsive; say so in case it is still
+		// listening.
+		mux.CloseWithReason(websocket.StatusGoingAway, "connection lost")
+		Emit(fwd.Events, Event{Type: EventDisconnected, TunnelID: s.tun.ID})
+		if s.cfg.NoReconnect {
+			return ErrConnectionLost
+		}
+		s.setState(StateReconnecting)
+
+		status := s.cfg.Status
+		if status == nil {
+			status = Stderr
+		}
+		newConn, err := Reconnect(ctx, s.tun.RelayEndpoint, s.tun.SessionToken, fwd.Events, s.tun.ID, status, s.cfg.DialOptions...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrConnectionLost, err)
+		}
+		conn = newConn
+	}
+}
+
+// acceptStreams hands the mux's streams to h until ctx is cancelled, which
+// it reports as true, or the mux closes, reported as false.
+func acceptStreams(ctx context.Context, mux *protocol.Mux, h Handler, fwd *Options) bool {
+	for {
+		stream, err := mux.AcceptStream(ctx)
+		if err != nil {
+			return ctx.Err() != nil
+		}
+		Emit(fwd.Events, Event{Type: EventStreamOpened, TunnelID: fwd.TunnelID, StreamID: stream.ID})
+
+		go func() {
+			defer Emit(fwd.Events, Event{Type: EventStreamClosed, TunnelID: fwd.TunnelID, StreamID: stream.ID})
+			defer stream.Close()
+			h.Handle(stream)
+		}()
+	}
+}
+
+// Stop ends the session and waits for it to finish. If the session has a
+// Client, the tunnel is then stopped on the control plane.
+func (s *Session) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+	if s.cfg.Client == nil || s.tun == nil {
+		return nil
+	}
+	if err := s.cfg.Client.StopTunnel(s.tun.ID); err != nil {
+		return err
+	}
+	Emit(s.cfg.Forward.events(), Event{Type: EventTunnelStopped, TunnelID: s.tun.ID})
+	return nil
+}
+
+// Wait blocks until the session has finished serving and returns why: nil
+// after cancellation or Stop, or an error wrapping ErrConnectionLost.
+func (s *Session) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Done is closed when the session has finished serving.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Tunnel returns the tunnel being served, or nil before Start has
+// created it.
+func (s *Session) Tunnel() *client.TunnelResponse {
+	return s.tun
+}
+
+// PublicURL returns the tunnel's public URL, or "" before Start has
+// created it.
+func (s *Session) PublicURL() string {
+	if s.tun == nil {
+		return ""
+	}
+	return s.tun.PublicURL
+}
+
+// State returns the session's current state.
+func (s *Session) State() State {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+// OnStateChange registers a callback that is called once for every change
+// of state, in order, from the goroutine making the change. It replaces
+// any previous callback; nil removes it. The callback must not block, as
+// the session waits for it.
+func (s *Session) OnStateChange(fn func(old, new State)) {
+	s.stateMu.Lock()
+	s.onState = fn
+	s.stateMu.Unlock()
+}
+
+// setState moves the session to state and reports the change, if it is
+// one.
+func (s *Session) setState(state State) {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+	s.stateMu.Lock()
+	old, fn := s.state, s.onState
+	s.state = state
+	s.stateMu.Unlock()
+	if old != state && fn != nil {
+		fn(old, state)
+	}
+}
+
+// Events returns a channel of the session's lifecycle and request events,
+// closed when the session finishes. Events are dropped rather than
+// delaying the tunnel if the channel is not drained.
+func (s *Session) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Session) emit(e Event) {
+	Emit(MultiSink(s.cfg.Forward.events(), sessionSink{s}), e)
+}
+
+// finish closes the event channel and marks the session done.
+func (s *Session) finish() {
+	s.setState(StateClosed)
+	s.eventsMu.Lock()
+	s.eventsDone = true
+	close(s.events)
+	s.eventsMu.Unlock()
+	close(s.done)
+}
+
+// sessionSink delivers events to a Session's channel.
+type sessionSink struct{ s *Session }
+
+func (k sessionSink) Emit(e Event) {
+	k.s.eventsMu.Lock()
+	defer k.s.eventsMu.Unlock()
+	if k.s.eventsDone {
+		return
+	}
+	select {
+	case k.s.events <- e:
+	default:
+	}
+}
+
+// events returns o's event sink; o may be nil.
+func (o *Options) events() EventSink {
+	if o == nil {
+		return nil
+	}
+	return o.Events
+}
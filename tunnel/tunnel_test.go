@@ -0,0 +1,1914 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carloluisito/launchtunnel-cli/client"
+	"github.com/carloluisito/launchtunnel-cli/protocol"
+	"nhooyr.io/websocket"
+)
+
+// ---------------------------------------------------------------------------
+// Helpers
+// ---------------------------------------------------------------------------
+
+// setupMuxPair connects a relay-side mux to a client-side mux over an
+// in-process WebSocket.
+func setupMuxPair(t testing.TB) (relay *protocol.Mux, client *protocol.Mux) {
+	t.Helper()
+
+	relayReady := make(chan *protocol.Mux, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("websocket.Accept: %v", err)
+			return
+		}
+		relayReady <- protocol.NewMux(protocol.NewWebSocketTransport(conn), true)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+srv.URL[len("http"):], nil)
+	if err != nil {
+		t.Fatalf("websocket.Dial: %v", err)
+	}
+	client = protocol.NewMux(protocol.NewWebSocketTransport(conn), false)
+
+	select {
+	case relay = <-relayReady:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for relay mux")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		relay.Close()
+	})
+	return relay, client
+}
+
+// tunnelRoundTrip sends req through a fresh stream to ForwardHTTP, targeting the
+// local server at addr, and returns the response as seen by the relay.
+func tunnelRoundTrip(t *testing.T, addr string, opts *Options, req *http.Request) (*http.Response, []byte, error) {
+	t.Helper()
+	resp := tunnelStream(t, addr, opts, req)
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// tunnelStream is like tunnelRoundTrip but returns as soon as the response
+// headers arrive, leaving the body to be read by the caller.
+func tunnelStream(t *testing.T, addr string, opts *Options, req *http.Request) *http.Response {
+	t.Helper()
+	relay, client := setupMuxPair(t)
+
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	go func() {
+		s, err := client.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		ForwardHTTP(s, host, port, opts)
+	}()
+
+	s, err := relay.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := req.Write(s); err != nil {
+		t.Fatalf("writing request: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(s), req)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return resp
+}
+
+// ---------------------------------------------------------------------------
+// ForwardHTTP response size tests
+// ---------------------------------------------------------------------------
+
+func TestForwardHTTP_LargeResponseExceedsFrameLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), (protocol.MaxPayloadSize+1<<20)/16)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		_, _ = w.Write(payload)
+	}))
+	defer local.Close()
+
+	req, _ := http.NewRequest("GET", "/big", nil)
+	resp, body, err := tunnelRoundTrip(t, local.Listener.Addr().String(), nil, req)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: got %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(body, payload) {
+		t.Fatalf("body: got %d bytes, want %d intact", len(body), len(payload))
+	}
+}
+
+func TestForwardHTTP_MaxResponseSize(t *testing.T) {
+	const limit = 1 << 20
+	payload := bytes.Repeat([]byte("x"), protocol.MaxPayloadSize+1)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/known" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		}
+		_, _ = w.Write(payload)
+	}))
+	defer local.Close()
+	addr := local.Listener.Addr().String()
+
+	var warnings bytes.Buffer
+	oldStderr := Stderr
+	Stderr = &warnings
+	defer func() { Stderr = oldStderr }()
+
+	opts := &Options{MaxResponseSize: limit}
+
+	t.Run("known length", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/known", nil)
+		resp, body, err := tunnelRoundTrip(t, addr, opts, req)
+		if err != nil {
+			t.Fatalf("reading body: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("status: got %d, want 502", resp.StatusCode)
+		}
+		if !bytes.Contains(body, []byte("larger than this tunnel allows")) {
+			t.Errorf("body does not explain the limit: %q", body)
+		}
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/chunked", nil)
+		resp, body, err := tunnelRoundTrip(t, addr, opts, req)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status: got %d, want 200", resp.StatusCode)
+		}
+		if err == nil {
+			t.Fatal("expected an incomplete body, got a complete one")
+		}
+		if len(body) > limit {
+			t.Errorf("body: got %d bytes, want at most %d", len(body), limit)
+		}
+	})
+
+	t.Run("close-delimited", func(t *testing.T) {
+		// A response with neither a length nor chunked encoding ends when
+		// the connection closes, so only a reset can mark it as cut short.
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = http.ReadRequest(bufio.NewReader(conn))
+			_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n")
+			_, _ = conn.Write(payload)
+		}()
+
+		req, _ := http.NewRequest("GET", "/close", nil)
+		resp, body, err := tunnelRoundTrip(t, ln.Addr().String(), opts, req)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status: got %d, want 200", resp.StatusCode)
+		}
+		if err == nil {
+			t.Fatalf("expected the stream to be reset, got a complete %d-byte body", len(body))
+		}
+		if len(body) > limit {
+			t.Errorf("body: got %d bytes, want at most %d", len(body), limit)
+		}
+	})
+
+	if !bytes.Contains(warnings.Bytes(), []byte("response limit")) {
+		t.Errorf("expected a warning, got %q", warnings.String())
+	}
+}
+
+func TestForwardHTTP_StreamsServerSentEvents(t *testing.T) {
+	// The handler sends one event, then waits for the test to see it
+	// before sending the next. If nothing arrives within the deadline it
+	// gives up, so a buffered copy fails the test instead of hanging it.
+	next := make(chan struct{})
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	halt := func() { stopOnce.Do(func() { close(stop) }) }
+	deadline := time.AfterFunc(5*time.Second, halt)
+	defer deadline.Stop()
+
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "data: event %d\n\n", i)
+			w.(http.Flusher).Flush()
+			if i < 3 {
+				select {
+				case <-next:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}))
+	defer local.Close()
+	defer halt()
+
+	req, _ := http.NewRequest("GET", "/events", nil)
+	resp := tunnelStream(t, local.Listener.Addr().String(), nil, req)
+	br := bufio.NewReader(resp.Body)
+	for i := 1; i <= 3; i++ {
+		var got string
+		for got == "" {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("event %d was not delivered before the handler gave up: %v", i, err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				got = strings.TrimSpace(data)
+			}
+		}
+		if want := fmt.Sprintf("event %d", i); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		if i < 3 {
+			select {
+			case next <- struct{}{}:
+			case <-stop:
+			}
+		}
+	}
+}
+
+func TestForwardHTTP_Trailers(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = io.WriteString(w, "payload")
+		w.(http.Flusher).Flush()
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer local.Close()
+
+	req, _ := http.NewRequest("POST", "/svc.Method", nil)
+	resp, body, err := tunnelRoundTrip(t, local.Listener.Addr().String(), nil, req)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(body) != "payload" {
+		t.Errorf("body = %q, want %q", body, "payload")
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want %q", got, "0")
+	}
+}
+
+func TestForwardHTTP_CompressResponses(t *testing.T) {
+	payload := strings.Repeat(`{"id": 1, "name": "launchtunnel"}, `, 200)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/encoded":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		_, _ = io.WriteString(w, payload)
+	}))
+	defer local.Close()
+	addr := local.Listener.Addr().String()
+	opts := &Options{CompressResponses: true}
+
+	tests := []struct {
+		path, accept, wantEncoding string
+	}{
+		{"/json", "gzip, deflate", "gzip"},
+		{"/json", "deflate", "deflate"},
+		{"/json", "gzip;q=0, deflate", "deflate"},
+		{"/json", "", ""},
+		{"/png", "gzip", ""},
+		{"/encoded", "gzip", "br"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.accept, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			resp, body, err := tunnelRoundTrip(t, addr, opts, req)
+			if err != nil {
+				t.Fatalf("round trip: %v", err)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			var zr io.Reader
+			switch tt.wantEncoding {
+			case "gzip":
+				zr, err = gzip.NewReader(bytes.NewReader(body))
+			case "deflate":
+				zr, err = zlib.NewReader(bytes.NewReader(body))
+			default:
+				if string(body) != payload {
+					t.Errorf("body changed without compression")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("opening %s body: %v", tt.wantEncoding, err)
+			}
+			plain, err := io.ReadAll(zr)
+			if err != nil || string(plain) != payload {
+				t.Errorf("decompressed body mismatch (err %v)", err)
+			}
+			if len(body) >= len(payload) {
+				t.Errorf("compressed to %d bytes from %d", len(body), len(payload))
+			}
+			if resp.Header.Get("Content-Length") != "" || !slices.Contains(resp.Header.Values("Vary"), "Accept-Encoding") {
+				t.Errorf("headers not fixed up: %v", resp.Header)
+			}
+		})
+	}
+}
+
+func TestForwardTCP_DialFailureResetsStream(t *testing.T) {
+	relay, cl := setupMuxPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Nothing listens on a closed listener's port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	oldStderr := Stderr
+	Stderr = io.Discard
+	defer func() { Stderr = oldStderr }()
+
+	go func() {
+		s, err := cl.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		ForwardTCP(s, "127.0.0.1", port, nil)
+	}()
+
+	stream, err := relay.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	_, err = io.ReadAll(stream)
+	var resetErr *protocol.ResetError
+	if !errors.As(err, &resetErr) || resetErr.Code != protocol.ResetConnectRefused {
+		t.Fatalf("relay read: got %v, want a reset with %s", err, protocol.ResetConnectRefused)
+	}
+}
+
+// forwardTCPPair starts ForwardTCP for one stream to a local server that
+// sends size bytes and closes, and returns the relay's end of the stream.
+func forwardTCPPair(tb testing.TB, size int, opts *Options) *protocol.Stream {
+	tb.Helper()
+	relay, cl := setupMuxPair(tb)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.CopyN(conn, zeroReader{}, int64(size))
+	}()
+
+	go func() {
+		s, err := cl.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		ForwardTCP(s, "127.0.0.1", ln.Addr().(*net.TCPAddr).Port, opts)
+	}()
+	stream, err := relay.OpenStream(context.Background())
+	if err != nil {
+		tb.Fatalf("OpenStream: %v", err)
+	}
+	return stream
+}
+
+// frameSizes records the sizes of the writes it is given.
+type frameSizes struct{ total, largest int }
+
+func (f *frameSizes) Write(p []byte) (int, error) {
+	f.total += len(p)
+	f.largest = max(f.largest, len(p))
+	return len(p), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestForwardTCP_FrameSize(t *testing.T) {
+	const size = 4 << 20
+	for _, frameSize := range []int{0, 1024, 2 * protocol.MaxPayloadSize} {
+		stream := forwardTCPPair(t, size, &Options{TCPFrameSize: frameSize})
+		want := (&Options{TCPFrameSize: frameSize}).tcpFrameSize()
+		// Stream.WriteTo hands over one frame's payload per Write.
+		var frames frameSizes
+		if _, err := io.Copy(&frames, stream); err != nil {
+			t.Fatalf("TCPFrameSize %d: %v", frameSize, err)
+		}
+		if got := frames.total; got != size {
+			t.Errorf("TCPFrameSize %d: got %d bytes, want %d", frameSize, got, size)
+		}
+		if frames.largest > want {
+			t.Errorf("TCPFrameSize %d: got a %d-byte frame, want at most %d", frameSize, frames.largest, want)
+		}
+	}
+}
+
+// benchmarkForwardTCP measures a 32 MB download through ForwardTCP.
+func benchmarkForwardTCP(b *testing.B, frameSize int) {
+	const size = 32 << 20
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		stream := forwardTCPPair(b, size, &Options{TCPFrameSize: frameSize})
+		n, err := io.Copy(io.Discard, stream)
+		if err != nil || n != size {
+			b.Fatalf("copied %d bytes, err %v", n, err)
+		}
+	}
+}
+
+func BenchmarkForwardTCP_32K(b *testing.B)  { benchmarkForwardTCP(b, 32*1024) }
+func BenchmarkForwardTCP_256K(b *testing.B) { benchmarkForwardTCP(b, 256*1024) }
+func BenchmarkForwardTCP_1M(b *testing.B)   { benchmarkForwardTCP(b, 1<<20) }
+func BenchmarkForwardTCP_4M(b *testing.B)   { benchmarkForwardTCP(b, 4<<20) }
+
+func TestForwardHTTP_RequestID(t *testing.T) {
+	seen := make(chan string, 1)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get(RequestIDHeader)
+	}))
+	defer local.Close()
+	addr := local.Listener.Addr().String()
+
+	var inspect bytes.Buffer
+	oldStderr := Stderr
+	Stderr = &inspect
+	defer func() { Stderr = oldStderr }()
+	opts := &Options{Inspect: true}
+
+	t.Run("from relay", func(t *testing.T) {
+		inspect.Reset()
+		req, _ := http.NewRequest("GET", "/a", nil)
+		req.Header.Set(RequestIDHeader, "relay-123")
+		resp, _, err := tunnelRoundTrip(t, addr, opts, req)
+		if err != nil {
+			t.Fatalf("round trip: %v", err)
+		}
+		if got := <-seen; got != "relay-123" {
+			t.Errorf("local server saw %q, want the relay's ID", got)
+		}
+		if got := resp.Header.Get(RequestIDHeader); got != "relay-123" {
+			t.Errorf("response header = %q, want the relay's ID", got)
+		}
+		if !strings.Contains(inspect.String(), "[relay-123]") {
+			t.Errorf("inspect output %q does not include the ID", inspect.String())
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/b", nil)
+		resp, _, err := tunnelRoundTrip(t, addr, opts, req)
+		if err != nil {
+			t.Fatalf("round trip: %v", err)
+		}
+		id := <-seen
+		if !strings.HasPrefix(id, requestIDPrefix+"-") {
+			t.Errorf("local server saw %q, want a generated ID", id)
+		}
+		if got := resp.Header.Get(RequestIDHeader); got != id {
+			t.Errorf("response header = %q, want %q", got, id)
+		}
+	})
+}
+
+func TestForwardHTTP_LocalScheme(t *testing.T) {
+	tlsLocal := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure")
+	}))
+	tlsLocal.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsLocal.StartTLS()
+	defer tlsLocal.Close()
+	plainLocal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "plain")
+	}))
+	defer plainLocal.Close()
+
+	// A server that answers anything with a TLS alert record.
+	alertLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alertLn.Close()
+	go func() {
+		for {
+			c, err := alertLn.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = c.Read(make([]byte, 1024))
+			_, _ = c.Write([]byte("\x15\x03\x01\x00\x02\x02\x16"))
+			c.Close()
+		}
+	}()
+
+	tests := []struct {
+		name, addr, scheme string
+		wantStatus         int
+		wantBody, wantWarn string
+	}{
+		{"https", tlsLocal.Listener.Addr().String(), "https", http.StatusOK, "secure", ""},
+		{"http to Go TLS server", tlsLocal.Listener.Addr().String(), "", http.StatusBadRequest, "HTTPS server", "--local-scheme https"},
+		{"http to TLS alert", alertLn.Addr().String(), "", http.StatusBadGateway, "--local-scheme https", "--local-scheme https"},
+		{"https to plain server", plainLocal.Listener.Addr().String(), "https", http.StatusBadGateway, "Drop --local-scheme https", "plain HTTP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schemeWarned.Delete(tt.addr)
+			var warnings bytes.Buffer
+			oldStderr := Stderr
+			Stderr = &warnings
+			defer func() { Stderr = oldStderr }()
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			resp, body, err := tunnelRoundTrip(t, tt.addr, &Options{LocalScheme: tt.scheme}, req)
+			if err != nil {
+				t.Fatalf("round trip: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("got %d %q, want %d containing %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			if tt.wantWarn == "" && warnings.Len() > 0 {
+				t.Errorf("unexpected warning %q", warnings.String())
+			}
+			if !strings.Contains(warnings.String(), tt.wantWarn) {
+				t.Errorf("warning %q lacks %q", warnings.String(), tt.wantWarn)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Limiter tests
+// ---------------------------------------------------------------------------
+
+// fakeClock drives a Limiter without real sleeps.
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func newTestLimiter(rate float64, burst int) (*Limiter, *fakeClock) {
+	clk := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := NewLimiter(rate, burst)
+	l.now = func() time.Time { return clk.now }
+	l.sleep = func(d time.Duration) {
+		clk.slept += d
+		clk.now = clk.now.Add(d)
+	}
+	return l, clk
+}
+
+func TestLimiter_Burst(t *testing.T) {
+	l, _ := newTestLimiter(10, 5)
+
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow(); !ok {
+			t.Fatalf("request %d rejected within burst", i+1)
+		}
+	}
+	ok, retry := l.Allow()
+	if ok {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if retry != 100*time.Millisecond {
+		t.Errorf("retry after: got %v, want 100ms", retry)
+	}
+}
+
+func TestLimiter_SteadyState(t *testing.T) {
+	l, clk := newTestLimiter(10, 1)
+
+	// Drain the initial burst, then offer 100 requests at 20/s for 5s.
+	l.Allow()
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		clk.now = clk.now.Add(50 * time.Millisecond)
+		if ok, _ := l.Allow(); ok {
+			allowed++
+		}
+	}
+	if allowed != 50 {
+		t.Errorf("allowed %d of 100 requests over 5s at 10/s, want 50", allowed)
+	}
+}
+
+func TestLimiter_RefillCapsAtBurst(t *testing.T) {
+	l, clk := newTestLimiter(10, 3)
+
+	for i := 0; i < 3; i++ {
+		l.Allow()
+	}
+	clk.now = clk.now.Add(time.Hour)
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.Allow(); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("allowed %d after idling, want burst of 3", allowed)
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	// 1000 B/s with a 100 B bucket: the first 100 bytes are free, the
+	// remaining 900 take 0.9s.
+	l, clk := newTestLimiter(1000, 100)
+	var buf bytes.Buffer
+	w := &throttledWriter{w: &buf, l: l}
+
+	n, err := w.Write(make([]byte, 1000))
+	if err != nil || n != 1000 {
+		t.Fatalf("Write: n=%d err=%v", n, err)
+	}
+	if buf.Len() != 1000 {
+		t.Errorf("wrote %d bytes, want 1000", buf.Len())
+	}
+	if clk.slept != 900*time.Millisecond {
+		t.Errorf("slept %v, want 900ms", clk.slept)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Circuit breaker tests
+// ---------------------------------------------------------------------------
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{}
+
+	for i := 1; i < breakerThreshold; i++ {
+		if b.failure() {
+			t.Fatalf("failure %d tripped the breaker, want %d", i, breakerThreshold)
+		}
+		if ok, _ := b.allow(); !ok {
+			t.Fatalf("rejected after %d failures", i)
+		}
+	}
+	if !b.failure() {
+		t.Fatalf("failure %d did not trip the breaker", breakerThreshold)
+	}
+
+	ok, wait := b.allow()
+	if ok {
+		t.Fatal("allowed a request during the cooldown")
+	}
+	if wait <= 0 || wait > breakerCooldown {
+		t.Errorf("Retry-After = %s, want up to %s", wait, breakerCooldown)
+	}
+	if wait < breakerCooldown-time.Second {
+		t.Errorf("Retry-After = %s just after tripping, want close to %s", wait, breakerCooldown)
+	}
+
+	// Once the cooldown is over, one request probes the backend and the
+	// rest wait for another cooldown.
+	b.mu.Lock()
+	b.openUntil = time.Now().Add(-time.Millisecond)
+	b.mu.Unlock()
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("rejected the probe after the cooldown")
+	}
+	if ok, wait := b.allow(); ok || wait < breakerCooldown-time.Second {
+		t.Errorf("second request after the cooldown: allowed=%v Retry-After=%s, want rejected for a new cooldown", ok, wait)
+	}
+
+	// A failed probe keeps the breaker open; it does not re-trip.
+	if b.failure() {
+		t.Error("a failed probe reported tripping the breaker again")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Error("allowed a request after a failed probe")
+	}
+
+	b.success()
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("rejected a request after success")
+	}
+	for i := 1; i < breakerThreshold; i++ {
+		if b.failure() {
+			t.Fatalf("failure %d after success tripped the breaker", i)
+		}
+	}
+	if !b.failure() {
+		t.Errorf("failure %d after success did not trip the breaker", breakerThreshold)
+	}
+}
+
+func TestForwardHTTP_BreakerAnswers503(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var warnings bytes.Buffer
+	oldStderr := Stderr
+	Stderr = &warnings
+	defer func() { Stderr = oldStderr }()
+
+	opts := &Options{}
+	for i := 1; i <= breakerThreshold+1; i++ {
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp, _, err := tunnelRoundTrip(t, addr, opts, req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if i < breakerThreshold {
+			if resp.StatusCode != http.StatusBadGateway {
+				t.Errorf("request %d: status %d, want 502", i, resp.StatusCode)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("request %d: status %d, want 503", i, resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("Retry-After"), strconv.Itoa(int(breakerCooldown.Seconds())); got != want {
+			t.Errorf("request %d: Retry-After %q, want %q", i, got, want)
+		}
+	}
+	if !strings.Contains(warnings.String(), "unreachable") {
+		t.Errorf("expected a warning when the breaker tripped, got %q", warnings.String())
+	}
+}
+
+func TestGetBreaker_PerTarget(t *testing.T) {
+	a, b := getBreaker("127.0.0.1:1"), getBreaker("127.0.0.1:2")
+	if a == b {
+		t.Error("different targets share a breaker")
+	}
+	if getBreaker("127.0.0.1:1") != a {
+		t.Error("the same target got a new breaker")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// ResponseCache tests
+// ---------------------------------------------------------------------------
+
+func TestForwardHTTP_Cache(t *testing.T) {
+	var hits, conditional int
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/fresh.css":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag.js":
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				conditional++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		}
+		_, _ = io.WriteString(w, "body of "+r.URL.Path)
+	}))
+	defer local.Close()
+	addr := local.Listener.Addr().String()
+
+	opts := &Options{Cache: NewResponseCache(1 << 20)}
+	get := func(path string) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", path, nil)
+		resp, body, err := tunnelRoundTrip(t, addr, opts, req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if want := "body of " + path; string(body) != want {
+			t.Fatalf("GET %s: body %q, want %q", path, body, want)
+		}
+		return resp.Header.Get("X-Lt-Cache"), resp.Status
+	}
+
+	tests := []struct {
+		path            string
+		wantCache       []string
+		wantHits        int
+		wantConditional int
+	}{
+		{"/fresh.css", []string{"MISS", "HIT"}, 1, 0},
+		{"/etag.js", []string{"MISS", "HIT"}, 2, 1},
+		{"/nostore", []string{"MISS", "MISS"}, 2, 0},
+	}
+	for _, tt := range tests {
+		hits, conditional = 0, 0
+		for i, want := range tt.wantCache {
+			if got, status := get(tt.path); got != want {
+				t.Errorf("%s request %d: X-Lt-Cache %q (%s), want %q", tt.path, i+1, got, status, want)
+			}
+		}
+		if hits != tt.wantHits || conditional != tt.wantConditional {
+			t.Errorf("%s: backend saw %d requests (%d conditional), want %d (%d)",
+				tt.path, hits, conditional, tt.wantHits, tt.wantConditional)
+		}
+	}
+}
+
+func TestForwardHTTP_CacheSkipsCredentials(t *testing.T) {
+	var hits int
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/shared" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		user := "anonymous"
+		if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+			user = "alice"
+		}
+		_, _ = io.WriteString(w, "hello "+user)
+	}))
+	defer local.Close()
+	addr := local.Listener.Addr().String()
+
+	opts := &Options{Cache: NewResponseCache(1 << 20)}
+	get := func(path, header, value string) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, body, err := tunnelRoundTrip(t, addr, opts, req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return string(body), resp.Header.Get("X-Lt-Cache")
+	}
+
+	for _, h := range []struct{ name, value string }{{"Authorization", "Bearer secret"}, {"Cookie", "session=1"}} {
+		path := "/private-" + h.name
+		hits = 0
+		if body, _ := get(path, h.name, h.value); body != "hello alice" {
+			t.Fatalf("%s: got %q", h.name, body)
+		}
+		if body, cache := get(path, "", ""); body != "hello anonymous" || cache == "HIT" {
+			t.Errorf("%s: anonymous request got %q (X-Lt-Cache %s), want the backend's own answer", h.name, body, cache)
+		}
+		// The anonymous response is cached, but not for logged-in visitors.
+		if body, cache := get(path, h.name, h.value); body != "hello alice" || cache == "HIT" {
+			t.Errorf("%s: request with credentials got %q (X-Lt-Cache %s)", h.name, body, cache)
+		}
+		if hits != 3 {
+			t.Errorf("%s: backend saw %d requests, want 3", h.name, hits)
+		}
+	}
+
+	// A response marked public is shared.
+	hits = 0
+	get("/shared", "Authorization", "Bearer secret")
+	if _, cache := get("/shared", "", ""); cache != "HIT" || hits != 1 {
+		t.Errorf("public response: X-Lt-Cache %q after %d backend requests, want a HIT after 1", cache, hits)
+	}
+}
+
+func TestForwardHTTP_CacheWithTunnelCredentials(t *testing.T) {
+	var hits, authed int
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if _, _, ok := r.BasicAuth(); ok && r.Header.Get("Cookie") == "env=staging" {
+			authed++
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer local.Close()
+	addr := local.Listener.Addr().String()
+
+	// The tunnel's own credentials are the same for every visitor, so
+	// they must not stop the cache from sharing responses.
+	opts := &Options{
+		Cache:          NewResponseCache(1 << 20),
+		LocalBasicAuth: "admin:secret",
+		RequestHeaders: HeaderRules{Set: http.Header{"Cookie": {"env=staging"}}},
+	}
+	for i, want := range []string{"MISS", "HIT"} {
+		req, _ := http.NewRequest("GET", "/page", nil)
+		resp, _, err := tunnelRoundTrip(t, addr, opts, req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		if got := resp.Header.Get("X-Lt-Cache"); got != want {
+			t.Errorf("request %d: X-Lt-Cache = %q, want %q", i+1, got, want)
+		}
+	}
+	if hits != 1 || authed != 1 {
+		t.Errorf("backend saw %d requests, %d with the tunnel's credentials; want 1 and 1", hits, authed)
+	}
+
+	// A visitor's own credentials still bypass the shared entry.
+	req, _ := http.NewRequest("GET", "/page", nil)
+	req.Header.Set("Authorization", "Bearer visitor")
+	resp, _, err := tunnelRoundTrip(t, addr, opts, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get("X-Lt-Cache"); got == "HIT" {
+		t.Error("a visitor with credentials was served the shared entry")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Metrics tests
+// ---------------------------------------------------------------------------
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	m := NewMetrics()
+	sink := MultiSink(nil, m)
+	Emit(sink, Event{Type: EventConnected, TunnelID: "tun_1"})
+	Emit(sink, Event{Type: EventStreamOpened, TunnelID: "tun_1", StreamID: 1})
+	Emit(sink, Event{Type: EventStreamOpened, TunnelID: "tun_1", StreamID: 3})
+	Emit(sink, Event{Type: EventStreamClosed, TunnelID: "tun_1", StreamID: 1})
+	Emit(sink, Event{Type: EventReconnected, TunnelID: "tun_1"})
+	m.AddBytes("tun_1", true, 100)
+	m.AddBytes("tun_1", false, 250)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`lt_active_streams{tunnel_id="tun_1"} 1`,
+		`lt_requests_total{tunnel_id="tun_1"} 2`,
+		`lt_bytes_in_total{tunnel_id="tun_1"} 100`,
+		`lt_bytes_out_total{tunnel_id="tun_1"} 250`,
+		`lt_reconnects_total{tunnel_id="tun_1"} 1`,
+		`lt_connected{tunnel_id="tun_1"} 1`,
+		"# TYPE lt_bytes_in_total counter",
+	} {
+		if !bytes.Contains([]byte(body), []byte(want)) {
+			t.Errorf("metrics output missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Relay proxy tests
+// ---------------------------------------------------------------------------
+
+func TestRelayHTTPClient_UsesHTTPProxy(t *testing.T) {
+	seen := make(chan string, 1)
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Host
+		http.Error(w, "no upstream in test", http.StatusBadGateway)
+	}))
+	defer proxySrv.Close()
+
+	hc, err := RelayHTTPClient(proxySrv.URL, nil)
+	if err != nil {
+		t.Fatalf("RelayHTTPClient: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := DialRelay(ctx, "ws://relay.example.test/ws", "tok", WithHTTPClient(hc)); err == nil {
+		t.Fatal("expected dial through the stub proxy to fail")
+	}
+
+	select {
+	case host := <-seen:
+		if host != "relay.example.test" {
+			t.Errorf("proxy saw host %q, want relay.example.test", host)
+		}
+	default:
+		t.Fatal("the configured HTTP proxy was not used")
+	}
+}
+
+func TestRelayHTTPClient_UsesSOCKS5Proxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	greeting := make(chan byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var b [1]byte
+		if _, err := conn.Read(b[:]); err == nil {
+			greeting <- b[0]
+		}
+	}()
+
+	hc, err := RelayHTTPClient("socks5://"+ln.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("RelayHTTPClient: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = DialRelay(ctx, "ws://relay.example.test/ws", "tok", WithHTTPClient(hc))
+
+	select {
+	case v := <-greeting:
+		if v != 0x05 {
+			t.Errorf("proxy got version byte %#x, want 0x05", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the configured SOCKS5 proxy was not used")
+	}
+}
+
+func TestRelayHTTPClient_InvalidProxy(t *testing.T) {
+	for _, p := range []string{"ftp://proxy:21", "not a url", "http://"} {
+		if _, err := RelayHTTPClient(p, nil); err == nil {
+			t.Errorf("RelayHTTPClient(%q): expected error", p)
+		}
+	}
+}
+
+func TestDialRelay_SessionToken(t *testing.T) {
+	got := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.URL.RawQuery
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}))
+	defer srv.Close()
+	wsURL := "ws" + srv.URL[len("http"):]
+
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{wsURL + "/ws", "session_token=tok"},
+		{wsURL + "/ws?region=eu", "region=eu&session_token=tok"},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := DialRelay(ctx, tt.endpoint, "tok")
+		cancel()
+		if err != nil {
+			t.Fatalf("DialRelay(%s): %v", tt.endpoint, err)
+		}
+		conn.CloseNow()
+		if q := <-got; q != tt.want {
+			t.Errorf("DialRelay(%s): query %q, want %q", tt.endpoint, q, tt.want)
+		}
+	}
+}
+
+func TestParseRelayEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint, want string
+	}{
+		{"wss://relay.lt.dev/ws", "wss://relay.lt.dev/ws"},
+		{"ws://127.0.0.1:8080/ws", "ws://127.0.0.1:8080/ws"},
+		{"https://relay.lt.dev/ws", "wss://relay.lt.dev/ws"},
+		{" http://relay.lt.dev/ws?region=eu ", "ws://relay.lt.dev/ws?region=eu"},
+		{"", ""},
+		{"relay.lt.dev/ws", ""},
+		{"ftp://relay.lt.dev/ws", ""},
+		{"wss:///ws", ""},
+	}
+	for _, tt := range tests {
+		u, err := ParseRelayEndpoint(tt.endpoint)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseRelayEndpoint(%q) = %s, want error", tt.endpoint, u)
+			}
+			continue
+		}
+		if err != nil || u.String() != tt.want {
+			t.Errorf("ParseRelayEndpoint(%q) = %v, %v; want %s", tt.endpoint, u, err, tt.want)
+		}
+	}
+}
+
+func TestDialRelay_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// An http:// endpoint is upgraded rather than rejected.
+	conn, err := DialRelay(ctx, srv.URL+"/ws", "tok")
+	if err != nil {
+		t.Fatalf("DialRelay(http URL): %v", err)
+	}
+	conn.CloseNow()
+
+	if _, err := DialRelay(ctx, "", "tok"); err == nil || !strings.Contains(err.Error(), "no relay endpoint") {
+		t.Errorf("empty endpoint: err = %v", err)
+	}
+
+	// Nothing listens on a closed server's port.
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	addr := dead.Listener.Addr().String()
+	_, err = DialRelay(ctx, "ws://"+addr+"/ws", "tok")
+	if err == nil || !strings.Contains(err.Error(), addr) || !strings.Contains(err.Error(), "firewall") {
+		t.Errorf("unreachable relay: err = %v, want the host and a hint", err)
+	}
+}
+
+func TestDialRelayRetry(t *testing.T) {
+	var dials atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if dials.Add(1) <= 2 {
+			http.Error(w, "relay starting", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := DialRelayRetry(ctx, srv.URL+"/ws", "tok", 4)
+	if err != nil {
+		t.Fatalf("DialRelayRetry: %v", err)
+	}
+	conn.CloseNow()
+	if n := dials.Load(); n != 3 {
+		t.Errorf("dials = %d, want 3", n)
+	}
+
+	dials.Store(-10)
+	if _, err := DialRelayRetry(ctx, srv.URL+"/ws", "tok", 2); err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("failing relay: err = %v, want it to give up after 2 attempts", err)
+	}
+	if n := dials.Load(); n != -8 {
+		t.Errorf("dials = %d, want 2 more", n+10)
+	}
+
+	// An invalid endpoint fails at once rather than being retried.
+	start := time.Now()
+	if _, err := DialRelayRetry(ctx, "ftp://relay.example", "tok", 4); err == nil {
+		t.Error("invalid endpoint: want an error")
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("invalid endpoint took %s, want no retries", d)
+	}
+}
+
+func TestDialRelay_Headers(t *testing.T) {
+	type dialed struct {
+		query  string
+		header http.Header
+	}
+	got := make(chan dialed, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- dialed{r.URL.RawQuery, r.Header.Clone()}
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}))
+	defer srv.Close()
+	endpoint := "ws" + srv.URL[len("http"):] + "/ws"
+
+	extra := http.Header{"X-Proxy-Auth": {"secret"}}
+	tests := []struct {
+		name      string
+		opts      []DialOption
+		wantQuery string
+		wantToken string
+	}{
+		{"token in query", []DialOption{WithHeader(extra)}, "session_token=tok", ""},
+		{"token in header", []DialOption{WithHeader(extra), WithTokenInHeader()}, "", "tok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := DialRelay(ctx, endpoint, "tok", tt.opts...)
+			if err != nil {
+				t.Fatalf("DialRelay: %v", err)
+			}
+			conn.CloseNow()
+			d := <-got
+			if d.query != tt.wantQuery {
+				t.Errorf("query = %q, want %q", d.query, tt.wantQuery)
+			}
+			if v := d.header.Get(SessionTokenHeader); v != tt.wantToken {
+				t.Errorf("%s = %q, want %q", SessionTokenHeader, v, tt.wantToken)
+			}
+			if v := d.header.Get("X-Proxy-Auth"); v != "secret" {
+				t.Errorf("X-Proxy-Auth = %q, want the extra header", v)
+			}
+		})
+	}
+}
+
+func TestDialRelay_Compression(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("Sec-WebSocket-Extensions")
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{CompressionMode: websocket.CompressionNoContextTakeover})
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		conn.SetReadLimit(relayReadLimit)
+		// Echo one message so the round trip goes through compression.
+		typ, data, err := conn.Read(r.Context())
+		if err != nil {
+			return
+		}
+		_ = conn.Write(r.Context(), typ, data)
+	}))
+	defer srv.Close()
+	endpoint := "ws" + srv.URL[len("http"):] + "/ws"
+
+	for _, compress := range []bool{true, false} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var opts []DialOption
+		if !compress {
+			opts = append(opts, WithoutCompression())
+		}
+		conn, err := DialRelay(ctx, endpoint, "tok", opts...)
+		if err != nil {
+			cancel()
+			t.Fatalf("DialRelay(compress=%v): %v", compress, err)
+		}
+		if ext := <-got; strings.Contains(ext, "permessage-deflate") != compress {
+			t.Errorf("compress=%v: Sec-WebSocket-Extensions = %q", compress, ext)
+		}
+		page := bytes.Repeat([]byte("<li><a href=\"/docs\">Docs</a></li>\n"), 2000)
+		if err := conn.Write(ctx, websocket.MessageBinary, page); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		_, echo, err := conn.Read(ctx)
+		if err != nil || !bytes.Equal(echo, page) {
+			t.Errorf("compress=%v: echo of %d bytes came back as %d, %v", compress, len(page), len(echo), err)
+		}
+		conn.CloseNow()
+		cancel()
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Session tests
+// ---------------------------------------------------------------------------
+
+func TestSession_StartServeStop(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello from "+r.URL.Path)
+	}))
+	defer local.Close()
+	_, localPort, _ := net.SplitHostPort(local.Listener.Addr().String())
+
+	relayMux := make(chan *protocol.Mux, 1)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("session_token") != "tok" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		relayMux <- protocol.NewMux(protocol.NewWebSocketTransport(conn), true)
+	}))
+	defer relay.Close()
+
+	stopped := make(chan string, 1)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v1/tunnels":
+			fmt.Fprintf(w, `{"tunnel": {"id": "tun_1", "protocol": "http", "local_port": %s,
+				"public_url": "https://demo.lt.dev", "relay_endpoint": %q, "session_token": "tok"}}`,
+				localPort, "ws"+relay.URL[len("http"):])
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/stop"):
+			stopped <- r.URL.Path
+			_, _ = io.WriteString(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session := NewSession(SessionConfig{
+		Client:  client.New(api.URL, "key"),
+		Request: client.CreateTunnelRequest{Protocol: "http"},
+	})
+	if err := session.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := session.PublicURL(); got != "https://demo.lt.dev" {
+		t.Errorf("PublicURL = %q", got)
+	}
+
+	var rm *protocol.Mux
+	select {
+	case rm = <-relayMux:
+	case <-ctx.Done():
+		t.Fatal("session never connected to the relay")
+	}
+	defer rm.Close()
+
+	stream, err := rm.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "http://demo.lt.dev/hi", nil)
+	if err := req.Write(stream); err != nil {
+		t.Fatalf("writing request: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello from /hi" {
+		t.Errorf("body = %q", body)
+	}
+
+	if err := session.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if err := session.Wait(); err != nil {
+		t.Errorf("Wait after Stop: %v", err)
+	}
+	select {
+	case path := <-stopped:
+		if path != "/api/v1/tunnels/tun_1/stop" {
+			t.Errorf("stopped %s", path)
+		}
+	default:
+		t.Error("Stop did not stop the tunnel on the control plane")
+	}
+
+	var types []string
+	for e := range session.Events() {
+		types = append(types, e.Type)
+	}
+	for _, want := range []string{EventTunnelCreated, EventConnected, EventRequest} {
+		if !slices.Contains(types, want) {
+			t.Errorf("events %v lack %q", types, want)
+		}
+	}
+}
+
+func TestSession_StateChanges(t *testing.T) {
+	relayMux := make(chan *protocol.Mux, 2)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		relayMux <- protocol.NewMux(protocol.NewWebSocketTransport(conn), true)
+	}))
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type change struct{ old, new State }
+	var (
+		mu      sync.Mutex
+		changes []change
+		status  bytes.Buffer
+	)
+	session := NewSession(SessionConfig{
+		Tunnel:  &client.TunnelResponse{ID: "tun_1", Protocol: "http", LocalPort: 1, RelayEndpoint: relay.URL, SessionToken: "tok"},
+		Handler: HandlerFunc(func(*protocol.Stream) {}),
+		Status:  &status,
+	})
+	session.OnStateChange(func(old, new State) {
+		mu.Lock()
+		changes = append(changes, change{old, new})
+		mu.Unlock()
+	})
+	if got := session.State(); got != StateConnecting {
+		t.Errorf("State before Start = %s", got)
+	}
+	if err := session.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// Drop the first connection; the session reconnects.
+	(<-relayMux).Close()
+	var rm *protocol.Mux
+	select {
+	case rm = <-relayMux:
+	case <-ctx.Done():
+		t.Fatal("session never reconnected")
+	}
+	defer rm.Close()
+	for session.State() != StateConnected {
+		if ctx.Err() != nil {
+			t.Fatalf("State after reconnecting = %s", session.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := session.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got := session.State(); got != StateClosed {
+		t.Errorf("State after Stop = %s", got)
+	}
+	if got, want := status.String(), "Connection lost. Reconnecting...\nReconnected successfully.\n"; got != want {
+		t.Errorf("status output = %q, want %q", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []change{
+		{StateConnecting, StateConnected},
+		{StateConnected, StateReconnecting},
+		{StateReconnecting, StateConnected},
+		{StateConnected, StateClosed},
+	}
+	if !slices.Equal(changes, want) {
+		t.Errorf("state changes = %v, want %v", changes, want)
+	}
+}
+
+func TestHTTPHandler_ServesOverTunnel(t *testing.T) {
+	relay, cl := setupMuxPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %q from %s", r.Method, r.URL.Path, b, r.RemoteAddr)
+	}))
+	go acceptStreams(ctx, cl, h, &Options{})
+
+	stream, err := relay.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer stream.Close()
+	br := bufio.NewReader(stream)
+
+	// Two requests on one stream exercise keep-alive, which depends on
+	// the server being able to abort its background read.
+	for i, body := range []string{"", "ping"} {
+		req, _ := http.NewRequest("POST", "http://demo.lt.dev/echo", strings.NewReader(body))
+		if err := req.Write(stream); err != nil {
+			t.Fatalf("writing request %d: %v", i, err)
+		}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatalf("reading response %d: %v", i, err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		want := fmt.Sprintf("POST /echo %q from stream/%d", body, stream.ID)
+		if string(got) != want {
+			t.Errorf("response %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestServeHandler_StreamsResponse(t *testing.T) {
+	relay, cl := setupMuxPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	release := make(chan struct{})
+	go func() {
+		s, err := cl.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		ServeHandler(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "first\n")
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+			_, _ = io.WriteString(w, "second\n")
+		}))
+	}()
+
+	stream, err := relay.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer stream.Close()
+	req, _ := http.NewRequest("GET", "http://demo.lt.dev/events", nil)
+	if err := req.Write(stream); err != nil {
+		t.Fatalf("writing request: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first line must arrive while the handler is still running.
+	br := bufio.NewReader(resp.Body)
+	if line, err := br.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+	close(release)
+	if line, err := br.ReadString('\n'); err != nil || line != "second\n" {
+		t.Fatalf("second line = %q, %v", line, err)
+	}
+}
+
+func ExampleServeHandler() {
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello, %s", r.URL.Query().Get("name"))
+	})
+
+	// A relay and a client mux over a local WebSocket stand in for the
+	// real relay connection.
+	relayMux := make(chan *protocol.Mux, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ := websocket.Accept(w, r, nil)
+		relayMux <- protocol.NewMux(protocol.NewWebSocketTransport(conn), true)
+	}))
+	defer srv.Close()
+	conn, _, err := websocket.Dial(context.Background(), "ws"+srv.URL[len("http"):], nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	mux := protocol.NewMux(protocol.NewWebSocketTransport(conn), false)
+	defer mux.Close()
+	relay := <-relayMux
+	defer relay.Close()
+
+	go func() {
+		for {
+			s, err := mux.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			go ServeHandler(s, app)
+		}
+	}()
+
+	// The relay opens a stream per visitor connection and writes the
+	// visitor's request to it.
+	stream, _ := relay.OpenStream(context.Background())
+	defer stream.Close()
+	req, _ := http.NewRequest("GET", "http://demo.lt.dev/?name=gopher", nil)
+	_ = req.Write(stream)
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println(resp.Status, string(body))
+	// Output: 200 OK hello, gopher
+}
+
+// ---------------------------------------------------------------------------
+// Replay tests
+// ---------------------------------------------------------------------------
+
+func TestHARRequest_Replay(t *testing.T) {
+	var gotHost, gotSig, gotBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotHost, gotSig, gotBody = r.Host, r.Header.Get("X-Signature"), string(b)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer backend.Close()
+	host, portStr, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	r := HARRequest{
+		Method: "POST",
+		URL:    "https://demo.lt.dev/hooks/stripe?x=1",
+		Headers: []HARNameValue{
+			{Name: "Host", Value: "demo.lt.dev"},
+			{Name: "Content-Length", Value: "999"},
+			{Name: "X-Signature", Value: "abc"},
+		},
+		PostData: &HARPostData{MimeType: "application/json", Text: `{"ok":true}`},
+	}
+	req, err := r.NewRequest(host, port)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if req.URL.RequestURI() != "/hooks/stripe?x=1" {
+		t.Errorf("RequestURI = %q", req.URL.RequestURI())
+	}
+	resp, err := Replay(req)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want 202", resp.StatusCode)
+	}
+	if gotHost != "demo.lt.dev" || gotSig != "abc" || gotBody != `{"ok":true}` {
+		t.Errorf("backend got host=%q sig=%q body=%q", gotHost, gotSig, gotBody)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Local host allowlist tests
+// ---------------------------------------------------------------------------
+
+func TestCheckLocalHost(t *testing.T) {
+	allowed := []string{"devbox.internal", "10.1.0.0/16", "192.168.1.20"}
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"[::1]", true},
+		{"LocalHost", true},
+		{"devbox.internal", true},
+		{"10.1.42.7", true},
+		{"192.168.1.20", true},
+		{"10.2.0.1", false},
+		{"192.168.1.21", false},
+		{"db.internal", false},
+	}
+	for _, tt := range tests {
+		err := CheckLocalHost(tt.host, allowed)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckLocalHost(%q) = %v, want ok=%v", tt.host, err, tt.ok)
+		}
+	}
+	if err := CheckLocalHost("10.1.42.7", nil); err == nil {
+		t.Error("non-loopback host allowed with an empty allowlist")
+	}
+}
+
+func TestParseLocalHost(t *testing.T) {
+	tests := []struct {
+		host, want string
+	}{
+		{"127.0.0.1", "127.0.0.1"},
+		{" localhost ", "localhost"},
+		{"::1", "::1"},
+		{"[::1]", "::1"},
+		{"[fe80::1%eth0]", "fe80::1%eth0"},
+		{"", ""},
+		{"[localhost]", ""},
+		{"127.0.0.1:3000", ""},
+		{"[::1]:3000", ""},
+		{"http://localhost", ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseLocalHost(tt.host)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseLocalHost(%q) = %q, want error", tt.host, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLocalHost(%q) = %q, %v; want %q", tt.host, got, err, tt.want)
+		}
+	}
+	if got := LocalTarget("::1", 3000); got != "[::1]:3000" {
+		t.Errorf("LocalTarget(::1) = %q", got)
+	}
+	if got := LocalTarget("[::1]", 3000); got != "[::1]:3000" {
+		t.Errorf("LocalTarget([::1]) = %q", got)
+	}
+}
+
+// listenIPv6 listens on [::1], skipping the test where IPv6 is unavailable.
+func listenIPv6(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	return ln
+}
+
+func TestForwardHTTP_IPv6(t *testing.T) {
+	local := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "host=%s", r.Host)
+	}))
+	local.Listener = listenIPv6(t)
+	local.Start()
+	defer local.Close()
+	addr := local.Listener.Addr().String()
+
+	// A request without a Host header reaches the server addressed by its
+	// bracketed target.
+	req, _ := http.NewRequest("GET", "/", nil)
+	_, body, err := tunnelRoundTrip(t, addr, nil, req)
+	if err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	if want := "host=" + addr; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestForwardTCP_IPv6(t *testing.T) {
+	ln := listenIPv6(t)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	relay, cl := setupMuxPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		s, err := cl.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		// Bracketed, as a user might pass it to --local-host.
+		ForwardTCP(s, "[::1]", port, nil)
+	}()
+
+	stream, err := relay.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer stream.Close()
+	if _, err := stream.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Transport config tests
+// ---------------------------------------------------------------------------
+
+// cachedTargets returns the targets in the transport cache, sorted.
+func cachedTargets() []string {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	var targets []string
+	for target := range transportCache {
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+	return targets
+}
+
+func TestTransportCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	tc := DefaultTransportConfig()
+	tc.MaxTransports = 2
+	SetTransportConfig(tc)
+	defer SetTransportConfig(DefaultTransportConfig())
+
+	a := getTransport("a:1")
+	getTransport("b:1")
+	time.Sleep(time.Millisecond)
+	if got := getTransport("a:1"); got != a {
+		t.Fatal("a:1 was not reused")
+	}
+	getTransport("c:1")
+	if got, want := cachedTargets(), []string{"a:1", "c:1"}; !slices.Equal(got, want) {
+		t.Errorf("cached = %v, want %v", got, want)
+	}
+}
+
+func TestTransportCache_PrunesIdle(t *testing.T) {
+	tc := DefaultTransportConfig()
+	tc.IdleConnTimeout = time.Minute
+	SetTransportConfig(tc)
+	defer SetTransportConfig(DefaultTransportConfig())
+
+	getTransport("stale:1")
+	getTransport("fresh:1")
+	transportMu.Lock()
+	transportCache["stale:1"].lastUsed = time.Now().Add(-2 * time.Minute)
+	transportMu.Unlock()
+
+	getTransport("new:1")
+	if got, want := cachedTargets(), []string{"fresh:1", "new:1"}; !slices.Equal(got, want) {
+		t.Errorf("cached = %v, want %v", got, want)
+	}
+}
+
+func TestResetTransportCache(t *testing.T) {
+	defer SetTransportConfig(DefaultTransportConfig())
+	a := getTransport("a:1")
+	ResetTransportCache()
+	if got := cachedTargets(); len(got) != 0 {
+		t.Errorf("cached after reset = %v", got)
+	}
+	if getTransport("a:1") == a {
+		t.Error("a:1 transport reused after reset")
+	}
+}
+
+func TestForwardHTTP_ResponseHeaderTimeout(t *testing.T) {
+	tc := DefaultTransportConfig()
+	tc.ResponseHeaderTimeout = 50 * time.Millisecond
+	SetTransportConfig(tc)
+	defer SetTransportConfig(DefaultTransportConfig())
+
+	release := make(chan struct{})
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer local.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest("GET", "http://demo.lt.dev/slow", nil)
+	resp, _, err := tunnelRoundTrip(t, local.Listener.Addr().String(), nil, req)
+	if err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", resp.StatusCode)
+	}
+}